@@ -39,6 +39,9 @@ type Change struct {
 
 	SpawnTime time.Time `json:"spawn-time,omitempty"`
 	ReadyTime time.Time `json:"ready-time,omitempty"`
+	// ScheduledTime is when the change is scheduled to start, while
+	// it's waiting for that
+	ScheduledTime time.Time `json:"scheduled-time,omitempty"`
 
 	data map[string]*json.RawMessage
 }
@@ -62,9 +65,14 @@ type Task struct {
 	Status   string       `json:"status"`
 	Log      []string     `json:"log,omitempty"`
 	Progress TaskProgress `json:"progress"`
+	// WaitReason says what a task in the Wait status waits for
+	WaitReason string `json:"wait-reason,omitempty"`
 
 	SpawnTime time.Time `json:"spawn-time,omitempty"`
 	ReadyTime time.Time `json:"ready-time,omitempty"`
+	// ScheduledTime is when the change is scheduled to start, while
+	// it's waiting for that
+	ScheduledTime time.Time `json:"scheduled-time,omitempty"`
 }
 
 type TaskProgress struct {
@@ -92,10 +100,19 @@ func (client *Client) Change(id string) (*Change, error) {
 
 // Abort attempts to abort a change that is in not yet ready.
 func (client *Client) Abort(id string) (*Change, error) {
+	return client.changeAction(id, "abort")
+}
+
+// Approve resumes the tasks of a change waiting for approval.
+func (client *Client) Approve(id string) (*Change, error) {
+	return client.changeAction(id, "approve")
+}
+
+func (client *Client) changeAction(id, action string) (*Change, error) {
 	var postData struct {
 		Action string `json:"action"`
 	}
-	postData.Action = "abort"
+	postData.Action = action
 
 	var body bytes.Buffer
 	if err := json.NewEncoder(&body).Encode(postData); err != nil {
@@ -162,3 +179,63 @@ func (client *Client) Changes(opts *ChangesOptions) ([]*Change, error) {
 
 	return chgs, err
 }
+
+// ChangeHistoryTaskLog holds the error messages logged by a task of a
+// change in the history.
+type ChangeHistoryTaskLog struct {
+	Kind    string   `json:"kind"`
+	Summary string   `json:"summary"`
+	Status  string   `json:"status"`
+	Log     []string `json:"log"`
+}
+
+// ChangeHistoryEntry is the record of a change pruned from the system
+// state.
+type ChangeHistoryEntry struct {
+	ID      string `json:"id"`
+	Kind    string `json:"kind"`
+	Summary string `json:"summary"`
+	Status  string `json:"status"`
+
+	UserID int     `json:"user-id,omitempty"`
+	UID    *uint32 `json:"uid,omitempty"`
+
+	SnapNames []string `json:"snap-names,omitempty"`
+
+	SpawnTime time.Time `json:"spawn-time"`
+	ReadyTime time.Time `json:"ready-time,omitempty"`
+
+	TaskErrors []*ChangeHistoryTaskLog `json:"task-errors,omitempty"`
+}
+
+type ChangeHistoryOptions struct {
+	SnapName string // if empty, no filtering by name is done
+	// Since and Until, if set, limit the entries to the changes
+	// spawned in between them
+	Since time.Time
+	Until time.Time
+}
+
+// ChangeHistory returns the history of the changes that were pruned
+// from the system state, oldest first.
+func (client *Client) ChangeHistory(opts *ChangeHistoryOptions) ([]*ChangeHistoryEntry, error) {
+	query := url.Values{}
+	query.Set("select", "history")
+	if opts != nil {
+		if opts.SnapName != "" {
+			query.Set("for", opts.SnapName)
+		}
+		if !opts.Since.IsZero() {
+			query.Set("since", opts.Since.Format(time.RFC3339))
+		}
+		if !opts.Until.IsZero() {
+			query.Set("until", opts.Until.Format(time.RFC3339))
+		}
+	}
+
+	var entries []*ChangeHistoryEntry
+	if _, err := client.doSync("GET", "/v2/changes", query, nil, nil, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -24,6 +24,7 @@ import (
 
 	"github.com/snapcore/snapd/client"
 	"io/ioutil"
+	"net/url"
 	"time"
 )
 
@@ -183,6 +184,55 @@ func (cs *clientSuite) TestClientChangesData(c *check.C) {
 	c.Assert(err, check.Equals, client.ErrNoData)
 }
 
+func (cs *clientSuite) TestClientChangeHistory(c *check.C) {
+	cs.rsp = `{"type": "sync", "result": [{
+  "id":   "uno",
+  "kind": "remove-snap",
+  "summary": "...",
+  "status": "Error",
+  "user-id": 42,
+  "uid": 1000,
+  "snap-names": ["foo"],
+  "spawn-time": "2016-04-21T01:02:03Z",
+  "ready-time": "2016-04-21T01:02:04Z",
+  "task-errors": [{"kind": "unlink", "summary": "...", "status": "Error", "log": ["2016-04-21T01:02:04Z ERROR rm failed"]}]
+}]}`
+
+	entries, err := cs.cli.ChangeHistory(&client.ChangeHistoryOptions{
+		SnapName: "foo",
+		Since:    time.Date(2016, 4, 1, 0, 0, 0, 0, time.UTC),
+		Until:    time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC),
+	})
+	c.Assert(err, check.IsNil)
+	c.Check(cs.req.Method, check.Equals, "GET")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/changes")
+	c.Check(cs.req.URL.Query(), check.DeepEquals, url.Values{
+		"select": {"history"},
+		"for":    {"foo"},
+		"since":  {"2016-04-01T00:00:00Z"},
+		"until":  {"2016-05-01T00:00:00Z"},
+	})
+
+	uid := uint32(1000)
+	c.Check(entries, check.DeepEquals, []*client.ChangeHistoryEntry{{
+		ID:        "uno",
+		Kind:      "remove-snap",
+		Summary:   "...",
+		Status:    "Error",
+		UserID:    42,
+		UID:       &uid,
+		SnapNames: []string{"foo"},
+		SpawnTime: time.Date(2016, 04, 21, 1, 2, 3, 0, time.UTC),
+		ReadyTime: time.Date(2016, 04, 21, 1, 2, 4, 0, time.UTC),
+		TaskErrors: []*client.ChangeHistoryTaskLog{{
+			Kind:    "unlink",
+			Summary: "...",
+			Status:  "Error",
+			Log:     []string{"2016-04-21T01:02:04Z ERROR rm failed"},
+		}},
+	}})
+}
+
 func (cs *clientSuite) TestClientAbort(c *check.C) {
 	cs.rsp = `{"type": "sync", "result": {
   "id":   "uno",
@@ -213,3 +263,24 @@ func (cs *clientSuite) TestClientAbort(c *check.C) {
 
 	c.Assert(string(body), check.Equals, "{\"action\":\"abort\"}\n")
 }
+
+func (cs *clientSuite) TestClientApprove(c *check.C) {
+	cs.rsp = `{"type": "sync", "result": {
+  "id":   "uno",
+  "kind": "refresh",
+  "summary": "...",
+  "status": "Doing",
+  "tasks": [{"kind": "wait-approval", "summary": "...", "status": "Doing", "progress": {"done": 0, "total": 1}}],
+  "spawn-time": "2016-04-21T01:02:03Z"
+}}`
+
+	chg, err := cs.cli.Approve("uno")
+	c.Assert(err, check.IsNil)
+	c.Check(cs.req.Method, check.Equals, "POST")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/changes/uno")
+	c.Check(chg.Status, check.Equals, "Doing")
+
+	body, err := ioutil.ReadAll(cs.req.Body)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(body), check.Equals, "{\"action\":\"approve\"}\n")
+}
@@ -67,6 +67,11 @@ type Config struct {
 	// DisableAuth controls whether the client should send an
 	// Authorization header from reading the auth.json data.
 	DisableAuth bool
+
+	// Interactive controls whether snapd may ask the user to
+	// authenticate, via polkit, for actions they are not otherwise
+	// allowed to do.
+	Interactive bool
 }
 
 // A Client knows how to talk to the snappy daemon.
@@ -75,6 +80,10 @@ type Client struct {
 	doer    doer
 
 	disableAuth bool
+	interactive bool
+
+	warningCount     int
+	warningTimestamp time.Time
 }
 
 // New returns a new instance of Client
@@ -94,6 +103,7 @@ func New(config *Config) *Client {
 				Transport: &http.Transport{Dial: unixDialer()},
 			},
 			disableAuth: config.DisableAuth,
+			interactive: config.Interactive,
 		}
 	}
 
@@ -105,6 +115,7 @@ func New(config *Config) *Client {
 		baseURL:     *baseURL,
 		doer:        &http.Client{},
 		disableAuth: config.DisableAuth,
+		interactive: config.Interactive,
 	}
 }
 
@@ -160,6 +171,9 @@ func (client *Client) raw(method, urlpath string, query url.Values, headers map[
 	for key, value := range headers {
 		req.Header.Set(key, value)
 	}
+	if client.interactive {
+		req.Header.Set("X-Allow-Interaction", "true")
+	}
 
 	if !client.disableAuth {
 		// set Authorization header if there are user's credentials
@@ -243,6 +257,8 @@ func (client *Client) doSync(method, path string, query url.Values, headers map[
 	if err := client.do(method, path, query, headers, body, &rsp); err != nil {
 		return nil, err
 	}
+	client.warningCount = rsp.WarningCount
+	client.warningTimestamp = rsp.WarningTimestamp
 	if err := rsp.err(); err != nil {
 		return nil, err
 	}
@@ -265,6 +281,8 @@ func (client *Client) doAsync(method, path string, query url.Values, headers map
 	if err := client.do(method, path, query, headers, body, &rsp); err != nil {
 		return "", err
 	}
+	client.warningCount = rsp.WarningCount
+	client.warningTimestamp = rsp.WarningTimestamp
 	if err := rsp.err(); err != nil {
 		return "", err
 	}
@@ -317,6 +335,9 @@ type response struct {
 	Type       string          `json:"type"`
 	Change     string          `json:"change"`
 
+	WarningCount     int       `json:"warning-count"`
+	WarningTimestamp time.Time `json:"warning-timestamp"`
+
 	ResultInfo
 }
 
@@ -345,6 +366,8 @@ const (
 	ErrorKindNoUpdateAvailable    = "snap-no-update-available"
 
 	ErrorKindNotSnap = "snap-not-a-snap"
+
+	ErrorKindAuthCancelled = "auth-cancelled"
 )
 
 // IsTwoFactorError returns whether the given error is due to problems
@@ -516,3 +539,32 @@ func (client *Client) Users() ([]*User, error) {
 	}
 	return result, nil
 }
+
+func (client *Client) userRoleAction(action, user, role string) (*User, error) {
+	data, err := json.Marshal(map[string]string{
+		"action": action,
+		"user":   user,
+		"role":   role,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result User
+	if _, err := client.doSync("POST", "/v2/users", nil, nil, bytes.NewReader(data), &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GrantRole gives the role to the user identified by username or
+// email, limiting what it can do through the API to what its roles allow.
+func (client *Client) GrantRole(user, role string) (*User, error) {
+	return client.userRoleAction("grant-role", user, role)
+}
+
+// RevokeRole takes the role away from the user identified by username
+// or email.
+func (client *Client) RevokeRole(user, role string) (*User, error) {
+	return client.userRoleAction("revoke-role", user, role)
+}
@@ -20,6 +20,7 @@
 package client_test
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -170,6 +171,17 @@ func (cs *clientSuite) TestClientHonorsDisableAuth(c *C) {
 	c.Check(authorization, Equals, "")
 }
 
+func (cs *clientSuite) TestClientInteractive(c *C) {
+	var v string
+	_ = cs.cli.Do("GET", "/this", nil, nil, &v)
+	c.Check(cs.req.Header.Get("X-Allow-Interaction"), Equals, "")
+
+	cli := client.New(&client.Config{Interactive: true})
+	cli.SetDoer(cs)
+	_ = cli.Do("GET", "/this", nil, nil, &v)
+	c.Check(cs.req.Header.Get("X-Allow-Interaction"), Equals, "true")
+}
+
 func (cs *clientSuite) TestClientSysInfo(c *C) {
 	cs.rsp = `{"type": "sync", "result":
                      {"series": "16",
@@ -440,3 +452,27 @@ func (cs *clientSuite) TestUsers(c *C) {
 		{Username: "bar", Email: "bar@example.com"},
 	})
 }
+
+func (cs *clientSuite) TestGrantRevokeRole(c *C) {
+	cs.rsp = `{"type": "sync", "result": {"id": 1, "username": "foo", "roles": ["operator"]}}`
+	for _, t := range []struct {
+		action string
+		f      func(string, string) (*client.User, error)
+	}{
+		{"grant-role", cs.cli.GrantRole},
+		{"revoke-role", cs.cli.RevokeRole},
+	} {
+		user, err := t.f("foo", "viewer")
+		c.Assert(err, IsNil)
+		c.Check(user, DeepEquals, &client.User{ID: 1, Username: "foo", Roles: []string{"operator"}})
+		c.Check(cs.req.Method, Equals, "POST")
+		c.Check(cs.req.URL.Path, Equals, "/v2/users")
+		var body map[string]string
+		c.Assert(json.NewDecoder(cs.req.Body).Decode(&body), IsNil)
+		c.Check(body, DeepEquals, map[string]string{
+			"action": t.action,
+			"user":   "foo",
+			"role":   "viewer",
+		})
+	}
+}
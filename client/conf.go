@@ -24,15 +24,22 @@ import (
 	"encoding/json"
 	"net/url"
 	"strings"
+	"time"
 )
 
 // SetConf requests a snap to apply the provided patch to the configuration.
 func (client *Client) SetConf(snapName string, patch map[string]interface{}) (changeID string, err error) {
+	return client.SetConfAt(snapName, patch, time.Time{})
+}
+
+// SetConfAt is like SetConf but the change only starts at the given
+// time, unless it's zero.
+func (client *Client) SetConfAt(snapName string, patch map[string]interface{}, at time.Time) (changeID string, err error) {
 	b, err := json.Marshal(patch)
 	if err != nil {
 		return "", err
 	}
-	return client.doAsync("PUT", "/v2/snaps/"+snapName+"/conf", nil, nil, bytes.NewReader(b))
+	return client.doAsync("PUT", "/v2/snaps/"+snapName+"/conf", atQuery(at), nil, bytes.NewReader(b))
 }
 
 // Conf asks for a snap's current configuration.
@@ -21,6 +21,7 @@ package client_test
 
 import (
 	"encoding/json"
+	"time"
 
 	"gopkg.in/check.v1"
 )
@@ -31,6 +32,14 @@ func (cs *clientSuite) TestClientSetConfCallsEndpoint(c *check.C) {
 	c.Check(cs.req.URL.Path, check.Equals, "/v2/snaps/snap-name/conf")
 }
 
+func (cs *clientSuite) TestClientSetConfAt(c *check.C) {
+	at := time.Date(2017, 3, 4, 3, 0, 0, 0, time.UTC)
+	cs.cli.SetConfAt("snap-name", map[string]interface{}{"key": "value"}, at)
+	c.Check(cs.req.Method, check.Equals, "PUT")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/snaps/snap-name/conf")
+	c.Check(cs.req.URL.Query().Get("at"), check.Equals, "2017-03-04T03:00:00Z")
+}
+
 func (cs *clientSuite) TestClientGetConfCallsEndpoint(c *check.C) {
 	cs.cli.Conf("snap-name", []string{"test-key"})
 	c.Check(cs.req.Method, check.Equals, "GET")
@@ -0,0 +1,50 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// ConnectivityStatus is the outcome of probing one of the endpoints
+// used by snapd to talk to the store.
+type ConnectivityStatus struct {
+	Endpoint   string `json:"endpoint"`
+	URL        string `json:"url,omitempty"`
+	Proxy      string `json:"proxy,omitempty"`
+	DNS        string `json:"dns,omitempty"`
+	TLS        string `json:"tls,omitempty"`
+	HTTPStatus int    `json:"http-status,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// DebugConnectivity asks snapd to probe the endpoints of the store and
+// report how far it got with each.
+func (client *Client) DebugConnectivity() ([]*ConnectivityStatus, error) {
+	q := url.Values{}
+	q.Set("aspect", "connectivity")
+
+	var statuses []*ConnectivityStatus
+	if _, err := client.doSync("GET", "/v2/debug", q, nil, nil, &statuses); err != nil {
+		return nil, fmt.Errorf("cannot check store connectivity: %v", err)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,55 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client_test
+
+import (
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+)
+
+func (cs *clientSuite) TestClientDebugConnectivityCallsEndpoint(c *check.C) {
+	_, _ = cs.cli.DebugConnectivity()
+	c.Check(cs.req.Method, check.Equals, "GET")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/debug")
+	c.Check(cs.req.URL.Query().Get("aspect"), check.Equals, "connectivity")
+}
+
+func (cs *clientSuite) TestClientDebugConnectivity(c *check.C) {
+	cs.rsp = `{
+		"type": "sync",
+		"result": [
+			{"endpoint": "search", "url": "https://search.example.com/", "dns": "ok", "tls": "ok", "http-status": 200},
+			{"endpoint": "download", "proxy": "http://proxy:3128", "dns": "failed", "error": "cannot resolve"}
+		]
+	}`
+	statuses, err := cs.cli.DebugConnectivity()
+	c.Assert(err, check.IsNil)
+	c.Check(statuses, check.DeepEquals, []*client.ConnectivityStatus{
+		{Endpoint: "search", URL: "https://search.example.com/", DNS: "ok", TLS: "ok", HTTPStatus: 200},
+		{Endpoint: "download", Proxy: "http://proxy:3128", DNS: "failed", Error: "cannot resolve"},
+	})
+}
+
+func (cs *clientSuite) TestClientDebugConnectivityError(c *check.C) {
+	cs.rsp = `{"type": "error", "result": {"message": "boom"}}`
+	_, err := cs.cli.DebugConnectivity()
+	c.Check(err, check.ErrorMatches, "cannot check store connectivity: boom")
+}
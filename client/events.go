@@ -0,0 +1,109 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// An Event is a transition in the system state, as streamed by snapd.
+type Event struct {
+	Kind     string            `json:"kind"`
+	Time     time.Time         `json:"time"`
+	ChangeID string            `json:"change-id,omitempty"`
+	TaskID   string            `json:"task-id,omitempty"`
+	Status   string            `json:"status,omitempty"`
+	Progress *TaskProgress     `json:"progress,omitempty"`
+	Data     map[string]string `json:"data,omitempty"`
+}
+
+// EventsOptions selects the events to stream.
+type EventsOptions struct {
+	// ChangeID restricts the events to those about the given change
+	ChangeID string
+	// Kinds restricts the events to those of the given kinds
+	Kinds []string
+}
+
+// An EventStream iterates over the events streamed by snapd.
+type EventStream struct {
+	body io.ReadCloser
+	r    *bufio.Reader
+}
+
+// Events starts streaming the events selected by opts. Only events
+// happening after it returns are streamed.
+func (client *Client) Events(opts *EventsOptions) (*EventStream, error) {
+	if opts == nil {
+		opts = &EventsOptions{}
+	}
+	q := url.Values{}
+	if opts.ChangeID != "" {
+		q.Set("change", opts.ChangeID)
+	}
+	if len(opts.Kinds) > 0 {
+		q.Set("kinds", strings.Join(opts.Kinds, ","))
+	}
+
+	rsp, err := client.raw("GET", "/v2/events", q, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		defer rsp.Body.Close()
+		return nil, parseError(rsp)
+	}
+
+	return &EventStream{
+		body: rsp.Body,
+		r:    bufio.NewReader(rsp.Body),
+	}, nil
+}
+
+// Next blocks until the next event is streamed and returns it. It
+// returns io.EOF once the stream ended.
+func (s *EventStream) Next() (*Event, error) {
+	rec, err := s.r.ReadBytes('\n')
+	if err == io.EOF && len(bytes.TrimSpace(rec)) != 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ev Event
+	if err := json.Unmarshal(bytes.TrimLeft(rec, "\x1e"), &ev); err != nil {
+		return nil, fmt.Errorf("cannot decode event: %v", err)
+	}
+	return &ev, nil
+}
+
+// Close stops the streaming of events.
+func (s *EventStream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,82 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client_test
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+)
+
+func (cs *clientSuite) TestClientEvents(c *check.C) {
+	cs.rsp = "\x1e" + `{"kind": "task-progress", "time": "2017-04-21T01:02:03Z", "change-id": "42", "task-id": "84", "progress": {"label": "foo", "done": 1, "total": 2}}` + "\n" +
+		"\x1e" + `{"kind": "snap-installed", "time": "2017-04-21T01:02:04Z", "change-id": "42", "task-id": "85", "data": {"snap": "foo", "revision": "2"}}` + "\n"
+
+	stream, err := cs.cli.Events(&client.EventsOptions{
+		ChangeID: "42",
+		Kinds:    []string{"task-progress", "snap-installed"},
+	})
+	c.Assert(err, check.IsNil)
+	defer stream.Close()
+	c.Check(cs.req.Method, check.Equals, "GET")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/events")
+	c.Check(cs.req.URL.Query().Get("change"), check.Equals, "42")
+	c.Check(cs.req.URL.Query().Get("kinds"), check.Equals, "task-progress,snap-installed")
+
+	ev, err := stream.Next()
+	c.Assert(err, check.IsNil)
+	c.Check(ev, check.DeepEquals, &client.Event{
+		Kind:     "task-progress",
+		Time:     time.Date(2017, 4, 21, 1, 2, 3, 0, time.UTC),
+		ChangeID: "42",
+		TaskID:   "84",
+		Progress: &client.TaskProgress{Label: "foo", Done: 1, Total: 2},
+	})
+	ev, err = stream.Next()
+	c.Assert(err, check.IsNil)
+	c.Check(ev.Kind, check.Equals, "snap-installed")
+	c.Check(ev.Data, check.DeepEquals, map[string]string{"snap": "foo", "revision": "2"})
+
+	_, err = stream.Next()
+	c.Check(err, check.Equals, io.EOF)
+}
+
+func (cs *clientSuite) TestClientEventsTruncated(c *check.C) {
+	cs.rsp = "\x1e" + `{"kind": "task-pro`
+
+	stream, err := cs.cli.Events(nil)
+	c.Assert(err, check.IsNil)
+	c.Check(cs.req.URL.RawQuery, check.Equals, "")
+	_, err = stream.Next()
+	c.Check(err, check.Equals, io.ErrUnexpectedEOF)
+}
+
+func (cs *clientSuite) TestClientEventsError(c *check.C) {
+	cs.status = http.StatusNotFound
+	cs.header = http.Header{"Content-Type": []string{"application/json"}}
+	cs.rsp = `{"type": "error", "status-code": 404, "result": {"message": "cannot find change with id \"42\""}}`
+
+	_, err := cs.cli.Events(&client.EventsOptions{ChangeID: "42"})
+	c.Check(err, check.ErrorMatches, `cannot find change with id "42"`)
+}
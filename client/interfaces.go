@@ -22,6 +22,7 @@ package client
 import (
 	"bytes"
 	"encoding/json"
+	"time"
 )
 
 // Plug represents the potential of a given snap to connect to a slot.
@@ -78,22 +79,28 @@ func (client *Client) Interfaces() (interfaces Interfaces, err error) {
 }
 
 // performInterfaceAction performs a single action on the interface system.
-func (client *Client) performInterfaceAction(sa *InterfaceAction) (changeID string, err error) {
+func (client *Client) performInterfaceAction(sa *InterfaceAction, at time.Time) (changeID string, err error) {
 	b, err := json.Marshal(sa)
 	if err != nil {
 		return "", err
 	}
-	return client.doAsync("POST", "/v2/interfaces", nil, nil, bytes.NewReader(b))
+	return client.doAsync("POST", "/v2/interfaces", atQuery(at), nil, bytes.NewReader(b))
 }
 
 // Connect establishes a connection between a plug and a slot.
 // The plug and the slot must have the same interface.
 func (client *Client) Connect(plugSnapName, plugName, slotSnapName, slotName string) (changeID string, err error) {
+	return client.ConnectAt(plugSnapName, plugName, slotSnapName, slotName, time.Time{})
+}
+
+// ConnectAt is like Connect but the change only starts at the given
+// time, unless it's zero.
+func (client *Client) ConnectAt(plugSnapName, plugName, slotSnapName, slotName string, at time.Time) (changeID string, err error) {
 	return client.performInterfaceAction(&InterfaceAction{
 		Action: "connect",
 		Plugs:  []Plug{{Snap: plugSnapName, Name: plugName}},
 		Slots:  []Slot{{Snap: slotSnapName, Name: slotName}},
-	})
+	}, at)
 }
 
 // Disconnect breaks the connection between a plug and a slot.
@@ -102,5 +109,5 @@ func (client *Client) Disconnect(plugSnapName, plugName, slotSnapName, slotName
 		Action: "disconnect",
 		Plugs:  []Plug{{Snap: plugSnapName, Name: plugName}},
 		Slots:  []Slot{{Snap: slotSnapName, Name: slotName}},
-	})
+	}, time.Time{})
 }
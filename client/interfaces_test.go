@@ -21,6 +21,7 @@ package client_test
 
 import (
 	"encoding/json"
+	"time"
 
 	"gopkg.in/check.v1"
 
@@ -101,6 +102,14 @@ func (cs *clientSuite) TestClientConnectCallsEndpoint(c *check.C) {
 	c.Check(cs.req.URL.Path, check.Equals, "/v2/interfaces")
 }
 
+func (cs *clientSuite) TestClientConnectAt(c *check.C) {
+	at := time.Date(2017, 3, 4, 3, 0, 0, 0, time.UTC)
+	cs.cli.ConnectAt("producer", "plug", "consumer", "slot", at)
+	c.Check(cs.req.Method, check.Equals, "POST")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/interfaces")
+	c.Check(cs.req.URL.Query().Get("at"), check.Equals, "2017-03-04T03:00:00Z")
+}
+
 func (cs *clientSuite) TestClientConnect(c *check.C) {
 	cs.rsp = `{
 		"type": "async",
@@ -35,6 +35,8 @@ type User struct {
 	ID       int    `json:"id,omitempty"`
 	Username string `json:"username,omitempty"`
 	Email    string `json:"email,omitempty"`
+	// Roles are the roles granted to the user, if any
+	Roles []string `json:"roles,omitempty"`
 
 	Macaroon   string   `json:"macaroon,omitempty"`
 	Discharges []string `json:"discharges,omitempty"`
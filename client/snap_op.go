@@ -25,8 +25,10 @@ import (
 	"fmt"
 	"io"
 	"mime/multipart"
+	"net/url"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 type SnapOptions struct {
@@ -37,8 +39,42 @@ type SnapOptions struct {
 	Classic          bool   `json:"classic,omitempty"`
 	Dangerous        bool   `json:"dangerous,omitempty"`
 	IgnoreValidation bool   `json:"ignore-validation,omitempty"`
+	NoRateLimit      bool   `json:"no-rate-limit,omitempty"`
+
+	// Transaction is only used by multi-snap operations.
+	Transaction TransactionType `json:"transaction,omitempty"`
+
+	// At, if set, is when the change should start instead of right away.
+	At time.Time `json:"-"`
+}
+
+// atQuery returns the query asking for the change to start at the
+// given time, or nil if it should start right away.
+func atQuery(at time.Time) url.Values {
+	if at.IsZero() {
+		return nil
+	}
+	return url.Values{"at": []string{at.Format(time.RFC3339)}}
 }
 
+func (opts *SnapOptions) query() url.Values {
+	if opts == nil {
+		return nil
+	}
+	return atQuery(opts.At)
+}
+
+// TransactionType determines how the snaps of a multi-snap operation
+// are undone when one of them fails.
+type TransactionType string
+
+const (
+	// TransactionPerSnap undoes only the snap that failed.
+	TransactionPerSnap TransactionType = "per-snap"
+	// TransactionAllSnaps undoes all the snaps if any of them fails.
+	TransactionAllSnaps TransactionType = "all-snaps"
+)
+
 func (opts *SnapOptions) writeModeFields(mw *multipart.Writer) error {
 	fields := []struct {
 		f string
@@ -66,12 +102,16 @@ type actionData struct {
 	Action   string `json:"action"`
 	Name     string `json:"name,omitempty"`
 	SnapPath string `json:"snap-path,omitempty"`
+	// Delta is only used when sending a delta file
+	Delta bool `json:"-"`
 	*SnapOptions
 }
 
 type multiActionData struct {
-	Action string   `json:"action"`
-	Snaps  []string `json:"snaps,omitempty"`
+	Action      string          `json:"action"`
+	Snaps       []string        `json:"snaps,omitempty"`
+	Transaction TransactionType `json:"transaction,omitempty"`
+	NoRateLimit bool            `json:"no-rate-limit,omitempty"`
 }
 
 // Install adds the snap with the given name from the given channel (or
@@ -111,6 +151,11 @@ func (client *Client) Disable(name string, options *SnapOptions) (changeID strin
 	return client.doSnapAction("disable", name, options)
 }
 
+// Switch moves the snap to a different channel without a refresh
+func (client *Client) Switch(name string, options *SnapOptions) (changeID string, err error) {
+	return client.doSnapAction("switch", name, options)
+}
+
 // Revert rolls the snap back to the previous on-disk state
 func (client *Client) Revert(name string, options *SnapOptions) (changeID string, err error) {
 	return client.doSnapAction("revert", name, options)
@@ -136,16 +181,24 @@ func (client *Client) doSnapAction(actionName string, snapName string, options *
 		"Content-Type": "application/json",
 	}
 
-	return client.doAsync("POST", path, nil, headers, bytes.NewBuffer(data))
+	return client.doAsync("POST", path, options.query(), headers, bytes.NewBuffer(data))
 }
 
 func (client *Client) doMultiSnapAction(actionName string, snaps []string, options *SnapOptions) (changeID string, err error) {
+	var multiOptions SnapOptions
 	if options != nil {
-		return "", fmt.Errorf("cannot use options for multi-action") // (yet)
+		multiOptions.Transaction = options.Transaction
+		multiOptions.NoRateLimit = options.NoRateLimit
+		multiOptions.At = options.At
+		if *options != multiOptions {
+			return "", fmt.Errorf("cannot use options other than transaction, no-rate-limit and at for multi-action") // (yet)
+		}
 	}
 	action := multiActionData{
-		Action: actionName,
-		Snaps:  snaps,
+		Action:      actionName,
+		Snaps:       snaps,
+		Transaction: multiOptions.Transaction,
+		NoRateLimit: multiOptions.NoRateLimit,
 	}
 	data, err := json.Marshal(&action)
 	if err != nil {
@@ -156,7 +209,7 @@ func (client *Client) doMultiSnapAction(actionName string, snaps []string, optio
 		"Content-Type": "application/json",
 	}
 
-	return client.doAsync("POST", "/v2/snaps", nil, headers, bytes.NewBuffer(data))
+	return client.doAsync("POST", "/v2/snaps", multiOptions.query(), headers, bytes.NewBuffer(data))
 }
 
 // InstallPath sideloads the snap with the given path, returning the UUID
@@ -181,7 +234,41 @@ func (client *Client) InstallPath(path string, options *SnapOptions) (changeID s
 		"Content-Type": mw.FormDataContentType(),
 	}
 
-	return client.doAsync("POST", "/v2/snaps", nil, headers, pr)
+	return client.doAsync("POST", "/v2/snaps", options.query(), headers, pr)
+}
+
+// InstallDelta installs the snap obtained by applying the delta file
+// at deltaPath to the installed revision of the named snap.
+func (client *Client) InstallDelta(name, deltaPath string, options *SnapOptions) (changeID string, err error) {
+	if options == nil {
+		options = &SnapOptions{}
+	}
+	if options.Dangerous {
+		return "", ErrDangerousNotApplicable
+	}
+
+	f, err := os.Open(deltaPath)
+	if err != nil {
+		return "", fmt.Errorf("cannot open: %q", deltaPath)
+	}
+
+	action := actionData{
+		Action:      "install",
+		Name:        name,
+		SnapPath:    deltaPath,
+		Delta:       true,
+		SnapOptions: options,
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go sendSnapFile(deltaPath, f, pw, mw, &action)
+
+	headers := map[string]string{
+		"Content-Type": mw.FormDataContentType(),
+	}
+
+	return client.doAsync("POST", "/v2/snaps", options.query(), headers, pr)
 }
 
 // Try
@@ -232,6 +319,13 @@ func sendSnapFile(snapPath string, snapFile *os.File, pw *io.PipeWriter, mw *mul
 		}
 	}
 
+	if action.Delta {
+		if err := mw.WriteField("delta", "true"); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+	}
+
 	if err := action.writeModeFields(mw); err != nil {
 		pw.CloseWithError(err)
 		return
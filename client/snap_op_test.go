@@ -28,6 +28,7 @@ import (
 	"mime"
 	"mime/multipart"
 	"path/filepath"
+	"time"
 
 	"gopkg.in/check.v1"
 
@@ -46,6 +47,7 @@ var ops = []struct {
 	{(*client.Client).Revert, "revert"},
 	{(*client.Client).Enable, "enable"},
 	{(*client.Client).Disable, "disable"},
+	{(*client.Client).Switch, "switch"},
 }
 
 var multiOps = []struct {
@@ -171,6 +173,80 @@ func (cs *clientSuite) TestClientMultiOpSnap(c *check.C) {
 	}
 }
 
+func (cs *clientSuite) TestClientMultiOpSnapTransaction(c *check.C) {
+	cs.rsp = `{
+		"change": "d728",
+		"status-code": 202,
+		"type": "async"
+	}`
+	for _, s := range multiOps {
+		_, err := s.op(cs.cli, []string{pkgName, "other"}, &client.SnapOptions{Transaction: client.TransactionAllSnaps})
+		c.Assert(err, check.IsNil)
+
+		body, err := ioutil.ReadAll(cs.req.Body)
+		c.Assert(err, check.IsNil, check.Commentf(s.action))
+		jsonBody := make(map[string]interface{})
+		err = json.Unmarshal(body, &jsonBody)
+		c.Assert(err, check.IsNil, check.Commentf(s.action))
+		c.Check(jsonBody["action"], check.Equals, s.action, check.Commentf(s.action))
+		c.Check(jsonBody["transaction"], check.Equals, "all-snaps", check.Commentf(s.action))
+		c.Check(jsonBody, check.HasLen, 3, check.Commentf(s.action))
+	}
+}
+
+func (cs *clientSuite) TestClientMultiOpSnapNoRateLimit(c *check.C) {
+	cs.rsp = `{
+		"change": "d728",
+		"status-code": 202,
+		"type": "async"
+	}`
+	for _, s := range multiOps {
+		_, err := s.op(cs.cli, []string{pkgName, "other"}, &client.SnapOptions{NoRateLimit: true})
+		c.Assert(err, check.IsNil)
+
+		body, err := ioutil.ReadAll(cs.req.Body)
+		c.Assert(err, check.IsNil, check.Commentf(s.action))
+		jsonBody := make(map[string]interface{})
+		err = json.Unmarshal(body, &jsonBody)
+		c.Assert(err, check.IsNil, check.Commentf(s.action))
+		c.Check(jsonBody["no-rate-limit"], check.Equals, true, check.Commentf(s.action))
+		c.Check(jsonBody, check.HasLen, 3, check.Commentf(s.action))
+	}
+}
+
+func (cs *clientSuite) TestClientOpSnapAt(c *check.C) {
+	cs.rsp = `{
+		"change": "d728",
+		"status-code": 202,
+		"type": "async"
+	}`
+	at := time.Date(2017, 3, 4, 3, 0, 0, 0, time.UTC)
+	for _, s := range ops {
+		_, err := s.op(cs.cli, pkgName, &client.SnapOptions{At: at})
+		c.Assert(err, check.IsNil, check.Commentf(s.action))
+		c.Check(cs.req.URL.Query().Get("at"), check.Equals, "2017-03-04T03:00:00Z", check.Commentf(s.action))
+
+		body, err := ioutil.ReadAll(cs.req.Body)
+		c.Assert(err, check.IsNil, check.Commentf(s.action))
+		jsonBody := make(map[string]interface{})
+		err = json.Unmarshal(body, &jsonBody)
+		c.Assert(err, check.IsNil, check.Commentf(s.action))
+		c.Check(jsonBody, check.HasLen, 1, check.Commentf(s.action))
+	}
+	for _, s := range multiOps {
+		_, err := s.op(cs.cli, []string{pkgName, "other"}, &client.SnapOptions{At: at})
+		c.Assert(err, check.IsNil, check.Commentf(s.action))
+		c.Check(cs.req.URL.Query().Get("at"), check.Equals, "2017-03-04T03:00:00Z", check.Commentf(s.action))
+	}
+}
+
+func (cs *clientSuite) TestClientMultiOpSnapOtherOptions(c *check.C) {
+	for _, s := range multiOps {
+		_, err := s.op(cs.cli, []string{pkgName}, &client.SnapOptions{Channel: chanName, Transaction: client.TransactionAllSnaps})
+		c.Check(err, check.ErrorMatches, "cannot use options other than transaction, no-rate-limit and at for multi-action", check.Commentf(s.action))
+	}
+}
+
 func (cs *clientSuite) TestClientOpInstallPath(c *check.C) {
 	cs.rsp = `{
 		"change": "66b3",
@@ -198,6 +274,40 @@ func (cs *clientSuite) TestClientOpInstallPath(c *check.C) {
 	c.Check(id, check.Equals, "66b3")
 }
 
+func (cs *clientSuite) TestClientOpInstallDelta(c *check.C) {
+	cs.rsp = `{
+		"change": "66b3",
+		"status-code": 202,
+		"type": "async"
+	}`
+	bodyData := []byte("delta-data")
+
+	delta := filepath.Join(c.MkDir(), "foo_2.delta")
+	err := ioutil.WriteFile(delta, bodyData, 0644)
+	c.Assert(err, check.IsNil)
+
+	id, err := cs.cli.InstallDelta("foo", delta, nil)
+	c.Assert(err, check.IsNil)
+
+	body, err := ioutil.ReadAll(cs.req.Body)
+	c.Assert(err, check.IsNil)
+
+	c.Assert(string(body), check.Matches, "(?s).*\r\ndelta-data\r\n.*")
+	c.Assert(string(body), check.Matches, "(?s).*Content-Disposition: form-data; name=\"action\"\r\n\r\ninstall\r\n.*")
+	c.Assert(string(body), check.Matches, "(?s).*Content-Disposition: form-data; name=\"name\"\r\n\r\nfoo\r\n.*")
+	c.Assert(string(body), check.Matches, "(?s).*Content-Disposition: form-data; name=\"delta\"\r\n\r\ntrue\r\n.*")
+
+	c.Check(cs.req.Method, check.Equals, "POST")
+	c.Check(cs.req.URL.Path, check.Equals, fmt.Sprintf("/v2/snaps"))
+	c.Assert(cs.req.Header.Get("Content-Type"), check.Matches, "multipart/form-data; boundary=.*")
+	c.Check(id, check.Equals, "66b3")
+}
+
+func (cs *clientSuite) TestClientOpInstallDeltaDangerous(c *check.C) {
+	_, err := cs.cli.InstallDelta("foo", "foo_2.delta", &client.SnapOptions{Dangerous: true})
+	c.Assert(err, check.Equals, client.ErrDangerousNotApplicable)
+}
+
 func (cs *clientSuite) TestClientOpInstallDangerous(c *check.C) {
 	cs.rsp = `{
 		"change": "66b3",
@@ -0,0 +1,105 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"time"
+)
+
+// Warning is a problem that snapd wants to make the user aware of.
+type Warning struct {
+	Message     string        `json:"message"`
+	FirstAdded  time.Time     `json:"first-added"`
+	LastAdded   time.Time     `json:"last-added"`
+	LastShown   time.Time     `json:"last-shown"`
+	ExpireAfter time.Duration `json:"expire-after,omitempty"`
+	RepeatAfter time.Duration `json:"repeat-after,omitempty"`
+}
+
+// UnmarshalJSON decodes the durations, which snapd sends as strings.
+func (w *Warning) UnmarshalJSON(data []byte) error {
+	type plainWarning Warning
+	var aux struct {
+		*plainWarning
+		ExpireAfter string `json:"expire-after,omitempty"`
+		RepeatAfter string `json:"repeat-after,omitempty"`
+	}
+	aux.plainWarning = (*plainWarning)(w)
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	var err error
+	if aux.ExpireAfter != "" {
+		if w.ExpireAfter, err = time.ParseDuration(aux.ExpireAfter); err != nil {
+			return err
+		}
+	}
+	if aux.RepeatAfter != "" {
+		if w.RepeatAfter, err = time.ParseDuration(aux.RepeatAfter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WarningsOptions select the warnings to list.
+type WarningsOptions struct {
+	// All asks for all the warnings, not only the pending ones
+	All bool
+}
+
+// Warnings returns the warnings known to snapd; by default only the
+// ones that have not been okayed yet.
+func (client *Client) Warnings(opts WarningsOptions) ([]*Warning, error) {
+	var warnings []*Warning
+	q := url.Values{}
+	if opts.All {
+		q.Set("select", "all")
+	}
+	_, err := client.doSync("GET", "/v2/warnings", q, nil, nil, &warnings)
+	return warnings, err
+}
+
+// Okay asks snapd to mark as seen the warnings that were last added no
+// later than t.
+func (client *Client) Okay(t time.Time) error {
+	var postData struct {
+		Action    string    `json:"action"`
+		Timestamp time.Time `json:"timestamp"`
+	}
+	postData.Action = "okay"
+	postData.Timestamp = t
+
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(postData); err != nil {
+		return err
+	}
+	_, err := client.doSync("POST", "/v2/warnings", nil, nil, &body, nil)
+	return err
+}
+
+// WarningsSummary returns how many warnings were pending, and when the
+// last of them was added, as of the last response from snapd.
+func (client *Client) WarningsSummary() (count int, timestamp time.Time) {
+	return client.warningCount, client.warningTimestamp
+}
@@ -0,0 +1,97 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package client_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+)
+
+func (cs *clientSuite) TestWarnings(c *check.C) {
+	cs.rsp = `{
+		"type": "sync",
+		"result": [{
+			"message": "cannot auto-refresh snaps: boom",
+			"first-added": "2017-10-01T12:00:00Z",
+			"last-added": "2017-10-02T12:00:00Z",
+			"expire-after": "672h0m0s",
+			"repeat-after": "24h0m0s"
+		}]
+	}`
+	warnings, err := cs.cli.Warnings(client.WarningsOptions{})
+	c.Assert(err, check.IsNil)
+	c.Check(cs.req.Method, check.Equals, "GET")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/warnings")
+	c.Check(cs.req.URL.Query().Get("select"), check.Equals, "")
+	c.Check(warnings, check.DeepEquals, []*client.Warning{{
+		Message:     "cannot auto-refresh snaps: boom",
+		FirstAdded:  time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC),
+		LastAdded:   time.Date(2017, 10, 2, 12, 0, 0, 0, time.UTC),
+		ExpireAfter: 28 * 24 * time.Hour,
+		RepeatAfter: 24 * time.Hour,
+	}})
+}
+
+func (cs *clientSuite) TestWarningsAll(c *check.C) {
+	cs.rsp = `{"type": "sync", "result": []}`
+	_, err := cs.cli.Warnings(client.WarningsOptions{All: true})
+	c.Assert(err, check.IsNil)
+	c.Check(cs.req.URL.Query().Get("select"), check.Equals, "all")
+}
+
+func (cs *clientSuite) TestOkay(c *check.C) {
+	cs.rsp = `{"type": "sync", "result": 1}`
+	t0 := time.Date(2017, 10, 2, 12, 0, 0, 0, time.UTC)
+	c.Assert(cs.cli.Okay(t0), check.IsNil)
+	c.Check(cs.req.Method, check.Equals, "POST")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/warnings")
+
+	data, err := ioutil.ReadAll(cs.req.Body)
+	c.Assert(err, check.IsNil)
+	var body map[string]interface{}
+	c.Assert(json.Unmarshal(data, &body), check.IsNil)
+	c.Check(body, check.DeepEquals, map[string]interface{}{
+		"action":    "okay",
+		"timestamp": "2017-10-02T12:00:00Z",
+	})
+}
+
+func (cs *clientSuite) TestWarningsSummary(c *check.C) {
+	count, stamp := cs.cli.WarningsSummary()
+	c.Check(count, check.Equals, 0)
+	c.Check(stamp.IsZero(), check.Equals, true)
+
+	cs.rsp = `{
+		"type": "sync",
+		"result": {},
+		"warning-count": 2,
+		"warning-timestamp": "2017-10-02T12:00:00Z"
+	}`
+	_, err := cs.cli.SysInfo()
+	c.Assert(err, check.IsNil)
+	count, stamp = cs.cli.WarningsSummary()
+	c.Check(count, check.Equals, 2)
+	c.Check(stamp, check.DeepEquals, time.Date(2017, 10, 2, 12, 0, 0, 0, time.UTC))
+}
@@ -0,0 +1,118 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/httputil"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/store"
+	"github.com/snapcore/snapd/store/mirror"
+)
+
+var (
+	Stdout io.Writer = os.Stdout
+
+	// for the tests
+	mirrorImport = mirror.Import
+)
+
+type cmdServe struct {
+	Dir            string `long:"dir" required:"yes" description:"Directory of the mirror"`
+	Addr           string `long:"addr" default:"localhost:11028" description:"Address to serve on"`
+	AssertFallback bool   `long:"assert-fallback" description:"Fetch the assertions missing from the mirror from the main store"`
+}
+
+func (x *cmdServe) Execute(args []string) error {
+	var fallback *store.Store
+	if x.AssertFallback {
+		httputil.SetUserAgentFromVersion("unknown", "snap-store-mirror")
+		fallback = store.New(nil, nil)
+	}
+
+	m := mirror.New(x.Dir, x.Addr, fallback)
+	if err := m.Start(); err != nil {
+		return err
+	}
+	logger.Noticef("serving %s on %s", x.Dir, m.URL())
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+	<-ch
+
+	return m.Stop()
+}
+
+type cmdImport struct {
+	Dir        string   `long:"dir" required:"yes" description:"Directory of the mirror"`
+	Channels   []string `long:"channel" description:"Channel to release the snaps to, can be repeated"`
+	Positional struct {
+		Snaps []string `positional-arg-name:"<snap file>" required:"1"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (x *cmdImport) Execute(args []string) error {
+	channels := x.Channels
+	if len(channels) == 0 {
+		channels = []string{"stable"}
+	}
+	for _, snapPath := range x.Positional.Snaps {
+		si, err := mirrorImport(x.Dir, snapPath, append([]string(nil), channels...))
+		if err != nil {
+			return fmt.Errorf("cannot import %q: %v", snapPath, err)
+		}
+		fmt.Fprintf(Stdout, "Imported %s revision %s\n", si.RealName, si.Revision)
+	}
+	return nil
+}
+
+func parser() *flags.Parser {
+	p := flags.NewParser(nil, flags.HelpFlag|flags.PassDoubleDash)
+	p.AddCommand("serve", "Serve a mirror", "Serve the snaps and assertions of a mirror directory to snapd, which uses it once its store.url option points to it.", &cmdServe{})
+	p.AddCommand("import", "Import snaps into a mirror", "Import snaps and the assertions saved next to them by snap download into a mirror directory.", &cmdImport{})
+	return p
+}
+
+func run(args []string) error {
+	_, err := parser().ParseArgs(args)
+	return err
+}
+
+func main() {
+	if err := logger.SimpleSetup(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to activate logging: %v\n", err)
+	}
+
+	if err := run(os.Args[1:]); err != nil {
+		if e, ok := err.(*flags.Error); ok && e.Type == flags.ErrHelp {
+			fmt.Fprintln(Stdout, err)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,92 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/store/mirror"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type mirrorCmdSuite struct {
+	stdout *bytes.Buffer
+}
+
+var _ = Suite(&mirrorCmdSuite{})
+
+func (s *mirrorCmdSuite) SetUpTest(c *C) {
+	s.stdout = &bytes.Buffer{}
+	Stdout = s.stdout
+}
+
+func (s *mirrorCmdSuite) TearDownTest(c *C) {
+	Stdout = os.Stdout
+	mirrorImport = mirror.Import
+}
+
+func (s *mirrorCmdSuite) TestImport(c *C) {
+	var calls []string
+	mirrorImport = func(dir, snapPath string, channels []string) (*snap.SideInfo, error) {
+		c.Check(dir, Equals, "/srv/mirror")
+		c.Check(channels, DeepEquals, []string{"stable", "beta"})
+		calls = append(calls, snapPath)
+		name := filepath.Base(snapPath)
+		return &snap.SideInfo{RealName: name[:len(name)-len("_1.snap")], Revision: snap.R(1)}, nil
+	}
+
+	err := run([]string{"import", "--dir", "/srv/mirror", "--channel", "stable", "--channel", "beta", "foo_1.snap", "bar_1.snap"})
+	c.Assert(err, IsNil)
+	c.Check(calls, DeepEquals, []string{"foo_1.snap", "bar_1.snap"})
+	c.Check(s.stdout.String(), Equals, "Imported foo revision 1\nImported bar revision 1\n")
+}
+
+func (s *mirrorCmdSuite) TestImportDefaultsToStable(c *C) {
+	mirrorImport = func(dir, snapPath string, channels []string) (*snap.SideInfo, error) {
+		c.Check(channels, DeepEquals, []string{"stable"})
+		return &snap.SideInfo{RealName: "foo", Revision: snap.R(1)}, nil
+	}
+
+	err := run([]string{"import", "--dir", "/srv/mirror", "foo_1.snap"})
+	c.Assert(err, IsNil)
+}
+
+func (s *mirrorCmdSuite) TestImportError(c *C) {
+	mirrorImport = func(dir, snapPath string, channels []string) (*snap.SideInfo, error) {
+		return nil, errors.New("boom")
+	}
+
+	err := run([]string{"import", "--dir", "/srv/mirror", "foo_1.snap"})
+	c.Check(err, ErrorMatches, `cannot import "foo_1.snap": boom`)
+}
+
+func (s *mirrorCmdSuite) TestImportNeedsDir(c *C) {
+	err := run([]string{"import", "foo_1.snap"})
+	c.Check(err, ErrorMatches, ".*--dir.*")
+}
@@ -0,0 +1,61 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/i18n"
+)
+
+type cmdApprove struct {
+	Positional struct {
+		ID changeID
+	} `positional-args:"yes" required:"yes"`
+}
+
+var shortApproveHelp = i18n.G("Approve a change waiting for approval")
+
+var longApproveHelp = i18n.G(`
+The approve command lets a change proceed that waits for approval, such
+as a kernel refresh when refresh.kernel-approval is set.
+`)
+
+func init() {
+	addCommand("approve",
+		shortApproveHelp,
+		longApproveHelp,
+		func() flags.Commander {
+			return &cmdApprove{}
+		},
+		nil,
+		[]argDesc{{name: i18n.G("<change-id>")}},
+	)
+}
+
+func (x *cmdApprove) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	cli := Client()
+	_, err := cli.Approve(string(x.Positional.ID))
+	return err
+}
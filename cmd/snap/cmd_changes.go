@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"regexp"
 	"sort"
+	"strconv"
 	"time"
 
 	"github.com/snapcore/snapd/client"
@@ -34,11 +35,23 @@ import (
 var shortChangesHelp = i18n.G("List system changes")
 var shortChangeHelp = i18n.G("List a change's tasks")
 var longChangesHelp = i18n.G(`
-The changes command displays a summary of the recent system changes performed.`)
+The changes command displays a summary of the recent system changes performed.
+
+With --history it displays instead the record kept of the older changes,
+optionally limited to the ones started between the dates given with --since
+and --until, as YYYY-MM-DD or in RFC 3339 format.`)
 var longChangeHelp = i18n.G(`
 The change command displays a summary of tasks associated to an individual change.`)
+var shortTasksHelp = i18n.G("List a change's tasks")
+var longTasksHelp = i18n.G(`
+The tasks command displays a summary of tasks associated to an individual change,
+like the change command, including what the waiting tasks wait for.`)
 
 type cmdChanges struct {
+	History bool   `long:"history"`
+	Since   string `long:"since"`
+	Until   string `long:"until"`
+
 	Positional struct {
 		Snap string `positional-arg-name:"<snap>"`
 	} `positional-args:"yes"`
@@ -51,8 +64,13 @@ type cmdChange struct {
 }
 
 func init() {
-	addCommand("changes", shortChangesHelp, longChangesHelp, func() flags.Commander { return &cmdChanges{} }, nil, nil)
+	addCommand("changes", shortChangesHelp, longChangesHelp, func() flags.Commander { return &cmdChanges{} }, map[string]string{
+		"history": i18n.G("Show the history of older changes"),
+		"since":   i18n.G("Show only the history of changes started at or after this date"),
+		"until":   i18n.G("Show only the history of changes started before the end of this date"),
+	}, nil)
 	addCommand("change", shortChangeHelp, longChangeHelp, func() flags.Commander { return &cmdChange{} }, nil, nil)
+	addCommand("tasks", shortTasksHelp, longTasksHelp, func() flags.Commander { return &cmdChange{} }, nil, nil)
 }
 
 type changesByTime []*client.Change
@@ -78,6 +96,13 @@ func (c *cmdChanges) Execute(args []string) error {
 		return nil
 	}
 
+	if c.History {
+		return c.showHistory()
+	}
+	if c.Since != "" || c.Until != "" {
+		return fmt.Errorf(i18n.G("--since and --until can only be used with --history"))
+	}
+
 	opts := client.ChangesOptions{
 		SnapName: c.Positional.Snap,
 		Selector: client.ChangesAll,
@@ -104,7 +129,76 @@ func (c *cmdChanges) Execute(args []string) error {
 		if chg.ReadyTime.IsZero() {
 			readyTime = "-"
 		}
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", chg.ID, chg.Status, spawnTime, readyTime, chg.Summary)
+		summary := chg.Summary
+		if !chg.ScheduledTime.IsZero() {
+			summary = fmt.Sprintf(i18n.G("%s (scheduled for %s)"), summary, chg.ScheduledTime.UTC().Format(time.RFC3339))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", chg.ID, chg.Status, spawnTime, readyTime, summary)
+	}
+
+	w.Flush()
+	fmt.Fprintln(Stdout)
+
+	return nil
+}
+
+// parseHistoryDate parses a date given as YYYY-MM-DD, in local time,
+// or in RFC 3339 format. With endOfDay a bare date is taken as the end
+// of that day.
+func parseHistoryDate(s string, endOfDay bool) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf(i18n.G("cannot parse date %q: expected YYYY-MM-DD or RFC 3339 format"), s)
+	}
+	return t, nil
+}
+
+func (c *cmdChanges) showHistory() error {
+	opts := client.ChangeHistoryOptions{
+		SnapName: c.Positional.Snap,
+	}
+	var err error
+	if c.Since != "" {
+		if opts.Since, err = parseHistoryDate(c.Since, false); err != nil {
+			return err
+		}
+	}
+	if c.Until != "" {
+		if opts.Until, err = parseHistoryDate(c.Until, true); err != nil {
+			return err
+		}
+	}
+
+	cli := Client()
+	entries, err := cli.ChangeHistory(&opts)
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		return fmt.Errorf(i18n.G("no changes found in the history"))
+	}
+
+	w := tabWriter()
+
+	fmt.Fprintf(w, i18n.G("ID\tStatus\tSpawn\tReady\tUID\tSummary\n"))
+	for _, e := range entries {
+		spawnTime := e.SpawnTime.UTC().Format(time.RFC3339)
+		readyTime := e.ReadyTime.UTC().Format(time.RFC3339)
+		if e.ReadyTime.IsZero() {
+			readyTime = "-"
+		}
+		uid := "-"
+		if e.UID != nil {
+			uid = strconv.FormatUint(uint64(*e.UID), 10)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", e.ID, e.Status, spawnTime, readyTime, uid, e.Summary)
 	}
 
 	w.Flush()
@@ -133,6 +227,10 @@ func (c *cmdChange) Execute([]string) error {
 		if t.Status == "Doing" && t.Progress.Total > 1 {
 			summary = fmt.Sprintf("%s (%.2f%%)", summary, float64(t.Progress.Done)/float64(t.Progress.Total)*100.0)
 		}
+		if t.Status == "Wait" && t.WaitReason != "" {
+			// TRANSLATORS: the first %s is the task summary, the second what it waits for
+			summary = fmt.Sprintf(i18n.G("%s (waiting for %s)"), summary, t.WaitReason)
+		}
 		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", t.Status, spawnTime, readyTime, summary)
 	}
 
@@ -22,6 +22,7 @@ package main_test
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"gopkg.in/check.v1"
 
@@ -95,3 +96,135 @@ Doing +2016-04-21T01:02:03Z +2016-04-21T01:02:04Z +some summary \(50.00%\)
 `)
 	c.Check(s.Stderr(), check.Equals, "")
 }
+
+var mockChangeWaitingJSON = `{"type": "sync", "result": {
+  "id":   "uno",
+  "kind": "refresh-snap",
+  "summary": "...",
+  "status": "Wait",
+  "ready": false,
+  "spawn-time": "2016-04-21T01:02:03Z",
+  "tasks": [{"kind": "wait-approval", "summary": "Wait for approval", "status": "Wait", "wait-reason": "change approval", "progress": {"done": 1, "total": 1}, "spawn-time": "2016-04-21T01:02:03Z"}]
+}}`
+
+func (s *SnapSuite) TestChangeWaiting(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, check.Equals, "GET")
+		c.Check(r.URL.Path, check.Equals, "/v2/changes/42")
+		fmt.Fprintln(w, mockChangeWaitingJSON)
+	})
+	rest, err := snap.Parser().ParseArgs([]string{"tasks", "42"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), check.Matches, `(?ms)Status +Spawn +Ready +Summary
+Wait +2016-04-21T01:02:03Z +- +Wait for approval \(waiting for change approval\)
+`)
+	c.Check(s.Stderr(), check.Equals, "")
+}
+
+func (s *SnapSuite) TestChangesScheduled(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, check.Equals, "GET")
+		c.Check(r.URL.Path, check.Equals, "/v2/changes")
+		fmt.Fprintln(w, `{"type": "sync", "result": [{
+  "id": "42",
+  "kind": "refresh-snap",
+  "summary": "Refresh snap \"foo\"",
+  "status": "Do",
+  "spawn-time": "2017-03-03T15:02:03Z",
+  "scheduled-time": "2017-03-04T03:00:00Z"
+}]}`)
+	})
+	rest, err := snap.Parser().ParseArgs([]string{"changes"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), check.Matches, `(?ms)ID +Status +Spawn +Ready +Summary
+42 +Do +2017-03-03T15:02:03Z +- +Refresh snap "foo" \(scheduled for 2017-03-04T03:00:00Z\)
+`)
+}
+
+func (s *SnapSuite) TestApprove(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, check.Equals, "POST")
+		c.Check(r.URL.Path, check.Equals, "/v2/changes/42")
+		c.Check(DecodedRequestBody(c, r), check.DeepEquals, map[string]interface{}{
+			"action": "approve",
+		})
+		fmt.Fprintln(w, `{"type": "sync", "result": {"id": "42", "status": "Doing"}}`)
+		n++
+	})
+	rest, err := snap.Parser().ParseArgs([]string{"approve", "42"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(n, check.Equals, 1)
+}
+
+var mockChangeHistoryJSON = `{"type": "sync", "result": [{
+  "id":   "7",
+  "kind": "remove-snap",
+  "summary": "Remove snap \"foo\"",
+  "status": "Done",
+  "uid": 1000,
+  "snap-names": ["foo"],
+  "spawn-time": "2016-04-21T01:02:03Z",
+  "ready-time": "2016-04-21T01:02:04Z"
+}, {
+  "id":   "9",
+  "kind": "auto-refresh",
+  "summary": "Auto-refresh snap \"foo\"",
+  "status": "Error",
+  "snap-names": ["foo"],
+  "spawn-time": "2016-04-22T01:02:03Z",
+  "ready-time": "2016-04-22T01:02:04Z"
+}]}`
+
+func (s *SnapSuite) TestChangesHistory(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			c.Check(r.Method, check.Equals, "GET")
+			c.Check(r.URL.Path, check.Equals, "/v2/changes")
+			q := r.URL.Query()
+			c.Check(q.Get("select"), check.Equals, "history")
+			c.Check(q.Get("for"), check.Equals, "foo")
+			c.Check(q.Get("since"), check.Equals, "2016-04-01T00:00:00Z")
+			until, err := time.Parse(time.RFC3339, q.Get("until"))
+			c.Check(err, check.IsNil)
+			c.Check(until.Equal(time.Date(2016, 5, 1, 0, 0, 0, 0, time.Local)), check.Equals, true)
+			fmt.Fprintln(w, mockChangeHistoryJSON)
+		default:
+			c.Fatalf("expected to get 1 requests, now on %d", n+1)
+		}
+
+		n++
+	})
+	rest, err := snap.Parser().ParseArgs([]string{"changes", "--history", "--since", "2016-04-01T00:00:00Z", "--until", "2016-04-30", "foo"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), check.Matches, `(?ms)ID +Status +Spawn +Ready +UID +Summary
+7 +Done +2016-04-21T01:02:03Z +2016-04-21T01:02:04Z +1000 +Remove snap "foo"
+9 +Error +2016-04-22T01:02:03Z +2016-04-22T01:02:04Z +- +Auto-refresh snap "foo"
+`)
+	c.Check(s.Stderr(), check.Equals, "")
+}
+
+func (s *SnapSuite) TestChangesHistoryEmpty(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"type": "sync", "result": []}`)
+	})
+	_, err := snap.Parser().ParseArgs([]string{"changes", "--history"})
+	c.Assert(err, check.ErrorMatches, "no changes found in the history")
+}
+
+func (s *SnapSuite) TestChangesHistoryErrors(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Fatalf("unexpected request")
+	})
+	_, err := snap.Parser().ParseArgs([]string{"changes", "--since", "2016-04-01"})
+	c.Assert(err, check.ErrorMatches, "--since and --until can only be used with --history")
+
+	_, err = snap.Parser().ParseArgs([]string{"changes", "--history", "--until", "last week"})
+	c.Assert(err, check.ErrorMatches, `cannot parse date "last week": expected YYYY-MM-DD or RFC 3339 format`)
+}
@@ -26,6 +26,9 @@ import (
 )
 
 type cmdConnect struct {
+	waitMixin
+	scheduleMixin
+
 	Positionals struct {
 		PlugSpec connectPlugSpec `required:"yes"`
 		SlotSpec SnapAndName
@@ -56,7 +59,7 @@ the plug name.
 func init() {
 	addCommand("connect", shortConnectHelp, longConnectHelp, func() flags.Commander {
 		return &cmdConnect{}
-	}, nil, []argDesc{
+	}, waitDescs.also(scheduleDescs), []argDesc{
 		{name: i18n.G("<snap>:<plug>")},
 		{name: i18n.G("<snap>:<slot>")},
 	})
@@ -74,12 +77,20 @@ func (x *cmdConnect) Execute(args []string) error {
 		x.Positionals.PlugSpec.Snap = ""
 	}
 
+	at, err := x.schedule(&x.waitMixin)
+	if err != nil {
+		return err
+	}
+
 	cli := Client()
-	id, err := cli.Connect(x.Positionals.PlugSpec.Snap, x.Positionals.PlugSpec.Name, x.Positionals.SlotSpec.Snap, x.Positionals.SlotSpec.Name)
+	id, err := cli.ConnectAt(x.Positionals.PlugSpec.Snap, x.Positionals.PlugSpec.Name, x.Positionals.SlotSpec.Snap, x.Positionals.SlotSpec.Name, at)
 	if err != nil {
 		return err
 	}
 
-	_, err = wait(cli, id)
+	_, err = x.wait(cli, id)
+	if err == noWait {
+		return nil
+	}
 	return err
 }
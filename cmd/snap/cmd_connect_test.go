@@ -30,7 +30,7 @@ import (
 
 func (s *SnapSuite) TestConnectHelp(c *C) {
 	msg := `Usage:
-  snap.test [OPTIONS] connect [<snap>:<plug>] [<snap>:<slot>]
+  snap.test [OPTIONS] connect [connect-OPTIONS] [<snap>:<plug>] [<snap>:<slot>]
 
 The connect command connects a plug to a slot.
 It may be called in the following ways:
@@ -55,6 +55,10 @@ Application Options:
 
 Help Options:
   -h, --help               Show this help message
+
+[connect command options]
+          --at=            Only start the operation at the given time (HH:MM,
+                           YYYY-MM-DD HH:MM or RFC 3339)
 `
 	rest, err := Parser().ParseArgs([]string{"connect", "--help"})
 	c.Assert(err.Error(), Equals, msg)
@@ -94,6 +98,22 @@ func (s *SnapSuite) TestConnectExplicitEverything(c *C) {
 	c.Assert(rest, DeepEquals, []string{})
 }
 
+func (s *SnapSuite) TestConnectAt(c *C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, "POST")
+		c.Check(r.URL.Path, Equals, "/v2/interfaces")
+		c.Check(r.URL.Query().Get("at"), Equals, "2017-03-04T03:00:00Z")
+		fmt.Fprintln(w, `{"type":"async", "status-code": 202, "change": "zzz"}`)
+		n++
+	})
+	rest, err := Parser().ParseArgs([]string{"connect", "--at", "2017-03-04T03:00:00Z", "producer:plug", "consumer:slot"})
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, []string{})
+	c.Check(s.Stdout(), Equals, "Change zzz scheduled for 2017-03-04T03:00:00Z\n")
+	c.Check(n, Equals, 1)
+}
+
 func (s *SnapSuite) TestConnectExplicitPlugImplicitSlot(c *C) {
 	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
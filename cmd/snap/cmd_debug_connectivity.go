@@ -0,0 +1,86 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/i18n"
+)
+
+type cmdDebugConnectivity struct{}
+
+var shortDebugConnectivityHelp = i18n.G("Checks whether the store can be reached")
+var longDebugConnectivityHelp = i18n.G(`
+The connectivity command asks snapd to probe the endpoints it uses to
+talk to the store, through the configured proxies, and reports for each
+whether its host could be looked up (DNS), whether a TLS connection
+could be made and which HTTP status it replied with.
+`)
+
+func init() {
+	addDebugCommand("connectivity", shortDebugConnectivityHelp, longDebugConnectivityHelp, func() flags.Commander {
+		return &cmdDebugConnectivity{}
+	}, nil, nil)
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func (x *cmdDebugConnectivity) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	statuses, err := Client().DebugConnectivity()
+	if err != nil {
+		return err
+	}
+
+	w := tabWriter()
+	fmt.Fprintln(w, i18n.G("Endpoint\tURL\tDNS\tTLS\tHTTP\tNotes"))
+	failed := 0
+	for _, st := range statuses {
+		httpStatus := "-"
+		if st.HTTPStatus != 0 {
+			httpStatus = fmt.Sprintf("%d", st.HTTPStatus)
+		}
+		notes := st.Error
+		if notes == "" && st.Proxy != "" {
+			notes = fmt.Sprintf(i18n.G("via proxy %s"), st.Proxy)
+		}
+		if st.Error != "" {
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", st.Endpoint, dashIfEmpty(st.URL), dashIfEmpty(st.DNS), dashIfEmpty(st.TLS), httpStatus, dashIfEmpty(notes))
+	}
+	w.Flush()
+
+	if failed > 0 {
+		return fmt.Errorf(i18n.G("cannot reach %d of %d store endpoints"), failed, len(statuses))
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"net/http"
+
+	. "gopkg.in/check.v1"
+
+	snap "github.com/snapcore/snapd/cmd/snap"
+)
+
+func (s *SnapSuite) TestDebugConnectivity(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, "GET")
+		c.Check(r.URL.Path, Equals, "/v2/debug")
+		c.Check(r.URL.Query().Get("aspect"), Equals, "connectivity")
+		EncodeResponseBody(c, w, map[string]interface{}{
+			"type": "sync",
+			"result": []map[string]interface{}{
+				{"endpoint": "search", "url": "https://search.example.com/", "dns": "ok", "tls": "ok", "http-status": 200},
+				{"endpoint": "download", "url": "http://download.example.com/", "proxy": "http://proxy:3128", "dns": "ok", "http-status": 302},
+			},
+		})
+	})
+	rest, err := snap.Parser().ParseArgs([]string{"debug", "connectivity"})
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, []string{})
+	c.Check(s.Stdout(), Equals, `Endpoint  URL                           DNS  TLS  HTTP  Notes
+search    https://search.example.com/   ok   ok   200   -
+download  http://download.example.com/  ok   -    302   via proxy http://proxy:3128
+`)
+	c.Check(s.Stderr(), Equals, "")
+}
+
+func (s *SnapSuite) TestDebugConnectivityFailures(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		EncodeResponseBody(c, w, map[string]interface{}{
+			"type": "sync",
+			"result": []map[string]interface{}{
+				{"endpoint": "search", "url": "https://search.example.com/", "dns": "failed", "error": "cannot resolve"},
+				{"endpoint": "download", "error": "cannot find the download host"},
+			},
+		})
+	})
+	_, err := snap.Parser().ParseArgs([]string{"debug", "connectivity"})
+	c.Assert(err, ErrorMatches, "cannot reach 2 of 2 store endpoints")
+	c.Check(s.Stdout(), Equals, `Endpoint  URL                          DNS     TLS  HTTP  Notes
+search    https://search.example.com/  failed  -    -     cannot resolve
+download  -                            -       -    -     cannot find the download host
+`)
+}
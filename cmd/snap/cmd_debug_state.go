@@ -0,0 +1,322 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/i18n"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+)
+
+type cmdDebugState struct {
+	Change string `long:"change"`
+	Task   string `long:"task"`
+	Dot    bool   `long:"dot"`
+
+	Positional struct {
+		StateFile string `positional-arg-name:"<state-file>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+var shortDebugStateHelp = i18n.G("Inspects a snapd state file")
+var longDebugStateHelp = i18n.G(`
+The state command loads the given snapd state file, such as one copied
+from a broken device, without talking to snapd, and shows its changes,
+snaps, connections and configuration.
+
+With --change it shows the tasks of the given change and their logs
+instead, and with --task the details of the given task. Adding --dot to
+--change outputs the graph of the tasks waiting for each other in the
+dot format, for graphviz.
+`)
+
+func init() {
+	addDebugCommand("state", shortDebugStateHelp, longDebugStateHelp, func() flags.Commander {
+		return &cmdDebugState{}
+	}, map[string]string{
+		"change": i18n.G("Show the tasks of the change with the given ID"),
+		"task":   i18n.G("Show the task with the given ID"),
+		"dot":    i18n.G("Output the graph of the tasks of the change in the dot format"),
+	}, []argDesc{{
+		name: i18n.G("<state-file>"),
+		desc: i18n.G("The state file to inspect"),
+	}})
+}
+
+// the subset of the snap state that is shown
+type debugSnapState struct {
+	Type     string           `json:"type"`
+	Sequence []*snap.SideInfo `json:"sequence"`
+	Active   bool             `json:"active,omitempty"`
+	Current  snap.Revision    `json:"current"`
+	Channel  string           `json:"channel,omitempty"`
+}
+
+type debugConnState struct {
+	Auto      bool   `json:"auto,omitempty"`
+	Interface string `json:"interface,omitempty"`
+}
+
+func loadState(path string) (*state.State, error) {
+	data, err := state.ReadJournal(path)
+	if err != nil {
+		return nil, fmt.Errorf(i18n.G("cannot read state file: %v"), err)
+	}
+	st, err := state.ReadState(nil, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf(i18n.G("cannot decode state file: %v"), err)
+	}
+	return st, nil
+}
+
+func formatStateTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func (x *cmdDebugState) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+	if x.Change != "" && x.Task != "" {
+		return fmt.Errorf(i18n.G("cannot use --change and --task together"))
+	}
+	if x.Dot && x.Change == "" {
+		return fmt.Errorf(i18n.G("--dot can only be used with --change"))
+	}
+
+	st, err := loadState(x.Positional.StateFile)
+	if err != nil {
+		return err
+	}
+	st.Lock()
+	defer st.Unlock()
+
+	switch {
+	case x.Change != "":
+		chg := st.Change(x.Change)
+		if chg == nil {
+			return fmt.Errorf(i18n.G("no change with ID %q in the state"), x.Change)
+		}
+		if x.Dot {
+			return writeTaskGraph(Stdout, chg)
+		}
+		showStateChange(chg)
+	case x.Task != "":
+		t := st.Task(x.Task)
+		if t == nil {
+			return fmt.Errorf(i18n.G("no task with ID %q in the state"), x.Task)
+		}
+		showStateTask(t)
+	default:
+		return showStateOverview(st)
+	}
+
+	return nil
+}
+
+type stateChangesByTime []*state.Change
+
+func (s stateChangesByTime) Len() int      { return len(s) }
+func (s stateChangesByTime) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s stateChangesByTime) Less(i, j int) bool {
+	return s[i].SpawnTime().Before(s[j].SpawnTime())
+}
+
+func showStateOverview(st *state.State) error {
+	changes := st.Changes()
+	sort.Sort(stateChangesByTime(changes))
+
+	w := tabWriter()
+	fmt.Fprintln(Stdout, i18n.G("Changes:"))
+	fmt.Fprintf(w, i18n.G("ID\tStatus\tSpawn\tReady\tKind\tSummary\n"))
+	for _, chg := range changes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", chg.ID(), chg.Status(), formatStateTime(chg.SpawnTime()), formatStateTime(chg.ReadyTime()), chg.Kind(), chg.Summary())
+	}
+	w.Flush()
+
+	var snaps map[string]*debugSnapState
+	if err := st.Get("snaps", &snaps); err != nil && err != state.ErrNoState {
+		return err
+	}
+	names := make([]string, 0, len(snaps))
+	for name := range snaps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(Stdout)
+	fmt.Fprintln(Stdout, i18n.G("Snaps:"))
+	fmt.Fprintf(w, i18n.G("Name\tActive\tCurrent\tSequence\tChannel\tType\n"))
+	for _, name := range names {
+		snapst := snaps[name]
+		seq := make([]string, len(snapst.Sequence))
+		for i, si := range snapst.Sequence {
+			seq[i] = si.Revision.String()
+		}
+		fmt.Fprintf(w, "%s\t%t\t%s\t%s\t%s\t%s\n", name, snapst.Active, snapst.Current, strings.Join(seq, ","), dashIfEmpty(snapst.Channel), dashIfEmpty(snapst.Type))
+	}
+	w.Flush()
+
+	var conns map[string]*debugConnState
+	if err := st.Get("conns", &conns); err != nil && err != state.ErrNoState {
+		return err
+	}
+	ids := make([]string, 0, len(conns))
+	for id := range conns {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	fmt.Fprintln(Stdout)
+	fmt.Fprintln(Stdout, i18n.G("Connections:"))
+	fmt.Fprintf(w, i18n.G("Connection\tInterface\tAuto\n"))
+	for _, id := range ids {
+		fmt.Fprintf(w, "%s\t%s\t%t\n", id, dashIfEmpty(conns[id].Interface), conns[id].Auto)
+	}
+	w.Flush()
+
+	var config map[string]map[string]*json.RawMessage
+	if err := st.Get("config", &config); err != nil && err != state.ErrNoState {
+		return err
+	}
+	names = names[:0]
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(Stdout)
+	fmt.Fprintln(Stdout, i18n.G("Config:"))
+	for _, name := range names {
+		keys := make([]string, 0, len(config[name]))
+		for key := range config[name] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := "null"
+			if raw := config[name][key]; raw != nil {
+				value = string(*raw)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", name, key, value)
+		}
+	}
+	w.Flush()
+	fmt.Fprintln(Stdout)
+
+	return nil
+}
+
+func showStateChange(chg *state.Change) {
+	w := tabWriter()
+
+	fmt.Fprintf(w, i18n.G("ID\tStatus\tSpawn\tReady\tKind\tSummary\n"))
+	for _, t := range chg.Tasks() {
+		summary := t.Summary()
+		if t.Status() == state.WaitStatus && t.WaitReason() != "" {
+			// TRANSLATORS: the first %s is the task summary, the second what it waits for
+			summary = fmt.Sprintf(i18n.G("%s (waiting for %s)"), summary, t.WaitReason())
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", t.ID(), t.Status(), formatStateTime(t.SpawnTime()), formatStateTime(t.ReadyTime()), t.Kind(), summary)
+	}
+
+	w.Flush()
+
+	for _, t := range chg.Tasks() {
+		if len(t.Log()) == 0 {
+			continue
+		}
+		fmt.Fprintln(Stdout)
+		fmt.Fprintln(Stdout, line)
+		fmt.Fprintln(Stdout, t.Summary())
+		fmt.Fprintln(Stdout)
+		for _, line := range t.Log() {
+			fmt.Fprintln(Stdout, line)
+		}
+	}
+
+	fmt.Fprintln(Stdout)
+}
+
+func taskIDs(tasks []*state.Task) string {
+	if len(tasks) == 0 {
+		return "-"
+	}
+	ids := make([]string, len(tasks))
+	for i, t := range tasks {
+		ids[i] = t.ID()
+	}
+	return strings.Join(ids, ",")
+}
+
+func showStateTask(t *state.Task) {
+	w := tabWriter()
+
+	changeID := "-"
+	if chg := t.Change(); chg != nil {
+		changeID = chg.ID()
+	}
+	fmt.Fprintf(w, i18n.G("id:\t%s\n"), t.ID())
+	fmt.Fprintf(w, i18n.G("kind:\t%s\n"), t.Kind())
+	fmt.Fprintf(w, i18n.G("summary:\t%s\n"), t.Summary())
+	fmt.Fprintf(w, i18n.G("status:\t%s\n"), t.Status())
+	fmt.Fprintf(w, i18n.G("change:\t%s\n"), changeID)
+	fmt.Fprintf(w, i18n.G("spawn:\t%s\n"), formatStateTime(t.SpawnTime()))
+	fmt.Fprintf(w, i18n.G("ready:\t%s\n"), formatStateTime(t.ReadyTime()))
+	fmt.Fprintf(w, i18n.G("waits-for:\t%s\n"), taskIDs(t.WaitTasks()))
+	fmt.Fprintf(w, i18n.G("halts:\t%s\n"), taskIDs(t.HaltTasks()))
+	w.Flush()
+
+	if len(t.Log()) > 0 {
+		fmt.Fprintln(Stdout)
+		for _, line := range t.Log() {
+			fmt.Fprintln(Stdout, line)
+		}
+	}
+}
+
+// writeTaskGraph writes the graph of the tasks of the change in the dot
+// format, with an edge from each task to the tasks that wait for it.
+func writeTaskGraph(w io.Writer, chg *state.Change) error {
+	fmt.Fprintln(w, "digraph {")
+	for _, t := range chg.Tasks() {
+		fmt.Fprintf(w, "\t%q [label=%q];\n", t.ID(), fmt.Sprintf("%s: %s\n%s", t.ID(), t.Kind(), t.Status()))
+	}
+	for _, t := range chg.Tasks() {
+		for _, halt := range t.HaltTasks() {
+			fmt.Fprintf(w, "\t%q -> %q;\n", t.ID(), halt.ID())
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
@@ -0,0 +1,161 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	snap "github.com/snapcore/snapd/cmd/snap"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+func (s *SnapSuite) mockStateFile(c *C) (path, changeID, taskID string) {
+	restore := state.MockTime(time.Date(2017, 5, 1, 10, 0, 0, 0, time.UTC))
+	defer restore()
+
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	chg := st.NewChange("install-snap", "Install \"foo\" snap")
+	t1 := st.NewTask("download-snap", "Download snap \"foo\"")
+	t2 := st.NewTask("link-snap", "Make snap \"foo\" available")
+	t2.WaitFor(t1)
+	chg.AddTask(t1)
+	chg.AddTask(t2)
+	t1.SetStatus(state.DoneStatus)
+	t2.SetStatus(state.ErrorStatus)
+	t2.Errorf("cannot link")
+
+	st.Set("snaps", map[string]interface{}{
+		"foo": map[string]interface{}{
+			"type":     "app",
+			"active":   true,
+			"current":  "2",
+			"channel":  "stable",
+			"sequence": []map[string]interface{}{{"name": "foo", "revision": "1"}, {"name": "foo", "revision": "2"}},
+		},
+	})
+	st.Set("conns", map[string]interface{}{
+		"foo:network core:network": map[string]interface{}{"interface": "network", "auto": true},
+	})
+	st.Set("config", map[string]interface{}{
+		"core": map[string]interface{}{"refresh.schedule": "00:00-04:59"},
+	})
+
+	data, err := json.Marshal(st)
+	c.Assert(err, IsNil)
+	path = filepath.Join(c.MkDir(), "state.json")
+	c.Assert(ioutil.WriteFile(path, data, 0644), IsNil)
+
+	return path, chg.ID(), t2.ID()
+}
+
+func (s *SnapSuite) TestDebugState(c *C) {
+	path, _, _ := s.mockStateFile(c)
+
+	rest, err := snap.Parser().ParseArgs([]string{"debug", "state", path})
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, []string{})
+	c.Check(s.Stdout(), Equals, `Changes:
+ID   Status  Spawn                 Ready                 Kind          Summary
+1    Error   2017-05-01T10:00:00Z  2017-05-01T10:00:00Z  install-snap  Install "foo" snap
+
+Snaps:
+Name  Active  Current  Sequence  Channel  Type
+foo   true    2        1,2       stable   app
+
+Connections:
+Connection                Interface  Auto
+foo:network core:network  network    true
+
+Config:
+core  refresh.schedule  "00:00-04:59"
+
+`)
+	c.Check(s.Stderr(), Equals, "")
+}
+
+func (s *SnapSuite) TestDebugStateChange(c *C) {
+	path, changeID, _ := s.mockStateFile(c)
+
+	_, err := snap.Parser().ParseArgs([]string{"debug", "state", "--change", changeID, path})
+	c.Assert(err, IsNil)
+	c.Check(s.Stdout(), Equals, `ID   Status  Spawn                 Ready                 Kind           Summary
+1    Done    2017-05-01T10:00:00Z  2017-05-01T10:00:00Z  download-snap  Download snap "foo"
+2    Error   2017-05-01T10:00:00Z  2017-05-01T10:00:00Z  link-snap      Make snap "foo" available
+
+......................................................................
+Make snap "foo" available
+
+2017-05-01T10:00:00Z ERROR cannot link
+
+`)
+}
+
+func (s *SnapSuite) TestDebugStateChangeDot(c *C) {
+	path, changeID, _ := s.mockStateFile(c)
+
+	_, err := snap.Parser().ParseArgs([]string{"debug", "state", "--change", changeID, "--dot", path})
+	c.Assert(err, IsNil)
+	c.Check(s.Stdout(), Equals, `digraph {
+	"1" [label="1: download-snap\nDone"];
+	"2" [label="2: link-snap\nError"];
+	"1" -> "2";
+}
+`)
+}
+
+func (s *SnapSuite) TestDebugStateTask(c *C) {
+	path, _, taskID := s.mockStateFile(c)
+
+	_, err := snap.Parser().ParseArgs([]string{"debug", "state", "--task", taskID, path})
+	c.Assert(err, IsNil)
+	c.Check(s.Stdout(), Equals, `id:         2
+kind:       link-snap
+summary:    Make snap "foo" available
+status:     Error
+change:     1
+spawn:      2017-05-01T10:00:00Z
+ready:      2017-05-01T10:00:00Z
+waits-for:  1
+halts:      -
+
+2017-05-01T10:00:00Z ERROR cannot link
+`)
+}
+
+func (s *SnapSuite) TestDebugStateErrors(c *C) {
+	path, _, _ := s.mockStateFile(c)
+
+	_, err := snap.Parser().ParseArgs([]string{"debug", "state", "--change", "1", "--task", "1", path})
+	c.Check(err, ErrorMatches, "cannot use --change and --task together")
+	_, err = snap.Parser().ParseArgs([]string{"debug", "state", "--dot", path})
+	c.Check(err, ErrorMatches, "--dot can only be used with --change")
+	_, err = snap.Parser().ParseArgs([]string{"debug", "state", "--change", "42", path})
+	c.Check(err, ErrorMatches, `no change with ID "42" in the state`)
+	_, err = snap.Parser().ParseArgs([]string{"debug", "state", filepath.Join(c.MkDir(), "missing")})
+	c.Check(err, ErrorMatches, `cannot read state file: open .*/missing: file does not exist`)
+}
@@ -0,0 +1,85 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/i18n"
+	"github.com/snapcore/snapd/snap/delta"
+)
+
+type cmdDelta struct {
+	Format     string `long:"format" choice:"xdelta3" choice:"squashfs" default:"xdelta3"`
+	Output     string `short:"o" long:"output"`
+	Positional struct {
+		Source string `positional-arg-name:"<source-snap>"`
+		Target string `positional-arg-name:"<target-snap>"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+var shortDeltaHelp = i18n.G("Generates a delta between two snap files")
+var longDeltaHelp = i18n.G(`
+The delta command generates a delta that turns the source snap file into
+the target snap file. The delta can then be installed with
+'snap install --delta=<delta-file> <snap>' on a system that has the
+source revision of the snap installed.
+
+The xdelta3 format is a delta between the snap files as they are. The
+squashfs format is a delta between their uncompressed contents, which is
+usually much smaller; applying it needs the snap to be rebuilt with the
+same mksquashfs options, which are recorded in the delta.
+`)
+
+func init() {
+	addCommand("delta", shortDeltaHelp, longDeltaHelp, func() flags.Commander { return &cmdDelta{} },
+		map[string]string{
+			"format": i18n.G("Format of the delta, 'xdelta3' or 'squashfs' (defaults to 'xdelta3')"),
+			"output": i18n.G("Write the delta to this file instead of <target-snap>.delta in the current directory"),
+		}, []argDesc{{
+			name: i18n.G("<source-snap>"),
+			desc: i18n.G("The snap file the delta starts from"),
+		}, {
+			name: i18n.G("<target-snap>"),
+			desc: i18n.G("The snap file the delta produces"),
+		}})
+}
+
+func (x *cmdDelta) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	output := x.Output
+	if output == "" {
+		output = strings.TrimSuffix(filepath.Base(x.Positional.Target), ".snap") + ".delta"
+	}
+
+	if err := delta.Generate(x.Format, x.Positional.Source, x.Positional.Target, output); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(Stdout, i18n.G("Generated delta %s\n"), output)
+	return nil
+}
@@ -0,0 +1,72 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"gopkg.in/check.v1"
+
+	snap "github.com/snapcore/snapd/cmd/snap"
+	"github.com/snapcore/snapd/testutil"
+)
+
+func (s *SnapSuite) TestDelta(c *check.C) {
+	mockXdelta3 := testutil.MockCommand(c, "xdelta3", "")
+	defer mockXdelta3.Restore()
+
+	rest, err := snap.Parser().ParseArgs([]string{"delta", "/some/path/foo_1.snap", "/other/path/foo_2.snap"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(mockXdelta3.Calls(), check.DeepEquals, [][]string{
+		{"xdelta3", "-e", "-s", "/some/path/foo_1.snap", "/other/path/foo_2.snap", "foo_2.delta"},
+	})
+	c.Check(s.Stdout(), check.Equals, "Generated delta foo_2.delta\n")
+	c.Check(s.Stderr(), check.Equals, "")
+}
+
+func (s *SnapSuite) TestDeltaOutput(c *check.C) {
+	mockXdelta3 := testutil.MockCommand(c, "xdelta3", "")
+	defer mockXdelta3.Restore()
+
+	_, err := snap.Parser().ParseArgs([]string{"delta", "-o", "/tmp/foo.delta", "foo_1.snap", "foo_2.snap"})
+	c.Assert(err, check.IsNil)
+	c.Check(mockXdelta3.Calls(), check.DeepEquals, [][]string{
+		{"xdelta3", "-e", "-s", "foo_1.snap", "foo_2.snap", "/tmp/foo.delta"},
+	})
+	c.Check(s.Stdout(), check.Equals, "Generated delta /tmp/foo.delta\n")
+}
+
+func (s *SnapSuite) TestDeltaSquashfs(c *check.C) {
+	mockXdelta3 := testutil.MockCommand(c, "xdelta3", "")
+	defer mockXdelta3.Restore()
+
+	_, err := snap.Parser().ParseArgs([]string{"delta", "--format=squashfs", "foo_1.snap", "foo_2.snap"})
+	c.Assert(err, check.ErrorMatches, `open foo_2.snap: no such file or directory`)
+	c.Check(mockXdelta3.Calls(), check.HasLen, 0)
+	c.Check(s.Stdout(), check.Equals, "")
+}
+
+func (s *SnapSuite) TestDeltaFails(c *check.C) {
+	mockXdelta3 := testutil.MockCommand(c, "xdelta3", "echo 'foo_1.snap: No such file or directory'; exit 1")
+	defer mockXdelta3.Restore()
+
+	_, err := snap.Parser().ParseArgs([]string{"delta", "foo_1.snap", "foo_2.snap"})
+	c.Assert(err, check.ErrorMatches, `cannot generate delta: foo_1.snap: No such file or directory`)
+	c.Check(s.Stdout(), check.Equals, "")
+}
@@ -24,6 +24,7 @@ import (
 	"fmt"
 	"io"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/tabwriter"
 
@@ -36,6 +37,58 @@ import (
 	"github.com/snapcore/snapd/strutil"
 )
 
+var riskOrder = func() map[string]int {
+	m := make(map[string]int, len(snap.Risks))
+	for i, risk := range snap.Risks {
+		m[risk] = i
+	}
+	return m
+}()
+
+type byRiskAndBranch []snap.Channel
+
+func (chs byRiskAndBranch) Len() int      { return len(chs) }
+func (chs byRiskAndBranch) Swap(i, j int) { chs[i], chs[j] = chs[j], chs[i] }
+func (chs byRiskAndBranch) Less(i, j int) bool {
+	if chs[i].Risk != chs[j].Risk {
+		return riskOrder[chs[i].Risk] < riskOrder[chs[j].Risk]
+	}
+	return chs[i].Branch < chs[j].Branch
+}
+
+// channelsByTrack returns the names in the channel map grouped by
+// track, the default track first and the others sorted by name; in
+// each track the risks go from stable to edge, each followed by its
+// branches.
+func channelsByTrack(channels map[string]*snap.ChannelSnapInfo) []string {
+	tracks := make(map[string][]snap.Channel)
+	names := make(map[snap.Channel]string, len(channels))
+	var trackNames []string
+	for name := range channels {
+		ch, err := snap.ParseChannel(name)
+		if err != nil {
+			continue
+		}
+		if _, ok := tracks[ch.Track]; !ok && ch.Track != snap.DefaultTrack {
+			trackNames = append(trackNames, ch.Track)
+		}
+		tracks[ch.Track] = append(tracks[ch.Track], ch)
+		names[ch] = name
+	}
+	sort.Strings(trackNames)
+	trackNames = append([]string{snap.DefaultTrack}, trackNames...)
+
+	var ordered []string
+	for _, track := range trackNames {
+		chs := tracks[track]
+		sort.Sort(byRiskAndBranch(chs))
+		for _, ch := range chs {
+			ordered = append(ordered, names[ch])
+		}
+	}
+	return ordered
+}
+
 type infoCmd struct {
 	Verbose    bool `long:"verbose"`
 	Positional struct {
@@ -263,11 +316,8 @@ func (x *infoCmd) Execute([]string) error {
 		if remote != nil && remote.Channels != nil {
 			// \t\t\t so we get "installed" lined up with "channels"
 			fmt.Fprintf(w, "channels:\t\t\t\n")
-			for _, ch := range []string{"stable", "candidate", "beta", "edge"} {
+			for _, ch := range channelsByTrack(remote.Channels) {
 				m := remote.Channels[ch]
-				if m == nil {
-					continue
-				}
 				fmt.Fprintf(w, "  %s:\t%s\t(%s)\t%s\t%s\n", ch, m.Version, m.Revision, strutil.SizeToStr(m.Size), NotesFromChannelSnapInfo(m))
 			}
 		}
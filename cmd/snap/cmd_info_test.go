@@ -59,3 +59,71 @@ description: |
 `)
 	c.Check(s.Stderr(), check.Equals, "")
 }
+
+const findHelloWithTracksJSON = `
+{
+  "type": "sync",
+  "status-code": 200,
+  "status": "OK",
+  "result": [
+    {
+      "channel": "stable",
+      "confinement": "strict",
+      "description": "GNU hello prints a friendly greeting.",
+      "developer": "canonical",
+      "download-size": 65536,
+      "id": "mVyGrEwiqSi5PugCwyH7WgpoQLemtTd6",
+      "name": "hello",
+      "resource": "/v2/snaps/hello",
+      "revision": "1",
+      "status": "available",
+      "summary": "GNU Hello",
+      "type": "app",
+      "version": "2.10",
+      "channels": {
+        "2.x/edge": {"revision": "5", "version": "2.11~pre", "channel": "2.x/edge", "confinement": "strict", "size": 65536},
+        "edge": {"revision": "4", "version": "3.0~pre", "channel": "edge", "confinement": "strict", "size": 65536},
+        "stable/hotfix": {"revision": "3", "version": "2.10+fix", "channel": "stable/hotfix", "confinement": "strict", "size": 65536},
+        "1.x/stable": {"revision": "2", "version": "1.9", "channel": "1.x/stable", "confinement": "strict", "size": 65536},
+        "2.x/stable": {"revision": "1", "version": "2.10", "channel": "2.x/stable", "confinement": "strict", "size": 65536},
+        "stable": {"revision": "1", "version": "2.10", "channel": "stable", "confinement": "strict", "size": 65536}
+      }
+    }
+  ],
+  "sources": [
+    "store"
+  ]
+}
+`
+
+func (s *SnapSuite) TestInfoChannelsGroupedByTrack(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			c.Check(r.Method, check.Equals, "GET")
+			c.Check(r.URL.Path, check.Equals, "/v2/find")
+			fmt.Fprint(w, findHelloWithTracksJSON)
+		case 1:
+			c.Check(r.Method, check.Equals, "GET")
+			c.Check(r.URL.Path, check.Equals, "/v2/snaps/hello")
+			fmt.Fprintln(w, "{}")
+		default:
+			c.Fatalf("expected to get 2 requests, now on %d (%v)", n+1, r)
+		}
+
+		n++
+	})
+	rest, err := snap.Parser().ParseArgs([]string{"info", "hello"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), check.Matches, `(?s).*channels: +
+  stable: +2.10 +\(1\) +65kB +-
+  stable/hotfix: +2.10\+fix +\(3\) +65kB +-
+  edge: +3.0~pre +\(4\) +65kB +-
+  1.x/stable: +1.9 +\(2\) +65kB +-
+  2.x/stable: +2.10 +\(1\) +65kB +-
+  2.x/edge: +2.11~pre +\(5\) +65kB +-
+`)
+	c.Check(s.Stderr(), check.Equals, "")
+}
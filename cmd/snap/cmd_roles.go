@@ -0,0 +1,118 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/i18n"
+)
+
+type roleArgs struct {
+	Positional struct {
+		User string
+		Role string
+	} `positional-args:"yes" required:"yes"`
+}
+
+type cmdGrantRole struct {
+	roleArgs
+}
+
+type cmdRevokeRole struct {
+	roleArgs
+}
+
+var shortGrantRoleHelp = i18n.G("Grant a role to a user")
+var longGrantRoleHelp = i18n.G(`
+The grant-role command grants a role to a user known to snapd, identified
+by username or email, limiting what the user can do through snapd to what
+its roles allow:
+
+  viewer     can look at snaps, changes and other state
+  operator   can in addition operate the system, e.g. abort changes or
+             enable and disable snaps
+  admin      can do anything
+
+Users without any role can do anything.
+`)
+
+var shortRevokeRoleHelp = i18n.G("Revoke a role from a user")
+var longRevokeRoleHelp = i18n.G(`
+The revoke-role command takes a role away from a user known to snapd,
+identified by username or email. Users keep at least one role.
+`)
+
+var roleArgDescs = []argDesc{{
+	// TRANSLATORS: noun
+	name: i18n.G("<user>"),
+	desc: i18n.G("The username or email of the user"),
+}, {
+	// TRANSLATORS: noun
+	name: i18n.G("<role>"),
+	desc: i18n.G("One of viewer, operator or admin"),
+}}
+
+func init() {
+	addCommand("grant-role", shortGrantRoleHelp, longGrantRoleHelp, func() flags.Commander {
+		return &cmdGrantRole{}
+	}, nil, roleArgDescs)
+	addCommand("revoke-role", shortRevokeRoleHelp, longRevokeRoleHelp, func() flags.Commander {
+		return &cmdRevokeRole{}
+	}, nil, roleArgDescs)
+}
+
+func showUserRoles(user *client.User) {
+	name := user.Username
+	if name == "" {
+		name = user.Email
+	}
+	fmt.Fprintf(Stdout, i18n.G("User %q now has roles: %s\n"), name, strings.Join(user.Roles, ", "))
+}
+
+func (x *cmdGrantRole) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	user, err := Client().GrantRole(x.Positional.User, x.Positional.Role)
+	if err != nil {
+		return err
+	}
+	showUserRoles(user)
+	return nil
+}
+
+func (x *cmdRevokeRole) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	user, err := Client().RevokeRole(x.Positional.User, x.Positional.Role)
+	if err != nil {
+		return err
+	}
+	showUserRoles(user)
+	return nil
+}
@@ -0,0 +1,72 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"encoding/json"
+	"net/http"
+
+	. "gopkg.in/check.v1"
+
+	snap "github.com/snapcore/snapd/cmd/snap"
+)
+
+func (s *SnapSuite) TestGrantRevokeRole(c *C) {
+	var action string
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, "POST")
+		c.Check(r.URL.Path, Equals, "/v2/users")
+		var body map[string]string
+		c.Assert(json.NewDecoder(r.Body).Decode(&body), IsNil)
+		c.Check(body, DeepEquals, map[string]string{
+			"action": action,
+			"user":   "tech",
+			"role":   "operator",
+		})
+		EncodeResponseBody(c, w, map[string]interface{}{
+			"type":   "sync",
+			"result": map[string]interface{}{"id": 1, "username": "tech", "roles": []string{"viewer", "operator"}},
+		})
+	})
+
+	action = "grant-role"
+	rest, err := snap.Parser().ParseArgs([]string{"grant-role", "tech", "operator"})
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, []string{})
+	c.Check(s.Stdout(), Equals, "User \"tech\" now has roles: viewer, operator\n")
+
+	s.stdout.Reset()
+	action = "revoke-role"
+	_, err = snap.Parser().ParseArgs([]string{"revoke-role", "tech", "operator"})
+	c.Assert(err, IsNil)
+	c.Check(s.Stdout(), Equals, "User \"tech\" now has roles: viewer, operator\n")
+}
+
+func (s *SnapSuite) TestGrantRoleError(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(400)
+		EncodeResponseBody(c, w, map[string]interface{}{
+			"type":   "error",
+			"result": map[string]interface{}{"message": `invalid role "potato"`},
+		})
+	})
+	_, err := snap.Parser().ParseArgs([]string{"grant-role", "tech", "potato"})
+	c.Assert(err, ErrorMatches, `invalid role "potato"`)
+}
@@ -44,6 +44,9 @@ Nested values may be modified via a dotted path:
 `)
 
 type cmdSet struct {
+	waitMixin
+	scheduleMixin
+
 	Positional struct {
 		Snap       installedSnapName
 		ConfValues []string `required:"1"`
@@ -51,7 +54,7 @@ type cmdSet struct {
 }
 
 func init() {
-	addCommand("set", shortSetHelp, longSetHelp, func() flags.Commander { return &cmdSet{} }, nil, []argDesc{
+	addCommand("set", shortSetHelp, longSetHelp, func() flags.Commander { return &cmdSet{} }, waitDescs.also(scheduleDescs), []argDesc{
 		{
 			name: "<snap>",
 			desc: i18n.G("The snap to configure (e.g. hello-world)"),
@@ -79,16 +82,24 @@ func (x *cmdSet) Execute(args []string) error {
 		}
 	}
 
-	return configure(string(x.Positional.Snap), patchValues)
+	return x.configure(string(x.Positional.Snap), patchValues)
 }
 
-func configure(snapName string, patchValues map[string]interface{}) error {
+func (x *cmdSet) configure(snapName string, patchValues map[string]interface{}) error {
+	at, err := x.schedule(&x.waitMixin)
+	if err != nil {
+		return err
+	}
+
 	cli := Client()
-	id, err := cli.SetConf(snapName, patchValues)
+	id, err := cli.SetConfAt(snapName, patchValues, at)
 	if err != nil {
 		return err
 	}
 
-	_, err = wait(cli, id)
+	_, err = x.wait(cli, id)
+	if err == noWait {
+		return nil
+	}
 	return err
 }
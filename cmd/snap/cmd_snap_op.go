@@ -36,6 +36,7 @@ import (
 	"github.com/snapcore/snapd/i18n"
 	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/progress"
+	"github.com/snapcore/snapd/snap"
 )
 
 func lastLogStr(logs []string) string {
@@ -48,10 +49,17 @@ func lastLogStr(logs []string) string {
 var (
 	maxGoneTime = 5 * time.Second
 	pollTime    = 100 * time.Millisecond
+	// eventsPollTime is how long to wait for events before polling
+	// anyway when following the events of a change
+	eventsPollTime = 5 * time.Second
 )
 
 type waitMixin struct {
 	NoWait bool `long:"no-wait" hidden:"true"`
+
+	// scheduledAt is set when the change was scheduled to start
+	// later, and so there is nothing to wait for
+	scheduledAt time.Time
 }
 
 // TODO: use waitMixin outside of cmd_snap_op.go
@@ -67,10 +75,97 @@ func (wmx *waitMixin) wait(cli *client.Client, id string) (*client.Change, error
 		fmt.Fprintf(Stdout, "%s\n", id)
 		return nil, noWait
 	}
+	if !wmx.scheduledAt.IsZero() {
+		fmt.Fprintf(Stdout, i18n.G("Change %s scheduled for %s\n"), id, wmx.scheduledAt.Format(time.RFC3339))
+		return nil, noWait
+	}
 	return wait(cli, id)
 }
 
+type scheduleMixin struct {
+	At string `long:"at"`
+}
+
+var scheduleDescs = mixinDescs{
+	"at": i18n.G("Only start the operation at the given time (HH:MM, YYYY-MM-DD HH:MM or RFC 3339)"),
+}
+
+// schedule sets up the wait to not wait for the change if it was asked
+// to start later, and returns when that is.
+func (smx scheduleMixin) schedule(wmx *waitMixin) (time.Time, error) {
+	if smx.At == "" {
+		return time.Time{}, nil
+	}
+	at, err := parseAt(smx.At, time.Now())
+	if err != nil {
+		return time.Time{}, err
+	}
+	wmx.scheduledAt = at
+	return at, nil
+}
+
+// parseAt parses the time given with --at: either a full RFC 3339 time,
+// a date and time in local time, or just a time in local time meaning
+// its next occurrence after now.
+func parseAt(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("15:04", s, time.Local); err == nil {
+		now = now.In(time.Local)
+		at := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, time.Local)
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+		return at, nil
+	}
+	return time.Time{}, fmt.Errorf(i18n.G("cannot parse time %q, want HH:MM, YYYY-MM-DD HH:MM or RFC 3339"), s)
+}
+
+// changeUpdates streams the events of the change with the given id and
+// signals on the returned channel when there are news about it; the
+// channel is closed when the stream ends. It returns a nil channel if
+// the events cannot be streamed (e.g. with an older snapd).
+func changeUpdates(cli *client.Client, id string) (updates <-chan struct{}, stop func()) {
+	stream, err := cli.Events(&client.EventsOptions{ChangeID: id})
+	if err != nil {
+		return nil, func() {}
+	}
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer close(ch)
+		for {
+			if _, err := stream.Next(); err != nil {
+				return
+			}
+			// coalesce news not picked up yet
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return ch, func() { stream.Close() }
+}
+
 func wait(cli *client.Client, id string) (*client.Change, error) {
+	return waitChange(cli, id, false)
+}
+
+// waitChange waits for the change with the given id to be ready,
+// showing its progress; with useEvents it follows the events of the
+// change instead of polling it, when possible.
+func waitChange(cli *client.Client, id string, useEvents bool) (*client.Change, error) {
+	var updates <-chan struct{}
+	if useEvents {
+		var stop func()
+		updates, stop = changeUpdates(cli, id)
+		defer stop()
+	}
+
 	pb := progress.NewTextProgress()
 	defer func() {
 		pb.Finished()
@@ -140,6 +235,18 @@ func wait(cli *client.Client, id string) (*client.Change, error) {
 			return nil, fmt.Errorf(i18n.G("change finished in status %q with no error message"), chg.Status)
 		}
 
+		if updates != nil {
+			select {
+			case _, ok := <-updates:
+				if !ok {
+					// the stream ended, e.g. snapd restarted
+					updates = nil
+				}
+			case <-time.After(eventsPollTime):
+			}
+			continue
+		}
+
 		// note this very purposely is not a ticker; we want
 		// to sleep 100ms between calls, not call once every
 		// 100ms.
@@ -195,6 +302,7 @@ and the snap can easily be enabled again.
 
 type cmdRemove struct {
 	waitMixin
+	scheduleMixin
 
 	Revision   string `long:"revision"`
 	Positional struct {
@@ -270,7 +378,12 @@ func (x *cmdRemove) removeMany(opts *client.SnapOptions) error {
 }
 
 func (x *cmdRemove) Execute([]string) error {
-	opts := &client.SnapOptions{Revision: x.Revision}
+	at, err := x.schedule(&x.waitMixin)
+	if err != nil {
+		return err
+	}
+
+	opts := &client.SnapOptions{Revision: x.Revision, At: at}
 	if len(x.Positional.Snaps) == 1 {
 		return x.removeOne(opts)
 	}
@@ -278,7 +391,7 @@ func (x *cmdRemove) Execute([]string) error {
 	if x.Revision != "" {
 		return errors.New(i18n.G("a single snap name is needed to specify the revision"))
 	}
-	return x.removeMany(nil)
+	return x.removeMany(&client.SnapOptions{At: at})
 }
 
 type channelMixin struct {
@@ -305,7 +418,7 @@ func (mxd mixinDescs) also(m map[string]string) mixinDescs {
 }
 
 var channelDescs = mixinDescs{
-	"channel":   i18n.G("Use this channel instead of stable"),
+	"channel":   i18n.G("Use this channel (track/risk/branch) instead of stable"),
 	"beta":      i18n.G("Install from the beta channel"),
 	"edge":      i18n.G("Install from the edge channel"),
 	"candidate": i18n.G("Install from the candidate channel"),
@@ -331,6 +444,12 @@ func (mx *channelMixin) setChannelFromCommandline() error {
 		mx.Channel = ch.chName
 	}
 
+	if mx.Channel != "" {
+		if _, err := snap.ParseChannel(mx.Channel); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -402,11 +521,31 @@ func (mx modeMixin) setModes(opts *client.SnapOptions) {
 	opts.Classic = mx.Classic
 }
 
+type transactionMixin struct {
+	Transaction client.TransactionType `long:"transaction" choice:"per-snap" choice:"all-snaps"`
+}
+
+var transactionDescs = mixinDescs{
+	"transaction": i18n.G("Have one transaction per-snap or one for all the specified snaps"),
+}
+
+// multiOptions returns the options to use for a multi-snap operation,
+// which are nil unless a transaction type, no rate limit or a start
+// time was asked for.
+func (mx transactionMixin) multiOptions(noRateLimit bool, at time.Time) *client.SnapOptions {
+	if mx.Transaction == "" && !noRateLimit && at.IsZero() {
+		return nil
+	}
+	return &client.SnapOptions{Transaction: mx.Transaction, NoRateLimit: noRateLimit, At: at}
+}
+
 type cmdInstall struct {
 	waitMixin
+	scheduleMixin
 
 	channelMixin
 	modeMixin
+	transactionMixin
 	Revision string `long:"revision"`
 
 	Dangerous bool `long:"dangerous"`
@@ -414,6 +553,10 @@ type cmdInstall struct {
 	// because we released 2.14.2 with --force-dangerous
 	ForceDangerous bool `long:"force-dangerous" hidden:"yes"`
 
+	Delta string `long:"delta"`
+
+	NoRateLimit bool `long:"no-rate-limit"`
+
 	Positional struct {
 		Snaps []remoteSnapName `positional-arg-name:"<snap>"`
 	} `positional-args:"yes" required:"yes"`
@@ -476,6 +619,26 @@ func (x *cmdInstall) installOne(name string, opts *client.SnapOptions) error {
 	return showDone([]string{name}, "install")
 }
 
+func (x *cmdInstall) installDelta(name, deltaPath string, opts *client.SnapOptions) error {
+	cli := Client()
+	changeID, err := cli.InstallDelta(name, deltaPath, opts)
+	if err != nil {
+		return err
+	}
+
+	setupAbortHandler(changeID)
+
+	_, err = x.wait(cli, changeID)
+	if err == noWait {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return showDone([]string{name}, "install")
+}
+
 func (x *cmdInstall) installMany(names []string, opts *client.SnapOptions) error {
 	// sanity check
 	for _, name := range names {
@@ -534,12 +697,18 @@ func (x *cmdInstall) Execute([]string) error {
 	if err := x.validateMode(); err != nil {
 		return err
 	}
+	at, err := x.schedule(&x.waitMixin)
+	if err != nil {
+		return err
+	}
 
 	dangerous := x.Dangerous || x.ForceDangerous
 	opts := &client.SnapOptions{
-		Channel:   x.Channel,
-		Revision:  x.Revision,
-		Dangerous: dangerous,
+		Channel:     x.Channel,
+		Revision:    x.Revision,
+		Dangerous:   dangerous,
+		NoRateLimit: x.NoRateLimit,
+		At:          at,
 	}
 	x.setModes(opts)
 
@@ -548,6 +717,13 @@ func (x *cmdInstall) Execute([]string) error {
 		names[i] = string(name)
 	}
 
+	if x.Delta != "" {
+		if len(names) != 1 {
+			return errors.New(i18n.G("a single snap name is needed to install a delta"))
+		}
+		return x.installDelta(names[0], x.Delta, opts)
+	}
+
 	if len(names) == 1 {
 		return x.installOne(names[0], opts)
 	}
@@ -556,18 +732,21 @@ func (x *cmdInstall) Execute([]string) error {
 		return errors.New(i18n.G("a single snap name is needed to specify mode or channel flags"))
 	}
 
-	return x.installMany(names, nil)
+	return x.installMany(names, x.multiOptions(x.NoRateLimit, at))
 }
 
 type cmdRefresh struct {
 	waitMixin
+	scheduleMixin
 
 	channelMixin
 	modeMixin
+	transactionMixin
 
 	Revision         string `long:"revision"`
 	List             bool   `long:"list"`
 	IgnoreValidation bool   `long:"ignore-validation"`
+	NoRateLimit      bool   `long:"no-rate-limit"`
 	Positional       struct {
 		Snaps []installedSnapName `positional-arg-name:"<snap>"`
 	} `positional-args:"yes"`
@@ -663,6 +842,10 @@ func (x *cmdRefresh) Execute([]string) error {
 			return errors.New(i18n.G("--list does not take mode nor channel flags"))
 		}
 
+		if x.At != "" {
+			return errors.New(i18n.G("--list does not take the --at flag"))
+		}
+
 		return x.listRefresh()
 	}
 
@@ -671,6 +854,11 @@ func (x *cmdRefresh) Execute([]string) error {
 		return nil
 	}
 
+	at, err := x.schedule(&x.waitMixin)
+	if err != nil {
+		return err
+	}
+
 	names := make([]string, len(x.Positional.Snaps))
 	for i, name := range x.Positional.Snaps {
 		names[i] = string(name)
@@ -679,7 +867,9 @@ func (x *cmdRefresh) Execute([]string) error {
 		opts := &client.SnapOptions{
 			Channel:          x.Channel,
 			IgnoreValidation: x.IgnoreValidation,
+			NoRateLimit:      x.NoRateLimit,
 			Revision:         x.Revision,
+			At:               at,
 		}
 		x.setModes(opts)
 		return x.refreshOne(names[0], opts)
@@ -693,7 +883,7 @@ func (x *cmdRefresh) Execute([]string) error {
 		return errors.New(i18n.G("a single snap name must be specified when ignoring validation"))
 	}
 
-	return x.refreshMany(names, nil)
+	return x.refreshMany(names, x.multiOptions(x.NoRateLimit, at))
 }
 
 type cmdTry struct {
@@ -892,20 +1082,73 @@ func (x *cmdRevert) Execute(args []string) error {
 	return nil
 }
 
+type cmdSwitch struct {
+	waitMixin
+	channelMixin
+
+	Positional struct {
+		Snap installedSnapName `positional-arg-name:"<snap>"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+var shortSwitchHelp = i18n.G("Switches snap to a different channel")
+var longSwitchHelp = i18n.G(`
+The switch command switches the given snap to a different channel without
+doing a refresh. The snap keeps running the revision it has; the next
+refresh will follow the new channel.
+`)
+
+func (x *cmdSwitch) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	if err := x.setChannelFromCommandline(); err != nil {
+		return err
+	}
+
+	name := string(x.Positional.Snap)
+	channel := x.Channel
+	if !x.asksForChannel() {
+		return fmt.Errorf(i18n.G("missing --channel=<channel-name> parameter"))
+	}
+
+	cli := Client()
+	opts := &client.SnapOptions{Channel: channel}
+	changeID, err := cli.Switch(name, opts)
+	if err != nil {
+		return err
+	}
+
+	_, err = x.wait(cli, changeID)
+	if err == noWait {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(Stdout, i18n.G("%q switched to the %q channel\n"), name, channel)
+	return nil
+}
+
 func init() {
 	addCommand("remove", shortRemoveHelp, longRemoveHelp, func() flags.Commander { return &cmdRemove{} },
-		waitDescs.also(map[string]string{"revision": i18n.G("Remove only the given revision")}), nil)
+		waitDescs.also(scheduleDescs).also(map[string]string{"revision": i18n.G("Remove only the given revision")}), nil)
 	addCommand("install", shortInstallHelp, longInstallHelp, func() flags.Commander { return &cmdInstall{} },
-		waitDescs.also(channelDescs).also(modeDescs).also(map[string]string{
+		waitDescs.also(scheduleDescs).also(channelDescs).also(modeDescs).also(transactionDescs).also(map[string]string{
 			"revision":        i18n.G("Install the given revision of a snap, to which you must have developer access"),
 			"dangerous":       i18n.G("Install the given snap file even if there are no pre-acknowledged signatures for it, meaning it was not verified and could be dangerous (--devmode implies this)"),
 			"force-dangerous": i18n.G("Alias for --dangerous (DEPRECATED)"),
+			"delta":           i18n.G("Install the snap obtained by applying this delta file to the installed revision"),
+			"no-rate-limit":   i18n.G("Download without the bandwidth limit set with refresh.rate-limit"),
 		}), nil)
 	addCommand("refresh", shortRefreshHelp, longRefreshHelp, func() flags.Commander { return &cmdRefresh{} },
-		waitDescs.also(channelDescs).also(modeDescs).also(map[string]string{
+		waitDescs.also(scheduleDescs).also(channelDescs).also(modeDescs).also(transactionDescs).also(map[string]string{
 			"revision":          i18n.G("Refresh to the given revision"),
 			"list":              i18n.G("Show available snaps for refresh"),
 			"ignore-validation": i18n.G("Ignore validation by other snaps blocking the refresh"),
+			"no-rate-limit":     i18n.G("Download without the bandwidth limit set with refresh.rate-limit"),
 		}), nil)
 	addCommand("try", shortTryHelp, longTryHelp, func() flags.Commander { return &cmdTry{} }, waitDescs.also(modeDescs), nil)
 	addCommand("enable", shortEnableHelp, longEnableHelp, func() flags.Commander { return &cmdEnable{} }, waitDescs, nil)
@@ -913,4 +1156,11 @@ func init() {
 	addCommand("revert", shortRevertHelp, longRevertHelp, func() flags.Commander { return &cmdRevert{} }, waitDescs.also(modeDescs).also(map[string]string{
 		"revision": "Revert to the given revision",
 	}), nil)
+	addCommand("switch", shortSwitchHelp, longSwitchHelp, func() flags.Commander { return &cmdSwitch{} }, waitDescs.also(channelDescs).also(map[string]string{
+		"channel":   i18n.G("Switch to this channel"),
+		"beta":      i18n.G("Switch to the beta channel"),
+		"edge":      i18n.G("Switch to the edge channel"),
+		"candidate": i18n.G("Switch to the candidate channel"),
+		"stable":    i18n.G("Switch to the stable channel"),
+	}), nil)
 }
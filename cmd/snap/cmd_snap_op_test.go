@@ -187,6 +187,78 @@ func (s *SnapOpSuite) TestInstall(c *check.C) {
 	c.Check(s.srv.n, check.Equals, s.srv.total)
 }
 
+func (s *SnapOpSuite) TestInstallAt(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, check.Equals, "POST")
+		c.Check(r.URL.Path, check.Equals, "/v2/snaps/foo")
+		c.Check(r.URL.Query().Get("at"), check.Equals, "2017-03-04T03:00:00Z")
+		c.Check(DecodedRequestBody(c, r), check.DeepEquals, map[string]interface{}{
+			"action": "install",
+		})
+		fmt.Fprintln(w, `{"type": "async", "change": "42", "status-code": 202}`)
+		n++
+	})
+	rest, err := snap.Parser().ParseArgs([]string{"install", "--at", "2017-03-04T03:00:00Z", "foo"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), check.Equals, "Change 42 scheduled for 2017-03-04T03:00:00Z\n")
+	c.Check(s.Stderr(), check.Equals, "")
+	// no waiting for the change
+	c.Check(n, check.Equals, 1)
+}
+
+func (s *SnapOpSuite) TestRefreshManyAt(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, check.Equals, "POST")
+		c.Check(r.URL.Path, check.Equals, "/v2/snaps")
+		c.Check(r.URL.Query().Get("at"), check.Equals, "2017-03-04T03:00:00Z")
+		c.Check(DecodedRequestBody(c, r), check.DeepEquals, map[string]interface{}{
+			"action": "refresh",
+			"snaps":  []interface{}{"foo", "bar"},
+		})
+		fmt.Fprintln(w, `{"type": "async", "change": "42", "status-code": 202}`)
+		n++
+	})
+	rest, err := snap.Parser().ParseArgs([]string{"refresh", "--at", "2017-03-04T03:00:00Z", "foo", "bar"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), check.Equals, "Change 42 scheduled for 2017-03-04T03:00:00Z\n")
+	c.Check(n, check.Equals, 1)
+}
+
+func (s *SnapOpSuite) TestRemoveAtInvalid(c *check.C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Fatalf("unexpected request %v", r)
+	})
+	_, err := snap.Parser().ParseArgs([]string{"remove", "--at", "tomorrow", "foo"})
+	c.Assert(err, check.ErrorMatches, `cannot parse time "tomorrow", want HH:MM, YYYY-MM-DD HH:MM or RFC 3339`)
+}
+
+func (s *SnapOpSuite) TestParseAt(c *check.C) {
+	now := time.Date(2017, 3, 4, 12, 30, 0, 0, time.Local)
+
+	at, err := snap.ParseAt("2017-03-05T03:00:00Z", now)
+	c.Assert(err, check.IsNil)
+	c.Check(at.Equal(time.Date(2017, 3, 5, 3, 0, 0, 0, time.UTC)), check.Equals, true)
+
+	at, err = snap.ParseAt("2017-03-05 03:00", now)
+	c.Assert(err, check.IsNil)
+	c.Check(at.Equal(time.Date(2017, 3, 5, 3, 0, 0, 0, time.Local)), check.Equals, true)
+
+	// a time of day is its next occurrence
+	at, err = snap.ParseAt("03:00", now)
+	c.Assert(err, check.IsNil)
+	c.Check(at.Equal(time.Date(2017, 3, 5, 3, 0, 0, 0, time.Local)), check.Equals, true)
+	at, err = snap.ParseAt("13:00", now)
+	c.Assert(err, check.IsNil)
+	c.Check(at.Equal(time.Date(2017, 3, 4, 13, 0, 0, 0, time.Local)), check.Equals, true)
+
+	_, err = snap.ParseAt("3 AM", now)
+	c.Check(err, check.ErrorMatches, `cannot parse time "3 AM", .*`)
+}
+
 func (s *SnapOpSuite) TestInstallDevMode(c *check.C) {
 	s.srv.checker = func(r *http.Request) {
 		c.Check(r.URL.Path, check.Equals, "/v2/snaps/foo")
@@ -258,6 +330,44 @@ func formFile(form *multipart.Form, c *check.C) (name, filename string, content
 	return "", "", nil
 }
 
+func (s *SnapOpSuite) TestInstallDelta(c *check.C) {
+	s.srv.checker = func(r *http.Request) {
+		c.Check(r.URL.Path, check.Equals, "/v2/snaps")
+
+		form := testForm(r, c)
+		defer form.RemoveAll()
+
+		c.Check(form.Value["action"], check.DeepEquals, []string{"install"})
+		c.Check(form.Value["name"], check.DeepEquals, []string{"foo"})
+		c.Check(form.Value["delta"], check.DeepEquals, []string{"true"})
+		c.Check(form.Value["snap-path"], check.NotNil)
+		c.Check(form.Value, check.HasLen, 4)
+
+		name, _, body := formFile(form, c)
+		c.Check(name, check.Equals, "snap")
+		c.Check(string(body), check.Equals, "delta-data")
+	}
+
+	s.RedirectClientToTestServer(s.srv.handle)
+	deltaPath := filepath.Join(c.MkDir(), "foo_2.delta")
+	err := ioutil.WriteFile(deltaPath, []byte("delta-data"), 0644)
+	c.Assert(err, check.IsNil)
+
+	rest, err := snap.Parser().ParseArgs([]string{"install", "--delta", deltaPath, "foo"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), check.Matches, `(?sm).*foo 1.0 from 'bar' installed`)
+	c.Check(s.Stderr(), check.Equals, "")
+	// ensure that the fake server api was actually hit
+	c.Check(s.srv.n, check.Equals, s.srv.total)
+}
+
+func (s *SnapOpSuite) TestInstallDeltaMany(c *check.C) {
+	s.RedirectClientToTestServer(nil)
+	_, err := snap.Parser().ParseArgs([]string{"install", "--delta", "foo_2.delta", "foo", "bar"})
+	c.Assert(err, check.ErrorMatches, `a single snap name is needed to install a delta`)
+}
+
 func (s *SnapOpSuite) TestInstallPath(c *check.C) {
 	s.srv.checker = func(r *http.Request) {
 		c.Check(r.URL.Path, check.Equals, "/v2/snaps")
@@ -565,6 +675,41 @@ func (s *SnapOpSuite) TestRefreshOneIgnoreValidation(c *check.C) {
 	c.Assert(err, check.IsNil)
 }
 
+func (s *SnapOpSuite) TestRefreshOneNoRateLimit(c *check.C) {
+	s.RedirectClientToTestServer(s.srv.handle)
+	s.srv.checker = func(r *http.Request) {
+		c.Check(r.Method, check.Equals, "POST")
+		c.Check(r.URL.Path, check.Equals, "/v2/snaps/one")
+		c.Check(DecodedRequestBody(c, r), check.DeepEquals, map[string]interface{}{
+			"action":        "refresh",
+			"no-rate-limit": true,
+		})
+	}
+	_, err := snap.Parser().ParseArgs([]string{"refresh", "--no-rate-limit", "one"})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *SnapOpSuite) TestRefreshManyNoRateLimit(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(n, check.Equals, 0)
+		c.Check(r.Method, check.Equals, "POST")
+		c.Check(r.URL.Path, check.Equals, "/v2/snaps")
+		c.Check(DecodedRequestBody(c, r), check.DeepEquals, map[string]interface{}{
+			"action":        "refresh",
+			"snaps":         []interface{}{"one", "two"},
+			"no-rate-limit": true,
+		})
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintln(w, `{"type":"async", "change": "42", "status-code": 202}`)
+		n++
+	})
+
+	_, err := snap.Parser().ParseArgs([]string{"refresh", "--no-rate-limit", "--no-wait", "one", "two"})
+	c.Assert(err, check.IsNil)
+	c.Check(n, check.Equals, 1)
+}
+
 func (s *SnapOpSuite) TestRefreshOneModeErr(c *check.C) {
 	s.RedirectClientToTestServer(nil)
 	_, err := snap.Parser().ParseArgs([]string{"refresh", "--jailmode", "--devmode", "one"})
@@ -577,6 +722,28 @@ func (s *SnapOpSuite) TestRefreshOneChanErr(c *check.C) {
 	c.Assert(err, check.ErrorMatches, `Please specify a single channel`)
 }
 
+func (s *SnapOpSuite) TestRefreshOneInvalidChannel(c *check.C) {
+	s.RedirectClientToTestServer(nil)
+	_, err := snap.Parser().ParseArgs([]string{"refresh", "--channel=2.x/foo", "one"})
+	c.Assert(err, check.ErrorMatches, `invalid risk in channel name "2.x/foo": "foo"`)
+}
+
+func (s *SnapOpSuite) TestRefreshOneTrackChannel(c *check.C) {
+	s.RedirectClientToTestServer(s.srv.handle)
+	s.srv.checker = func(r *http.Request) {
+		c.Check(r.Method, check.Equals, "POST")
+		c.Check(r.URL.Path, check.Equals, "/v2/snaps/foo")
+		c.Check(DecodedRequestBody(c, r), check.DeepEquals, map[string]interface{}{
+			"action":  "refresh",
+			"channel": "2.x/beta",
+		})
+		s.srv.channel = "2.x/beta"
+	}
+	_, err := snap.Parser().ParseArgs([]string{"refresh", "--channel=2.x/beta", "foo"})
+	c.Assert(err, check.IsNil)
+	c.Check(s.Stdout(), check.Matches, `(?sm).*foo \(2.x/beta\) 1.0 from 'bar' refreshed`)
+}
+
 func (s *SnapOpSuite) TestRefreshAllChannel(c *check.C) {
 	s.RedirectClientToTestServer(nil)
 	_, err := snap.Parser().ParseArgs([]string{"refresh", "--beta"})
@@ -595,6 +762,50 @@ func (s *SnapOpSuite) TestRefreshManyIgnoreValidation(c *check.C) {
 	c.Assert(err, check.ErrorMatches, `a single snap name must be specified when ignoring validation`)
 }
 
+func (s *SnapOpSuite) TestRefreshManyTransaction(c *check.C) {
+	total := 3
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			c.Check(r.Method, check.Equals, "POST")
+			c.Check(r.URL.Path, check.Equals, "/v2/snaps")
+			c.Check(DecodedRequestBody(c, r), check.DeepEquals, map[string]interface{}{
+				"action":      "refresh",
+				"snaps":       []interface{}{"one", "two"},
+				"transaction": "all-snaps",
+			})
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprintln(w, `{"type":"async", "change": "42", "status-code": 202}`)
+		case 1:
+			c.Check(r.Method, check.Equals, "GET")
+			c.Check(r.URL.Path, check.Equals, "/v2/changes/42")
+			fmt.Fprintln(w, `{"type": "sync", "result": {"ready": true, "status": "Done", "data": {"snap-names": ["one","two"]}}}`)
+		case 2:
+			c.Check(r.Method, check.Equals, "GET")
+			c.Check(r.URL.Path, check.Equals, "/v2/snaps")
+			fmt.Fprintf(w, `{"type": "sync", "result": [{"name": "one", "status": "active", "version": "1.0", "developer": "bar", "revision":42, "channel":"stable"},{"name": "two", "status": "active", "version": "2.0", "developer": "baz", "revision":42, "channel":"stable"}]}\n`)
+		default:
+			c.Fatalf("expected to get %d requests, now on %d", total, n+1)
+		}
+
+		n++
+	})
+
+	rest, err := snap.Parser().ParseArgs([]string{"refresh", "--transaction=all-snaps", "one", "two"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), check.Matches, `(?sm).*one 1.0 from 'bar' refreshed`)
+	c.Check(s.Stdout(), check.Matches, `(?sm).*two 2.0 from 'baz' refreshed`)
+	c.Check(n, check.Equals, total)
+}
+
+func (s *SnapOpSuite) TestRefreshManyTransactionInvalid(c *check.C) {
+	s.RedirectClientToTestServer(nil)
+	_, err := snap.Parser().ParseArgs([]string{"refresh", "--transaction=some-snaps", "one", "two"})
+	c.Assert(err, check.ErrorMatches, `.*Invalid value .some-snaps. for option .--transaction.*`)
+}
+
 func (s *SnapOpSuite) TestRefreshAllModeFlags(c *check.C) {
 	s.RedirectClientToTestServer(nil)
 	_, err := snap.Parser().ParseArgs([]string{"refresh", "--devmode"})
@@ -765,6 +976,36 @@ func (s *SnapOpSuite) TestDisable(c *check.C) {
 	c.Check(s.srv.n, check.Equals, s.srv.total)
 }
 
+func (s *SnapOpSuite) TestSwitch(c *check.C) {
+	s.srv.total = 3
+	s.srv.checker = func(r *http.Request) {
+		c.Check(r.URL.Path, check.Equals, "/v2/snaps/foo")
+		c.Check(DecodedRequestBody(c, r), check.DeepEquals, map[string]interface{}{
+			"action":  "switch",
+			"channel": "beta",
+		})
+	}
+
+	s.RedirectClientToTestServer(s.srv.handle)
+	rest, err := snap.Parser().ParseArgs([]string{"switch", "--beta", "foo"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), check.Matches, `(?sm).*"foo" switched to the "beta" channel`)
+	c.Check(s.Stderr(), check.Equals, "")
+	// ensure that the fake server api was actually hit
+	c.Check(s.srv.n, check.Equals, s.srv.total)
+}
+
+func (s *SnapOpSuite) TestSwitchUnhappy(c *check.C) {
+	_, err := snap.Parser().ParseArgs([]string{"switch"})
+	c.Assert(err, check.ErrorMatches, "the required argument `<snap>` was not provided")
+}
+
+func (s *SnapOpSuite) TestSwitchAlsoUnhappy(c *check.C) {
+	_, err := snap.Parser().ParseArgs([]string{"switch", "foo"})
+	c.Assert(err, check.ErrorMatches, `missing --channel=<channel-name> parameter`)
+}
+
 func (s *SnapOpSuite) TestRemove(c *check.C) {
 	s.srv.total = 3
 	s.srv.checker = func(r *http.Request) {
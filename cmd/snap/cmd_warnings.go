@@ -0,0 +1,184 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/i18n"
+	"github.com/snapcore/snapd/osutil"
+)
+
+type cmdWarnings struct {
+	All bool `long:"all"`
+}
+
+type cmdOkay struct{}
+
+var shortWarningsHelp = i18n.G("List warnings")
+var longWarningsHelp = i18n.G(`
+The warnings command lists the warnings that were reported to the system.
+
+Once a warning is listed with 'snap warnings' it can be acknowledged
+with 'snap okay', after which it is not listed again until it is
+repeated, and no longer causes a hint to be shown after other snap
+commands.
+`)
+
+var shortOkayHelp = i18n.G("Acknowledge warnings")
+var longOkayHelp = i18n.G(`
+The okay command acknowledges the warnings listed with 'snap warnings'.
+`)
+
+func init() {
+	addCommand("warnings", shortWarningsHelp, longWarningsHelp, func() flags.Commander { return &cmdWarnings{} }, map[string]string{
+		"all": i18n.G("Show all warnings, including the ones already acknowledged"),
+	}, nil)
+	addCommand("okay", shortOkayHelp, longOkayHelp, func() flags.Commander { return &cmdOkay{} }, nil, nil)
+}
+
+func (x *cmdWarnings) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	cli := Client()
+	warnings, err := cli.Warnings(client.WarningsOptions{All: x.All})
+	if err != nil {
+		return err
+	}
+	if len(warnings) == 0 {
+		fmt.Fprintln(Stderr, i18n.G("No warnings."))
+		return nil
+	}
+
+	var last time.Time
+	w := tabWriter()
+	fmt.Fprintln(w, i18n.G("Last\tWarning"))
+	for _, warning := range warnings {
+		fmt.Fprintf(w, "%s\t%s\n", warning.LastAdded.UTC().Format(time.RFC3339), warning.Message)
+		if warning.LastAdded.After(last) {
+			last = warning.LastAdded
+		}
+	}
+	w.Flush()
+
+	return writeWarningTimestamp(last)
+}
+
+func (x *cmdOkay) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	last, err := lastWarningTimestamp()
+	if err != nil {
+		return err
+	}
+
+	cli := Client()
+	return cli.Okay(last)
+}
+
+// warnFilename returns the file in which the timestamp of the latest
+// warning listed to the user is kept, so that 'snap okay' acknowledges
+// only what the user has seen.
+var warnFilename = func(homeDir string) string {
+	return filepath.Join(homeDir, ".snap", "warnings.json")
+}
+
+type warningsState struct {
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func writeWarningTimestamp(t time.Time) error {
+	real, err := osutil.RealUser()
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.Atoi(real.Uid)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(real.Gid)
+	if err != nil {
+		return err
+	}
+
+	filename := warnFilename(real.HomeDir)
+	if err := osutil.MkdirAllChown(filepath.Dir(filename), 0700, uid, gid); err != nil {
+		return err
+	}
+	data, err := json.Marshal(warningsState{Timestamp: t})
+	if err != nil {
+		return err
+	}
+	return osutil.AtomicWriteFileChown(filename, data, 0600, 0, uid, gid)
+}
+
+var errNoWarningsListed = fmt.Errorf(i18n.G("you must have looked at the warnings before acknowledging them. Try 'snap warnings'."))
+
+func lastWarningTimestamp() (time.Time, error) {
+	real, err := osutil.RealUser()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	f, err := os.Open(warnFilename(real.HomeDir))
+	if os.IsNotExist(err) {
+		return time.Time{}, errNoWarningsListed
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot open warnings timestamp file: %v", err)
+	}
+	defer f.Close()
+
+	var st warningsState
+	if err := json.NewDecoder(f).Decode(&st); err != nil {
+		return time.Time{}, fmt.Errorf("cannot decode warnings timestamp file: %v", err)
+	}
+	if st.Timestamp.IsZero() {
+		return time.Time{}, errNoWarningsListed
+	}
+	return st.Timestamp, nil
+}
+
+// maybePresentWarnings prints a hint about the warnings snapd reported
+// if any of them is newer than the ones the user already listed.
+func maybePresentWarnings(count int, timestamp time.Time) {
+	if count == 0 {
+		return
+	}
+	if last, _ := lastWarningTimestamp(); !timestamp.After(last) {
+		return
+	}
+
+	// TRANSLATORS: %d is the number of new warnings
+	fmt.Fprintf(Stderr, i18n.NG("WARNING: There is %d new warning. See 'snap warnings'.\n",
+		"WARNING: There are %d new warnings. See 'snap warnings'.\n", uint32(count)), count)
+}
@@ -0,0 +1,130 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	snap "github.com/snapcore/snapd/cmd/snap"
+)
+
+func (s *SnapSuite) TestWarnings(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, "GET")
+		c.Check(r.URL.Path, Equals, "/v2/warnings")
+		c.Check(r.URL.Query().Get("select"), Equals, "")
+		EncodeResponseBody(c, w, map[string]interface{}{
+			"type": "sync",
+			"result": []map[string]interface{}{
+				{"message": "cannot auto-refresh snaps: boom", "first-added": "2017-10-01T12:00:00Z", "last-added": "2017-10-01T12:00:00Z"},
+				{"message": "cannot become operational: nope", "first-added": "2017-10-01T13:00:00Z", "last-added": "2017-10-02T13:00:00Z"},
+			},
+			"warning-count":     2,
+			"warning-timestamp": "2017-10-02T13:00:00Z",
+		})
+	})
+	rest, err := snap.Parser().ParseArgs([]string{"warnings"})
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, []string{})
+	c.Check(s.Stdout(), Equals, `Last                  Warning
+2017-10-01T12:00:00Z  cannot auto-refresh snaps: boom
+2017-10-02T13:00:00Z  cannot become operational: nope
+`)
+	c.Check(s.Stderr(), Equals, "")
+
+	// the latest listed warning is recorded for 'snap okay'
+	data, err := ioutil.ReadFile(s.WarnFile)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, `{"timestamp":"2017-10-02T13:00:00Z"}`)
+}
+
+func (s *SnapSuite) TestWarningsAllNone(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Query().Get("select"), Equals, "all")
+		EncodeResponseBody(c, w, map[string]interface{}{
+			"type":   "sync",
+			"result": []interface{}{},
+		})
+	})
+	_, err := snap.Parser().ParseArgs([]string{"warnings", "--all"})
+	c.Assert(err, IsNil)
+	c.Check(s.Stdout(), Equals, "")
+	c.Check(s.Stderr(), Equals, "No warnings.\n")
+}
+
+func (s *SnapSuite) TestOkay(c *C) {
+	c.Assert(ioutil.WriteFile(s.WarnFile, []byte(`{"timestamp":"2017-10-02T13:00:00Z"}`), 0600), IsNil)
+
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		c.Check(r.Method, Equals, "POST")
+		c.Check(r.URL.Path, Equals, "/v2/warnings")
+		var body map[string]interface{}
+		c.Assert(json.NewDecoder(r.Body).Decode(&body), IsNil)
+		c.Check(body, DeepEquals, map[string]interface{}{
+			"action":    "okay",
+			"timestamp": "2017-10-02T13:00:00Z",
+		})
+		EncodeResponseBody(c, w, map[string]interface{}{
+			"type":   "sync",
+			"result": 2,
+		})
+	})
+	_, err := snap.Parser().ParseArgs([]string{"okay"})
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, 1)
+}
+
+func (s *SnapSuite) TestOkayBeforeWarnings(c *C) {
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Fatalf("unexpected request")
+	})
+	_, err := snap.Parser().ParseArgs([]string{"okay"})
+	c.Assert(err, ErrorMatches, "you must have looked at the warnings before acknowledging them. Try 'snap warnings'.")
+}
+
+func (s *SnapSuite) TestWarningsHint(c *C) {
+	stamp := time.Date(2017, 10, 2, 13, 0, 0, 0, time.UTC)
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		EncodeResponseBody(c, w, map[string]interface{}{
+			"type":              "sync",
+			"result":            map[string]interface{}{"id": "42"},
+			"warning-count":     2,
+			"warning-timestamp": stamp,
+		})
+	})
+
+	restore := mockArgs("snap", "abort", "42")
+	defer restore()
+	c.Assert(snap.RunMain(), IsNil)
+	c.Check(s.Stderr(), Equals, "WARNING: There are 2 new warnings. See 'snap warnings'.\n")
+
+	// no hint once the warnings were listed
+	s.stderr.Reset()
+	c.Assert(ioutil.WriteFile(s.WarnFile, []byte(`{"timestamp":"2017-10-02T13:00:00Z"}`), 0600), IsNil)
+	c.Assert(snap.RunMain(), IsNil)
+	c.Check(s.Stderr(), Equals, "")
+}
@@ -51,7 +51,7 @@ func (x *cmdWatch) Execute(args []string) error {
 		return ErrExtraArgs
 	}
 	cli := Client()
-	_, err := wait(cli, string(x.Positional.ChangeID))
+	_, err := waitChange(cli, string(x.Positional.ChangeID), true)
 
 	return err
 }
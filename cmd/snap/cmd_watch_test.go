@@ -47,6 +47,11 @@ func (s *SnapSuite) TestCmdWatch(c *C) {
 
 	n := 0
 	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/events" {
+			// as with an older snapd
+			w.WriteHeader(404)
+			return
+		}
 		switch n {
 		case 0:
 			c.Check(r.Method, Equals, "GET")
@@ -83,3 +88,39 @@ func (s *SnapSuite) TestCmdWatch(c *C) {
 	c.Assert(err, IsNil)
 	c.Check(string(buf), testutil.Contains, "\rmy-snap 50.00 KB / 100.00 KB")
 }
+
+func (s *SnapSuite) TestCmdWatchEvents(c *C) {
+	restore := snap.MockPollTime(time.Hour)
+	defer restore()
+	restore = snap.MockEventsPollTime(time.Hour)
+	defer restore()
+
+	done := make(chan struct{})
+	defer close(done)
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, "GET")
+		if r.URL.Path == "/v2/events" {
+			c.Check(r.URL.Query().Get("change"), Equals, "42")
+			w.Header().Set("Content-Type", "application/json-seq")
+			fmt.Fprintln(w, "\x1e"+`{"kind": "task-status", "change-id": "42", "task-id": "84", "status": "Done"}`)
+			w.(http.Flusher).Flush()
+			<-done
+			return
+		}
+		c.Check(r.URL.Path, Equals, "/v2/changes/42")
+		switch n {
+		case 0:
+			fmt.Fprintf(w, fmtWatchChangeJSON, 0, 100*1024)
+		case 1:
+			fmt.Fprintln(w, `{"type": "sync", "result": {"id": "42", "ready": true, "status": "Done"}}`)
+		default:
+			c.Fatalf("expected to get 2 requests for the change, now on %d", n+1)
+		}
+		n++
+	})
+
+	_, err := snap.Parser().ParseArgs([]string{"watch", "42"})
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, 2)
+}
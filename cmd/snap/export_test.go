@@ -35,6 +35,7 @@ var (
 	SnapRunHook        = snapRunHook
 	Wait               = wait
 	ResolveApp         = resolveApp
+	ParseAt            = parseAt
 )
 
 func MockPollTime(d time.Duration) (restore func()) {
@@ -45,6 +46,14 @@ func MockPollTime(d time.Duration) (restore func()) {
 	}
 }
 
+func MockEventsPollTime(d time.Duration) (restore func()) {
+	d0 := eventsPollTime
+	eventsPollTime = d
+	return func() {
+		eventsPollTime = d0
+	}
+}
+
 func MockMaxGoneTime(d time.Duration) (restore func()) {
 	d0 := maxGoneTime
 	maxGoneTime = d
@@ -102,3 +111,11 @@ func AliasInfoLess(snapName1, alias1, app1, snapName2, alias2, app2 string) bool
 	}
 	return x.Less(0, 1)
 }
+
+func MockWarnFilename(f func(homeDir string) string) (restore func()) {
+	old := warnFilename
+	warnFilename = f
+	return func() {
+		warnFilename = old
+	}
+}
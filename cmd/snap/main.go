@@ -80,6 +80,9 @@ type cmdInfo struct {
 // commands holds information about all non-experimental commands.
 var commands []*cmdInfo
 
+// debugCommands holds information about all debug commands.
+var debugCommands []*cmdInfo
+
 // experimentalCommands holds information about all experimental commands.
 var experimentalCommands []*cmdInfo
 
@@ -98,6 +101,22 @@ func addCommand(name, shortHelp, longHelp string, builder func() flags.Commander
 	return info
 }
 
+// addDebugCommand replaces parser.addCommand() in a way that is
+// compatible with re-constructing a pristine parser. It is meant for
+// adding debug commands.
+func addDebugCommand(name, shortHelp, longHelp string, builder func() flags.Commander, optDescs map[string]string, argDescs []argDesc) *cmdInfo {
+	info := &cmdInfo{
+		name:      name,
+		shortHelp: shortHelp,
+		longHelp:  longHelp,
+		builder:   builder,
+		optDescs:  optDescs,
+		argDescs:  argDescs,
+	}
+	debugCommands = append(debugCommands, info)
+	return info
+}
+
 // addExperimentalCommand replaces parser.addCommand() in a way that is
 // compatible with re-constructing a pristine parser. It is meant for
 // adding experimental commands.
@@ -137,6 +156,60 @@ func lintArg(cmdName, optName, desc, origDesc string) {
 	}
 }
 
+// commandAdder is either the parser or a command with sub-commands.
+type commandAdder interface {
+	AddCommand(name, shortHelp, longHelp string, data interface{}) (*flags.Command, error)
+}
+
+// addCommandTo adds the command described by c to parent, setting up
+// the descriptions of its options and arguments.
+func addCommandTo(parent commandAdder, parser *flags.Parser, c *cmdInfo) {
+	obj := c.builder()
+	if x, ok := obj.(parserSetter); ok {
+		x.setParser(parser)
+	}
+
+	cmd, err := parent.AddCommand(c.name, c.shortHelp, strings.TrimSpace(c.longHelp), obj)
+	if err != nil {
+		logger.Panicf("cannot add command %q: %v", c.name, err)
+	}
+	cmd.Hidden = c.hidden
+
+	opts := cmd.Options()
+	if c.optDescs != nil && len(opts) != len(c.optDescs) {
+		logger.Panicf("wrong number of option descriptions for %s: expected %d, got %d", c.name, len(opts), len(c.optDescs))
+	}
+	for _, opt := range opts {
+		name := opt.LongName
+		if name == "" {
+			name = string(opt.ShortName)
+		}
+		desc, ok := c.optDescs[name]
+		if !(c.optDescs == nil || ok) {
+			logger.Panicf("%s missing description for %s", c.name, name)
+		}
+		lintDesc(c.name, name, desc, opt.Description)
+		if desc != "" {
+			opt.Description = desc
+		}
+	}
+
+	args := cmd.Args()
+	if c.argDescs != nil && len(args) != len(c.argDescs) {
+		logger.Panicf("wrong number of argument descriptions for %s: expected %d, got %d", c.name, len(args), len(c.argDescs))
+	}
+	for i, arg := range args {
+		name, desc := arg.Name, ""
+		if c.argDescs != nil {
+			name = c.argDescs[i].name
+			desc = c.argDescs[i].desc
+		}
+		lintArg(c.name, name, desc, arg.Description)
+		arg.Name = name
+		arg.Description = desc
+	}
+}
+
 // Parser creates and populates a fresh parser.
 // Since commands have local state a fresh parser is required to isolate tests
 // from each other.
@@ -160,60 +233,34 @@ snaps on the system. Start with 'snap list' to see installed snaps.
 
 	// Add all regular commands
 	for _, c := range commands {
-		obj := c.builder()
-		if x, ok := obj.(parserSetter); ok {
-			x.setParser(parser)
-		}
-
-		cmd, err := parser.AddCommand(c.name, c.shortHelp, strings.TrimSpace(c.longHelp), obj)
-		if err != nil {
-			logger.Panicf("cannot add command %q: %v", c.name, err)
-		}
-		cmd.Hidden = c.hidden
-
-		opts := cmd.Options()
-		if c.optDescs != nil && len(opts) != len(c.optDescs) {
-			logger.Panicf("wrong number of option descriptions for %s: expected %d, got %d", c.name, len(opts), len(c.optDescs))
-		}
-		for _, opt := range opts {
-			name := opt.LongName
-			if name == "" {
-				name = string(opt.ShortName)
-			}
-			desc, ok := c.optDescs[name]
-			if !(c.optDescs == nil || ok) {
-				logger.Panicf("%s missing description for %s", c.name, name)
-			}
-			lintDesc(c.name, name, desc, opt.Description)
-			if desc != "" {
-				opt.Description = desc
-			}
-		}
+		addCommandTo(parser, parser, c)
+	}
 
-		args := cmd.Args()
-		if c.argDescs != nil && len(args) != len(c.argDescs) {
-			logger.Panicf("wrong number of argument descriptions for %s: expected %d, got %d", c.name, len(args), len(c.argDescs))
-		}
-		for i, arg := range args {
-			name, desc := arg.Name, ""
-			if c.argDescs != nil {
-				name = c.argDescs[i].name
-				desc = c.argDescs[i].desc
-			}
-			lintArg(c.name, name, desc, arg.Description)
-			arg.Name = name
-			arg.Description = desc
-		}
+	// Add the debug command, and all its sub-commands
+	debugCommand, err := parser.AddCommand("debug", shortDebugHelp, strings.TrimSpace(longDebugHelp), &cmdDebug{})
+	if err != nil {
+		logger.Panicf("cannot add command %q: %v", "debug", err)
 	}
+	debugCommand.Hidden = true
+	for _, c := range debugCommands {
+		addCommandTo(debugCommand, parser, c)
+	}
+
 	return parser
 }
 
 // ClientConfig is the configuration of the Client used by all commands.
 var ClientConfig client.Config
 
+// lastClient is the last client handed out by Client, consulted once
+// the command is done for the warnings snapd reported.
+var lastClient *client.Client
+
 // Client returns a new client using ClientConfig as configuration.
 func Client() *client.Client {
-	return client.New(&ClientConfig)
+	cli := client.New(&ClientConfig)
+	lastClient = cli
+	return cli
 }
 
 func init() {
@@ -266,6 +313,9 @@ func main() {
 		}
 	}()
 
+	// let snapd ask for authentication when there is someone to answer
+	ClientConfig.Interactive = terminal.IsTerminal(0)
+
 	// no magic /o\
 	if err := run(); err != nil {
 		fmt.Fprintf(Stderr, i18n.G("error: %v\n"), err)
@@ -282,8 +332,17 @@ func (e *exitStatus) Error() string {
 }
 
 func run() error {
+	lastClient = nil
 	parser := Parser()
 	_, err := parser.Parse()
+	if err == nil && lastClient != nil && parser.Command.Active != nil {
+		switch parser.Command.Active.Name {
+		case "warnings", "okay":
+			// the user is dealing with the warnings already
+		default:
+			maybePresentWarnings(lastClient.WarningsSummary())
+		}
+	}
 	if err != nil {
 		if e, ok := err.(*flags.Error); ok {
 			if e.Type == flags.ErrHelp || e.Type == flags.ErrCommandRequired {
@@ -52,6 +52,7 @@ type BaseSnapSuite struct {
 	password string
 
 	AuthFile string
+	WarnFile string
 }
 
 func (s *BaseSnapSuite) readPassword(fd int) ([]byte, error) {
@@ -71,6 +72,8 @@ func (s *BaseSnapSuite) SetUpTest(c *C) {
 	snap.ReadPassword = s.readPassword
 	s.AuthFile = filepath.Join(c.MkDir(), "json")
 	os.Setenv(TestAuthFileEnvKey, s.AuthFile)
+	s.WarnFile = filepath.Join(c.MkDir(), "warnings.json")
+	s.AddCleanup(snap.MockWarnFilename(func(string) string { return s.WarnFile }))
 }
 
 func (s *BaseSnapSuite) TearDownTest(c *C) {
@@ -33,7 +33,7 @@ import (
 )
 
 func init() {
-	err := logger.SimpleSetup()
+	err := logger.DaemonSetup()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "WARNING: failed to activate logging: %s\n", err)
 	}
@@ -20,6 +20,7 @@
 package daemon
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -28,6 +29,7 @@ import (
 	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -47,8 +49,10 @@ import (
 	"github.com/snapcore/snapd/interfaces"
 	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/overlord"
 	"github.com/snapcore/snapd/overlord/assertstate"
 	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/changehistory"
 	"github.com/snapcore/snapd/overlord/configstate"
 	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/devicestate"
@@ -59,6 +63,7 @@ import (
 	"github.com/snapcore/snapd/progress"
 	"github.com/snapcore/snapd/release"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/delta"
 	"github.com/snapcore/snapd/store"
 	"github.com/snapcore/snapd/strutil"
 )
@@ -78,6 +83,7 @@ var api = []*Command{
 	assertsFindManyCmd,
 	stateChangeCmd,
 	stateChangesCmd,
+	eventsCmd,
 	createUserCmd,
 	buyCmd,
 	readyToBuyCmd,
@@ -85,6 +91,9 @@ var api = []*Command{
 	usersCmd,
 	sectionsCmd,
 	aliasesCmd,
+	debugCmd,
+	warningsCmd,
+	metricsCmd,
 }
 
 var (
@@ -109,6 +118,7 @@ var (
 		Path:   "/v2/logout",
 		POST:   logoutUser,
 		UserOK: true,
+		Role:   postRole(auth.RoleViewer),
 	}
 
 	appIconCmd = &Command{
@@ -124,30 +134,35 @@ var (
 	}
 
 	snapsCmd = &Command{
-		Path:   "/v2/snaps",
-		UserOK: true,
-		GET:    getSnapsInfo,
-		POST:   postSnaps,
+		Path:         "/v2/snaps",
+		UserOK:       true,
+		PolkitAction: polkitRequestAction,
+		GET:          getSnapsInfo,
+		POST:         postSnaps,
 	}
 
 	snapCmd = &Command{
-		Path:   "/v2/snaps/{name}",
-		UserOK: true,
-		GET:    getSnapInfo,
-		POST:   postSnap,
+		Path:         "/v2/snaps/{name}",
+		UserOK:       true,
+		PolkitAction: polkitRequestAction,
+		Role:         snapRequestRole,
+		GET:          getSnapInfo,
+		POST:         postSnap,
 	}
 
 	snapConfCmd = &Command{
-		Path: "/v2/snaps/{name}/conf",
-		GET:  getSnapConf,
-		PUT:  setSnapConf,
+		Path:         "/v2/snaps/{name}/conf",
+		PolkitAction: polkitAction("configure"),
+		GET:          getSnapConf,
+		PUT:          setSnapConf,
 	}
 
 	interfacesCmd = &Command{
-		Path:   "/v2/interfaces",
-		UserOK: true,
-		GET:    getInterfaces,
-		POST:   changeInterfaces,
+		Path:         "/v2/interfaces",
+		UserOK:       true,
+		PolkitAction: polkitRequestAction,
+		GET:          getInterfaces,
+		POST:         changeInterfaces,
 	}
 
 	// TODO: allow to post assertions for UserOK? they are verified anyway
@@ -165,8 +180,9 @@ var (
 	stateChangeCmd = &Command{
 		Path:   "/v2/changes/{id}",
 		UserOK: true,
+		Role:   postRole(auth.RoleOperator),
 		GET:    getChange,
-		POST:   abortChange,
+		POST:   postChange,
 	}
 
 	stateChangesCmd = &Command{
@@ -175,6 +191,12 @@ var (
 		GET:    getChanges,
 	}
 
+	eventsCmd = &Command{
+		Path:   "/v2/events",
+		UserOK: true,
+		GET:    getEvents,
+	}
+
 	createUserCmd = &Command{
 		Path:   "/v2/create-user",
 		UserOK: false,
@@ -203,6 +225,7 @@ var (
 		Path:   "/v2/users",
 		UserOK: false,
 		GET:    getUsers,
+		POST:   postUsers,
 	}
 
 	sectionsCmd = &Command{
@@ -217,8 +240,99 @@ var (
 		GET:    getAliases,
 		POST:   changeAliases,
 	}
+
+	debugCmd = &Command{
+		Path:   "/v2/debug",
+		UserOK: true,
+		GET:    getDebug,
+	}
+
+	warningsCmd = &Command{
+		Path:   "/v2/warnings",
+		UserOK: true,
+		Role:   postRole(auth.RoleOperator),
+		GET:    getWarnings,
+		POST:   ackWarnings,
+	}
+
+	metricsCmd = &Command{
+		Path:   "/v2/metrics",
+		UserOK: true,
+		GET:    getMetrics,
+	}
 )
 
+const polkitActionPrefix = "io.snapcraft.snapd."
+
+// polkitAction returns a PolkitAction for commands that always need
+// the same polkit action.
+func polkitAction(action string) func(*http.Request) string {
+	return func(*http.Request) string {
+		return polkitActionPrefix + action
+	}
+}
+
+// polkitRequestAction returns the polkit action for the operation
+// requested of snaps or interfaces, found in the request body which is
+// left to be read again by the command.
+func polkitRequestAction(r *http.Request) string {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		// sideloading
+		return polkitActionPrefix + "install"
+	}
+
+	switch action := requestAction(r); action {
+	case "install", "try":
+		return polkitActionPrefix + "install"
+	case "refresh", "switch":
+		return polkitActionPrefix + "refresh"
+	case "remove", "revert", "enable", "disable", "connect", "disconnect":
+		return polkitActionPrefix + action
+	}
+	return ""
+}
+
+// requestAction returns the action found in the JSON request body, if
+// any, leaving the body to be read again by the command.
+func requestAction(r *http.Request) string {
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxReadBuflen))
+	r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(data), r.Body))
+	if err != nil {
+		return ""
+	}
+	var req struct {
+		Action string `json:"action"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return ""
+	}
+	return req.Action
+}
+
+// postRole returns a Role for commands whose POST always needs role.
+func postRole(role auth.Role) func(*http.Request) auth.Role {
+	return func(r *http.Request) auth.Role {
+		if r.Method == "POST" {
+			return role
+		}
+		return ""
+	}
+}
+
+// snapRequestRole returns the role needed for the operation requested
+// of a snap: operators can enable and disable snaps, but changing what
+// is installed is left to admins.
+func snapRequestRole(r *http.Request) auth.Role {
+	if r.Method != "POST" {
+		return ""
+	}
+	switch requestAction(r) {
+	case "enable", "disable":
+		return auth.RoleOperator
+	}
+	return ""
+}
+
 func tbd(c *Command, r *http.Request, user *auth.UserState) Response {
 	return SyncResponse([]string{"TBD"}, nil)
 }
@@ -256,6 +370,8 @@ type userResponseData struct {
 	Username string   `json:"username,omitempty"`
 	Email    string   `json:"email,omitempty"`
 	SSHKeys  []string `json:"ssh-keys,omitempty"`
+	// Roles are the roles granted to the user, if any
+	Roles []auth.Role `json:"roles,omitempty"`
 
 	Macaroon   string   `json:"macaroon,omitempty"`
 	Discharges []string `json:"discharges,omitempty"`
@@ -734,6 +850,9 @@ type snapInstruction struct {
 	JailMode         bool          `json:"jailmode"`
 	Classic          bool          `json:"classic"`
 	IgnoreValidation bool          `json:"ignore-validation"`
+	NoRateLimit      bool          `json:"no-rate-limit"`
+	// Transaction is only meaningful for multi-snap operations
+	Transaction snapstate.TransactionType `json:"transaction"`
 	// dropping support temporarely until flag confusion is sorted,
 	// this isn't supported by client atm anyway
 	LeaveOld bool         `json:"temp-dropped-leave-old"`
@@ -745,7 +864,17 @@ type snapInstruction struct {
 }
 
 func (inst *snapInstruction) modeFlags() (snapstate.Flags, error) {
-	return modeFlags(inst.DevMode, inst.JailMode, inst.Classic)
+	flags, err := modeFlags(inst.DevMode, inst.JailMode, inst.Classic)
+	flags.NoRateLimit = inst.NoRateLimit
+	return flags, err
+}
+
+// multiFlags returns the flags for a multi-snap operation.
+func (inst *snapInstruction) multiFlags() *snapstate.Flags {
+	return &snapstate.Flags{
+		Transaction: inst.Transaction,
+		NoRateLimit: inst.NoRateLimit,
+	}
 }
 
 var (
@@ -760,6 +889,7 @@ var (
 	snapstateRemoveMany        = snapstate.RemoveMany
 	snapstateRevert            = snapstate.Revert
 	snapstateRevertToRevision  = snapstate.RevertToRevision
+	snapstateSwitch            = snapstate.Switch
 
 	assertstateRefreshSnapDeclarations = assertstate.RefreshSnapDeclarations
 )
@@ -838,7 +968,7 @@ func snapUpdateMany(inst *snapInstruction, st *state.State) (msg string, updated
 		return "", nil, nil, err
 	}
 
-	updated, tasksets, err = snapstateUpdateMany(st, inst.Snaps, inst.userID)
+	updated, tasksets, err = snapstateUpdateMany(st, inst.Snaps, inst.userID, inst.multiFlags())
 	if err != nil {
 		return "", nil, nil, err
 	}
@@ -863,7 +993,7 @@ func snapUpdateMany(inst *snapInstruction, st *state.State) (msg string, updated
 }
 
 func snapInstallMany(inst *snapInstruction, st *state.State) (msg string, installed []string, tasksets []*state.TaskSet, err error) {
-	installed, tasksets, err = snapstateInstallMany(st, inst.Snaps, inst.userID)
+	installed, tasksets, err = snapstateInstallMany(st, inst.Snaps, inst.userID, inst.multiFlags())
 	if err != nil {
 		return "", nil, nil, err
 	}
@@ -1011,6 +1141,22 @@ func snapDisable(inst *snapInstruction, st *state.State) (string, []*state.TaskS
 	return msg, []*state.TaskSet{ts}, nil
 }
 
+func snapSwitch(inst *snapInstruction, st *state.State) (string, []*state.TaskSet, error) {
+	if !inst.Revision.Unset() {
+		return "", nil, errors.New("switch takes no revision")
+	}
+	if inst.Channel == "" {
+		return "", nil, errors.New("switch requires a channel")
+	}
+	ts, err := snapstateSwitch(st, inst.Snaps[0], inst.Channel, inst.userID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	msg := fmt.Sprintf(i18n.G("Switch %q snap to %s"), inst.Snaps[0], inst.Channel)
+	return msg, []*state.TaskSet{ts}, nil
+}
+
 type snapActionFunc func(*snapInstruction, *state.State) (string, []*state.TaskSet, error)
 
 var snapInstructionDispTable = map[string]snapActionFunc{
@@ -1020,6 +1166,7 @@ var snapInstructionDispTable = map[string]snapActionFunc{
 	"revert":  snapRevert,
 	"enable":  snapEnable,
 	"disable": snapDisable,
+	"switch":  snapSwitch,
 }
 
 func (inst *snapInstruction) dispatch() snapActionFunc {
@@ -1067,6 +1214,11 @@ func postSnap(c *Command, r *http.Request, user *auth.UserState) Response {
 		return BadRequest("cannot decode request body into snap instruction: %v", err)
 	}
 
+	at, err := scheduledTime(r)
+	if err != nil {
+		return BadRequest("%v", err)
+	}
+
 	state := c.d.overlord.State()
 	state.Lock()
 	defer state.Unlock()
@@ -1089,6 +1241,7 @@ func postSnap(c *Command, r *http.Request, user *auth.UserState) Response {
 	}
 
 	chg := newChange(state, inst.Action+"-snap", msg, tsets, inst.Snaps)
+	scheduleChange(chg, at)
 
 	ensureStateSoon(state)
 
@@ -1106,6 +1259,64 @@ func newChange(st *state.State, kind, summary string, tsets []*state.TaskSet, sn
 	return chg
 }
 
+// scheduledTime returns the time requested with the "at" parameter for
+// the change to start at, or the zero time if it should start right away.
+func scheduledTime(r *http.Request) (time.Time, error) {
+	s := r.URL.Query().Get("at")
+	if s == "" {
+		return time.Time{}, nil
+	}
+	at, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %q parameter: %v", "at", err)
+	}
+	now := time.Now()
+	if at.Before(now) {
+		return time.Time{}, fmt.Errorf("cannot schedule a change in the past")
+	}
+	// the change would be aborted before starting
+	if maxWait := overlord.AbortWait(); at.After(now.Add(maxWait)) {
+		return time.Time{}, fmt.Errorf("cannot schedule a change more than %d days ahead", int(maxWait.Hours()/24))
+	}
+	return at, nil
+}
+
+// scheduleChange holds the tasks the change starts with until the given
+// time, if any. Holding the first tasks is enough as the others wait
+// for them.
+//
+// Until then the change conflicts with other changes to the same snaps
+// as if it had started, see snapstate.CheckChangeConflict.
+func scheduleChange(chg *state.Change, at time.Time) {
+	if at.IsZero() {
+		return
+	}
+	for _, t := range chg.Tasks() {
+		if len(t.WaitTasks()) == 0 {
+			t.At(at)
+		}
+	}
+}
+
+// scheduledFor returns when the change is scheduled to start, or nil if
+// it isn't waiting for that.
+func scheduledFor(chg *state.Change) *time.Time {
+	if chg.Status() != state.DoStatus {
+		return nil
+	}
+	var when time.Time
+	for _, t := range chg.Tasks() {
+		at := t.AtTime()
+		if !at.IsZero() && (when.IsZero() || at.Before(when)) {
+			when = at
+		}
+	}
+	if when.IsZero() || !when.After(time.Now()) {
+		return nil
+	}
+	return &when
+}
+
 const maxReadBuflen = 1024 * 1024
 
 func trySnap(c *Command, r *http.Request, user *auth.UserState, trydir string, flags snapstate.Flags) Response {
@@ -1186,6 +1397,19 @@ func snapsOp(c *Command, r *http.Request, user *auth.UserState) Response {
 	if inst.Channel != "" || !inst.Revision.Unset() || inst.DevMode || inst.JailMode {
 		return BadRequest("unsupported option provided for multi-snap operation")
 	}
+	if err := snapstate.ValidateTransaction(inst.Transaction); err != nil {
+		return BadRequest("%v", err)
+	}
+	if inst.Transaction != "" && inst.Action != "refresh" && inst.Action != "install" {
+		return BadRequest("transaction type is unsupported for multi-snap %s", inst.Action)
+	}
+	if inst.NoRateLimit && inst.Action != "refresh" && inst.Action != "install" {
+		return BadRequest("no-rate-limit is unsupported for multi-snap %s", inst.Action)
+	}
+	at, err := scheduledTime(r)
+	if err != nil {
+		return BadRequest("%v", err)
+	}
 
 	st := c.d.overlord.State()
 	st.Lock()
@@ -1198,7 +1422,6 @@ func snapsOp(c *Command, r *http.Request, user *auth.UserState) Response {
 	var msg string
 	var affected []string
 	var tsets []*state.TaskSet
-	var err error
 	switch inst.Action {
 	case "refresh":
 		msg, affected, tsets, err = snapUpdateMany(&inst, st)
@@ -1219,6 +1442,7 @@ func snapsOp(c *Command, r *http.Request, user *auth.UserState) Response {
 		chg.SetStatus(state.DoneStatus)
 	} else {
 		chg = newChange(st, inst.Action+"-snap", msg, tsets, affected)
+		scheduleChange(chg, at)
 		ensureStateSoon(st)
 	}
 	chg.Set("api-data", map[string]interface{}{"snap-names": affected})
@@ -1253,6 +1477,11 @@ func postSnaps(c *Command, r *http.Request, user *auth.UserState) Response {
 		return BadRequest("cannot read POST form: %v", err)
 	}
 
+	at, err := scheduledTime(r)
+	if err != nil {
+		return BadRequest("%v", err)
+	}
+
 	dangerousOK := isTrue(form, "dangerous")
 	flags, err := modeFlags(isTrue(form, "devmode"), isTrue(form, "jailmode"), isTrue(form, "classic"))
 	if err != nil {
@@ -1318,6 +1547,25 @@ out:
 	}
 
 	st := c.d.overlord.State()
+
+	var deltaFor string
+	if isTrue(form, "delta") {
+		if dangerousOK {
+			return BadRequest("cannot install a delta without signatures")
+		}
+		if len(form.Value["name"]) == 0 {
+			return BadRequest("need 'name' value in form to install a delta")
+		}
+		deltaFor = form.Value["name"][0]
+
+		targetPath, err := applySideloadDelta(st, deltaFor, tempPath)
+		if err != nil {
+			return BadRequest("cannot apply delta for snap %q: %v", deltaFor, err)
+		}
+		os.Remove(tempPath)
+		tempPath = targetPath
+	}
+
 	st.Lock()
 	defer st.Unlock()
 
@@ -1332,8 +1580,9 @@ out:
 			sideInfo = si
 		case asserts.ErrNotFound:
 			// with devmode we try to find assertions but it's ok
-			// if they are not there (implies --dangerous)
-			if !isTrue(form, "devmode") {
+			// if they are not there (implies --dangerous), but a
+			// delta can only be checked against the signatures
+			if !isTrue(form, "devmode") || deltaFor != "" {
 				msg := "cannot find signatures with metadata for snap"
 				if origPath != "" {
 					msg = fmt.Sprintf("%s %q", msg, origPath)
@@ -1346,6 +1595,10 @@ out:
 		}
 	}
 
+	if deltaFor != "" && snapName != deltaFor {
+		return BadRequest("cannot install delta for snap %q: it produces snap %q", deltaFor, snapName)
+	}
+
 	if snapName == "" {
 		// potentially dangerous but dangerous or devmode params were set
 		info, err := unsafeReadSnapInfo(tempPath)
@@ -1377,6 +1630,7 @@ out:
 
 	chg := newChange(st, "install-snap", msg, tsets, []string{snapName})
 	chg.Set("api-data", map[string]string{"snap-name": snapName})
+	scheduleChange(chg, at)
 
 	ensureStateSoon(st)
 
@@ -1387,6 +1641,56 @@ out:
 	return AsyncResponse(nil, &Meta{Change: chg.ID()})
 }
 
+var (
+	deltaApply    = delta.Apply
+	deltaFormatOf = delta.FormatOf
+)
+
+// applySideloadDelta builds the snap file obtained by applying the
+// delta at deltaPath to the current revision of the named snap, and
+// returns its path.
+func applySideloadDelta(st *state.State, name, deltaPath string) (string, error) {
+	st.Lock()
+	var snapst snapstate.SnapState
+	err := snapstate.Get(st, name, &snapst)
+	if err == nil && !snapst.HasCurrent() {
+		err = state.ErrNoState
+	}
+	if err == state.ErrNoState {
+		err = fmt.Errorf("snap is not installed")
+	}
+	var info *snap.Info
+	if err == nil {
+		info, err = snapst.CurrentInfo()
+	}
+	st.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	format, err := deltaFormatOf(deltaPath)
+	if err != nil {
+		return "", err
+	}
+
+	// if you change this prefix, look for it in the tests
+	tmpf, err := ioutil.TempFile("", "snapd-sideload-pkg-")
+	if err != nil {
+		return "", err
+	}
+	targetPath := tmpf.Name()
+	tmpf.Close()
+	// xdelta3 refuses to overwrite existing files
+	os.Remove(targetPath)
+
+	if err := deltaApply(format, info.MountFile(), deltaPath, targetPath); err != nil {
+		os.Remove(targetPath)
+		return "", err
+	}
+
+	return targetPath, nil
+}
+
 func unsafeReadSnapInfoImpl(snapPath string) (*snap.Info, error) {
 	// Condider using DeriveSideInfo before falling back to this!
 	snapf, err := snap.Open(snapPath)
@@ -1460,6 +1764,11 @@ func setSnapConf(c *Command, r *http.Request, user *auth.UserState) Response {
 		return BadRequest("cannot decode request body into patch values: %v", err)
 	}
 
+	at, err := scheduledTime(r)
+	if err != nil {
+		return BadRequest("%v", err)
+	}
+
 	st := c.d.overlord.State()
 	st.Lock()
 	defer st.Unlock()
@@ -1475,6 +1784,7 @@ func setSnapConf(c *Command, r *http.Request, user *auth.UserState) Response {
 
 	summary := fmt.Sprintf("Change configuration of %q snap", snapName)
 	change := newChange(st, "configure-snap", summary, []*state.TaskSet{taskset}, []string{snapName})
+	scheduleChange(change, at)
 
 	st.EnsureBefore(0)
 
@@ -1542,9 +1852,13 @@ func changeInterfaces(c *Command, r *http.Request, user *auth.UserState) Respons
 		return BadRequest("at least one plug and slot is required")
 	}
 
+	at, err := scheduledTime(r)
+	if err != nil {
+		return BadRequest("%v", err)
+	}
+
 	var summary string
 	var taskset *state.TaskSet
-	var err error
 
 	state := c.d.overlord.State()
 	state.Lock()
@@ -1570,6 +1884,7 @@ func changeInterfaces(c *Command, r *http.Request, user *auth.UserState) Respons
 	change := state.NewChange(a.Action+"-snap", summary)
 	change.Set("snap-names", []string{a.Plugs[0].Snap, a.Slots[0].Snap})
 	change.AddAll(taskset)
+	scheduleChange(change, at)
 
 	state.EnsureBefore(0)
 
@@ -1634,6 +1949,9 @@ type changeInfo struct {
 
 	SpawnTime time.Time  `json:"spawn-time,omitempty"`
 	ReadyTime *time.Time `json:"ready-time,omitempty"`
+	// ScheduledTime is when the change is scheduled to start, while
+	// it's waiting for that
+	ScheduledTime *time.Time `json:"scheduled-time,omitempty"`
 
 	Data map[string]*json.RawMessage `json:"data,omitempty"`
 }
@@ -1645,6 +1963,8 @@ type taskInfo struct {
 	Status   string           `json:"status"`
 	Log      []string         `json:"log,omitempty"`
 	Progress taskInfoProgress `json:"progress"`
+	// WaitReason says what a task in the Wait status waits for
+	WaitReason string `json:"wait-reason,omitempty"`
 
 	SpawnTime time.Time  `json:"spawn-time,omitempty"`
 	ReadyTime *time.Time `json:"ready-time,omitempty"`
@@ -1656,6 +1976,37 @@ type taskInfoProgress struct {
 	Total int    `json:"total"`
 }
 
+type eventInfo struct {
+	Kind     string            `json:"kind"`
+	Time     time.Time         `json:"time"`
+	ChangeID string            `json:"change-id,omitempty"`
+	TaskID   string            `json:"task-id,omitempty"`
+	Status   string            `json:"status,omitempty"`
+	Progress *taskInfoProgress `json:"progress,omitempty"`
+	Data     map[string]string `json:"data,omitempty"`
+}
+
+func event2eventInfo(ev *state.Event) *eventInfo {
+	evInfo := &eventInfo{
+		Kind:     ev.Kind,
+		Time:     ev.Time,
+		ChangeID: ev.ChangeID,
+		TaskID:   ev.TaskID,
+		Data:     ev.Data,
+	}
+	if ev.Kind == state.EventChangeStatus || ev.Kind == state.EventTaskStatus {
+		evInfo.Status = ev.Status.String()
+	}
+	if ev.Progress != nil {
+		evInfo.Progress = &taskInfoProgress{
+			Label: ev.Progress.Label,
+			Done:  ev.Progress.Done,
+			Total: ev.Progress.Total,
+		}
+	}
+	return evInfo
+}
+
 func change2changeInfo(chg *state.Change) *changeInfo {
 	status := chg.Status()
 	chgInfo := &changeInfo{
@@ -1671,6 +2022,7 @@ func change2changeInfo(chg *state.Change) *changeInfo {
 	if !readyTime.IsZero() {
 		chgInfo.ReadyTime = &readyTime
 	}
+	chgInfo.ScheduledTime = scheduledFor(chg)
 	if err := chg.Err(); err != nil {
 		chgInfo.Err = err.Error()
 	}
@@ -1691,7 +2043,8 @@ func change2changeInfo(chg *state.Change) *changeInfo {
 				Done:  done,
 				Total: total,
 			},
-			SpawnTime: t.SpawnTime(),
+			WaitReason: t.WaitReason(),
+			SpawnTime:  t.SpawnTime(),
 		}
 		readyTime := t.ReadyTime()
 		if !readyTime.IsZero() {
@@ -1722,6 +2075,43 @@ func getChange(c *Command, r *http.Request, user *auth.UserState) Response {
 	return SyncResponse(change2changeInfo(chg), nil)
 }
 
+// getEvents streams the events of the state, optionally only those
+// about the change given with "change" and/or of the kinds listed in
+// "kinds", until the client goes away.
+func getEvents(c *Command, r *http.Request, user *auth.UserState) Response {
+	query := r.URL.Query()
+
+	var kinds map[string]bool
+	if qkinds := query.Get("kinds"); qkinds != "" {
+		kinds = make(map[string]bool)
+		for _, kind := range strings.Split(qkinds, ",") {
+			kinds[kind] = true
+		}
+	}
+
+	st := c.d.overlord.State()
+	chID := query.Get("change")
+	if chID != "" {
+		st.Lock()
+		chg := st.Change(chID)
+		st.Unlock()
+		if chg == nil {
+			return NotFound("cannot find change with id %q", chID)
+		}
+	}
+
+	return &eventsResponse{
+		st: st,
+		filter: func(ev *state.Event) bool {
+			if chID != "" && ev.ChangeID != chID {
+				return false
+			}
+			return kinds == nil || kinds[ev.Kind]
+		},
+		dying: c.d.Dying(),
+	}
+}
+
 func getChanges(c *Command, r *http.Request, user *auth.UserState) Response {
 	query := r.URL.Query()
 	qselect := query.Get("select")
@@ -1736,8 +2126,10 @@ func getChanges(c *Command, r *http.Request, user *auth.UserState) Response {
 		filter = func(chg *state.Change) bool { return !chg.Status().Ready() }
 	case "ready":
 		filter = func(chg *state.Change) bool { return chg.Status().Ready() }
+	case "history":
+		return getChangeHistory(c, query)
 	default:
-		return BadRequest("select should be one of: all,in-progress,ready")
+		return BadRequest("select should be one of: all,in-progress,ready,history")
 	}
 
 	if wantedName := query.Get("for"); wantedName != "" {
@@ -1777,12 +2169,47 @@ func getChanges(c *Command, r *http.Request, user *auth.UserState) Response {
 	return SyncResponse(chgInfos, nil)
 }
 
-func abortChange(c *Command, r *http.Request, user *auth.UserState) Response {
+// getChangeHistory returns the entries of the history of the changes
+// pruned from the state, spawned between the optional "since" and
+// "until" times.
+func getChangeHistory(c *Command, query url.Values) Response {
+	opts := &changehistory.Options{
+		SnapName: query.Get("for"),
+	}
+	for _, param := range []struct {
+		name string
+		t    *time.Time
+	}{
+		{"since", &opts.Since},
+		{"until", &opts.Until},
+	} {
+		v := query.Get(param.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return BadRequest("invalid %q parameter: %v", param.name, err)
+		}
+		*param.t = t
+	}
+
+	entries, err := c.d.overlord.ChangeHistory().Entries(opts)
+	if err != nil {
+		return InternalError("cannot read the change history: %v", err)
+	}
+	if entries == nil {
+		entries = []*changehistory.Entry{}
+	}
+	return SyncResponse(entries, nil)
+}
+
+func postChange(c *Command, r *http.Request, user *auth.UserState) Response {
 	chID := muxVars(r)["id"]
-	state := c.d.overlord.State()
-	state.Lock()
-	defer state.Unlock()
-	chg := state.Change(chID)
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+	chg := st.Change(chID)
 	if chg == nil {
 		return NotFound("cannot find change with id %q", chID)
 	}
@@ -1796,19 +2223,25 @@ func abortChange(c *Command, r *http.Request, user *auth.UserState) Response {
 		return BadRequest("cannot decode data from request body: %v", err)
 	}
 
-	if reqData.Action != "abort" {
-		return BadRequest("change action %q is unsupported", reqData.Action)
-	}
+	switch reqData.Action {
+	case "abort":
+		if chg.Status().Ready() {
+			return BadRequest("cannot abort change %s with nothing pending", chID)
+		}
 
-	if chg.Status().Ready() {
-		return BadRequest("cannot abort change %s with nothing pending", chID)
+		// flag the change
+		chg.Abort()
+	case "approve":
+		// resume the tasks waiting for it
+		if len(chg.Resume(state.WaitApproval)) == 0 {
+			return BadRequest("cannot approve change %s with nothing waiting for approval", chID)
+		}
+	default:
+		return BadRequest("change action %q is unsupported", reqData.Action)
 	}
 
-	// flag the change
-	chg.Abort()
-
-	// actually ask to proceed with the abort
-	ensureStateSoon(state)
+	// actually ask to proceed
+	ensureStateSoon(st)
 
 	return SyncResponse(change2changeInfo(chg), nil)
 }
@@ -2201,11 +2634,83 @@ func getUsers(c *Command, r *http.Request, user *auth.UserState) Response {
 			Username: u.Username,
 			Email:    u.Email,
 			ID:       u.ID,
+			Roles:    u.Roles,
 		}
 	}
 	return SyncResponse(resp, nil)
 }
 
+type usersAction struct {
+	Action string `json:"action"`
+	// User is the username or email of the user to act on
+	User string    `json:"user"`
+	Role auth.Role `json:"role"`
+}
+
+func postUsers(c *Command, r *http.Request, user *auth.UserState) Response {
+	uid, err := postCreateUserUcrednetGetUID(r.RemoteAddr)
+	if err != nil {
+		return BadRequest("cannot get ucrednet uid: %v", err)
+	}
+	if uid != 0 {
+		return BadRequest("cannot manage users as non-root")
+	}
+
+	var action usersAction
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&action); err != nil {
+		return BadRequest("cannot decode request body into users action: %v", err)
+	}
+	if action.User == "" {
+		return BadRequest("missing user to act on")
+	}
+
+	var op func(*state.State, int, auth.Role) error
+	switch action.Action {
+	case "grant-role":
+		op = auth.GrantRole
+	case "revoke-role":
+		op = auth.RevokeRole
+	default:
+		return BadRequest("unknown users action %q", action.Action)
+	}
+	if err := auth.ValidRole(action.Role); err != nil {
+		return BadRequest("%v", err)
+	}
+
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+	users, err := auth.Users(st)
+	if err != nil {
+		return InternalError("cannot get users: %v", err)
+	}
+	var target *auth.UserState
+	for _, u := range users {
+		if u.Username == action.User || u.Email == action.User {
+			target = u
+			break
+		}
+	}
+	if target == nil {
+		return NotFound("cannot find user %q", action.User)
+	}
+	if err := op(st, target.ID, action.Role); err != nil {
+		return BadRequest("cannot %s for user %q: %v", strings.Replace(action.Action, "-", " ", -1), action.User, err)
+	}
+	target, err = auth.User(st, target.ID)
+	if err != nil {
+		return InternalError("cannot get user: %v", err)
+	}
+
+	return SyncResponse(&userResponseData{
+		ID:       target.ID,
+		Username: target.Username,
+		Email:    target.Email,
+		Roles:    target.Roles,
+	}, nil)
+}
+
 // aliasAction is an action performed on aliases
 type aliasAction struct {
 	Action  string   `json:"action"`
@@ -2311,3 +2816,126 @@ func getAliases(c *Command, r *http.Request, user *auth.UserState) Response {
 
 	return SyncResponse(res, nil)
 }
+
+func getDebug(c *Command, r *http.Request, user *auth.UserState) Response {
+	aspect := r.URL.Query().Get("aspect")
+	switch aspect {
+	case "connectivity":
+		statuses, err := getStore(c).ConnectivityCheck()
+		if err != nil {
+			return InternalError("cannot check store connectivity: %v", err)
+		}
+		return SyncResponse(statuses, nil)
+	case "":
+		return BadRequest("missing debug aspect")
+	default:
+		return BadRequest("unknown debug aspect %q", aspect)
+	}
+}
+
+type warningInfo struct {
+	Message     string     `json:"message"`
+	FirstAdded  time.Time  `json:"first-added"`
+	LastAdded   time.Time  `json:"last-added"`
+	LastShown   *time.Time `json:"last-shown,omitempty"`
+	ExpireAfter string     `json:"expire-after,omitempty"`
+	RepeatAfter string     `json:"repeat-after,omitempty"`
+}
+
+func warning2warningInfo(w *state.Warning) *warningInfo {
+	wi := &warningInfo{
+		Message:     w.Message(),
+		FirstAdded:  w.FirstAdded(),
+		LastAdded:   w.LastAdded(),
+		ExpireAfter: w.ExpireAfter().String(),
+		RepeatAfter: w.RepeatAfter().String(),
+	}
+	if t := w.LastShown(); !t.IsZero() {
+		wi.LastShown = &t
+	}
+	return wi
+}
+
+func getWarnings(c *Command, r *http.Request, user *auth.UserState) Response {
+	var all bool
+	switch sel := r.URL.Query().Get("select"); sel {
+	case "all":
+		all = true
+	case "pending", "":
+		all = false
+	default:
+		return BadRequest("invalid select parameter: %q", sel)
+	}
+
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+
+	var warnings []*state.Warning
+	if all {
+		warnings = st.AllWarnings()
+	} else {
+		warnings = st.PendingWarnings()
+	}
+
+	infos := make([]*warningInfo, len(warnings))
+	for i, w := range warnings {
+		infos[i] = warning2warningInfo(w)
+	}
+
+	return SyncResponse(infos, nil)
+}
+
+type warningsAction struct {
+	Action    string    `json:"action"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func ackWarnings(c *Command, r *http.Request, user *auth.UserState) Response {
+	decoder := json.NewDecoder(r.Body)
+	var action warningsAction
+	if err := decoder.Decode(&action); err != nil {
+		return BadRequest("cannot decode request body into warnings operation: %v", err)
+	}
+	if action.Action != "okay" {
+		return BadRequest("unknown warning action %q", action.Action)
+	}
+	if action.Timestamp.IsZero() {
+		return BadRequest("missing timestamp of the latest warning to okay")
+	}
+
+	st := c.d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+	n := st.OkayWarnings(action.Timestamp)
+
+	return SyncResponse(n, nil)
+}
+
+// metricsEnabled returns whether the metrics are exposed, which is
+// opted into with:
+//
+// $ snap set core metrics.enabled=true
+func metricsEnabled(st *state.State) (bool, error) {
+	var enabled bool
+	tr := config.NewTransaction(st)
+	if err := tr.Get("core", "metrics.enabled", &enabled); err != nil && !config.IsNoOption(err) {
+		return false, err
+	}
+	return enabled, nil
+}
+
+func getMetrics(c *Command, r *http.Request, user *auth.UserState) Response {
+	st := c.d.overlord.State()
+	st.Lock()
+	enabled, err := metricsEnabled(st)
+	st.Unlock()
+	if err != nil {
+		return InternalError("%v", err)
+	}
+	if !enabled {
+		return NotFound("metrics are not enabled (see core option metrics.enabled)")
+	}
+
+	return metricsResponse{}
+}
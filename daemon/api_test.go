@@ -20,6 +20,7 @@
 package daemon
 
 import (
+	"bufio"
 	"bytes"
 	"crypto"
 	"encoding/json"
@@ -33,6 +34,7 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -54,6 +56,7 @@ import (
 	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/overlord/assertstate"
 	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/changehistory"
 	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/ifacestate"
 	"github.com/snapcore/snapd/overlord/snapstate"
@@ -61,6 +64,7 @@ import (
 	"github.com/snapcore/snapd/progress"
 	"github.com/snapcore/snapd/release"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/delta"
 	"github.com/snapcore/snapd/snap/snaptest"
 	"github.com/snapcore/snapd/store"
 	"github.com/snapcore/snapd/testutil"
@@ -78,6 +82,7 @@ type apiBaseSuite struct {
 	refreshCandidates []*store.RefreshCandidate
 	buyOptions        *store.BuyOptions
 	buyResult         *store.BuyResult
+	connectivity      []*store.ConnectivityStatus
 	storeSigning      *assertstest.StoreStack
 	restoreRelease    func()
 	trustedRestorer   func()
@@ -109,7 +114,7 @@ func (s *apiBaseSuite) SuggestedCurrency() string {
 	return s.suggestedCurrency
 }
 
-func (s *apiBaseSuite) Download(context.Context, string, string, *snap.DownloadInfo, progress.Meter, *auth.UserState) error {
+func (s *apiBaseSuite) Download(context.Context, string, string, *snap.DownloadInfo, progress.Meter, *auth.UserState, *store.DownloadOptions) error {
 	panic("Download not expected to be called")
 }
 
@@ -132,6 +137,10 @@ func (s *apiBaseSuite) Sections(*auth.UserState) ([]string, error) {
 	panic("Sections not expected to be called")
 }
 
+func (s *apiBaseSuite) ConnectivityCheck() ([]*store.ConnectivityStatus, error) {
+	return s.connectivity, s.err
+}
+
 func (s *apiBaseSuite) muxVars(*http.Request) map[string]string {
 	return s.vars
 }
@@ -172,6 +181,7 @@ func (s *apiBaseSuite) SetUpTest(c *check.C) {
 	s.user = nil
 	s.d = nil
 	s.refreshCandidates = nil
+	s.connectivity = nil
 	// Disable real security backends for all API tests
 	s.restoreBackends = ifacestate.MockSecurityBackends(nil)
 
@@ -190,6 +200,7 @@ func (s *apiBaseSuite) SetUpTest(c *check.C) {
 	snapstateRemoveMany = nil
 	snapstateRevert = nil
 	snapstateRevertToRevision = nil
+	snapstateSwitch = nil
 	snapstateTryPath = nil
 	snapstateUpdate = nil
 	snapstateUpdateMany = nil
@@ -201,6 +212,8 @@ func (s *apiBaseSuite) TearDownTest(c *check.C) {
 	s.restoreBackends()
 	unsafeReadSnapInfo = unsafeReadSnapInfoImpl
 	ensureStateSoon = ensureStateSoonImpl
+	deltaApply = delta.Apply
+	deltaFormatOf = delta.FormatOf
 	dirs.SetRootDir("")
 
 	assertstateRefreshSnapDeclarations = assertstate.RefreshSnapDeclarations
@@ -212,6 +225,7 @@ func (s *apiBaseSuite) TearDownTest(c *check.C) {
 	snapstateRemoveMany = snapstate.RemoveMany
 	snapstateRevert = snapstate.Revert
 	snapstateRevertToRevision = snapstate.RevertToRevision
+	snapstateSwitch = snapstate.Switch
 	snapstateTryPath = snapstate.TryPath
 	snapstateUpdate = snapstate.Update
 	snapstateUpdateMany = snapstate.UpdateMany
@@ -498,13 +512,17 @@ func (s *apiSuite) TestListIncludesAll(c *check.C) {
 		"snapstateRefreshCandidates",
 		"snapstateRevert",
 		"snapstateRevertToRevision",
+		"snapstateSwitch",
 		"assertstateRefreshSnapDeclarations",
 		"unsafeReadSnapInfo",
+		"deltaApply",
+		"deltaFormatOf",
 		"osutilAddUser",
 		"setupLocalUser",
 		"storeUserInfo",
 		"postCreateUserUcrednetGetUID",
 		"ensureStateSoon",
+		"polkitActionPrefix",
 	}
 	c.Check(found, check.Equals, len(api)+len(exceptions),
 		check.Commentf(`At a glance it looks like you've not added all the Commands defined in api to the api list. If that is not the case, please add the exception to the "exceptions" list in this test.`))
@@ -1634,6 +1652,74 @@ func (s *apiSuite) TestPostSnap(c *check.C) {
 	c.Check(soon, check.Equals, 1)
 }
 
+func (s *apiSuite) TestPostSnapAt(c *check.C) {
+	d := s.daemon(c)
+	d.overlord.Loop()
+	defer d.overlord.Stop()
+	ensureStateSoon = func(st *state.State) {}
+
+	s.vars = map[string]string{"name": "foo"}
+
+	snapInstructionDispTable["refresh"] = func(inst *snapInstruction, st *state.State) (string, []*state.TaskSet, error) {
+		t1 := st.NewTask("fake-download", "Download")
+		t2 := st.NewTask("fake-link", "Link")
+		t2.WaitFor(t1)
+		return "Refresh", []*state.TaskSet{state.NewTaskSet(t1, t2)}, nil
+	}
+	defer func() {
+		snapInstructionDispTable["refresh"] = snapUpdate
+	}()
+
+	at := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	buf := bytes.NewBufferString(`{"action": "refresh"}`)
+	req, err := http.NewRequest("POST", "/v2/snaps/foo?at="+at.Format(time.RFC3339), buf)
+	c.Assert(err, check.IsNil)
+
+	rsp := postSnap(snapCmd, req, nil).(*resp)
+	c.Assert(rsp.Type, check.Equals, ResponseTypeAsync)
+
+	st := d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+	chg := st.Change(rsp.Change)
+	c.Assert(chg, check.NotNil)
+	tasks := chg.Tasks()
+	c.Assert(tasks, check.HasLen, 2)
+	// only the first task is held until then
+	c.Check(tasks[0].AtTime().Equal(at), check.Equals, true)
+	c.Check(tasks[1].AtTime().IsZero(), check.Equals, true)
+
+	chgInfo := change2changeInfo(chg)
+	c.Assert(chgInfo.ScheduledTime, check.NotNil)
+	c.Check(chgInfo.ScheduledTime.Equal(at), check.Equals, true)
+
+	// once started the change isn't waiting for it anymore
+	tasks[0].SetStatus(state.DoingStatus)
+	c.Check(change2changeInfo(chg).ScheduledTime, check.IsNil)
+}
+
+func (s *apiSuite) TestPostSnapAtInvalid(c *check.C) {
+	s.daemon(c)
+	s.vars = map[string]string{"name": "foo"}
+
+	for _, t := range []struct {
+		at  string
+		err string
+	}{
+		{"tomorrow", `invalid "at" parameter: .*`},
+		{time.Now().Add(-time.Hour).Format(time.RFC3339), "cannot schedule a change in the past"},
+		{time.Now().Add(8 * 24 * time.Hour).Format(time.RFC3339), "cannot schedule a change more than 7 days ahead"},
+	} {
+		buf := bytes.NewBufferString(`{"action": "refresh"}`)
+		req, err := http.NewRequest("POST", "/v2/snaps/foo?at="+url.QueryEscape(t.at), buf)
+		c.Assert(err, check.IsNil)
+
+		rsp := postSnap(snapCmd, req, nil).(*resp)
+		c.Check(rsp.Status, check.Equals, http.StatusBadRequest)
+		c.Check(rsp.Result.(*errorResult).Message, check.Matches, t.err)
+	}
+}
+
 func (s *apiSuite) TestPostSnapSetsUser(c *check.C) {
 	d := s.daemon(c)
 	ensureStateSoon = func(st *state.State) {}
@@ -1683,6 +1769,7 @@ func (s *apiSuite) TestPostSnapDispatch(c *check.C) {
 		{"revert", snapRevert},
 		{"enable", snapEnable},
 		{"disable", snapDisable},
+		{"switch", snapSwitch},
 		{"xyzzy", nil},
 	}
 
@@ -1707,6 +1794,49 @@ func (s *apiSuite) TestPostSnapEnableDisableRevision(c *check.C) {
 	}
 }
 
+func (s *apiSuite) TestSwitchSnap(c *check.C) {
+	var queue []string
+	snapstateSwitch = func(s *state.State, name, channel string, userID int) (*state.TaskSet, error) {
+		queue = append(queue, name+" "+channel)
+		return state.NewTaskSet(), nil
+	}
+
+	d := s.daemon(c)
+	inst := &snapInstruction{Action: "switch", Snaps: []string{"some-snap"}, Channel: "beta"}
+
+	st := d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+	summary, _, err := inst.dispatch()(inst, st)
+	c.Check(err, check.IsNil)
+	c.Check(queue, check.DeepEquals, []string{"some-snap beta"})
+	c.Check(summary, check.Equals, `Switch "some-snap" snap to beta`)
+}
+
+func (s *apiSuite) TestSwitchSnapNoChannel(c *check.C) {
+	buf := bytes.NewBufferString(`{"action": "switch"}`)
+	req, err := http.NewRequest("POST", "/v2/snaps/hello-world", buf)
+	c.Assert(err, check.IsNil)
+
+	rsp := postSnap(snapCmd, req, nil).(*resp)
+
+	c.Check(rsp.Type, check.Equals, ResponseTypeError)
+	c.Check(rsp.Status, check.Equals, http.StatusBadRequest)
+	c.Check(rsp.Result.(*errorResult).Message, testutil.Contains, "switch requires a channel")
+}
+
+func (s *apiSuite) TestSwitchSnapRevision(c *check.C) {
+	buf := bytes.NewBufferString(`{"action": "switch", "channel": "beta", "revision": "42"}`)
+	req, err := http.NewRequest("POST", "/v2/snaps/hello-world", buf)
+	c.Assert(err, check.IsNil)
+
+	rsp := postSnap(snapCmd, req, nil).(*resp)
+
+	c.Check(rsp.Type, check.Equals, ResponseTypeError)
+	c.Check(rsp.Status, check.Equals, http.StatusBadRequest)
+	c.Check(rsp.Result.(*errorResult).Message, testutil.Contains, "switch takes no revision")
+}
+
 var sideLoadBodyWithoutDevMode = "" +
 	"----hello--\r\n" +
 	"Content-Disposition: form-data; name=\"snap\"; filename=\"x\"\r\n" +
@@ -1915,6 +2045,173 @@ func (s *apiSuite) TestLocalInstallSnapDeriveSideInfo(c *check.C) {
 	})
 }
 
+func (s *apiSuite) mockInstalledX(c *check.C, st *state.State) {
+	st.Lock()
+	defer st.Unlock()
+	snapstate.Set(st, "x", &snapstate.SnapState{
+		Active:   true,
+		Sequence: []*snap.SideInfo{{RealName: "x", SnapID: "x-id", Revision: snap.R(40)}},
+		Current:  snap.R(40),
+	})
+}
+
+const deltaBody = "" +
+	"----hello--\r\n" +
+	"Content-Disposition: form-data; name=\"delta\"\r\n" +
+	"\r\n" +
+	"true\r\n" +
+	"----hello--\r\n" +
+	"Content-Disposition: form-data; name=\"name\"\r\n" +
+	"\r\n" +
+	"x\r\n" +
+	"----hello--\r\n" +
+	"Content-Disposition: form-data; name=\"snap\"; filename=\"x_41.delta\"\r\n" +
+	"\r\n" +
+	"delta\r\n" +
+	"----hello--\r\n"
+
+func (s *apiSuite) TestSideloadDelta(c *check.C) {
+	d := s.daemon(c)
+	d.overlord.Loop()
+	defer d.overlord.Stop()
+	st := d.overlord.State()
+	s.mockInstalledX(c, st)
+
+	assertAdd(st, s.storeSigning.StoreAccountKey(""))
+	dev1Acct := assertstest.NewAccount(s.storeSigning, "devel1", nil, "")
+	assertAdd(st, dev1Acct)
+	snapDecl, err := s.storeSigning.Sign(asserts.SnapDeclarationType, map[string]interface{}{
+		"series":       "16",
+		"snap-id":      "x-id",
+		"snap-name":    "x",
+		"publisher-id": dev1Acct.AccountID(),
+		"timestamp":    time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, check.IsNil)
+	assertAdd(st, snapDecl)
+	// the digest of "xyzzy"
+	snapRev, err := s.storeSigning.Sign(asserts.SnapRevisionType, map[string]interface{}{
+		"snap-sha3-384": "YK0GWATaZf09g_fvspYPqm_qtaiqf-KjaNj5uMEQCjQpuXWPjqQbeBINL5H_A0Lo",
+		"snap-size":     "5",
+		"snap-id":       "x-id",
+		"snap-revision": "41",
+		"developer-id":  dev1Acct.AccountID(),
+		"timestamp":     time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, check.IsNil)
+	assertAdd(st, snapRev)
+
+	var deltaPath, targetPath string
+	deltaFormatOf = func(dPath string) (string, error) {
+		return "squashfs", nil
+	}
+	deltaApply = func(format, sourcePath, dPath, tPath string) error {
+		c.Check(format, check.Equals, "squashfs")
+		c.Check(sourcePath, check.Equals, filepath.Join(dirs.SnapBlobDir, "x_40.snap"))
+		content, err := ioutil.ReadFile(dPath)
+		c.Assert(err, check.IsNil)
+		c.Check(string(content), check.Equals, "delta")
+		deltaPath = dPath
+		targetPath = tPath
+		return ioutil.WriteFile(tPath, []byte("xyzzy"), 0644)
+	}
+	snapstateCoreInfo = func(s *state.State) (*snap.Info, error) {
+		return nil, nil
+	}
+	snapstateInstallPath = func(s *state.State, si *snap.SideInfo, path, channel string, flags snapstate.Flags) (*state.TaskSet, error) {
+		c.Check(path, check.Equals, targetPath)
+		c.Check(flags, check.Equals, snapstate.Flags{RemoveSnapPath: true})
+		c.Check(si, check.DeepEquals, &snap.SideInfo{
+			RealName: "x",
+			SnapID:   "x-id",
+			Revision: snap.R(41),
+		})
+
+		return state.NewTaskSet(), nil
+	}
+
+	req, err := http.NewRequest("POST", "/v2/snaps", bytes.NewBufferString(deltaBody))
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-Type", "multipart/thing; boundary=--hello--")
+
+	rsp := postSnaps(snapsCmd, req, nil).(*resp)
+	c.Assert(rsp.Type, check.Equals, ResponseTypeAsync)
+	// the delta itself is gone
+	c.Check(osutil.FileExists(deltaPath), check.Equals, false)
+
+	st.Lock()
+	defer st.Unlock()
+	chg := st.Change(rsp.Change)
+	c.Assert(chg, check.NotNil)
+	c.Check(chg.Summary(), check.Equals, `Install "x" snap from file "x_41.delta"`)
+}
+
+func (s *apiSuite) TestSideloadDeltaNoSignatures(c *check.C) {
+	d := s.daemon(c)
+	d.overlord.Loop()
+	defer d.overlord.Stop()
+	s.mockInstalledX(c, d.overlord.State())
+
+	deltaFormatOf = func(deltaPath string) (string, error) {
+		return "xdelta3", nil
+	}
+	deltaApply = func(format, sourcePath, deltaPath, targetPath string) error {
+		return ioutil.WriteFile(targetPath, []byte("xyzzy"), 0644)
+	}
+
+	req, err := http.NewRequest("POST", "/v2/snaps", bytes.NewBufferString(deltaBody))
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-Type", "multipart/thing; boundary=--hello--")
+
+	// this is the prefix used for tempfiles for sideloading
+	glob := filepath.Join(os.TempDir(), "snapd-sideload-pkg-*")
+	glbBefore, _ := filepath.Glob(glob)
+	rsp := postSnaps(snapsCmd, req, nil).(*resp)
+	c.Assert(rsp.Type, check.Equals, ResponseTypeError)
+	c.Check(rsp.Result.(*errorResult).Message, check.Equals, `cannot find signatures with metadata for snap "x_41.delta"`)
+	glbAfter, _ := filepath.Glob(glob)
+	c.Check(len(glbBefore), check.Equals, len(glbAfter))
+}
+
+func (s *apiSuite) TestSideloadDeltaUnknownFormat(c *check.C) {
+	d := s.daemon(c)
+	d.overlord.Loop()
+	defer d.overlord.Stop()
+	s.mockInstalledX(c, d.overlord.State())
+
+	deltaApply = func(format, sourcePath, deltaPath, targetPath string) error {
+		c.Fatalf("unexpected call to apply the delta")
+		return nil
+	}
+
+	req, err := http.NewRequest("POST", "/v2/snaps", bytes.NewBufferString(deltaBody))
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-Type", "multipart/thing; boundary=--hello--")
+
+	rsp := postSnaps(snapsCmd, req, nil).(*resp)
+	c.Assert(rsp.Type, check.Equals, ResponseTypeError)
+	c.Check(rsp.Result.(*errorResult).Message, check.Matches, `cannot apply delta for snap "x": cannot determine the format of delta ".*"`)
+}
+
+func (s *apiSuite) TestSideloadDeltaNotInstalled(c *check.C) {
+	d := s.daemon(c)
+	d.overlord.Loop()
+	defer d.overlord.Stop()
+
+	deltaApply = func(format, sourcePath, deltaPath, targetPath string) error {
+		c.Fatalf("unexpected call to apply the delta")
+		return nil
+	}
+
+	req, err := http.NewRequest("POST", "/v2/snaps", bytes.NewBufferString(deltaBody))
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-Type", "multipart/thing; boundary=--hello--")
+
+	rsp := postSnaps(snapsCmd, req, nil).(*resp)
+	c.Assert(rsp.Type, check.Equals, ResponseTypeError)
+	c.Check(rsp.Result.(*errorResult).Message, check.Equals, `cannot apply delta for snap "x": snap is not installed`)
+}
+
 func (s *apiSuite) TestSideloadSnapNoSignaturesDangerOff(c *check.C) {
 	body := "" +
 		"----hello--\r\n" +
@@ -2527,6 +2824,33 @@ func (s *apiSuite) TestRefreshClassic(c *check.C) {
 	c.Check(calledFlags, check.DeepEquals, snapstate.Flags{Classic: true})
 }
 
+func (s *apiSuite) TestRefreshNoRateLimit(c *check.C) {
+	var calledFlags snapstate.Flags
+
+	snapstateUpdate = func(s *state.State, name, channel string, revision snap.Revision, userID int, flags snapstate.Flags) (*state.TaskSet, error) {
+		calledFlags = flags
+		t := s.NewTask("fake-refresh-snap", "Doing a fake install")
+		return state.NewTaskSet(t), nil
+	}
+	assertstateRefreshSnapDeclarations = func(s *state.State, userID int) error {
+		return nil
+	}
+
+	d := s.daemon(c)
+	inst := &snapInstruction{
+		Action:      "refresh",
+		NoRateLimit: true,
+		Snaps:       []string{"some-snap"},
+	}
+
+	st := d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+	_, _, err := inst.dispatch()(inst, st)
+	c.Assert(err, check.IsNil)
+	c.Check(calledFlags, check.DeepEquals, snapstate.Flags{NoRateLimit: true})
+}
+
 func (s *apiSuite) TestRefreshIgnoreValidation(c *check.C) {
 	var calledFlags snapstate.Flags
 	calledUserID := 0
@@ -2574,7 +2898,7 @@ func (s *apiSuite) TestRefreshIgnoreValidation(c *check.C) {
 
 func (s *apiSuite) TestPostSnapsOp(c *check.C) {
 	assertstateRefreshSnapDeclarations = func(*state.State, int) error { return nil }
-	snapstateUpdateMany = func(s *state.State, names []string, userID int) ([]string, []*state.TaskSet, error) {
+	snapstateUpdateMany = func(s *state.State, names []string, userID int, flags *snapstate.Flags) ([]string, []*state.TaskSet, error) {
 		c.Check(names, check.HasLen, 0)
 		t := s.NewTask("fake-refresh-all", "Refreshing everything")
 		return []string{"fake1", "fake2"}, []*state.TaskSet{state.NewTaskSet(t)}, nil
@@ -2603,6 +2927,52 @@ func (s *apiSuite) TestPostSnapsOp(c *check.C) {
 	c.Check(apiData["snap-names"], check.DeepEquals, []interface{}{"fake1", "fake2"})
 }
 
+func (s *apiSuite) TestPostSnapsOpTransaction(c *check.C) {
+	assertstateRefreshSnapDeclarations = func(*state.State, int) error { return nil }
+	var calledFlags *snapstate.Flags
+	snapstateUpdateMany = func(s *state.State, names []string, userID int, flags *snapstate.Flags) ([]string, []*state.TaskSet, error) {
+		calledFlags = flags
+		t := s.NewTask("fake-refresh-2", "Refreshing two")
+		return names, []*state.TaskSet{state.NewTaskSet(t)}, nil
+	}
+
+	d := s.daemon(c)
+	d.overlord.Loop()
+	defer d.overlord.Stop()
+
+	buf := bytes.NewBufferString(`{"action": "refresh", "snaps": ["foo", "bar"], "transaction": "all-snaps"}`)
+	req, err := http.NewRequest("POST", "/v2/snaps", buf)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-Type", "application/json")
+
+	rsp, ok := postSnaps(snapsCmd, req, nil).(*resp)
+	c.Assert(ok, check.Equals, true)
+	c.Check(rsp.Type, check.Equals, ResponseTypeAsync)
+	c.Assert(calledFlags, check.NotNil)
+	c.Check(calledFlags.Transaction, check.Equals, snapstate.TransactionAllSnaps)
+}
+
+func (s *apiSuite) TestPostSnapsOpTransactionInvalid(c *check.C) {
+	s.daemon(c)
+
+	for _, tc := range []struct {
+		body string
+		err  string
+	}{
+		{`{"action": "refresh", "transaction": "some-snaps"}`, `unknown transaction type "some-snaps"`},
+		{`{"action": "remove", "snaps": ["foo"], "transaction": "all-snaps"}`, `transaction type is unsupported for multi-snap remove`},
+	} {
+		req, err := http.NewRequest("POST", "/v2/snaps", bytes.NewBufferString(tc.body))
+		c.Assert(err, check.IsNil)
+		req.Header.Set("Content-Type", "application/json")
+
+		rsp, ok := postSnaps(snapsCmd, req, nil).(*resp)
+		c.Assert(ok, check.Equals, true)
+		c.Check(rsp.Type, check.Equals, ResponseTypeError)
+		c.Check(rsp.Result.(*errorResult).Message, check.Equals, tc.err)
+	}
+}
+
 func (s *apiSuite) TestRefreshAll(c *check.C) {
 	refreshSnapDecls := false
 	assertstateRefreshSnapDeclarations = func(s *state.State, userID int) error {
@@ -2621,7 +2991,7 @@ func (s *apiSuite) TestRefreshAll(c *check.C) {
 	} {
 		refreshSnapDecls = false
 
-		snapstateUpdateMany = func(s *state.State, names []string, userID int) ([]string, []*state.TaskSet, error) {
+		snapstateUpdateMany = func(s *state.State, names []string, userID int, flags *snapstate.Flags) ([]string, []*state.TaskSet, error) {
 			c.Check(names, check.HasLen, 0)
 			t := s.NewTask("fake-refresh-all", "Refreshing everything")
 			return tst.snaps, []*state.TaskSet{state.NewTaskSet(t)}, nil
@@ -2645,7 +3015,7 @@ func (s *apiSuite) TestRefreshAllNoChanges(c *check.C) {
 		return assertstate.RefreshSnapDeclarations(s, userID)
 	}
 
-	snapstateUpdateMany = func(s *state.State, names []string, userID int) ([]string, []*state.TaskSet, error) {
+	snapstateUpdateMany = func(s *state.State, names []string, userID int, flags *snapstate.Flags) ([]string, []*state.TaskSet, error) {
 		c.Check(names, check.HasLen, 0)
 		return nil, nil, nil
 	}
@@ -2668,7 +3038,7 @@ func (s *apiSuite) TestRefreshMany(c *check.C) {
 		return nil
 	}
 
-	snapstateUpdateMany = func(s *state.State, names []string, userID int) ([]string, []*state.TaskSet, error) {
+	snapstateUpdateMany = func(s *state.State, names []string, userID int, flags *snapstate.Flags) ([]string, []*state.TaskSet, error) {
 		c.Check(names, check.HasLen, 2)
 		t := s.NewTask("fake-refresh-2", "Refreshing two")
 		return names, []*state.TaskSet{state.NewTaskSet(t)}, nil
@@ -2686,33 +3056,86 @@ func (s *apiSuite) TestRefreshMany(c *check.C) {
 	c.Check(refreshSnapDecls, check.Equals, true)
 }
 
-func (s *apiSuite) TestRefreshMany1(c *check.C) {
-	refreshSnapDecls := false
+func (s *apiSuite) TestSnapsOpAt(c *check.C) {
 	assertstateRefreshSnapDeclarations = func(s *state.State, userID int) error {
-		refreshSnapDecls = true
 		return nil
 	}
-
-	snapstateUpdateMany = func(s *state.State, names []string, userID int) ([]string, []*state.TaskSet, error) {
-		c.Check(names, check.HasLen, 1)
-		t := s.NewTask("fake-refresh-1", "Refreshing one")
+	snapstateUpdateMany = func(s *state.State, names []string, userID int, flags *snapstate.Flags) ([]string, []*state.TaskSet, error) {
+		t := s.NewTask("fake-refresh-2", "Refreshing two")
 		return names, []*state.TaskSet{state.NewTaskSet(t)}, nil
 	}
+	ensureStateSoon = func(st *state.State) {}
 
 	d := s.daemon(c)
-	inst := &snapInstruction{Action: "refresh", Snaps: []string{"foo"}}
-	st := d.overlord.State()
-	st.Lock()
-	summary, updates, _, err := snapUpdateMany(inst, st)
-	st.Unlock()
+	d.overlord.Loop()
+	defer d.overlord.Stop()
+	at := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	buf := bytes.NewBufferString(`{"action": "refresh", "snaps": ["foo", "bar"]}`)
+	req, err := http.NewRequest("POST", "/v2/snaps?at="+at.Format(time.RFC3339), buf)
 	c.Assert(err, check.IsNil)
-	c.Check(summary, check.Equals, `Refresh snap "foo"`)
+	req.Header.Set("Content-Type", "application/json")
+
+	rsp := postSnaps(snapsCmd, req, nil).(*resp)
+	c.Assert(rsp.Type, check.Equals, ResponseTypeAsync)
+
+	st := d.overlord.State()
+	st.Lock()
+	defer st.Unlock()
+	chg := st.Change(rsp.Change)
+	c.Assert(chg, check.NotNil)
+	c.Assert(chg.Tasks(), check.HasLen, 1)
+	c.Check(chg.Tasks()[0].AtTime().Equal(at), check.Equals, true)
+}
+
+func (s *apiSuite) TestRefreshManyNoRateLimit(c *check.C) {
+	assertstateRefreshSnapDeclarations = func(s *state.State, userID int) error {
+		return nil
+	}
+
+	var calledFlags *snapstate.Flags
+	snapstateUpdateMany = func(s *state.State, names []string, userID int, flags *snapstate.Flags) ([]string, []*state.TaskSet, error) {
+		calledFlags = flags
+		t := s.NewTask("fake-refresh-2", "Refreshing two")
+		return names, []*state.TaskSet{state.NewTaskSet(t)}, nil
+	}
+
+	d := s.daemon(c)
+	inst := &snapInstruction{Action: "refresh", NoRateLimit: true, Snaps: []string{"foo", "bar"}}
+	st := d.overlord.State()
+	st.Lock()
+	_, _, _, err := snapUpdateMany(inst, st)
+	st.Unlock()
+	c.Assert(err, check.IsNil)
+	c.Check(calledFlags, check.DeepEquals, &snapstate.Flags{NoRateLimit: true})
+}
+
+func (s *apiSuite) TestRefreshMany1(c *check.C) {
+	refreshSnapDecls := false
+	assertstateRefreshSnapDeclarations = func(s *state.State, userID int) error {
+		refreshSnapDecls = true
+		return nil
+	}
+
+	snapstateUpdateMany = func(s *state.State, names []string, userID int, flags *snapstate.Flags) ([]string, []*state.TaskSet, error) {
+		c.Check(names, check.HasLen, 1)
+		t := s.NewTask("fake-refresh-1", "Refreshing one")
+		return names, []*state.TaskSet{state.NewTaskSet(t)}, nil
+	}
+
+	d := s.daemon(c)
+	inst := &snapInstruction{Action: "refresh", Snaps: []string{"foo"}}
+	st := d.overlord.State()
+	st.Lock()
+	summary, updates, _, err := snapUpdateMany(inst, st)
+	st.Unlock()
+	c.Assert(err, check.IsNil)
+	c.Check(summary, check.Equals, `Refresh snap "foo"`)
 	c.Check(updates, check.DeepEquals, inst.Snaps)
 	c.Check(refreshSnapDecls, check.Equals, true)
 }
 
 func (s *apiSuite) TestInstallMany(c *check.C) {
-	snapstateInstallMany = func(s *state.State, names []string, userID int) ([]string, []*state.TaskSet, error) {
+	snapstateInstallMany = func(s *state.State, names []string, userID int, flags *snapstate.Flags) ([]string, []*state.TaskSet, error) {
 		c.Check(names, check.HasLen, 2)
 		t := s.NewTask("fake-install-2", "Install two")
 		return names, []*state.TaskSet{state.NewTaskSet(t)}, nil
@@ -3919,6 +4342,71 @@ func (s *apiSuite) TestStateChangesForSnapName(c *check.C) {
 	c.Assert(err, check.IsNil)
 }
 
+func (s *apiSuite) TestStateChangesHistory(c *check.C) {
+	restore := state.MockTime(time.Date(2016, 04, 21, 1, 2, 3, 0, time.UTC))
+	defer restore()
+
+	// Setup
+	d := newTestDaemon(c)
+	st := d.overlord.State()
+	st.Lock()
+	ids := setupChanges(st)
+	chg := st.Change(ids[1])
+	chg.Set("snap-names", []string{"funky-snap-name"})
+	chg.Set("user-id", 42)
+	st.Prune(time.Hour, 24*time.Hour, 100)
+	c.Assert(st.Change(ids[1]), check.IsNil)
+	st.Unlock()
+
+	// Execute
+	req, err := http.NewRequest("GET", "/v2/changes?select=history", nil)
+	c.Assert(err, check.IsNil)
+	rsp := getChanges(stateChangesCmd, req, nil).(*resp)
+
+	// Verify
+	c.Check(rsp.Type, check.Equals, ResponseTypeSync)
+	c.Check(rsp.Status, check.Equals, http.StatusOK)
+	c.Assert(rsp.Result, check.FitsTypeOf, []*changehistory.Entry(nil))
+	entries := rsp.Result.([]*changehistory.Entry)
+	c.Assert(entries, check.HasLen, 1)
+	c.Check(entries[0].ID, check.Equals, ids[1])
+	c.Check(entries[0].Kind, check.Equals, "remove")
+	c.Check(entries[0].Status, check.Equals, "Error")
+	c.Check(entries[0].UserID, check.Equals, 42)
+	c.Check(entries[0].SnapNames, check.DeepEquals, []string{"funky-snap-name"})
+	c.Assert(entries[0].TaskErrors, check.HasLen, 1)
+	c.Check(entries[0].TaskErrors[0].Log, check.DeepEquals, []string{"2016-04-21T01:02:03Z ERROR rm failed"})
+
+	// filtered by time
+	for _, t := range []struct {
+		query string
+		n     int
+	}{
+		{"since=2016-04-21T00:00:00Z", 1},
+		{"since=2016-04-22T00:00:00Z", 0},
+		{"until=2016-04-21T00:00:00Z", 0},
+		{"since=2016-04-21T00:00:00Z&until=2016-04-22T00:00:00Z", 1},
+		{"for=funky-snap-name", 1},
+		{"for=other-snap", 0},
+	} {
+		req, err := http.NewRequest("GET", "/v2/changes?select=history&"+t.query, nil)
+		c.Assert(err, check.IsNil)
+		rsp := getChanges(stateChangesCmd, req, nil).(*resp)
+		c.Check(rsp.Status, check.Equals, http.StatusOK, check.Commentf(t.query))
+		c.Check(rsp.Result, check.HasLen, t.n, check.Commentf(t.query))
+	}
+}
+
+func (s *apiSuite) TestStateChangesHistoryInvalidTime(c *check.C) {
+	newTestDaemon(c)
+
+	req, err := http.NewRequest("GET", "/v2/changes?select=history&since=yesterday", nil)
+	c.Assert(err, check.IsNil)
+	rsp := getChanges(stateChangesCmd, req, nil).(*resp)
+	c.Check(rsp.Status, check.Equals, http.StatusBadRequest)
+	c.Check(rsp.Result.(*errorResult).Message, check.Matches, `invalid "since" parameter: .*`)
+}
+
 func (s *apiSuite) TestStateChange(c *check.C) {
 	restore := state.MockTime(time.Date(2016, 04, 21, 1, 2, 3, 0, time.UTC))
 	defer restore()
@@ -4003,7 +4491,7 @@ func (s *apiSuite) TestStateChangeAbort(c *check.C) {
 	// Execute
 	req, err := http.NewRequest("POST", "/v2/changes/"+ids[0], buf)
 	c.Assert(err, check.IsNil)
-	rsp := abortChange(stateChangeCmd, req, nil).(*resp)
+	rsp := postChange(stateChangeCmd, req, nil).(*resp)
 	rec := httptest.NewRecorder()
 	rsp.ServeHTTP(rec, req)
 
@@ -4069,7 +4557,7 @@ func (s *apiSuite) TestStateChangeAbortIsReady(c *check.C) {
 	// Execute
 	req, err := http.NewRequest("POST", "/v2/changes/"+ids[0], buf)
 	c.Assert(err, check.IsNil)
-	rsp := abortChange(stateChangeCmd, req, nil).(*resp)
+	rsp := postChange(stateChangeCmd, req, nil).(*resp)
 	rec := httptest.NewRecorder()
 	rsp.ServeHTTP(rec, req)
 
@@ -4087,6 +4575,48 @@ func (s *apiSuite) TestStateChangeAbortIsReady(c *check.C) {
 	})
 }
 
+func (s *apiSuite) TestStateChangeApprove(c *check.C) {
+	soon := 0
+	ensureStateSoon = func(st *state.State) {
+		soon++
+	}
+
+	d := newTestDaemon(c)
+	st := d.overlord.State()
+	st.Lock()
+	ids := setupChanges(st)
+	t := st.Task(ids[2])
+	t.SetStatus(state.DoingStatus)
+	t.SetToWait(state.WaitApproval, "change approval")
+	chgInfo := change2changeInfo(st.Change(ids[0]))
+	st.Unlock()
+	s.vars = map[string]string{"id": ids[0]}
+
+	c.Check(chgInfo.Status, check.Equals, "Wait")
+	c.Check(chgInfo.Tasks[0].Status, check.Equals, "Wait")
+	c.Check(chgInfo.Tasks[0].WaitReason, check.Equals, "change approval")
+
+	buf := bytes.NewBufferString(`{"action": "approve"}`)
+	req, err := http.NewRequest("POST", "/v2/changes/"+ids[0], buf)
+	c.Assert(err, check.IsNil)
+	rsp := postChange(stateChangeCmd, req, nil).(*resp)
+	c.Check(rsp.Status, check.Equals, http.StatusOK)
+	c.Check(soon, check.Equals, 1)
+
+	chgInfo = rsp.Result.(*changeInfo)
+	c.Check(chgInfo.Tasks[0].Status, check.Equals, "Doing")
+	c.Check(chgInfo.Tasks[0].WaitReason, check.Equals, "")
+
+	// nothing left to approve
+	buf = bytes.NewBufferString(`{"action": "approve"}`)
+	req, err = http.NewRequest("POST", "/v2/changes/"+ids[0], buf)
+	c.Assert(err, check.IsNil)
+	rsp = postChange(stateChangeCmd, req, nil).(*resp)
+	c.Check(rsp.Status, check.Equals, http.StatusBadRequest)
+	c.Check(rsp.Result.(*errorResult).Message, check.Equals, fmt.Sprintf("cannot approve change %s with nothing waiting for approval", ids[0]))
+	c.Check(soon, check.Equals, 1)
+}
+
 const validBuyInput = `{
 		  "snap-id": "the-snap-id-1234abcd",
 		  "snap-name": "the snap name",
@@ -4773,6 +5303,68 @@ func (s *postCreateUserSuite) TestUsersHasUser(c *check.C) {
 	c.Check(rsp.Result, check.DeepEquals, expected)
 }
 
+func (s *postCreateUserSuite) TestUsersGrantRevokeRole(c *check.C) {
+	st := s.d.overlord.State()
+	st.Lock()
+	u, err := auth.NewUser(st, "someuser", "mymail@test.com", "macaroon", []string{"discharge"})
+	st.Unlock()
+	c.Assert(err, check.IsNil)
+
+	buf := bytes.NewBufferString(`{"action": "grant-role", "user": "someuser", "role": "operator"}`)
+	req, err := http.NewRequest("POST", "/v2/users", buf)
+	c.Assert(err, check.IsNil)
+	rsp := postUsers(usersCmd, req, nil).(*resp)
+	c.Check(rsp.Type, check.Equals, ResponseTypeSync)
+	c.Check(rsp.Result, check.DeepEquals, &userResponseData{
+		ID:       u.ID,
+		Username: "someuser",
+		Email:    "mymail@test.com",
+		Roles:    []auth.Role{auth.RoleOperator},
+	})
+
+	// users can be found by email too
+	buf = bytes.NewBufferString(`{"action": "grant-role", "user": "mymail@test.com", "role": "viewer"}`)
+	req, err = http.NewRequest("POST", "/v2/users", buf)
+	c.Assert(err, check.IsNil)
+	rsp = postUsers(usersCmd, req, nil).(*resp)
+	c.Check(rsp.Type, check.Equals, ResponseTypeSync)
+
+	buf = bytes.NewBufferString(`{"action": "revoke-role", "user": "someuser", "role": "operator"}`)
+	req, err = http.NewRequest("POST", "/v2/users", buf)
+	c.Assert(err, check.IsNil)
+	rsp = postUsers(usersCmd, req, nil).(*resp)
+	c.Check(rsp.Type, check.Equals, ResponseTypeSync)
+	c.Check(rsp.Result.(*userResponseData).Roles, check.DeepEquals, []auth.Role{auth.RoleViewer})
+
+	st.Lock()
+	u, err = auth.User(st, u.ID)
+	st.Unlock()
+	c.Assert(err, check.IsNil)
+	c.Check(u.Roles, check.DeepEquals, []auth.Role{auth.RoleViewer})
+}
+
+func (s *postCreateUserSuite) TestUsersRoleErrors(c *check.C) {
+	st := s.d.overlord.State()
+	st.Lock()
+	_, err := auth.NewUser(st, "someuser", "mymail@test.com", "macaroon", []string{"discharge"})
+	st.Unlock()
+	c.Assert(err, check.IsNil)
+
+	for body, msg := range map[string]string{
+		`{"action": "grant-role", "user": "someuser", "role": "potato"}`: `invalid role "potato"`,
+		`{"action": "frobble", "user": "someuser", "role": "admin"}`:     `unknown users action "frobble"`,
+		`{"action": "grant-role", "role": "admin"}`:                      `missing user to act on`,
+		`{"action": "revoke-role", "user": "someuser", "role": "admin"}`: `cannot revoke role for user "someuser": user does not have role "admin"`,
+		`{"action": "grant-role", "user": "other", "role": "admin"}`:     `cannot find user "other"`,
+	} {
+		req, err := http.NewRequest("POST", "/v2/users", bytes.NewBufferString(body))
+		c.Assert(err, check.IsNil)
+		rsp := postUsers(usersCmd, req, nil).(*resp)
+		c.Check(rsp.Type, check.Equals, ResponseTypeError)
+		c.Check(rsp.Result.(*errorResult).Message, check.Equals, msg)
+	}
+}
+
 func (s *postCreateUserSuite) TestSysinfoIsManaged(c *check.C) {
 	st := s.d.overlord.State()
 	st.Lock()
@@ -5024,3 +5616,427 @@ func (s *apiSuite) TestAliases(c *check.C) {
 	})
 
 }
+
+func (s *apiSuite) TestDebugConnectivity(c *check.C) {
+	s.daemon(c)
+
+	s.connectivity = []*store.ConnectivityStatus{
+		{Endpoint: "search", URL: "https://search.example.com/", DNS: "ok", TLS: "ok", HTTPStatus: 200},
+		{Endpoint: "download", DNS: "failed", Error: "cannot resolve"},
+	}
+
+	req, err := http.NewRequest("GET", "/v2/debug?aspect=connectivity", nil)
+	c.Assert(err, check.IsNil)
+
+	rsp := getDebug(debugCmd, req, nil).(*resp)
+	c.Check(rsp.Type, check.Equals, ResponseTypeSync)
+	c.Check(rsp.Status, check.Equals, http.StatusOK)
+	c.Check(rsp.Result, check.DeepEquals, s.connectivity)
+}
+
+func (s *apiSuite) TestDebugConnectivityError(c *check.C) {
+	s.daemon(c)
+
+	s.err = errors.New("boom")
+
+	req, err := http.NewRequest("GET", "/v2/debug?aspect=connectivity", nil)
+	c.Assert(err, check.IsNil)
+
+	rsp := getDebug(debugCmd, req, nil).(*resp)
+	c.Check(rsp.Type, check.Equals, ResponseTypeError)
+	c.Check(rsp.Status, check.Equals, http.StatusInternalServerError)
+	c.Check(rsp.Result.(*errorResult).Message, check.Equals, "cannot check store connectivity: boom")
+}
+
+func (s *apiSuite) TestDebugBadAspect(c *check.C) {
+	s.daemon(c)
+
+	for query, msg := range map[string]string{
+		"":               "missing debug aspect",
+		"?aspect=potato": `unknown debug aspect "potato"`,
+	} {
+		req, err := http.NewRequest("GET", "/v2/debug"+query, nil)
+		c.Assert(err, check.IsNil)
+
+		rsp := getDebug(debugCmd, req, nil).(*resp)
+		c.Check(rsp.Type, check.Equals, ResponseTypeError)
+		c.Check(rsp.Status, check.Equals, http.StatusBadRequest)
+		c.Check(rsp.Result.(*errorResult).Message, check.Equals, msg)
+	}
+}
+
+func readEvent(c *check.C, r *bufio.Reader) map[string]interface{} {
+	rec, err := r.ReadBytes('\n')
+	c.Assert(err, check.IsNil)
+	c.Assert(rec[0], check.Equals, byte(recordSeparator))
+	var ev map[string]interface{}
+	c.Assert(json.Unmarshal(rec[1:], &ev), check.IsNil)
+	return ev
+}
+
+func (s *apiSuite) TestEvents(c *check.C) {
+	d := s.daemon(c)
+	st := d.overlord.State()
+
+	st.Lock()
+	chg := st.NewChange("install", "...")
+	t := st.NewTask("download", "1...")
+	chg.AddTask(t)
+	other := st.NewChange("remove", "...")
+	st.Unlock()
+
+	req, err := http.NewRequest("GET", "/v2/events?change="+chg.ID()+"&kinds=task-status,change-status,snap-installed", nil)
+	c.Assert(err, check.IsNil)
+	rsp := getEvents(eventsCmd, req, nil)
+
+	server := httptest.NewServer(rsp)
+	defer server.Close()
+
+	stream, err := http.Get(server.URL)
+	c.Assert(err, check.IsNil)
+	defer stream.Body.Close()
+	c.Check(stream.StatusCode, check.Equals, http.StatusOK)
+	c.Check(stream.Header.Get("Content-Type"), check.Equals, "application/json-seq")
+
+	st.Lock()
+	other.SetStatus(state.ErrorStatus)
+	t.SetStatus(state.DoingStatus)
+	t.SetProgress("snap", 1, 2)
+	t.EmitEvent(&state.Event{Kind: "snap-installed", Data: map[string]string{"snap": "foo"}})
+	t.SetStatus(state.DoneStatus)
+	st.Unlock()
+
+	r := bufio.NewReader(stream.Body)
+	ev := readEvent(c, r)
+	c.Check(ev["time"], check.NotNil)
+	delete(ev, "time")
+	c.Check(ev, check.DeepEquals, map[string]interface{}{
+		"kind":      "task-status",
+		"change-id": chg.ID(),
+		"task-id":   t.ID(),
+		"status":    "Doing",
+	})
+	ev = readEvent(c, r)
+	c.Check(ev["kind"], check.Equals, "change-status")
+	c.Check(ev["status"], check.Equals, "Doing")
+	ev = readEvent(c, r)
+	c.Check(ev["kind"], check.Equals, "snap-installed")
+	c.Check(ev["data"], check.DeepEquals, map[string]interface{}{"snap": "foo"})
+	ev = readEvent(c, r)
+	c.Check(ev["kind"], check.Equals, "task-status")
+	c.Check(ev["status"], check.Equals, "Done")
+	ev = readEvent(c, r)
+	c.Check(ev["kind"], check.Equals, "change-status")
+	c.Check(ev["status"], check.Equals, "Done")
+
+	// the stream ends when the daemon stops
+	d.tomb.Kill(nil)
+	_, err = r.ReadBytes('\n')
+	c.Check(err, check.Equals, io.EOF)
+}
+
+func (s *apiSuite) TestEventsProgress(c *check.C) {
+	d := s.daemon(c)
+	st := d.overlord.State()
+
+	req, err := http.NewRequest("GET", "/v2/events", nil)
+	c.Assert(err, check.IsNil)
+	server := httptest.NewServer(getEvents(eventsCmd, req, nil))
+	defer server.Close()
+
+	stream, err := http.Get(server.URL)
+	c.Assert(err, check.IsNil)
+	defer stream.Body.Close()
+
+	st.Lock()
+	chg := st.NewChange("install", "...")
+	t := st.NewTask("download", "1...")
+	chg.AddTask(t)
+	t.SetProgress("snap", 1, 2)
+	st.Unlock()
+
+	ev := readEvent(c, bufio.NewReader(stream.Body))
+	delete(ev, "time")
+	c.Check(ev, check.DeepEquals, map[string]interface{}{
+		"kind":      "task-progress",
+		"change-id": chg.ID(),
+		"task-id":   t.ID(),
+		"progress": map[string]interface{}{
+			"label": "snap",
+			"done":  float64(1),
+			"total": float64(2),
+		},
+	})
+
+	d.tomb.Kill(nil)
+}
+
+func (s *apiSuite) TestEventsNotKeepingUp(c *check.C) {
+	d := s.daemon(c)
+	st := d.overlord.State()
+
+	restore := eventsBufferSize
+	eventsBufferSize = 1
+	defer func() { eventsBufferSize = restore }()
+
+	req, err := http.NewRequest("GET", "/v2/events", nil)
+	c.Assert(err, check.IsNil)
+	server := httptest.NewServer(getEvents(eventsCmd, req, nil))
+	defer server.Close()
+
+	stream, err := http.Get(server.URL)
+	c.Assert(err, check.IsNil)
+	defer stream.Body.Close()
+
+	st.Lock()
+	for i := 0; i < 10; i++ {
+		st.EmitEvent(&state.Event{Kind: "foo"})
+	}
+	st.Unlock()
+
+	// the stream is closed, after at most what was buffered
+	body, err := ioutil.ReadAll(stream.Body)
+	c.Assert(err, check.IsNil)
+	c.Check(bytes.Count(body, []byte{'\n'}) <= 2, check.Equals, true)
+}
+
+func (s *apiSuite) TestEventsUnknownChange(c *check.C) {
+	s.daemon(c)
+
+	req, err := http.NewRequest("GET", "/v2/events?change=42", nil)
+	c.Assert(err, check.IsNil)
+
+	rsp := getEvents(eventsCmd, req, nil).(*resp)
+	c.Check(rsp.Status, check.Equals, http.StatusNotFound)
+	c.Check(rsp.Result.(*errorResult).Message, check.Equals, `cannot find change with id "42"`)
+}
+
+func (s *apiSuite) TestGetWarnings(c *check.C) {
+	d := s.daemon(c)
+	st := d.overlord.State()
+
+	t0 := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	restore := state.MockTime(t0)
+	defer restore()
+	st.Lock()
+	st.Warnf("something went wrong")
+	st.Unlock()
+
+	req, err := http.NewRequest("GET", "/v2/warnings", nil)
+	c.Assert(err, check.IsNil)
+	rsp := getWarnings(warningsCmd, req, nil).(*resp)
+	c.Check(rsp.Type, check.Equals, ResponseTypeSync)
+	c.Check(rsp.Status, check.Equals, http.StatusOK)
+	c.Check(rsp.Result, check.DeepEquals, []*warningInfo{{
+		Message:     "something went wrong",
+		FirstAdded:  t0,
+		LastAdded:   t0,
+		ExpireAfter: state.DefaultExpireAfter.String(),
+		RepeatAfter: state.DefaultRepeatAfter.String(),
+	}})
+
+	// once okayed the warning is no longer pending
+	st.Lock()
+	c.Check(st.OkayWarnings(t0), check.Equals, 1)
+	st.Unlock()
+
+	rsp = getWarnings(warningsCmd, req, nil).(*resp)
+	c.Check(rsp.Result, check.HasLen, 0)
+
+	// but it is still listed when asking for all of them
+	req, err = http.NewRequest("GET", "/v2/warnings?select=all", nil)
+	c.Assert(err, check.IsNil)
+	rsp = getWarnings(warningsCmd, req, nil).(*resp)
+	c.Assert(rsp.Result, check.HasLen, 1)
+	c.Check(*rsp.Result.([]*warningInfo)[0].LastShown, check.Equals, t0)
+}
+
+func (s *apiSuite) TestGetWarningsBadSelect(c *check.C) {
+	s.daemon(c)
+
+	req, err := http.NewRequest("GET", "/v2/warnings?select=potato", nil)
+	c.Assert(err, check.IsNil)
+	rsp := getWarnings(warningsCmd, req, nil).(*resp)
+	c.Check(rsp.Status, check.Equals, http.StatusBadRequest)
+	c.Check(rsp.Result.(*errorResult).Message, check.Equals, `invalid select parameter: "potato"`)
+}
+
+func (s *apiSuite) TestAckWarnings(c *check.C) {
+	d := s.daemon(c)
+	st := d.overlord.State()
+
+	t0 := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	restore := state.MockTime(t0)
+	st.Lock()
+	st.Warnf("one")
+	st.Unlock()
+	restore()
+	restore = state.MockTime(t0.Add(time.Hour))
+	defer restore()
+	st.Lock()
+	st.Warnf("two")
+	st.Unlock()
+
+	// only the warnings up to the timestamp are okayed
+	buf := bytes.NewBufferString(`{"action": "okay", "timestamp": "2017-10-01T12:00:00Z"}`)
+	req, err := http.NewRequest("POST", "/v2/warnings", buf)
+	c.Assert(err, check.IsNil)
+	rsp := ackWarnings(warningsCmd, req, nil).(*resp)
+	c.Check(rsp.Type, check.Equals, ResponseTypeSync)
+	c.Check(rsp.Result, check.Equals, 1)
+
+	st.Lock()
+	pending := st.PendingWarnings()
+	st.Unlock()
+	c.Assert(pending, check.HasLen, 1)
+	c.Check(pending[0].Message(), check.Equals, "two")
+}
+
+func (s *apiSuite) TestAckWarningsErrors(c *check.C) {
+	s.daemon(c)
+
+	for body, msg := range map[string]string{
+		`{"action": "potato", "timestamp": "2017-10-01T12:00:00Z"}`: `unknown warning action "potato"`,
+		`{"action": "okay"}`: `missing timestamp of the latest warning to okay`,
+		`}`:                  `cannot decode request body into warnings operation: .*`,
+	} {
+		req, err := http.NewRequest("POST", "/v2/warnings", bytes.NewBufferString(body))
+		c.Assert(err, check.IsNil)
+		rsp := ackWarnings(warningsCmd, req, nil).(*resp)
+		c.Check(rsp.Status, check.Equals, http.StatusBadRequest)
+		c.Check(rsp.Result.(*errorResult).Message, check.Matches, msg)
+	}
+}
+
+func (s *apiSuite) TestPolkitRequestAction(c *check.C) {
+	for body, action := range map[string]string{
+		`{"action": "install"}`:    "io.snapcraft.snapd.install",
+		`{"action": "try"}`:        "io.snapcraft.snapd.install",
+		`{"action": "refresh"}`:    "io.snapcraft.snapd.refresh",
+		`{"action": "switch"}`:     "io.snapcraft.snapd.refresh",
+		`{"action": "remove"}`:     "io.snapcraft.snapd.remove",
+		`{"action": "connect"}`:    "io.snapcraft.snapd.connect",
+		`{"action": "disconnect"}`: "io.snapcraft.snapd.disconnect",
+		`{"action": "potato"}`:     "",
+		`}`:                        "",
+	} {
+		req, err := http.NewRequest("POST", "/v2/snaps/foo", bytes.NewBufferString(body))
+		c.Assert(err, check.IsNil)
+		req.Header.Set("Content-Type", "application/json")
+		c.Check(polkitRequestAction(req), check.Equals, action, check.Commentf(body))
+
+		// the body is left for the command to read
+		data, err := ioutil.ReadAll(req.Body)
+		c.Assert(err, check.IsNil)
+		c.Check(string(data), check.Equals, body)
+	}
+
+	req, err := http.NewRequest("POST", "/v2/snaps", nil)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=foo")
+	c.Check(polkitRequestAction(req), check.Equals, "io.snapcraft.snapd.install")
+
+	c.Check(snapConfCmd.PolkitAction(req), check.Equals, "io.snapcraft.snapd.configure")
+}
+
+func (s *apiSuite) TestSnapRequestRole(c *check.C) {
+	s.daemon(c)
+
+	operator := &auth.UserState{Roles: []auth.Role{auth.RoleOperator}}
+	for body, allowed := range map[string]bool{
+		`{"action": "enable"}`:  true,
+		`{"action": "disable"}`: true,
+		`{"action": "install"}`: false,
+		`{"action": "refresh"}`: false,
+		`{"action": "remove"}`:  false,
+		`{"action": "revert"}`:  false,
+		`}`:                     false,
+	} {
+		req, err := http.NewRequest("POST", "/v2/snaps/foo", bytes.NewBufferString(body))
+		c.Assert(err, check.IsNil)
+		req.RemoteAddr = "uid=42;"
+		c.Check(snapCmd.canAccess(req, operator), check.Equals, allowed, check.Commentf(body))
+
+		// the body is left for the command to read
+		data, err := ioutil.ReadAll(req.Body)
+		c.Assert(err, check.IsNil)
+		c.Check(string(data), check.Equals, body)
+	}
+
+	// viewers can only look
+	viewer := &auth.UserState{Roles: []auth.Role{auth.RoleViewer}}
+	req, err := http.NewRequest("POST", "/v2/snaps/foo", bytes.NewBufferString(`{"action": "enable"}`))
+	c.Assert(err, check.IsNil)
+	req.RemoteAddr = "uid=42;"
+	c.Check(snapCmd.canAccess(req, viewer), check.Equals, false)
+	req, err = http.NewRequest("GET", "/v2/snaps/foo", nil)
+	c.Assert(err, check.IsNil)
+	req.RemoteAddr = "uid=42;"
+	c.Check(snapCmd.canAccess(req, viewer), check.Equals, true)
+}
+
+// scrapeMetrics parses the samples of metrics in the Prometheus text
+// format, as a scraper would.
+func scrapeMetrics(c *check.C, body string) (samples map[string]string, types map[string]string) {
+	samples = make(map[string]string)
+	types = make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		if strings.HasPrefix(line, "# TYPE ") {
+			fields := strings.Fields(line)
+			c.Assert(fields, check.HasLen, 4)
+			types[fields[2]] = fields[3]
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		c.Assert(i > 0, check.Equals, true, check.Commentf("bad sample %q", line))
+		samples[line[:i]] = line[i+1:]
+	}
+	return samples, types
+}
+
+func (s *apiSuite) TestMetrics(c *check.C) {
+	d := s.daemon(c)
+	st := d.overlord.State()
+
+	req, err := http.NewRequest("GET", "/v2/metrics", nil)
+	c.Assert(err, check.IsNil)
+
+	// not enabled by default
+	rsp, ok := getMetrics(metricsCmd, req, nil).(*resp)
+	c.Assert(ok, check.Equals, true)
+	c.Check(rsp.Status, check.Equals, http.StatusNotFound)
+
+	st.Lock()
+	tr := config.NewTransaction(st)
+	tr.Set("core", "metrics.enabled", true)
+	tr.Commit()
+	chg := st.NewChange("metrics-test", "...")
+	t := st.NewTask("metrics-test-task", "...")
+	chg.AddTask(t)
+	t.SetStatus(state.DoneStatus)
+	st.Unlock()
+
+	rec := httptest.NewRecorder()
+	getMetrics(metricsCmd, req, nil).ServeHTTP(rec, req)
+	c.Check(rec.Code, check.Equals, http.StatusOK)
+	c.Check(rec.HeaderMap.Get("Content-Type"), check.Equals, "text/plain; version=0.0.4; charset=utf-8")
+
+	samples, types := scrapeMetrics(c, rec.Body.String())
+	c.Check(types, check.DeepEquals, map[string]string{
+		"snapd_change_duration_seconds":           "histogram",
+		"snapd_ensure_duration_seconds":           "histogram",
+		"snapd_state_checkpoint_duration_seconds": "histogram",
+		"snapd_state_checkpoint_size_bytes":       "gauge",
+		"snapd_store_download_bytes_total":        "counter",
+		"snapd_store_request_errors_total":        "counter",
+		"snapd_store_requests_total":              "counter",
+		"snapd_task_duration_seconds":             "histogram",
+	})
+	c.Check(samples[`snapd_task_duration_seconds_count{kind="metrics-test-task",status="Done"}`], check.Equals, "1")
+	c.Check(samples[`snapd_change_duration_seconds_count{kind="metrics-test",status="Done"}`], check.Equals, "1")
+	c.Check(samples[`snapd_change_duration_seconds_bucket{kind="metrics-test",status="Done",le="+Inf"}`], check.Equals, "1")
+	c.Check(samples["snapd_state_checkpoint_size_bytes"], check.Not(check.Equals), "")
+}
@@ -42,6 +42,7 @@ import (
 	"github.com/snapcore/snapd/overlord"
 	"github.com/snapcore/snapd/overlord/auth"
 	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/polkit"
 )
 
 // A Daemon listens for requests and routes them to the right command
@@ -50,8 +51,10 @@ type Daemon struct {
 	overlord      *overlord.Overlord
 	snapdListener net.Listener
 	snapListener  net.Listener
-	tomb          tomb.Tomb
-	router        *mux.Router
+	// remoteListener is for clients on other hosts, if enabled
+	remoteListener net.Listener
+	tomb           tomb.Tomb
+	router         *mux.Router
 	// enableInternalInterfaceActions controls if adding and removing slots and plugs is allowed.
 	enableInternalInterfaceActions bool
 }
@@ -73,16 +76,74 @@ type Command struct {
 	UserOK bool
 	// is this path accessible on the snapd-snap socket?
 	SnapOK bool
+	// which polkit action, if any, lets non-admin users do more
+	// than GET?
+	PolkitAction func(*http.Request) string
+	// which role do authenticated users need for the request? By
+	// default, or if it returns "", GET needs viewer and the other
+	// methods admin
+	Role func(*http.Request) auth.Role
 
 	d *Daemon
 }
 
+// allowInteractionHeader is the header with which clients tell that
+// polkit can ask the user to authenticate.
+const allowInteractionHeader = "X-Allow-Interaction"
+
+var polkitCheckAuthorization = polkit.CheckAuthorization
+
+// canAccessViaPolkit checks with polkit whether the non-admin user
+// behind the request is authorized for the action of the command,
+// possibly after authenticating.
+func (c *Command) canAccessViaPolkit(r *http.Request) (bool, error) {
+	if c.PolkitAction == nil || r.Method == "GET" {
+		return false, nil
+	}
+	uid, err := ucrednetGetUID(r.RemoteAddr)
+	if err != nil {
+		return false, nil
+	}
+	pid, err := ucrednetGetPID(r.RemoteAddr)
+	if err != nil {
+		return false, nil
+	}
+	actionID := c.PolkitAction(r)
+	if actionID == "" {
+		return false, nil
+	}
+
+	flags := polkit.CheckNone
+	if r.Header.Get(allowInteractionHeader) == "true" {
+		flags |= polkit.CheckAllowInteraction
+	}
+	return polkitCheckAuthorization(pid, uid, actionID, nil, flags)
+}
+
+// requiredRole returns the role authenticated users need for r.
+func (c *Command) requiredRole(r *http.Request) auth.Role {
+	if c.Role != nil {
+		if role := c.Role(r); role != "" {
+			return role
+		}
+	}
+	if r.Method == "GET" {
+		return auth.RoleViewer
+	}
+	return auth.RoleAdmin
+}
+
 func (c *Command) canAccess(r *http.Request, user *auth.UserState) bool {
-	if user != nil {
-		// Authenticated users do anything for now.
+	if user != nil && user.HasRole(c.requiredRole(r)) {
+		// Authenticated users do what their roles allow.
 		return true
 	}
 
+	if isRemote(r) {
+		// Remote clients can only do what their roles allow.
+		return false
+	}
+
 	isUser := false
 	uid, err := ucrednetGetUID(r.RemoteAddr)
 	if err == nil {
@@ -117,13 +178,43 @@ func (c *Command) canAccess(r *http.Request, user *auth.UserState) bool {
 func (c *Command) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	state := c.d.overlord.State()
 	state.Lock()
-	// TODO Look at the error and fail if there's an attempt to authenticate with invalid data.
-	user, _ := UserFromRequest(state, r)
+	var user *auth.UserState
+	if isRemote(r) {
+		var err error
+		user, err = remoteUser(state, r)
+		if err != nil {
+			state.Unlock()
+			logger.Noticef("denying access to remote client %s: %v", r.RemoteAddr, err)
+			Unauthorized("access denied").ServeHTTP(w, r)
+			return
+		}
+	} else {
+		// TODO Look at the error and fail if there's an attempt to authenticate with invalid data.
+		user, _ = UserFromRequest(state, r)
+	}
 	state.Unlock()
 
 	if !c.canAccess(r, user) {
-		Unauthorized("access denied").ServeHTTP(w, r)
-		return
+		ok, err := c.canAccessViaPolkit(r)
+		if err == polkit.ErrDismissed {
+			rsp := &resp{
+				Type: ResponseTypeError,
+				Result: &errorResult{
+					Message: "cancelled",
+					Kind:    errorKindAuthCancelled,
+				},
+				Status: http.StatusForbidden,
+			}
+			rsp.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			logger.Noticef("cannot check polkit authorization: %v", err)
+		}
+		if !ok {
+			Unauthorized("access denied").ServeHTTP(w, r)
+			return
+		}
 	}
 
 	var rspf ResponseFunc
@@ -144,9 +235,33 @@ func (c *Command) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		rsp = rspf(c, r, user)
 	}
 
+	if rsp, ok := rsp.(*resp); ok {
+		state.Lock()
+		if rsp.Type == ResponseTypeAsync && rsp.Meta != nil && rsp.Change != "" {
+			recordRequester(state.Change(rsp.Change), r, user)
+		}
+		count, latest := state.WarningsSummary()
+		state.Unlock()
+		rsp.addWarningsToMeta(count, latest)
+	}
+
 	rsp.ServeHTTP(w, r)
 }
 
+// recordRequester notes in the change who asked for it, to be kept in
+// the change history.
+func recordRequester(chg *state.Change, r *http.Request, user *auth.UserState) {
+	if chg == nil {
+		return
+	}
+	if user != nil && user.ID != 0 {
+		chg.Set("user-id", user.ID)
+	}
+	if uid, err := ucrednetGetUID(r.RemoteAddr); err == nil {
+		chg.Set("uid", uid)
+	}
+}
+
 type wrappedWriter struct {
 	w http.ResponseWriter
 	s int
@@ -165,6 +280,22 @@ func (w *wrappedWriter) WriteHeader(s int) {
 	w.s = s
 }
 
+// Flush lets streaming responses flush through the wrapper.
+func (w *wrappedWriter) Flush() {
+	if f, ok := w.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// CloseNotify lets streaming responses notice that the client went
+// away through the wrapper.
+func (w *wrappedWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.w.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return nil
+}
+
 func logit(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ww := &wrappedWriter{w: w}
@@ -245,6 +376,8 @@ func (d *Daemon) Init() error {
 		logger.Debugf("cannot get listener for %q: %v", dirs.SnapSocket, err)
 	}
 
+	d.initRemoteListener()
+
 	d.addRoutes()
 
 	logger.Debugf("init done in %s", time.Now().Sub(t0))
@@ -300,6 +433,16 @@ func (d *Daemon) Start() {
 			})
 		}
 
+		if d.remoteListener != nil {
+			d.tomb.Go(func() error {
+				if err := http.Serve(d.remoteListener, logit(d.router)); err != nil && d.tomb.Err() == tomb.ErrStillAlive {
+					return err
+				}
+
+				return nil
+			})
+		}
+
 		if err := http.Serve(d.snapdListener, logit(d.router)); err != nil && d.tomb.Err() == tomb.ErrStillAlive {
 			return err
 		}
@@ -315,6 +458,9 @@ func (d *Daemon) Stop() error {
 	if d.snapListener != nil {
 		d.snapListener.Close()
 	}
+	if d.remoteListener != nil {
+		d.remoteListener.Close()
+	}
 	d.overlord.Stop()
 
 	return d.tomb.Wait()
@@ -20,6 +20,9 @@
 package daemon
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -34,6 +37,7 @@ import (
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/overlord/auth"
 	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/polkit"
 )
 
 // Hook up check.v1 into the "go test" runner
@@ -113,6 +117,133 @@ func (s *daemonSuite) TestCommandMethodDispatch(c *check.C) {
 	c.Check(rec.Code, check.Equals, http.StatusMethodNotAllowed)
 }
 
+func (s *daemonSuite) TestCommandAddsWarningsToMeta(c *check.C) {
+	d := newTestDaemon(c)
+	cmd := &Command{d: d}
+	cmd.GET = func(*Command, *http.Request, *auth.UserState) Response {
+		return SyncResponse("ok", nil)
+	}
+
+	req, err := http.NewRequest("GET", "", nil)
+	c.Assert(err, check.IsNil)
+	req.RemoteAddr = "uid=0;" + req.RemoteAddr
+
+	// no warnings, no meta
+	rec := httptest.NewRecorder()
+	cmd.ServeHTTP(rec, req)
+	c.Check(rec.Code, check.Equals, http.StatusOK)
+	var rsp map[string]interface{}
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &rsp), check.IsNil)
+	c.Check(rsp["warning-count"], check.IsNil)
+	c.Check(rsp["warning-timestamp"], check.IsNil)
+
+	t0 := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	restore := state.MockTime(t0)
+	defer restore()
+	st := d.overlord.State()
+	st.Lock()
+	st.Warnf("hello")
+	st.Unlock()
+
+	rec = httptest.NewRecorder()
+	cmd.ServeHTTP(rec, req)
+	c.Check(rec.Code, check.Equals, http.StatusOK)
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &rsp), check.IsNil)
+	c.Check(rsp["warning-count"], check.Equals, 1.0)
+	c.Check(rsp["warning-timestamp"], check.Equals, t0.Format(time.RFC3339Nano))
+}
+
+func (s *daemonSuite) TestCommandRecordsRequester(c *check.C) {
+	d := newTestDaemon(c)
+	st := d.overlord.State()
+	var chgID string
+	cmd := &Command{d: d}
+	cmd.POST = func(*Command, *http.Request, *auth.UserState) Response {
+		st.Lock()
+		defer st.Unlock()
+		chgID = st.NewChange("foo", "...").ID()
+		return AsyncResponse(nil, &Meta{Change: chgID})
+	}
+
+	st.Lock()
+	user, err := auth.NewUser(st, "username", "email@test.com", "macaroon", []string{"discharge"})
+	st.Unlock()
+	c.Assert(err, check.IsNil)
+
+	req, err := http.NewRequest("POST", "", nil)
+	c.Assert(err, check.IsNil)
+	req.RemoteAddr = "uid=1000;" + req.RemoteAddr
+	req.Header.Set("Authorization", fmt.Sprintf(`Macaroon root="%s", discharge="discharge"`, user.Macaroon))
+
+	rec := httptest.NewRecorder()
+	cmd.ServeHTTP(rec, req)
+	c.Check(rec.Code, check.Equals, http.StatusAccepted)
+
+	st.Lock()
+	defer st.Unlock()
+	chg := st.Change(chgID)
+	c.Assert(chg, check.NotNil)
+	var userID int
+	var uid uint32
+	c.Check(chg.Get("user-id", &userID), check.IsNil)
+	c.Check(userID, check.Equals, user.ID)
+	c.Check(chg.Get("uid", &uid), check.IsNil)
+	c.Check(uid, check.Equals, uint32(1000))
+}
+
+func (s *daemonSuite) TestPolkitAccess(c *check.C) {
+	var calls []string
+	var allowed bool
+	var checkErr error
+	polkitCheckAuthorization = func(pid, uid uint32, actionID string, details map[string]string, flags polkit.CheckFlags) (bool, error) {
+		calls = append(calls, fmt.Sprintf("%d %d %s %v", pid, uid, actionID, flags))
+		return allowed, checkErr
+	}
+	defer func() { polkitCheckAuthorization = polkit.CheckAuthorization }()
+
+	cmd := &Command{d: newTestDaemon(c), UserOK: true}
+	cmd.POST = func(*Command, *http.Request, *auth.UserState) Response {
+		return SyncResponse("ok", nil)
+	}
+	post := func(interactive bool) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "", nil)
+		c.Assert(err, check.IsNil)
+		req.RemoteAddr = "uid=1000;pid=100;"
+		if interactive {
+			req.Header.Set("X-Allow-Interaction", "true")
+		}
+		rec := httptest.NewRecorder()
+		cmd.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// without a polkit action polkit is not asked
+	c.Check(post(false).Code, check.Equals, http.StatusUnauthorized)
+	c.Check(calls, check.HasLen, 0)
+
+	cmd.PolkitAction = func(*http.Request) string { return "io.snapcraft.snapd.install" }
+	c.Check(post(false).Code, check.Equals, http.StatusUnauthorized)
+	c.Check(calls, check.DeepEquals, []string{"100 1000 io.snapcraft.snapd.install 0"})
+
+	allowed = true
+	c.Check(post(true).Code, check.Equals, http.StatusOK)
+	c.Check(calls[1], check.Equals, "100 1000 io.snapcraft.snapd.install 1")
+
+	allowed = false
+	checkErr = polkit.ErrDismissed
+	rec := post(true)
+	c.Check(rec.Code, check.Equals, http.StatusForbidden)
+	var rsp map[string]interface{}
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &rsp), check.IsNil)
+	c.Check(rsp["result"], check.DeepEquals, map[string]interface{}{
+		"message": "cancelled",
+		"kind":    "auth-cancelled",
+	})
+
+	checkErr = errors.New("no polkit")
+	c.Check(post(false).Code, check.Equals, http.StatusUnauthorized)
+}
+
 func (s *daemonSuite) TestGuestAccess(c *check.C) {
 	get := &http.Request{Method: "GET"}
 	put := &http.Request{Method: "PUT"}
@@ -171,6 +302,31 @@ func (s *daemonSuite) TestUserAccess(c *check.C) {
 	c.Check(cmd.canAccess(put, nil), check.Equals, false)
 }
 
+func (s *daemonSuite) TestRoleAccess(c *check.C) {
+	get := &http.Request{Method: "GET", RemoteAddr: "uid=42;"}
+	pst := &http.Request{Method: "POST", RemoteAddr: "uid=42;"}
+
+	// users from before roles can do anything
+	user := &auth.UserState{}
+	cmd := &Command{d: newTestDaemon(c)}
+	c.Check(cmd.canAccess(get, user), check.Equals, true)
+	c.Check(cmd.canAccess(pst, user), check.Equals, true)
+
+	user.Roles = []auth.Role{auth.RoleViewer}
+	c.Check(cmd.canAccess(get, user), check.Equals, true)
+	c.Check(cmd.canAccess(pst, user), check.Equals, false)
+
+	cmd.Role = postRole(auth.RoleOperator)
+	c.Check(cmd.canAccess(pst, user), check.Equals, false)
+	user.Roles = []auth.Role{auth.RoleOperator}
+	c.Check(cmd.canAccess(pst, user), check.Equals, true)
+
+	// root can still do anything
+	pst.RemoteAddr = "uid=0;"
+	user.Roles = []auth.Role{auth.RoleViewer}
+	c.Check(cmd.canAccess(pst, user), check.Equals, true)
+}
+
 func (s *daemonSuite) TestSuperAccess(c *check.C) {
 	get := &http.Request{Method: "GET", RemoteAddr: "uid=0;"}
 	put := &http.Request{Method: "PUT", RemoteAddr: "uid=0;"}
@@ -0,0 +1,157 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+// The API can also be reached remotely, over TCP secured by mutual
+// TLS, which is opted into with:
+//
+// $ snap set core remote.address=:8443
+//
+// The server certificate and key are then read from server.crt and
+// server.key in dirs.SnapRemoteDir, and clients must present a
+// certificate signed by one of the CAs in clients-ca.pem there. The
+// clients are identified by the common name of their certificate and
+// can only do what the roles they are given allow, e.g.:
+//
+// $ snap set core remote.clients='{"agent.example.com": ["operator"]}'
+//
+// remote.address and the certificates are only read when snapd starts,
+// so changing them needs a restart of snapd, while remote.clients is
+// checked on every request.
+
+// remoteAddress returns the address to listen on for remote clients,
+// or "" if remote access is not enabled.
+func remoteAddress(st *state.State) (string, error) {
+	var address string
+	tr := config.NewTransaction(st)
+	if err := tr.Get("core", "remote.address", &address); err != nil && !config.IsNoOption(err) {
+		return "", err
+	}
+	return address, nil
+}
+
+func remoteTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dirs.SnapRemoteDir, "server.crt"), filepath.Join(dirs.SnapRemoteDir, "server.key"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot load server certificate: %v", err)
+	}
+	caPEM, err := ioutil.ReadFile(filepath.Join(dirs.SnapRemoteDir, "clients-ca.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot load client CA certificates: %v", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("cannot load client CA certificates: no certificates found in %s", filepath.Join(dirs.SnapRemoteDir, "clients-ca.pem"))
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// initRemoteListener sets up the listener for remote clients, if remote
+// access is enabled. Failing to do so only disables remote access, so
+// that snapd, and the local snap command to fix the setup, still work.
+func (d *Daemon) initRemoteListener() {
+	st := d.overlord.State()
+	st.Lock()
+	address, err := remoteAddress(st)
+	st.Unlock()
+	if err != nil {
+		logger.Noticef("Cannot get remote.address setting, remote access disabled: %v", err)
+		return
+	}
+	if address == "" {
+		return
+	}
+	listener, err := getRemoteListener(address)
+	if err != nil {
+		logger.Noticef("Cannot listen on %s for remote clients, remote access disabled: %v", address, err)
+		return
+	}
+	d.remoteListener = listener
+}
+
+// getRemoteListener returns a listener for remote clients on address,
+// only accepting connections from clients with a trusted certificate.
+func getRemoteListener(address string) (net.Listener, error) {
+	tlsConfig, err := remoteTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	return tls.Listen("tcp", address, tlsConfig)
+}
+
+// isRemote returns whether the request came through the remote listener.
+func isRemote(r *http.Request) bool {
+	return r.TLS != nil
+}
+
+// remoteUser returns the user for the client of a remote request,
+// identified by its certificate and with the roles it was given.
+func remoteUser(st *state.State, r *http.Request) (*auth.UserState, error) {
+	if len(r.TLS.PeerCertificates) == 0 {
+		return nil, fmt.Errorf("no client certificate")
+	}
+	name := r.TLS.PeerCertificates[0].Subject.CommonName
+
+	var clients map[string][]auth.Role
+	tr := config.NewTransaction(st)
+	if err := tr.Get("core", "remote.clients", &clients); err != nil && !config.IsNoOption(err) {
+		return nil, err
+	}
+	roles, ok := clients[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown client %q", name)
+	}
+	if len(roles) == 0 {
+		// users without roles can do anything, which is not
+		// what a client without roles should get
+		roles = []auth.Role{auth.RoleViewer}
+	}
+	for _, role := range roles {
+		if err := auth.ValidRole(role); err != nil {
+			return nil, fmt.Errorf("invalid roles for client %q: %v", name, err)
+		}
+	}
+
+	return &auth.UserState{
+		Username: name,
+		Roles:    roles,
+	}, nil
+}
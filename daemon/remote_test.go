@@ -0,0 +1,215 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type remoteSuite struct {
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	caPool *x509.CertPool
+}
+
+var _ = check.Suite(&remoteSuite{})
+
+func (s *remoteSuite) SetUpTest(c *check.C) {
+	dirs.SetRootDir(c.MkDir())
+	c.Assert(os.MkdirAll(filepath.Dir(dirs.SnapStateFile), 0755), check.IsNil)
+	c.Assert(os.MkdirAll(dirs.SnapRemoteDir, 0700), check.IsNil)
+
+	s.ca, s.caKey = s.makeCert(c, "test-ca", nil, nil)
+	s.caPool = x509.NewCertPool()
+	s.caPool.AddCert(s.ca)
+	writePEM(c, filepath.Join(dirs.SnapRemoteDir, "clients-ca.pem"), "CERTIFICATE", s.ca.Raw)
+
+	server, serverKey := s.makeCert(c, "127.0.0.1", s.ca, s.caKey)
+	writePEM(c, filepath.Join(dirs.SnapRemoteDir, "server.crt"), "CERTIFICATE", server.Raw)
+	keyDER, err := x509.MarshalECPrivateKey(serverKey)
+	c.Assert(err, check.IsNil)
+	writePEM(c, filepath.Join(dirs.SnapRemoteDir, "server.key"), "EC PRIVATE KEY", keyDER)
+}
+
+func (s *remoteSuite) TearDownTest(c *check.C) {
+	dirs.SetRootDir("")
+}
+
+func writePEM(c *check.C, path, typ string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	c.Assert(ioutil.WriteFile(path, data, 0600), check.IsNil)
+}
+
+// makeCert makes a certificate for name, signed by parent, or a CA
+// certificate if parent is nil.
+func (s *remoteSuite) makeCert(c *check.C, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, check.IsNil)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	c.Assert(err, check.IsNil)
+	cert, err := x509.ParseCertificate(der)
+	c.Assert(err, check.IsNil)
+	return cert, key
+}
+
+func (s *remoteSuite) client(c *check.C, name string) *http.Client {
+	tlsConfig := &tls.Config{RootCAs: s.caPool}
+	if name != "" {
+		cert, key := s.makeCert(c, name, s.ca, s.caKey)
+		tlsConfig.Certificates = []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+}
+
+func (s *remoteSuite) startDaemon(c *check.C) (d *Daemon, baseURL string) {
+	d = newTestDaemon(c)
+	st := d.overlord.State()
+	st.Lock()
+	// mark as already seeded
+	st.Set("seeded", true)
+	tr := config.NewTransaction(st)
+	tr.Set("core", "remote.address", "127.0.0.1:0")
+	tr.Set("core", "remote.clients", map[string]interface{}{
+		"viewer.example.com": []string{"viewer"},
+		"nobody.example.com": []string{},
+	})
+	tr.Commit()
+	st.Unlock()
+
+	d.initRemoteListener()
+	c.Assert(d.remoteListener, check.NotNil)
+	var err error
+	d.snapdListener, err = net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+
+	d.Start()
+	return d, "https://" + d.remoteListener.Addr().String()
+}
+
+func (s *remoteSuite) TestRemoteAccess(c *check.C) {
+	d, baseURL := s.startDaemon(c)
+	defer d.Stop()
+
+	client := s.client(c, "viewer.example.com")
+	rsp, err := client.Get(baseURL + "/v2/system-info")
+	c.Assert(err, check.IsNil)
+	rsp.Body.Close()
+	c.Check(rsp.StatusCode, check.Equals, 200)
+
+	// viewers cannot change anything
+	rsp, err = client.Post(baseURL+"/v2/snaps/foo", "application/json", strings.NewReader(`{"action": "install"}`))
+	c.Assert(err, check.IsNil)
+	rsp.Body.Close()
+	c.Check(rsp.StatusCode, check.Equals, 401)
+
+	// clients without roles are only viewers
+	client = s.client(c, "nobody.example.com")
+	rsp, err = client.Post(baseURL+"/v2/snaps/foo", "application/json", strings.NewReader(`{"action": "install"}`))
+	c.Assert(err, check.IsNil)
+	rsp.Body.Close()
+	c.Check(rsp.StatusCode, check.Equals, 401)
+}
+
+func (s *remoteSuite) TestRemoteAccessUnknownClient(c *check.C) {
+	d, baseURL := s.startDaemon(c)
+	defer d.Stop()
+
+	// not even guest access
+	rsp, err := s.client(c, "other.example.com").Get(baseURL + "/v2/system-info")
+	c.Assert(err, check.IsNil)
+	rsp.Body.Close()
+	c.Check(rsp.StatusCode, check.Equals, 401)
+}
+
+func (s *remoteSuite) TestRemoteAccessNeedsCertificate(c *check.C) {
+	d, baseURL := s.startDaemon(c)
+	defer d.Stop()
+
+	_, err := s.client(c, "").Get(baseURL + "/v2/system-info")
+	c.Check(err, check.NotNil)
+}
+
+func (s *remoteSuite) TestRemoteListenerMissingCertificate(c *check.C) {
+	c.Assert(os.Remove(filepath.Join(dirs.SnapRemoteDir, "server.crt")), check.IsNil)
+
+	_, err := getRemoteListener("127.0.0.1:0")
+	c.Check(err, check.ErrorMatches, "cannot load server certificate: .*")
+}
+
+func (s *remoteSuite) TestInitRemoteListenerFailureIsLogged(c *check.C) {
+	c.Assert(os.Remove(filepath.Join(dirs.SnapRemoteDir, "server.crt")), check.IsNil)
+
+	var logbuf bytes.Buffer
+	l, err := logger.NewConsoleLog(&logbuf, 0)
+	c.Assert(err, check.IsNil)
+	logger.SetLogger(l)
+	defer logger.SetLogger(logger.NullLogger)
+
+	d := newTestDaemon(c)
+	st := d.overlord.State()
+	st.Lock()
+	tr := config.NewTransaction(st)
+	tr.Set("core", "remote.address", "127.0.0.1:0")
+	tr.Commit()
+	st.Unlock()
+
+	// snapd carries on without remote access
+	d.initRemoteListener()
+	c.Check(d.remoteListener, check.IsNil)
+	c.Check(logbuf.String(), testutil.Contains, "Cannot listen on 127.0.0.1:0 for remote clients, remote access disabled: cannot load server certificate")
+}
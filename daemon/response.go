@@ -26,9 +26,12 @@ import (
 	"net/http"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/metrics"
+	"github.com/snapcore/snapd/overlord/state"
 )
 
 // ResponseType is the response type
@@ -64,6 +67,9 @@ type Meta struct {
 	Paging            *Paging  `json:"paging,omitempty"`
 	SuggestedCurrency string   `json:"suggested-currency,omitempty"`
 	Change            string   `json:"change,omitempty"`
+
+	WarningCount     int        `json:"warning-count,omitempty"`
+	WarningTimestamp *time.Time `json:"warning-timestamp,omitempty"`
 }
 
 type Paging struct {
@@ -89,6 +95,22 @@ func (r *resp) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// addWarningsToMeta sets the summary of the pending warnings in the
+// response, so that clients can tell their users about them.
+func (r *resp) addWarningsToMeta(count int, latest time.Time) {
+	if count == 0 {
+		return
+	}
+	if r.Type != ResponseTypeSync && r.Type != ResponseTypeAsync {
+		return
+	}
+	if r.Meta == nil {
+		r.Meta = &Meta{}
+	}
+	r.Meta.WarningCount = count
+	r.Meta.WarningTimestamp = &latest
+}
+
 func (r *resp) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
 	status := r.Status
 	bs, err := r.MarshalJSON()
@@ -133,6 +155,8 @@ const (
 
 	errorKindSnapNeedsMode          = errorKind("snap-needs-mode")
 	errorKindSnapNeedsClassicSystem = errorKind("snap-needs-classic-system")
+
+	errorKindAuthCancelled = errorKind("auth-cancelled")
 )
 
 type errorValue interface{}
@@ -230,6 +254,97 @@ func (ar assertResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// metricsContentType is the media type of the Prometheus text format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metricsResponse serves the metrics of snapd in the Prometheus text
+// format.
+type metricsResponse struct{}
+
+func (metricsResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metricsContentType)
+	w.WriteHeader(http.StatusOK)
+	if err := metrics.WriteText(w); err != nil {
+		logger.Noticef("cannot write metrics into response: %v", err)
+	}
+}
+
+// eventsBufferSize is how many events can be queued for a client of
+// the events stream before it is considered not to be keeping up
+var eventsBufferSize = 100
+
+// recordSeparator starts each record of a JSON text sequence (RFC 7464)
+const recordSeparator = 0x1e
+
+type eventsResponse struct {
+	st     *state.State
+	filter func(*state.Event) bool
+	dying  <-chan struct{}
+}
+
+// ServeHTTP streams the events passing the filter as a JSON text
+// sequence, until the client goes away, does not keep up or the
+// daemon stops.
+func (er *eventsResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	events := make(chan *state.Event, eventsBufferSize)
+	overflow := make(chan struct{})
+	overflown := false
+	er.st.Lock()
+	// the handler is called with the state locked
+	id := er.st.AddEventHandler(func(ev *state.Event) {
+		if overflown || !er.filter(ev) {
+			return
+		}
+		select {
+		case events <- ev:
+		default:
+			overflown = true
+			close(overflow)
+		}
+	})
+	er.st.Unlock()
+	defer func() {
+		er.st.Lock()
+		er.st.RemoveEventHandler(id)
+		er.st.Unlock()
+	}()
+
+	flush := func() {}
+	if f, ok := w.(http.Flusher); ok {
+		flush = f.Flush
+	}
+	var gone <-chan bool
+	if cn, ok := w.(http.CloseNotifier); ok {
+		gone = cn.CloseNotify()
+	}
+
+	w.Header().Set("Content-Type", "application/json-seq")
+	w.WriteHeader(http.StatusOK)
+	flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case ev := <-events:
+			if _, err := w.Write([]byte{recordSeparator}); err != nil {
+				return
+			}
+			if err := enc.Encode(event2eventInfo(ev)); err != nil {
+				logger.Debugf("cannot write event into response: %v", err)
+				return
+			}
+			flush()
+		case <-overflow:
+			logger.Noticef("closing events stream to %s: client is not keeping up", r.RemoteAddr)
+			return
+		case <-gone:
+			return
+		case <-er.dying:
+			return
+		}
+	}
+}
+
 // errorResponder is a callable that produces an error Response.
 // e.g., InternalError("something broke: %v", err), etc.
 type errorResponder func(string, ...interface{}) Response
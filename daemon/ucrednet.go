@@ -28,7 +28,10 @@ import (
 	sys "syscall"
 )
 
-var errNoUID = errors.New("no uid found")
+var (
+	errNoUID = errors.New("no uid found")
+	errNoPID = errors.New("no pid found")
+)
 
 const ucrednetNobody = uint32((1 << 32) - 1)
 
@@ -46,22 +49,44 @@ func ucrednetGetUID(remoteAddr string) (uint32, error) {
 	return uint32(uid), nil
 }
 
+// ucrednetGetPID returns the pid of the peer of the connection that
+// remoteAddr was received from.
+func ucrednetGetPID(remoteAddr string) (uint32, error) {
+	if _, err := ucrednetGetUID(remoteAddr); err != nil {
+		return 0, err
+	}
+	rest := remoteAddr[strings.IndexByte(remoteAddr, ';')+1:]
+	idx := strings.IndexByte(rest, ';')
+	if !strings.HasPrefix(rest, "pid=") || idx < 5 {
+		return 0, errNoPID
+	}
+
+	pid, err := strconv.ParseUint(rest[4:idx], 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint32(pid), nil
+}
+
 type ucrednetAddr struct {
 	net.Addr
 	uid string
+	pid string
 }
 
 func (wa *ucrednetAddr) String() string {
-	return fmt.Sprintf("uid=%s;%s", wa.uid, wa.Addr)
+	return fmt.Sprintf("uid=%s;pid=%s;%s", wa.uid, wa.pid, wa.Addr)
 }
 
 type ucrednetConn struct {
 	net.Conn
 	uid string
+	pid string
 }
 
 func (wc *ucrednetConn) RemoteAddr() net.Addr {
-	return &ucrednetAddr{wc.Conn.RemoteAddr(), wc.uid}
+	return &ucrednetAddr{wc.Conn.RemoteAddr(), wc.uid, wc.pid}
 }
 
 type ucrednetListener struct{ net.Listener }
@@ -74,7 +99,7 @@ func (wl *ucrednetListener) Accept() (net.Conn, error) {
 		return nil, err
 	}
 
-	uid := ""
+	var uid, pid string
 	if ucon, ok := con.(*net.UnixConn); ok {
 		f, err := ucon.File()
 		if err != nil {
@@ -89,7 +114,8 @@ func (wl *ucrednetListener) Accept() (net.Conn, error) {
 		}
 
 		uid = strconv.FormatUint(uint64(ucred.Uid), 10)
+		pid = strconv.FormatUint(uint64(ucred.Pid), 10)
 	}
 
-	return &ucrednetConn{con, uid}, err
+	return &ucrednetConn{con, uid, pid}, err
 }
@@ -52,7 +52,7 @@ func (s *ucrednetSuite) TearDownSuite(c *check.C) {
 }
 
 func (s *ucrednetSuite) TestAcceptConnRemoteAddrString(c *check.C) {
-	s.ucred = &sys.Ucred{Uid: 42}
+	s.ucred = &sys.Ucred{Uid: 42, Pid: 100}
 	d := c.MkDir()
 	sock := filepath.Join(d, "sock")
 
@@ -73,10 +73,13 @@ func (s *ucrednetSuite) TestAcceptConnRemoteAddrString(c *check.C) {
 	defer conn.Close()
 
 	remoteAddr := conn.RemoteAddr().String()
-	c.Check(remoteAddr, check.Matches, "uid=42;.*")
+	c.Check(remoteAddr, check.Matches, "uid=42;pid=100;.*")
 	uid, err := ucrednetGetUID(remoteAddr)
 	c.Check(uid, check.Equals, uint32(42))
 	c.Check(err, check.IsNil)
+	pid, err := ucrednetGetPID(remoteAddr)
+	c.Check(pid, check.Equals, uint32(100))
+	c.Check(err, check.IsNil)
 }
 
 func (s *ucrednetSuite) TestNonUnix(c *check.C) {
@@ -99,7 +102,7 @@ func (s *ucrednetSuite) TestNonUnix(c *check.C) {
 	defer conn.Close()
 
 	remoteAddr := conn.RemoteAddr().String()
-	c.Check(remoteAddr, check.Matches, "uid=;.*")
+	c.Check(remoteAddr, check.Matches, "uid=;pid=;.*")
 	uid, err := ucrednetGetUID(remoteAddr)
 	c.Check(uid, check.Equals, ucrednetNobody)
 	c.Check(err, check.Equals, errNoUID)
@@ -170,3 +173,18 @@ func (s *ucrednetSuite) TestGet(c *check.C) {
 	c.Check(err, check.IsNil)
 	c.Check(uid, check.Equals, uint32(42))
 }
+
+func (s *ucrednetSuite) TestGetPID(c *check.C) {
+	pid, err := ucrednetGetPID("uid=42;pid=100;")
+	c.Check(err, check.IsNil)
+	c.Check(pid, check.Equals, uint32(100))
+
+	_, err = ucrednetGetPID("uid=42;")
+	c.Check(err, check.Equals, errNoPID)
+
+	_, err = ucrednetGetPID("uid=42;pid=;")
+	c.Check(err, check.Equals, errNoPID)
+
+	_, err = ucrednetGetPID("uid=;pid=100;")
+	c.Check(err, check.Equals, errNoUID)
+}
@@ -34,6 +34,7 @@ var (
 
 	SnapMountDir              string
 	SnapBlobDir               string
+	SnapDownloadCacheDir      string
 	SnapDataDir               string
 	SnapDataHomeGlob          string
 	SnapAppArmorDir           string
@@ -51,12 +52,14 @@ var (
 
 	SnapSeedDir   string
 	SnapDeviceDir string
+	SnapRemoteDir string
 
 	SnapAssertsDBDir      string
 	SnapTrustedAccountKey string
 	SnapAssertsSpoolDir   string
 
-	SnapStateFile string
+	SnapStateFile         string
+	SnapChangeHistoryFile string
 
 	SnapBinariesDir     string
 	SnapServicesDir     string
@@ -124,6 +127,7 @@ func SetRootDir(rootdir string) {
 	SnapMountPolicyDir = filepath.Join(rootdir, snappyDir, "mount")
 	SnapMetaDir = filepath.Join(rootdir, snappyDir, "meta")
 	SnapBlobDir = filepath.Join(rootdir, snappyDir, "snaps")
+	SnapDownloadCacheDir = filepath.Join(rootdir, snappyDir, "cache")
 	SnapDesktopFilesDir = filepath.Join(rootdir, snappyDir, "desktop", "applications")
 	SnapRunNsDir = filepath.Join(rootdir, "/run/snapd/ns")
 
@@ -135,9 +139,11 @@ func SetRootDir(rootdir string) {
 	SnapAssertsSpoolDir = filepath.Join(rootdir, "run/snapd/auto-import")
 
 	SnapStateFile = filepath.Join(rootdir, snappyDir, "state.json")
+	SnapChangeHistoryFile = filepath.Join(rootdir, snappyDir, "change-history.json")
 
 	SnapSeedDir = filepath.Join(rootdir, snappyDir, "seed")
 	SnapDeviceDir = filepath.Join(rootdir, snappyDir, "device")
+	SnapRemoteDir = filepath.Join(rootdir, snappyDir, "remote")
 
 	SnapBinariesDir = filepath.Join(SnapMountDir, "bin")
 	SnapServicesDir = filepath.Join(rootdir, "/etc/systemd/system")
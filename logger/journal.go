@@ -0,0 +1,128 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// variable to allow mocking the journal socket in the tests
+var journalSocket = "/run/systemd/journal/socket"
+
+// journal priorities, as in syslog
+const (
+	journalPriorityNotice = 5
+	journalPriorityDebug  = 7
+)
+
+// JournalLog sends messages to the systemd journal using its native
+// protocol, with the fields of structured messages as journal fields
+// prefixed with SNAPD_ (so a "change-id" field can be matched with
+// "journalctl SNAPD_CHANGE_ID=42").
+type JournalLog struct {
+	conn       *net.UnixConn
+	identifier string
+}
+
+// NewJournalLog creates a JournalLog with the given syslog identifier.
+func NewJournalLog(identifier string) (*JournalLog, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &JournalLog{conn: conn, identifier: identifier}, nil
+}
+
+func journalIdentifier() string {
+	return filepath.Base(os.Args[0])
+}
+
+// Notice sends msg to the journal with the notice priority.
+func (l *JournalLog) Notice(msg string) {
+	l.send(journalPriorityNotice, msg, nil)
+}
+
+// Debug sends msg to the journal with the debug priority.
+func (l *JournalLog) Debug(msg string) {
+	l.send(journalPriorityDebug, msg, nil)
+}
+
+// Log sends msg to the journal along with fields.
+func (l *JournalLog) Log(level Level, msg string, fields Fields) {
+	priority := journalPriorityNotice
+	if level >= DebugLevel {
+		priority = journalPriorityDebug
+	}
+	l.send(priority, msg, fields)
+}
+
+// send must be called directly from the Logger methods, which in turn
+// are called directly from the package functions.
+func (l *JournalLog) send(priority int, msg string, fields Fields) {
+	var buf bytes.Buffer
+	writeJournalField(&buf, "MESSAGE", msg)
+	writeJournalField(&buf, "PRIORITY", strconv.Itoa(priority))
+	writeJournalField(&buf, "SYSLOG_IDENTIFIER", l.identifier)
+	if _, file, line, ok := runtime.Caller(3); ok {
+		writeJournalField(&buf, "CODE_FILE", file)
+		writeJournalField(&buf, "CODE_LINE", strconv.Itoa(line))
+	}
+	for k, v := range fields {
+		writeJournalField(&buf, journalFieldName(k), v)
+	}
+	// there is nowhere left to report failing to log to
+	l.conn.Write(buf.Bytes())
+}
+
+// journalFieldName returns the journal field name for the given key:
+// journal field names may only contain uppercase letters, digits and
+// underscores.
+func journalFieldName(key string) string {
+	name := []byte("SNAPD_" + strings.ToUpper(key))
+	for i, c := range name {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
+
+// writeJournalField writes a field in the format of the native journal
+// protocol, where values with newlines must be prefixed by their size.
+func writeJournalField(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	if strings.IndexByte(value, '\n') < 0 {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+	buf.WriteByte('\n')
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
@@ -0,0 +1,117 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package logger
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type journalSuite struct {
+	listener *net.UnixConn
+	restore  func()
+}
+
+var _ = Suite(&journalSuite{})
+
+func (s *journalSuite) SetUpTest(c *C) {
+	sock := filepath.Join(c.MkDir(), "socket")
+	l, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	c.Assert(err, IsNil)
+	s.listener = l
+
+	old := journalSocket
+	journalSocket = sock
+	s.restore = func() { journalSocket = old }
+}
+
+func (s *journalSuite) TearDownTest(c *C) {
+	SetLogger(NullLogger)
+	s.listener.Close()
+	s.restore()
+}
+
+func (s *journalSuite) read(c *C) string {
+	buf := make([]byte, 4096)
+	n, err := s.listener.Read(buf)
+	c.Assert(err, IsNil)
+	return string(buf[:n])
+}
+
+func (s *journalSuite) TestNotice(c *C) {
+	l, err := NewJournalLog("snapd")
+	c.Assert(err, IsNil)
+	SetLogger(l)
+
+	Noticef("xyzzy")
+	msg := s.read(c)
+	c.Check(msg, Matches, `(?s)MESSAGE=xyzzy\nPRIORITY=5\nSYSLOG_IDENTIFIER=snapd\nCODE_FILE=.*/journal_test\.go\nCODE_LINE=\d+\n`)
+}
+
+func (s *journalSuite) TestFields(c *C) {
+	l, err := NewJournalLog("snapd")
+	c.Assert(err, IsNil)
+	SetLogger(l)
+
+	WithFields(Fields{"change-id": "42"}).Debugf("multi\nline")
+	msg := s.read(c)
+	c.Check(msg, Matches, "(?s)MESSAGE\n\x0a\x00\x00\x00\x00\x00\x00\x00multi\nline\nPRIORITY=7\n.*CODE_FILE=.*/journal_test\\.go\n.*SNAPD_CHANGE_ID=42\n")
+}
+
+func (s *journalSuite) TestDebugLevel(c *C) {
+	l, err := NewJournalLog("snapd")
+	c.Assert(err, IsNil)
+	SetLogger(l)
+
+	// debug messages go to the journal by default
+	Debugf("xyzzy")
+	c.Check(s.read(c), Matches, `(?s)MESSAGE=xyzzy\nPRIORITY=7\n.*`)
+
+	// but not at notice level
+	SetLevel(NoticeLevel)
+	defer SetLevel(DefaultLevel)
+
+	Debugf("xyzzy")
+	WithFields(Fields{"change-id": "42"}).Debugf("xyzzy")
+	Noticef("plugh")
+	c.Check(s.read(c), Matches, `(?s)MESSAGE=plugh\nPRIORITY=5\n.*`)
+
+	os.Setenv("SNAPD_DEBUG", "1")
+	defer os.Unsetenv("SNAPD_DEBUG")
+
+	Debugf("xyzzy")
+	c.Check(s.read(c), Matches, `(?s)MESSAGE=xyzzy\nPRIORITY=7\n.*`)
+}
+
+func (s *journalSuite) TestDaemonSetup(c *C) {
+	os.Setenv("JOURNAL_STREAM", "1:2")
+	defer os.Unsetenv("JOURNAL_STREAM")
+
+	c.Assert(DaemonSetup(), IsNil)
+	c.Check(logger, FitsTypeOf, &JournalLog{})
+}
+
+func (s *journalSuite) TestFieldName(c *C) {
+	c.Check(journalFieldName("change-id"), Equals, "SNAPD_CHANGE_ID")
+	c.Check(journalFieldName("snap"), Equals, "SNAPD_SNAP")
+}
@@ -26,6 +26,8 @@ import (
 	"log"
 	"log/syslog"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/snapcore/snapd/osutil"
@@ -48,6 +50,74 @@ const (
 	SyslogPriority = syslog.LOG_DEBUG | syslog.LOG_USER
 )
 
+// A StructuredLogger is a Logger that can also record key/value fields
+// alongside a message, in a way that allows them to be queried.
+type StructuredLogger interface {
+	Logger
+	// Log records msg at the given level together with fields
+	Log(level Level, msg string, fields Fields)
+}
+
+// Level is the verbosity of a log message. Messages above the current
+// level (see SetLevel) are dropped.
+type Level int
+
+const (
+	// NoticeLevel is for messages that the user should see
+	NoticeLevel Level = iota
+	// DebugLevel is for messages useful when debugging something
+	DebugLevel
+)
+
+// DefaultLevel is the level used until SetLevel is called. Debug
+// messages go to syslog or the journal by default, and to the console
+// only if SNAPD_DEBUG is set. At NoticeLevel they are dropped unless
+// SNAPD_DEBUG is set.
+const DefaultLevel = DebugLevel
+
+func (l Level) String() string {
+	switch l {
+	case NoticeLevel:
+		return "notice"
+	case DebugLevel:
+		return "debug"
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// ParseLevel returns the level with the given name.
+func ParseLevel(s string) (Level, error) {
+	switch s {
+	case "notice":
+		return NoticeLevel, nil
+	case "debug":
+		return DebugLevel, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q, expected one of: notice, debug", s)
+}
+
+// Fields are key/value pairs that give the context of a log message,
+// such as the change, task or snap it is about.
+type Fields map[string]string
+
+// String returns the fields sorted by key, formatted to be appended to
+// a log message, or "" if there are none.
+func (f Fields) String() string {
+	if len(f) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + f[k]
+	}
+	return " (" + strings.Join(pairs, " ") + ")"
+}
+
 type nullLogger struct{}
 
 func (nullLogger) Notice(string) {}
@@ -58,6 +128,7 @@ var NullLogger = nullLogger{}
 
 var (
 	logger Logger = NullLogger
+	level         = DefaultLevel
 	lock   sync.Mutex
 )
 
@@ -89,9 +160,91 @@ func Debugf(format string, v ...interface{}) {
 	lock.Lock()
 	defer lock.Unlock()
 
+	if !debugEnabled() {
+		return
+	}
 	logger.Debug(msg)
 }
 
+// An Entry is a set of fields to log messages with.
+type Entry struct {
+	fields Fields
+}
+
+// WithFields returns an Entry to log messages with the given fields.
+func WithFields(fields Fields) *Entry {
+	return &Entry{fields: fields}
+}
+
+// WithFields returns an Entry with both the fields of e and the given
+// ones, the latter taking precedence.
+func (e *Entry) WithFields(fields Fields) *Entry {
+	merged := make(Fields, len(e.fields)+len(fields))
+	for k, v := range e.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Entry{fields: merged}
+}
+
+// Noticef notifies the user of something, with the fields of e.
+func (e *Entry) Noticef(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	// the logger must be called from here directly so that it can
+	// find where the message comes from
+	if sl, ok := logger.(StructuredLogger); ok {
+		sl.Log(NoticeLevel, msg, e.fields)
+	} else {
+		logger.Notice(msg + e.fields.String())
+	}
+}
+
+// Debugf records something in the debug log, with the fields of e.
+func (e *Entry) Debugf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if !debugEnabled() {
+		return
+	}
+	if sl, ok := logger.(StructuredLogger); ok {
+		sl.Log(DebugLevel, msg, e.fields)
+	} else {
+		logger.Debug(msg + e.fields.String())
+	}
+}
+
+// debugEnabled returns whether debug messages are to be logged, either
+// because of the level or because SNAPD_DEBUG is set. It must be called
+// with the lock held.
+func debugEnabled() bool {
+	return level >= DebugLevel || osutil.GetenvBool("SNAPD_DEBUG")
+}
+
+// SetLevel sets the level above which messages are dropped.
+func SetLevel(l Level) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	level = l
+}
+
+// GetLevel returns the level above which messages are dropped.
+func GetLevel() Level {
+	lock.Lock()
+	defer lock.Unlock()
+
+	return level
+}
+
 // SetLogger sets the global logger to the given one
 func SetLogger(l Logger) {
 	lock.Lock()
@@ -156,3 +309,17 @@ func SimpleSetup() error {
 
 	return nil
 }
+
+// DaemonSetup creates the logger for a daemon: when running under
+// systemd it logs to the journal natively, so that fields can be
+// queried, otherwise it falls back to the default (console) logger.
+func DaemonSetup() error {
+	if os.Getenv("JOURNAL_STREAM") != "" {
+		l, err := NewJournalLog(journalIdentifier())
+		if err == nil {
+			SetLogger(l)
+			return nil
+		}
+	}
+	return SimpleSetup()
+}
@@ -150,3 +150,63 @@ func (s *LogSuite) TestSyslogFails(c *C) {
 	c.Check(logbuf.String(), Matches, `(?m).*logger_test\.go:\d+: I do not want to crash`)
 
 }
+
+func (s *LogSuite) TestWithFields(c *C) {
+	var logbuf bytes.Buffer
+	l, err := NewConsoleLog(&logbuf, DefaultFlags)
+	c.Assert(err, IsNil)
+
+	SetLogger(l)
+
+	e := WithFields(Fields{"task-id": "2", "change-id": "1"})
+	e.Noticef("xyzzy")
+	c.Check(logbuf.String(), Matches, `(?m).*logger_test\.go:\d+: xyzzy \(change-id=1 task-id=2\)`)
+
+	e.WithFields(Fields{"snap": "foo", "task-id": "3"}).Debugf("plugh")
+	c.Check(s.sysbuf.String(), Matches, `(?ms).*logger_test\.go:\d+: DEBUG: plugh \(change-id=1 snap=foo task-id=3\)`)
+}
+
+func (s *LogSuite) TestLevel(c *C) {
+	defer SetLevel(DefaultLevel)
+
+	var logbuf bytes.Buffer
+	l, err := NewConsoleLog(&logbuf, DefaultFlags)
+	c.Assert(err, IsNil)
+
+	SetLogger(l)
+
+	c.Check(GetLevel(), Equals, DebugLevel)
+	SetLevel(NoticeLevel)
+	c.Check(GetLevel(), Equals, NoticeLevel)
+
+	Debugf("xyzzy")
+	WithFields(Fields{"a": "b"}).Debugf("xyzzy")
+	c.Check(s.sysbuf.String(), Equals, "")
+
+	os.Setenv("SNAPD_DEBUG", "1")
+	Debugf("xyzzy")
+	WithFields(Fields{"a": "b"}).Debugf("xyzzy")
+	os.Unsetenv("SNAPD_DEBUG")
+	c.Check(s.sysbuf.String(), Matches, `(?ms).*DEBUG: xyzzy\n.*DEBUG: xyzzy \(a=b\)\n`)
+	logbuf.Reset()
+
+	Noticef("plugh")
+	c.Check(logbuf.String(), Matches, `(?m).*logger_test\.go:\d+: plugh`)
+}
+
+func (s *LogSuite) TestParseLevel(c *C) {
+	for _, l := range []Level{NoticeLevel, DebugLevel} {
+		parsed, err := ParseLevel(l.String())
+		c.Check(err, IsNil)
+		c.Check(parsed, Equals, l)
+	}
+	_, err := ParseLevel("loud")
+	c.Check(err, ErrorMatches, `unknown log level "loud", expected one of: notice, debug`)
+}
+
+func (s *LogSuite) TestDaemonSetupWithoutJournal(c *C) {
+	os.Unsetenv("JOURNAL_STREAM")
+
+	c.Assert(DaemonSetup(), IsNil)
+	c.Check(logger, FitsTypeOf, &ConsoleLog{})
+}
@@ -0,0 +1,335 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package metrics keeps counters, gauges and histograms about the
+// internals of snapd and writes them out in the Prometheus text
+// exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds of the histogram buckets suited
+// to durations in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// Registry holds a set of metrics to write out together.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// Default is the registry of the metrics created with the package
+// level functions.
+var Default = NewRegistry()
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic(fmt.Sprintf("internal error: metric %q registered twice", name))
+	}
+	r.metrics[name] = m
+}
+
+// WriteText writes all the metrics of the registry, sorted by name, in
+// the Prometheus text format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, len(names))
+	for i, name := range names {
+		metrics[i] = r.metrics[name]
+	}
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// WriteText writes the metrics of the default registry.
+func WriteText(w io.Writer) error {
+	return Default.WriteText(w)
+}
+
+// desc is what all metrics have in common: their name, help text, the
+// names of their labels and, for each combination of label values, a
+// series of values.
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]interface{}
+}
+
+// key returns the key of the series with the given label values.
+func (d *desc) key(labelValues []string) string {
+	if len(labelValues) != len(d.labels) {
+		panic(fmt.Sprintf("internal error: metric %q takes %d label values, got %d", d.name, len(d.labels), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+// get returns the series for the given label values, creating it with
+// mk if needed. It must be called with d.mu held.
+func (d *desc) get(labelValues []string, mk func() interface{}) interface{} {
+	k := d.key(labelValues)
+	s, ok := d.series[k]
+	if !ok {
+		s = mk()
+		d.series[k] = s
+	}
+	return s
+}
+
+// each calls f with the label values and value of every series, sorted
+// by label values. It must be called with d.mu held.
+func (d *desc) each(f func(labelValues []string, s interface{})) {
+	keys := make([]string, 0, len(d.series))
+	for k := range d.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var labelValues []string
+		if len(d.labels) > 0 {
+			labelValues = strings.Split(k, "\xff")
+		}
+		f(labelValues, d.series[k])
+	}
+}
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.typ)
+}
+
+func newDesc(name, help, typ string, labels []string) *desc {
+	return &desc{
+		name:   name,
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		series: make(map[string]interface{}),
+	}
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+// formatLabels returns the label pairs as written in braces after the
+// metric name, with an extra pair appended if given.
+func formatLabels(names, values []string, extra ...string) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelValueEscaper.Replace(values[i])))
+	}
+	if len(extra) == 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[0], extra[1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// CounterVec is a counter partitioned by a set of labels.
+type CounterVec struct {
+	*desc
+}
+
+// NewCounterVec registers and returns a new counter with the given
+// label names.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newDesc(name, help, "counter", labels)}
+	r.register(name, c)
+	return c
+}
+
+// NewCounterVec registers with the default registry and returns a new
+// counter with the given label names.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+// Add adds v, which must not be negative, to the counter for the given
+// label values.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("internal error: cannot decrease counter %q", c.name))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := c.get(labelValues, func() interface{} { return new(float64) }).(*float64)
+	*p += v
+}
+
+// Inc adds one to the counter for the given label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.each(func(labelValues []string, s interface{}) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, labelValues), formatValue(*s.(*float64)))
+	})
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	*desc
+}
+
+// NewGauge registers and returns a new gauge.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{newDesc(name, help, "gauge", nil)}
+	r.register(name, g)
+	return g
+}
+
+// NewGauge registers with the default registry and returns a new gauge.
+func NewGauge(name, help string) *Gauge {
+	return Default.NewGauge(name, help)
+}
+
+// Set sets the value of the gauge.
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p := g.get(nil, func() interface{} { return new(float64) }).(*float64)
+	*p = v
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.each(func(labelValues []string, s interface{}) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labels, labelValues), formatValue(*s.(*float64)))
+	})
+}
+
+// HistogramVec counts observations in buckets, partitioned by a set of
+// labels.
+type HistogramVec struct {
+	*desc
+	buckets []float64
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec registers and returns a new histogram with the given
+// bucket upper bounds, in increasing order, and label names.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("internal error: buckets of histogram %q are not sorted", name))
+	}
+	h := &HistogramVec{newDesc(name, help, "histogram", labels), buckets}
+	r.register(name, h)
+	return h
+}
+
+// NewHistogramVec registers with the default registry and returns a new
+// histogram with the given bucket upper bounds and label names.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+// Observe adds an observation of v to the histogram for the given label
+// values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(labelValues, func() interface{} {
+		return &histogram{counts: make([]uint64, len(h.buckets))}
+	}).(*histogram)
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.each(func(labelValues []string, series interface{}) {
+		s := series.(*histogram)
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, labelValues, "le", formatValue(upper)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, labelValues, "le", "+Inf"), s.count)
+		labels := formatLabels(h.labels, labelValues)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels, formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, s.count)
+	})
+}
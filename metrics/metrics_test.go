@@ -0,0 +1,117 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package metrics_test
+
+import (
+	"bytes"
+	"testing"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/metrics"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type metricsSuite struct{}
+
+var _ = check.Suite(&metricsSuite{})
+
+func (s *metricsSuite) TestCounter(c *check.C) {
+	r := metrics.NewRegistry()
+	counter := r.NewCounterVec("snapd_foo_total", "Number of foos.", "kind", "code")
+	counter.Inc("b", "200")
+	counter.Add(2, "a", "500")
+	counter.Inc("b", "200")
+	counter.Inc("a", `with "quotes"`)
+
+	var buf bytes.Buffer
+	c.Assert(r.WriteText(&buf), check.IsNil)
+	c.Check(buf.String(), check.Equals, `# HELP snapd_foo_total Number of foos.
+# TYPE snapd_foo_total counter
+snapd_foo_total{kind="a",code="500"} 2
+snapd_foo_total{kind="a",code="with \"quotes\""} 1
+snapd_foo_total{kind="b",code="200"} 2
+`)
+}
+
+func (s *metricsSuite) TestCounterWrongLabels(c *check.C) {
+	r := metrics.NewRegistry()
+	counter := r.NewCounterVec("snapd_foo_total", "Number of foos.", "kind")
+	c.Check(func() { counter.Inc() }, check.PanicMatches, `internal error: metric "snapd_foo_total" takes 1 label values, got 0`)
+	c.Check(func() { counter.Add(-1, "a") }, check.PanicMatches, `internal error: cannot decrease counter "snapd_foo_total"`)
+}
+
+func (s *metricsSuite) TestGauge(c *check.C) {
+	r := metrics.NewRegistry()
+	g := r.NewGauge("snapd_bar_bytes", "Size of the bar.\nIn bytes.")
+
+	var buf bytes.Buffer
+	c.Assert(r.WriteText(&buf), check.IsNil)
+	c.Check(buf.String(), check.Equals, `# HELP snapd_bar_bytes Size of the bar.\nIn bytes.
+# TYPE snapd_bar_bytes gauge
+`)
+
+	g.Set(10)
+	g.Set(1.5e9)
+	buf.Reset()
+	c.Assert(r.WriteText(&buf), check.IsNil)
+	c.Check(buf.String(), check.Equals, `# HELP snapd_bar_bytes Size of the bar.\nIn bytes.
+# TYPE snapd_bar_bytes gauge
+snapd_bar_bytes 1.5e+09
+`)
+}
+
+func (s *metricsSuite) TestHistogram(c *check.C) {
+	r := metrics.NewRegistry()
+	h := r.NewHistogramVec("snapd_baz_seconds", "How long baz takes.", []float64{0.1, 1}, "kind")
+	h.Observe(0.05, "x")
+	h.Observe(0.5, "x")
+	h.Observe(5, "x")
+
+	var buf bytes.Buffer
+	c.Assert(r.WriteText(&buf), check.IsNil)
+	c.Check(buf.String(), check.Equals, `# HELP snapd_baz_seconds How long baz takes.
+# TYPE snapd_baz_seconds histogram
+snapd_baz_seconds_bucket{kind="x",le="0.1"} 1
+snapd_baz_seconds_bucket{kind="x",le="1"} 2
+snapd_baz_seconds_bucket{kind="x",le="+Inf"} 3
+snapd_baz_seconds_sum{kind="x"} 5.55
+snapd_baz_seconds_count{kind="x"} 3
+`)
+}
+
+func (s *metricsSuite) TestRegistrySortsAndRejectsDuplicates(c *check.C) {
+	r := metrics.NewRegistry()
+	r.NewGauge("snapd_z", "Z.").Set(1)
+	r.NewCounterVec("snapd_a_total", "A.").Inc()
+
+	var buf bytes.Buffer
+	c.Assert(r.WriteText(&buf), check.IsNil)
+	c.Check(buf.String(), check.Equals, `# HELP snapd_a_total A.
+# TYPE snapd_a_total counter
+snapd_a_total 1
+# HELP snapd_z Z.
+# TYPE snapd_z gauge
+snapd_z 1
+`)
+
+	c.Check(func() { r.NewGauge("snapd_z", "Z again.") }, check.PanicMatches, `internal error: metric "snapd_z" registered twice`)
+}
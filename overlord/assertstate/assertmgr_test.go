@@ -95,7 +95,7 @@ func (sto *fakeStore) ListRefresh([]*store.RefreshCandidate, *auth.UserState) ([
 	panic("fakeStore.ListRefresh not expected")
 }
 
-func (sto *fakeStore) Download(context.Context, string, string, *snap.DownloadInfo, progress.Meter, *auth.UserState) error {
+func (sto *fakeStore) Download(context.Context, string, string, *snap.DownloadInfo, progress.Meter, *auth.UserState, *store.DownloadOptions) error {
 	panic("fakeStore.Download not expected")
 }
 
@@ -115,6 +115,10 @@ func (sto *fakeStore) Sections(*auth.UserState) ([]string, error) {
 	panic("fakeStore.Sections not expected")
 }
 
+func (sto *fakeStore) ConnectivityCheck() ([]*store.ConnectivityStatus, error) {
+	panic("fakeStore.ConnectivityCheck not expected")
+}
+
 func (s *assertMgrSuite) SetUpTest(c *C) {
 	dirs.SetRootDir(c.MkDir())
 
@@ -62,6 +62,51 @@ type UserState struct {
 	Discharges      []string `json:"discharges,omitempty"`
 	StoreMacaroon   string   `json:"store-macaroon,omitempty"`
 	StoreDischarges []string `json:"store-discharges,omitempty"`
+	// Roles limit what the user can do through the API; users
+	// without any roles predate them and can do anything
+	Roles []Role `json:"roles,omitempty"`
+}
+
+// Role is a set of permissions of users on the API.
+type Role string
+
+const (
+	// RoleViewer can only look at the system
+	RoleViewer Role = "viewer"
+	// RoleOperator can in addition operate the system, e.g. abort
+	// changes or enable and disable snaps, but not modify it
+	RoleOperator Role = "operator"
+	// RoleAdmin can do anything
+	RoleAdmin Role = "admin"
+)
+
+// roleLevels orders the roles, each role can do all that the lower
+// ones can
+var roleLevels = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// ValidRole returns an error if role is not known.
+func ValidRole(role Role) error {
+	if roleLevels[role] == 0 {
+		return fmt.Errorf("invalid role %q", role)
+	}
+	return nil
+}
+
+// HasRole returns whether the user has role, or a role that includes it.
+func (u *UserState) HasRole(role Role) bool {
+	if len(u.Roles) == 0 {
+		return true
+	}
+	for _, r := range u.Roles {
+		if roleLevels[r] >= roleLevels[role] {
+			return true
+		}
+	}
+	return false
 }
 
 // MacaroonSerialize returns a store-compatible serialized representation of the given macaroon
@@ -235,6 +280,50 @@ func UpdateUser(st *state.State, user *UserState) error {
 	return fmt.Errorf("invalid user")
 }
 
+// GrantRole gives the role to the user with the given ID.
+func GrantRole(st *state.State, userID int, role Role) error {
+	if err := ValidRole(role); err != nil {
+		return err
+	}
+	user, err := User(st, userID)
+	if err != nil {
+		return err
+	}
+	for _, r := range user.Roles {
+		if r == role {
+			return nil
+		}
+	}
+	user.Roles = append(user.Roles, role)
+	return UpdateUser(st, user)
+}
+
+// RevokeRole takes the role away from the user with the given ID. A
+// user needs to keep at least one role, or it could do anything.
+func RevokeRole(st *state.State, userID int, role Role) error {
+	if err := ValidRole(role); err != nil {
+		return err
+	}
+	user, err := User(st, userID)
+	if err != nil {
+		return err
+	}
+	roles := make([]Role, 0, len(user.Roles))
+	for _, r := range user.Roles {
+		if r != role {
+			roles = append(roles, r)
+		}
+	}
+	if len(roles) == len(user.Roles) {
+		return fmt.Errorf("user does not have role %q", role)
+	}
+	if len(roles) == 0 {
+		return fmt.Errorf("cannot revoke the last role of a user")
+	}
+	user.Roles = roles
+	return UpdateUser(st, user)
+}
+
 // Device returns the device details from the state.
 func Device(st *state.State) (*DeviceState, error) {
 	var authStateData AuthState
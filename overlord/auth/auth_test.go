@@ -311,6 +311,49 @@ func (as *authSuite) TestUpdateUser(c *C) {
 	c.Check(userFromState, DeepEquals, user)
 }
 
+func (as *authSuite) TestHasRole(c *C) {
+	// users from before roles can do anything
+	user := &auth.UserState{}
+	c.Check(user.HasRole(auth.RoleAdmin), Equals, true)
+
+	user.Roles = []auth.Role{auth.RoleOperator}
+	c.Check(user.HasRole(auth.RoleViewer), Equals, true)
+	c.Check(user.HasRole(auth.RoleOperator), Equals, true)
+	c.Check(user.HasRole(auth.RoleAdmin), Equals, false)
+
+	user.Roles = []auth.Role{auth.RoleViewer}
+	c.Check(user.HasRole(auth.RoleViewer), Equals, true)
+	c.Check(user.HasRole(auth.RoleOperator), Equals, false)
+
+	user.Roles = []auth.Role{"potato"}
+	c.Check(user.HasRole(auth.RoleViewer), Equals, false)
+}
+
+func (as *authSuite) TestGrantRevokeRole(c *C) {
+	as.state.Lock()
+	defer as.state.Unlock()
+	user, err := auth.NewUser(as.state, "username", "email@test.com", "macaroon", []string{"discharge"})
+	c.Assert(err, IsNil)
+
+	c.Assert(auth.GrantRole(as.state, user.ID, auth.RoleViewer), IsNil)
+	c.Assert(auth.GrantRole(as.state, user.ID, auth.RoleOperator), IsNil)
+	// granting twice is fine
+	c.Assert(auth.GrantRole(as.state, user.ID, auth.RoleViewer), IsNil)
+	user, err = auth.User(as.state, user.ID)
+	c.Assert(err, IsNil)
+	c.Check(user.Roles, DeepEquals, []auth.Role{auth.RoleViewer, auth.RoleOperator})
+
+	c.Assert(auth.RevokeRole(as.state, user.ID, auth.RoleOperator), IsNil)
+	user, err = auth.User(as.state, user.ID)
+	c.Assert(err, IsNil)
+	c.Check(user.Roles, DeepEquals, []auth.Role{auth.RoleViewer})
+
+	c.Check(auth.RevokeRole(as.state, user.ID, auth.RoleOperator), ErrorMatches, `user does not have role "operator"`)
+	c.Check(auth.RevokeRole(as.state, user.ID, auth.RoleViewer), ErrorMatches, `cannot revoke the last role of a user`)
+	c.Check(auth.GrantRole(as.state, user.ID, "potato"), ErrorMatches, `invalid role "potato"`)
+	c.Check(auth.GrantRole(as.state, 42, auth.RoleAdmin), ErrorMatches, `invalid user`)
+}
+
 func (as *authSuite) TestUpdateUserInvalid(c *C) {
 	as.state.Lock()
 	_, _ = auth.NewUser(as.state, "username", "email@test.com", "macaroon", []string{"discharge"})
@@ -22,18 +22,21 @@ package overlord
 import (
 	"time"
 
-	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/overlord/state"
 )
 
 type overlordStateBackend struct {
-	path           string
+	journal        *state.Journal
 	ensureBefore   func(d time.Duration)
 	requestRestart func(t state.RestartType)
 }
 
 func (osb *overlordStateBackend) Checkpoint(data []byte) error {
-	return osutil.AtomicWriteFile(osb.path, data, 0600, 0)
+	return osb.journal.Checkpoint(data)
+}
+
+func (osb *overlordStateBackend) CheckpointDelta(delta *state.Delta) error {
+	return osb.journal.CheckpointDelta(delta)
 }
 
 func (osb *overlordStateBackend) EnsureBefore(d time.Duration) {
@@ -0,0 +1,292 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package changehistory keeps an append-only record of the changes
+// pruned from the state, so that what was done on the system can be
+// audited long after the changes themselves are gone.
+package changehistory
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+var (
+	// maxSize is the size past which the history file is rotated
+	maxSize int64 = 1024 * 1024
+	// retention is for how long rotated history files are kept
+	retention = 90 * 24 * time.Hour
+
+	timeNow = time.Now
+)
+
+// rotatedTimeFormat is the format of the rotation time appended to the
+// name of rotated history files, sorting them chronologically.
+const rotatedTimeFormat = "20060102T150405.000000000Z"
+
+// TaskLog holds the error messages logged by a task of a change.
+type TaskLog struct {
+	Kind    string   `json:"kind"`
+	Summary string   `json:"summary"`
+	Status  string   `json:"status"`
+	Log     []string `json:"log"`
+}
+
+// Entry is the record of a change kept in the history.
+type Entry struct {
+	ID      string `json:"id"`
+	Kind    string `json:"kind"`
+	Summary string `json:"summary"`
+	Status  string `json:"status"`
+
+	// UserID is the id of the snapd user that requested the change, if any
+	UserID int `json:"user-id,omitempty"`
+	// UID is the system user that requested the change, if known
+	UID *uint32 `json:"uid,omitempty"`
+
+	SnapNames []string `json:"snap-names,omitempty"`
+
+	SpawnTime time.Time  `json:"spawn-time"`
+	ReadyTime *time.Time `json:"ready-time,omitempty"`
+
+	TaskErrors []*TaskLog `json:"task-errors,omitempty"`
+}
+
+// NewEntry returns the history entry for the change.
+//
+// The state must be locked.
+func NewEntry(chg *state.Change) *Entry {
+	e := &Entry{
+		ID:        chg.ID(),
+		Kind:      chg.Kind(),
+		Summary:   chg.Summary(),
+		Status:    chg.Status().String(),
+		SpawnTime: chg.SpawnTime(),
+	}
+	if readyTime := chg.ReadyTime(); !readyTime.IsZero() {
+		e.ReadyTime = &readyTime
+	}
+
+	// these are all optional
+	chg.Get("user-id", &e.UserID)
+	var uid uint32
+	if chg.Get("uid", &uid) == nil {
+		e.UID = &uid
+	}
+	chg.Get("snap-names", &e.SnapNames)
+
+	for _, t := range chg.Tasks() {
+		var errs []string
+		for _, msg := range t.Log() {
+			if strings.Contains(msg, " "+state.LogError+" ") {
+				errs = append(errs, msg)
+			}
+		}
+		if len(errs) == 0 {
+			continue
+		}
+		e.TaskErrors = append(e.TaskErrors, &TaskLog{
+			Kind:    t.Kind(),
+			Summary: t.Summary(),
+			Status:  t.Status().String(),
+			Log:     errs,
+		})
+	}
+	return e
+}
+
+func (e *Entry) hasSnap(name string) bool {
+	for _, snapName := range e.SnapNames {
+		if snapName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// History is the record of the changes pruned from the state, kept in
+// a file with one JSON entry per line that gets rotated once it grows
+// past a size, keeping the rotated files for 90 days.
+type History struct {
+	mu   sync.Mutex
+	path string
+}
+
+// New returns the history kept in the file at path.
+func New(path string) *History {
+	return &History{path: path}
+}
+
+// Archive appends to the history the entries for the changes. It is
+// meant to be set with State.SetChangeArchiver, and so is called with
+// the state locked.
+func (h *History) Archive(chgs []*state.Change) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, chg := range chgs {
+		if err := enc.Encode(NewEntry(chg)); err != nil {
+			return err
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.rotate(int64(buf.Len())); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// rotate moves the history file aside if appending size bytes to it
+// would take it past maxSize, and removes the rotated files older
+// than the retention period.
+func (h *History) rotate(size int64) error {
+	now := timeNow().UTC()
+	fi, err := os.Stat(h.path)
+	if err == nil && fi.Size() > 0 && fi.Size()+size > maxSize {
+		rotated := h.path + "." + now.Format(rotatedTimeFormat)
+		if err := os.Rename(h.path, rotated); err != nil {
+			return err
+		}
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	rotated, err := h.rotatedFiles()
+	if err != nil {
+		return err
+	}
+	for _, fn := range rotated {
+		when, err := time.Parse(rotatedTimeFormat, strings.TrimPrefix(fn, h.path+"."))
+		if err != nil {
+			continue
+		}
+		if when.Add(retention).Before(now) {
+			if err := os.Remove(fn); err != nil {
+				logger.Noticef("Cannot remove expired change history %q: %v", fn, err)
+			}
+		}
+	}
+	return nil
+}
+
+// rotatedFiles returns the rotated history files, oldest first.
+func (h *History) rotatedFiles() ([]string, error) {
+	matches, err := filepath.Glob(h.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// Options are the filters for the entries returned by Entries.
+type Options struct {
+	// Since and Until, if set, limit the entries to the changes
+	// spawned in between them
+	Since time.Time
+	Until time.Time
+	// SnapName, if set, limits the entries to the changes affecting
+	// that snap
+	SnapName string
+}
+
+func (opts *Options) match(e *Entry) bool {
+	if !opts.Since.IsZero() && e.SpawnTime.Before(opts.Since) {
+		return false
+	}
+	if !opts.Until.IsZero() && !e.SpawnTime.Before(opts.Until) {
+		return false
+	}
+	if opts.SnapName != "" && !e.hasSnap(opts.SnapName) {
+		return false
+	}
+	return true
+}
+
+// Entries returns the entries of the history matching opts, oldest
+// first.
+func (h *History) Entries(opts *Options) ([]*Entry, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fns, err := h.rotatedFiles()
+	if err != nil {
+		return nil, err
+	}
+	fns = append(fns, h.path)
+
+	var entries []*Entry
+	for _, fn := range fns {
+		f, err := os.Open(fn)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries, err = readEntries(f, opts, entries)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot read change history %q: %v", fn, err)
+		}
+	}
+	return entries, nil
+}
+
+func readEntries(f *os.File, opts *Options, entries []*Entry) ([]*Entry, error) {
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// an entry torn by a crash, skip it
+			logger.Debugf("Skipping invalid change history entry in %q: %v", f.Name(), err)
+			continue
+		}
+		if opts.match(&e) {
+			entries = append(entries, &e)
+		}
+	}
+	return entries, scanner.Err()
+}
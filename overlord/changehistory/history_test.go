@@ -0,0 +1,208 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package changehistory_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/changehistory"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type historySuite struct {
+	path string
+	st   *state.State
+	h    *changehistory.History
+}
+
+var _ = Suite(&historySuite{})
+
+func (s *historySuite) SetUpTest(c *C) {
+	s.path = filepath.Join(c.MkDir(), "change-history.json")
+	s.st = state.New(nil)
+	s.h = changehistory.New(s.path)
+}
+
+func (s *historySuite) newChange(kind string, spawn time.Time, snapNames ...string) *state.Change {
+	restore := state.MockTime(spawn)
+	defer restore()
+	chg := s.st.NewChange(kind, kind+" snaps")
+	if len(snapNames) > 0 {
+		chg.Set("snap-names", snapNames)
+	}
+	t := s.st.NewTask("foo", "foo the snaps")
+	chg.AddTask(t)
+	t.SetStatus(state.DoneStatus)
+	return chg
+}
+
+func (s *historySuite) TestNewEntry(c *C) {
+	s.st.Lock()
+	defer s.st.Unlock()
+
+	t0 := time.Date(2017, 4, 21, 1, 2, 3, 0, time.UTC)
+	restore := state.MockTime(t0)
+	defer restore()
+
+	chg := s.st.NewChange("install-snap", "Install snap \"foo\"")
+	chg.Set("snap-names", []string{"foo"})
+	chg.Set("user-id", 42)
+	chg.Set("uid", 1000)
+	t1 := s.st.NewTask("download", "Download foo")
+	t2 := s.st.NewTask("link", "Link foo")
+	chg.AddTask(t1)
+	chg.AddTask(t2)
+	t1.Logf("downloading")
+	t1.SetStatus(state.DoneStatus)
+	t2.Errorf("cannot link")
+	t2.SetStatus(state.ErrorStatus)
+
+	uid := uint32(1000)
+	e := changehistory.NewEntry(chg)
+	c.Check(e, DeepEquals, &changehistory.Entry{
+		ID:        chg.ID(),
+		Kind:      "install-snap",
+		Summary:   `Install snap "foo"`,
+		Status:    "Error",
+		UserID:    42,
+		UID:       &uid,
+		SnapNames: []string{"foo"},
+		SpawnTime: t0,
+		ReadyTime: &t0,
+		TaskErrors: []*changehistory.TaskLog{{
+			Kind:    "link",
+			Summary: "Link foo",
+			Status:  "Error",
+			Log:     []string{"2017-04-21T01:02:03Z ERROR cannot link"},
+		}},
+	})
+}
+
+func (s *historySuite) TestArchiveAndEntries(c *C) {
+	s.st.Lock()
+	defer s.st.Unlock()
+
+	t0 := time.Date(2017, 4, 21, 1, 2, 3, 0, time.UTC)
+	chg1 := s.newChange("install", t0, "foo")
+	chg2 := s.newChange("remove", t0.Add(24*time.Hour), "bar")
+	chg3 := s.newChange("refresh", t0.Add(48*time.Hour), "foo", "bar")
+
+	c.Assert(s.h.Archive([]*state.Change{chg1, chg2}), IsNil)
+	c.Assert(s.h.Archive([]*state.Change{chg3}), IsNil)
+
+	fi, err := os.Stat(s.path)
+	c.Assert(err, IsNil)
+	c.Check(fi.Mode().Perm(), Equals, os.FileMode(0600))
+
+	kinds := func(opts *changehistory.Options) []string {
+		entries, err := s.h.Entries(opts)
+		c.Assert(err, IsNil)
+		var kinds []string
+		for _, e := range entries {
+			kinds = append(kinds, e.Kind)
+		}
+		return kinds
+	}
+
+	c.Check(kinds(nil), DeepEquals, []string{"install", "remove", "refresh"})
+	c.Check(kinds(&changehistory.Options{Since: t0.Add(time.Hour)}), DeepEquals, []string{"remove", "refresh"})
+	c.Check(kinds(&changehistory.Options{Until: t0.Add(48 * time.Hour)}), DeepEquals, []string{"install", "remove"})
+	c.Check(kinds(&changehistory.Options{Since: t0.Add(time.Hour), Until: t0.Add(48 * time.Hour)}), DeepEquals, []string{"remove"})
+	c.Check(kinds(&changehistory.Options{SnapName: "foo"}), DeepEquals, []string{"install", "refresh"})
+}
+
+func (s *historySuite) TestEntriesNoHistory(c *C) {
+	entries, err := s.h.Entries(nil)
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 0)
+}
+
+func (s *historySuite) TestEntriesSkipsTornEntry(c *C) {
+	s.st.Lock()
+	chg := s.newChange("install", time.Now(), "foo")
+	c.Assert(s.h.Archive([]*state.Change{chg}), IsNil)
+	s.st.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0600)
+	c.Assert(err, IsNil)
+	_, err = f.Write([]byte(`{"id":"2","ki`))
+	c.Assert(err, IsNil)
+	f.Close()
+
+	entries, err := s.h.Entries(nil)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+	c.Check(entries[0].Kind, Equals, "install")
+}
+
+func (s *historySuite) TestRotation(c *C) {
+	restore := changehistory.MockMaxSize(1)
+	defer restore()
+
+	now := time.Date(2017, 4, 21, 1, 2, 3, 0, time.UTC)
+	restore = changehistory.MockTimeNow(func() time.Time { return now })
+	defer restore()
+
+	s.st.Lock()
+	defer s.st.Unlock()
+
+	for i := 0; i < 3; i++ {
+		chg := s.newChange(fmt.Sprintf("chg%d", i), now, "foo")
+		c.Assert(s.h.Archive([]*state.Change{chg}), IsNil)
+		now = now.Add(30 * 24 * time.Hour)
+	}
+
+	rotated, err := filepath.Glob(s.path + ".*")
+	c.Assert(err, IsNil)
+	c.Check(rotated, DeepEquals, []string{
+		s.path + ".20170521T010203.000000000Z",
+		s.path + ".20170620T010203.000000000Z",
+	})
+
+	entries, err := s.h.Entries(nil)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 3)
+	c.Check(entries[0].Kind, Equals, "chg0")
+	c.Check(entries[2].Kind, Equals, "chg2")
+
+	// rotated files are kept for 90 days
+	now = now.Add(60 * 24 * time.Hour)
+	chg := s.newChange("chg3", now, "foo")
+	c.Assert(s.h.Archive([]*state.Change{chg}), IsNil)
+
+	rotated, err = filepath.Glob(s.path + ".*")
+	c.Assert(err, IsNil)
+	c.Check(rotated, DeepEquals, []string{
+		s.path + ".20170620T010203.000000000Z",
+		s.path + ".20170918T010203.000000000Z",
+	})
+	entries, err = s.h.Entries(nil)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 3)
+	c.Check(entries[0].Kind, Equals, "chg1")
+}
@@ -22,8 +22,11 @@
 package configstate
 
 import (
+	"fmt"
 	"regexp"
 
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/hookstate"
 	"github.com/snapcore/snapd/overlord/state"
 )
@@ -42,5 +45,31 @@ func Manager(s *state.State, hookManager *hookstate.HookManager) (*ConfigManager
 
 	hookManager.Register(regexp.MustCompile("^configure$"), newConfigureHandler)
 
+	s.Lock()
+	level, err := loggingLevel(config.NewTransaction(s))
+	s.Unlock()
+	if err != nil {
+		logger.Noticef("Cannot get logging.level setting: %v", err)
+	} else {
+		logger.SetLevel(level)
+	}
+
 	return manager, nil
 }
+
+// loggingLevel returns the log level set with the core logging.level
+// option, or the default one if it is unset.
+func loggingLevel(tr *config.Transaction) (logger.Level, error) {
+	var name string
+	if err := tr.Get("core", "logging.level", &name); err != nil {
+		if config.IsNoOption(err) {
+			return logger.DefaultLevel, nil
+		}
+		return 0, err
+	}
+	level, err := logger.ParseLevel(name)
+	if err != nil {
+		return 0, fmt.Errorf("cannot set logging.level: %v", err)
+	}
+	return level, nil
+}
@@ -20,6 +20,7 @@
 package configstate
 
 import (
+	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/hookstate"
 )
@@ -80,6 +81,23 @@ func (h *configureHandler) Before() error {
 // Done is called by the HookManager after the configure hook has exited
 // successfully.
 func (h *configureHandler) Done() error {
+	if h.context.SnapName() != "core" {
+		return nil
+	}
+
+	h.context.Lock()
+	defer h.context.Unlock()
+
+	// apply the log level once the configuration is committed
+	level, err := loggingLevel(ContextTransaction(h.context))
+	if err != nil {
+		return err
+	}
+	h.context.OnDone(func() error {
+		logger.SetLevel(level)
+		return nil
+	})
+
 	return nil
 }
 
@@ -24,7 +24,9 @@ import (
 
 	. "gopkg.in/check.v1"
 
+	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/overlord/configstate"
+	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/hookstate"
 	"github.com/snapcore/snapd/overlord/hookstate/hooktest"
 	"github.com/snapcore/snapd/overlord/state"
@@ -73,3 +75,62 @@ func (s *configureHandlerSuite) TestBeforeInitializesTransaction(c *C) {
 	c.Check(tr.Get("test-snap", "foo", &value), IsNil)
 	c.Check(value, Equals, "bar")
 }
+
+type coreConfigureHandlerSuite struct {
+	context *hookstate.Context
+	handler hookstate.Handler
+}
+
+var _ = Suite(&coreConfigureHandlerSuite{})
+
+func (s *coreConfigureHandlerSuite) SetUpTest(c *C) {
+	state := state.New(nil)
+	state.Lock()
+	defer state.Unlock()
+
+	task := state.NewTask("test-task", "my test task")
+	setup := &hookstate.HookSetup{Snap: "core", Revision: snap.R(1), Hook: "configure"}
+
+	var err error
+	s.context, err = hookstate.NewContext(task, setup, hooktest.NewMockHandler())
+	c.Assert(err, IsNil)
+
+	s.handler = configstate.NewConfigureHandler(s.context)
+}
+
+func (s *coreConfigureHandlerSuite) TearDownTest(c *C) {
+	logger.SetLevel(logger.DefaultLevel)
+}
+
+func (s *coreConfigureHandlerSuite) run(c *C, patch map[string]interface{}) error {
+	s.context.Lock()
+	s.context.Set("patch", patch)
+	s.context.Unlock()
+
+	c.Assert(s.handler.Before(), IsNil)
+	if err := s.handler.Done(); err != nil {
+		return err
+	}
+
+	s.context.Lock()
+	defer s.context.Unlock()
+	return s.context.Done()
+}
+
+func (s *coreConfigureHandlerSuite) TestLoggingLevel(c *C) {
+	c.Assert(s.run(c, map[string]interface{}{"logging.level": "notice"}), IsNil)
+	c.Check(logger.GetLevel(), Equals, logger.NoticeLevel)
+
+	var value string
+	s.context.State().Lock()
+	err := config.NewTransaction(s.context.State()).Get("core", "logging.level", &value)
+	s.context.State().Unlock()
+	c.Assert(err, IsNil)
+	c.Check(value, Equals, "notice")
+}
+
+func (s *coreConfigureHandlerSuite) TestLoggingLevelInvalid(c *C) {
+	err := s.run(c, map[string]interface{}{"logging.level": "loud"})
+	c.Check(err, ErrorMatches, `cannot set logging.level: unknown log level "loud", expected one of: notice, debug`)
+	c.Check(logger.GetLevel(), Equals, logger.DefaultLevel)
+}
@@ -65,6 +65,7 @@ type DeviceManager struct {
 
 	lastBecomeOperationalAttempt time.Time
 	becomeOperationalBackoff     time.Duration
+	ensureOperationalFailures    int
 }
 
 // Manager returns a new device manager.
@@ -313,6 +314,10 @@ func (e *ensureError) Error() string {
 	return strings.Join(parts, "\n - ")
 }
 
+// ensureOperationalWarnAfter is the number of consecutive failures of
+// ensureOperational after which a warning is added.
+var ensureOperationalWarnAfter = 3
+
 // Ensure implements StateManager.Ensure.
 func (m *DeviceManager) Ensure() error {
 	var errs []error
@@ -321,7 +326,17 @@ func (m *DeviceManager) Ensure() error {
 		errs = append(errs, err)
 	}
 	if err := m.ensureOperational(); err != nil {
+		// warn only once the failures are not just transient, the
+		// errors themselves are logged with the ensure ones
+		m.ensureOperationalFailures++
+		if m.ensureOperationalFailures == ensureOperationalWarnAfter {
+			m.state.Lock()
+			m.state.Warnf("cannot become operational, see the snapd logs for details")
+			m.state.Unlock()
+		}
 		errs = append(errs, err)
+	} else {
+		m.ensureOperationalFailures = 0
 	}
 
 	if err := m.ensureBootOk(); err != nil {
@@ -984,7 +999,7 @@ func checkGadgetOrKernel(st *state.State, snapInfo, curInfo *snap.Info, flags sn
 			return fmt.Errorf("cannot install %s %q published by %q for model by %q", kind, snapInfo.Name(), publisher, model.BrandID())
 		}
 	} else {
-		logger.Noticef("installing unasserted %s %q", kind, snapInfo.Name())
+		logger.WithFields(logger.Fields{"snap": snapInfo.Name()}).Noticef("installing unasserted %s %q", kind, snapInfo.Name())
 	}
 
 	currentSnap, err := currentInfo(st)
@@ -107,7 +107,7 @@ func (sto *fakeStore) ListRefresh([]*store.RefreshCandidate, *auth.UserState) ([
 	panic("fakeStore.ListRefresh not expected")
 }
 
-func (sto *fakeStore) Download(context.Context, string, string, *snap.DownloadInfo, progress.Meter, *auth.UserState) error {
+func (sto *fakeStore) Download(context.Context, string, string, *snap.DownloadInfo, progress.Meter, *auth.UserState, *store.DownloadOptions) error {
 	panic("fakeStore.Download not expected")
 }
 
@@ -127,6 +127,10 @@ func (sto *fakeStore) Sections(*auth.UserState) ([]string, error) {
 	panic("fakeStore.Sections not expected")
 }
 
+func (sto *fakeStore) ConnectivityCheck() ([]*store.ConnectivityStatus, error) {
+	panic("fakeStore.ConnectivityCheck not expected")
+}
+
 func (s *deviceMgrSuite) SetUpTest(c *C) {
 	dirs.SetRootDir(c.MkDir())
 
@@ -1550,3 +1554,26 @@ func (s *deviceMgrSuite) TestCanAutoRefreshOnClassic(c *C) {
 	s.state.Set("seeded", false)
 	c.Check(canAutoRefresh(), Equals, false)
 }
+
+func (s *deviceMgrSuite) TestEnsureOperationalWarnsAfterRepeatedFailures(c *C) {
+	s.state.Lock()
+	// broken auth state
+	s.state.Set("auth", "nope")
+	s.state.Unlock()
+
+	for i := 0; i < 2; i++ {
+		s.mgr.Ensure()
+	}
+	s.state.Lock()
+	c.Check(s.state.AllWarnings(), HasLen, 0)
+	s.state.Unlock()
+
+	for i := 0; i < 3; i++ {
+		s.mgr.Ensure()
+	}
+	s.state.Lock()
+	defer s.state.Unlock()
+	warnings := s.state.AllWarnings()
+	c.Assert(warnings, HasLen, 1)
+	c.Check(warnings[0].Message(), Equals, "cannot become operational, see the snapd logs for details")
+}
@@ -20,6 +20,7 @@
 package devicestate_test
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -279,9 +280,9 @@ snaps:
 	c.Check(osutil.FileExists(filepath.Join(dirs.SnapMountDir, "local", "x1", "meta", "snap.yaml")), Equals, true)
 
 	// verify
-	r, err := os.Open(dirs.SnapStateFile)
+	data, err := state.ReadJournal(dirs.SnapStateFile)
 	c.Assert(err, IsNil)
-	state, err := state.ReadState(nil, r)
+	state, err := state.ReadState(nil, bytes.NewReader(data))
 	c.Assert(err, IsNil)
 
 	state.Lock()
@@ -446,9 +447,9 @@ snaps:
 	c.Check(osutil.FileExists(filepath.Join(dirs.SnapMountDir, "bar", "65", "meta", "snap.yaml")), Equals, true)
 
 	// verify
-	r, err := os.Open(dirs.SnapStateFile)
+	data, err := state.ReadJournal(dirs.SnapStateFile)
 	c.Assert(err, IsNil)
-	state, err := state.ReadState(nil, r)
+	state, err := state.ReadState(nil, bytes.NewReader(data))
 	c.Assert(err, IsNil)
 
 	state.Lock()
@@ -65,3 +65,10 @@ func MockStoreNew(new func(*store.Config, auth.AuthContext) *store.Store) (resto
 		storeNew = store.New
 	}
 }
+
+// MockBootIDPath sets the file the boot id is read from.
+func MockBootIDPath(path string) (restore func()) {
+	old := bootIDPath
+	bootIDPath = path
+	return func() { bootIDPath = old }
+}
@@ -423,9 +423,22 @@ func (m *InterfaceManager) doConnect(task *state.Task, _ *tomb.Tomb) error {
 	conns[connRef.ID()] = connState{Interface: plug.Interface}
 	setConns(st, conns)
 
+	emitConnEvent(task, EventInterfaceConnected, connRef, plug.Interface)
+
 	return nil
 }
 
+func emitConnEvent(task *state.Task, kind string, connRef interfaces.ConnRef, iface string) {
+	task.EmitEvent(&state.Event{
+		Kind: kind,
+		Data: map[string]string{
+			"plug":      connRef.PlugRef.String(),
+			"slot":      connRef.SlotRef.String(),
+			"interface": iface,
+		},
+	})
+}
+
 func snapNamesFromConns(conns []interfaces.ConnRef) []string {
 	m := make(map[string]bool)
 	for _, conn := range conns {
@@ -476,11 +489,20 @@ func (m *InterfaceManager) doDisconnect(task *state.Task, _ *tomb.Tomb) error {
 			return err
 		}
 	}
+	var disconnected []interfaces.ConnRef
+	var ifaces []string
 	for _, conn := range affectedConns {
+		if cstate, ok := conns[conn.ID()]; ok {
+			disconnected = append(disconnected, conn)
+			ifaces = append(ifaces, cstate.Interface)
+		}
 		delete(conns, conn.ID())
 	}
 
 	setConns(st, conns)
+	for i, conn := range disconnected {
+		emitConnEvent(task, EventInterfaceDisconnected, conn, ifaces[i])
+	}
 	return nil
 }
 
@@ -126,7 +126,7 @@ func (m *InterfaceManager) regenerateAllSecurityProfiles() error {
 		// Get the state of the snap so we can compute the confinement option
 		var snapst snapstate.SnapState
 		if err := snapstate.Get(m.state, snapName, &snapst); err != nil {
-			logger.Noticef("cannot get state of snap %q: %s", snapName, err)
+			logger.WithFields(logger.Fields{"snap": snapName}).Noticef("cannot get state of snap %q: %s", snapName, err)
 		}
 
 		// Compute confinement options
@@ -144,7 +144,7 @@ func (m *InterfaceManager) regenerateAllSecurityProfiles() error {
 			// Refresh security of this snap and backend
 			if err := backend.Setup(snapInfo, opts, m.repo); err != nil {
 				// Let's log this but carry on
-				logger.Noticef("cannot regenerate %s profile for snap %q: %s",
+				logger.WithFields(logger.Fields{"snap": snapName}).Noticef("cannot regenerate %s profile for snap %q: %s",
 					backend.Name(), snapName, err)
 			}
 		}
@@ -248,7 +248,7 @@ func (c *autoConnectChecker) check(plug *interfaces.Plug, slot *interfaces.Slot)
 		var err error
 		plugDecl, err = c.snapDeclaration(plug.Snap.SnapID)
 		if err != nil {
-			logger.Noticef("error: cannot find snap declaration for %q: %v", plug.Snap.Name(), err)
+			logger.WithFields(logger.Fields{"snap": plug.Snap.Name()}).Noticef("error: cannot find snap declaration for %q: %v", plug.Snap.Name(), err)
 			return false
 		}
 	}
@@ -258,7 +258,7 @@ func (c *autoConnectChecker) check(plug *interfaces.Plug, slot *interfaces.Slot)
 		var err error
 		slotDecl, err = c.snapDeclaration(slot.Snap.SnapID)
 		if err != nil {
-			logger.Noticef("error: cannot find snap declaration for %q: %v", slot.Snap.Name(), err)
+			logger.WithFields(logger.Fields{"snap": slot.Snap.Name()}).Noticef("error: cannot find snap declaration for %q: %v", slot.Snap.Name(), err)
 			return false
 		}
 	}
@@ -42,6 +42,17 @@ type InterfaceManager struct {
 	repo   *interfaces.Repository
 }
 
+// Kinds of the events emitted by the interface manager, see state.Event.
+const (
+	// EventInterfaceConnected is emitted when a plug got connected to
+	// a slot, with "plug", "slot" and "interface" data
+	EventInterfaceConnected = "interface-connected"
+	// EventInterfaceDisconnected is emitted when a plug got
+	// disconnected from a slot, with "plug", "slot" and "interface"
+	// data
+	EventInterfaceDisconnected = "interface-disconnected"
+)
+
 // Manager returns a new InterfaceManager.
 // Extra interfaces can be provided for testing.
 func Manager(s *state.State, hookManager *hookstate.HookManager, extraInterfaces []interfaces.Interface, extraBackends []interfaces.SecurityBackend) (*InterfaceManager, error) {
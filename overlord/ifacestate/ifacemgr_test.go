@@ -1376,6 +1376,57 @@ func (s *interfaceManagerSuite) TestConnectTracksConnectionsInState(c *C) {
 	})
 }
 
+func (s *interfaceManagerSuite) TestConnectDisconnectEmitEvents(c *C) {
+	s.mockIface(c, &ifacetest.TestInterface{InterfaceName: "test"})
+	s.mockSnap(c, consumerYaml)
+	s.mockSnap(c, producerYaml)
+
+	mgr := s.manager(c)
+
+	s.state.Lock()
+	var events []*state.Event
+	s.state.AddEventHandler(func(ev *state.Event) {
+		if ev.Kind == ifacestate.EventInterfaceConnected || ev.Kind == ifacestate.EventInterfaceDisconnected {
+			events = append(events, ev)
+		}
+	})
+	ts, err := ifacestate.Connect(s.state, "consumer", "plug", "producer", "slot")
+	c.Assert(err, IsNil)
+	change := s.state.NewChange("connect", "")
+	change.AddAll(ts)
+	s.state.Unlock()
+
+	s.settle(c)
+
+	s.state.Lock()
+	c.Assert(change.Err(), IsNil)
+	ts, err = ifacestate.Disconnect(s.state, "consumer", "plug", "producer", "slot")
+	c.Assert(err, IsNil)
+	change = s.state.NewChange("disconnect", "")
+	change.AddAll(ts)
+	s.state.Unlock()
+
+	mgr.Ensure()
+	mgr.Wait()
+	mgr.Stop()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Assert(change.Err(), IsNil)
+	c.Assert(events, HasLen, 2)
+	data := map[string]string{
+		"plug":      "consumer:plug",
+		"slot":      "producer:slot",
+		"interface": "test",
+	}
+	c.Check(events[0].Kind, Equals, ifacestate.EventInterfaceConnected)
+	c.Check(events[0].Data, DeepEquals, data)
+	c.Check(events[1].Kind, Equals, ifacestate.EventInterfaceDisconnected)
+	c.Check(events[1].ChangeID, Equals, change.ID())
+	c.Check(events[1].Data, DeepEquals, data)
+}
+
 func (s *interfaceManagerSuite) TestConnectSetsUpSecurity(c *C) {
 	s.mockIface(c, &ifacetest.TestInterface{InterfaceName: "test"})
 	s.mockSnap(c, consumerYaml)
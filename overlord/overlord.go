@@ -21,9 +21,12 @@
 package overlord
 
 import (
+	"bytes"
 	"fmt"
-	"os"
+	"io/ioutil"
+	"net/url"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -35,7 +38,9 @@ import (
 
 	"github.com/snapcore/snapd/overlord/assertstate"
 	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/changehistory"
 	"github.com/snapcore/snapd/overlord/configstate"
+	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/devicestate"
 	"github.com/snapcore/snapd/overlord/hookstate"
 	"github.com/snapcore/snapd/overlord/ifacestate"
@@ -52,8 +57,16 @@ var (
 	abortWait      = 24 * time.Hour * 7
 
 	pruneMaxChanges = 500
+
+	bootIDPath = "/proc/sys/kernel/random/boot_id"
 )
 
+// AbortWait returns how long changes have, from when they are created,
+// to become ready before they are aborted.
+func AbortWait() time.Duration {
+	return abortWait
+}
+
 // Overlord is the central manager of a snappy system, keeping
 // track of all available state managers and related helpers.
 type Overlord struct {
@@ -66,6 +79,9 @@ type Overlord struct {
 	pruneTicker *time.Ticker
 	// restarts
 	restartHandler func(t state.RestartType)
+	// persistence
+	journal       *state.Journal
+	changeHistory *changehistory.History
 	// managers
 	snapMgr   *snapstate.SnapManager
 	assertMgr *assertstate.AssertManager
@@ -84,7 +100,6 @@ func New() (*Overlord, error) {
 	}
 
 	backend := &overlordStateBackend{
-		path:           dirs.SnapStateFile,
 		ensureBefore:   o.ensureBefore,
 		requestRestart: o.requestRestart,
 	}
@@ -92,6 +107,13 @@ func New() (*Overlord, error) {
 	if err != nil {
 		return nil, err
 	}
+	o.journal = backend.journal
+
+	// keep a record of the changes that get pruned
+	o.changeHistory = changehistory.New(dirs.SnapChangeHistoryFile)
+	s.Lock()
+	s.SetChangeArchiver(o.changeHistory.Archive)
+	s.Unlock()
 
 	o.stateEng = NewStateEngine(s)
 
@@ -138,46 +160,101 @@ func New() (*Overlord, error) {
 
 	// setting up the store
 	authContext := auth.NewAuthContext(s, o.deviceMgr)
-	sto := storeNew(nil, authContext)
 	s.Lock()
+	storeCfg := storeConfig(s)
+	sto := storeNew(storeCfg, authContext)
 	snapstate.ReplaceStore(s, sto)
 	s.Unlock()
 
 	return o, nil
 }
 
-func loadState(backend state.Backend) (*state.State, error) {
-	if !osutil.FileExists(dirs.SnapStateFile) {
-		// fail fast, mostly interesting for tests, this dir is setup
-		// by the snapd package
-		stateDir := filepath.Dir(dirs.SnapStateFile)
-		if !osutil.IsDirectory(stateDir) {
-			return nil, fmt.Errorf("fatal: directory %q must be present", stateDir)
-		}
-		s := state.New(backend)
-		patch.Init(s)
-		return s, nil
+// storeConfig returns the configuration of the store, which can be
+// pointed at a mirror with:
+// $ snap set core store.url=<url>
+// taking effect when snapd is restarted.
+func storeConfig(s *state.State) *store.Config {
+	storeCfg := store.DefaultConfig()
+	storeCfg.CacheDir = dirs.SnapDownloadCacheDir
+
+	var storeURL string
+	tr := config.NewTransaction(s)
+	if err := tr.Get("core", "store.url", &storeURL); err != nil && !config.IsNoOption(err) {
+		logger.Noticef("Cannot get store.url setting: %v", err)
+	}
+	if storeURL == "" {
+		return storeCfg
+	}
+	u, err := url.Parse(storeURL)
+	if err == nil {
+		err = storeCfg.SetBaseURL(u)
+	}
+	if err != nil {
+		logger.Noticef("Cannot use store.url setting, using the default store: %v", err)
+	}
+	return storeCfg
+}
+
+func loadState(backend *overlordStateBackend) (*state.State, error) {
+	// fail fast, mostly interesting for tests, this dir is setup
+	// by the snapd package
+	stateDir := filepath.Dir(dirs.SnapStateFile)
+	if !osutil.IsDirectory(stateDir) {
+		return nil, fmt.Errorf("fatal: directory %q must be present", stateDir)
 	}
 
-	r, err := os.Open(dirs.SnapStateFile)
+	// an existing state file is taken as the snapshot of the journal
+	journal, data, err := state.OpenJournal(dirs.SnapStateFile)
 	if err != nil {
 		return nil, fmt.Errorf("cannot read the state file: %s", err)
 	}
-	defer r.Close()
+	backend.journal = journal
+
+	if data == nil {
+		s := state.New(backend)
+		patch.Init(s)
+		return s, nil
+	}
 
-	s, err := state.ReadState(backend, r)
+	s, err := state.ReadState(backend, bytes.NewReader(data))
 	if err != nil {
+		journal.Close()
 		return nil, err
 	}
 
 	// one-shot migrations
 	err = patch.Apply(s)
 	if err != nil {
+		journal.Close()
 		return nil, err
 	}
 	return s, nil
 }
 
+// resumeAfterRestart resumes the tasks waiting for the system to
+// restart if it booted since the last time snapd started.
+func resumeAfterRestart(s *state.State) {
+	data, err := ioutil.ReadFile(bootIDPath)
+	if err != nil {
+		logger.Noticef("Cannot determine the boot id: %v", err)
+		return
+	}
+	bootID := strings.TrimSpace(string(data))
+
+	var lastBootID string
+	if err := s.Get("boot-id", &lastBootID); err != nil && err != state.ErrNoState {
+		logger.Noticef("Cannot get the last boot id: %v", err)
+		return
+	}
+	if bootID == lastBootID {
+		return
+	}
+	s.Set("boot-id", bootID)
+	if resumed := s.Resume(state.WaitRestart); len(resumed) > 0 {
+		logger.Noticef("Resuming %d tasks after system restart.", len(resumed))
+	}
+}
+
 func (o *Overlord) ensureTimerSetup() {
 	o.ensureLock.Lock()
 	defer o.ensureLock.Unlock()
@@ -225,12 +302,22 @@ func (o *Overlord) SetRestartHandler(handleRestart func(t state.RestartType)) {
 // Loop runs a loop in a goroutine to ensure the current state regularly through StateEngine Ensure.
 func (o *Overlord) Loop() {
 	o.ensureTimerSetup()
+	s := o.State()
+	s.Lock()
+	resumeAfterRestart(s)
+	s.Unlock()
 	o.loopTomb.Go(func() error {
 		for {
 			o.ensureTimerReset()
 			// in case of errors engine logs them,
 			// continue to the next Ensure() try for now
 			o.stateEng.Ensure()
+			// an older snapd after a downgrade reads just the
+			// snapshot of the journal, so don't let it lag behind
+			// for long in case snapd doesn't get to stop cleanly
+			if err := o.journal.CompactStale(); err != nil {
+				logger.Noticef("Cannot compact the state journal: %v", err)
+			}
 			select {
 			case <-o.loopTomb.Dying():
 				return nil
@@ -250,6 +337,10 @@ func (o *Overlord) Stop() error {
 	o.loopTomb.Kill(nil)
 	err1 := o.loopTomb.Wait()
 	o.stateEng.Stop()
+	// leave a full snapshot of the state behind
+	if err := o.journal.Compact(); err != nil {
+		logger.Noticef("Cannot compact the state journal: %v", err)
+	}
 	return err1
 }
 
@@ -302,6 +393,11 @@ func (o *Overlord) State() *state.State {
 	return o.stateEng.State()
 }
 
+// ChangeHistory returns the record of the changes pruned from the state.
+func (o *Overlord) ChangeHistory() *changehistory.History {
+	return o.changeHistory
+}
+
 // SnapManager returns the snap manager responsible for snaps under
 // the overlord.
 func (o *Overlord) SnapManager() *snapstate.SnapManager {
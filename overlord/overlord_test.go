@@ -34,6 +34,7 @@ import (
 
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/overlord"
+	"github.com/snapcore/snapd/overlord/auth"
 	"github.com/snapcore/snapd/overlord/patch"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
@@ -43,7 +44,9 @@ import (
 
 func TestOverlord(t *testing.T) { TestingT(t) }
 
-type overlordSuite struct{}
+type overlordSuite struct {
+	restoreBootID func()
+}
 
 var _ = Suite(&overlordSuite{})
 
@@ -51,10 +54,13 @@ func (ovs *overlordSuite) SetUpTest(c *C) {
 	tmpdir := c.MkDir()
 	dirs.SetRootDir(tmpdir)
 	dirs.SnapStateFile = filepath.Join(tmpdir, "test.json")
+	dirs.SnapChangeHistoryFile = filepath.Join(tmpdir, "change-history.json")
 	snapstate.CanAutoRefresh = nil
+	ovs.restoreBootID = overlord.MockBootIDPath(filepath.Join(tmpdir, "boot_id"))
 }
 
 func (ovs *overlordSuite) TearDownTest(c *C) {
+	ovs.restoreBootID()
 	dirs.SetRootDir("/")
 }
 
@@ -111,13 +117,78 @@ func (ovs *overlordSuite) TestNewWithGoodState(c *C) {
 	c.Check(got, DeepEquals, expected)
 }
 
+func (ovs *overlordSuite) TestLoopResumesTasksAfterRestart(c *C) {
+	bootIDPath := filepath.Join(filepath.Dir(dirs.SnapStateFile), "boot_id")
+	c.Assert(ioutil.WriteFile(bootIDPath, []byte("boot-1\n"), 0644), IsNil)
+
+	o, err := overlord.New()
+	c.Assert(err, IsNil)
+	o.Loop()
+
+	st := o.State()
+	st.Lock()
+	chg := st.NewChange("install", "...")
+	// not handled by any manager
+	t := st.NewTask("foo", "...")
+	chg.AddTask(t)
+	t.SetStatus(state.DoingStatus)
+	t.SetToWait(state.WaitRestart, "system restart")
+	st.Unlock()
+	c.Assert(o.Stop(), IsNil)
+
+	// snapd restarted but not the system
+	o, err = overlord.New()
+	c.Assert(err, IsNil)
+	o.Loop()
+	st = o.State()
+	st.Lock()
+	c.Check(st.Task(t.ID()).Status(), Equals, state.WaitStatus)
+	st.Unlock()
+	c.Assert(o.Stop(), IsNil)
+
+	// the system restarted
+	c.Assert(ioutil.WriteFile(bootIDPath, []byte("boot-2\n"), 0644), IsNil)
+	o, err = overlord.New()
+	c.Assert(err, IsNil)
+	o.Loop()
+	defer o.Stop()
+	st = o.State()
+	st.Lock()
+	defer st.Unlock()
+	c.Check(st.Task(t.ID()).Status(), Equals, state.DoingStatus)
+
+	var bootID string
+	c.Assert(st.Get("boot-id", &bootID), IsNil)
+	c.Check(bootID, Equals, "boot-2")
+}
+
+func (ovs *overlordSuite) TestNewWithStoreURL(c *C) {
+	fakeState := []byte(fmt.Sprintf(`{"data":{"patch-level":%d,"config":{"core":{"store":{"url":"http://mirror.internal:8080/"}}}},"changes":null,"tasks":null,"last-change-id":0,"last-task-id":0,"last-lane-id":0}`, patch.Level))
+	err := ioutil.WriteFile(dirs.SnapStateFile, fakeState, 0600)
+	c.Assert(err, IsNil)
+
+	var storeCfg *store.Config
+	restore := overlord.MockStoreNew(func(cfg *store.Config, ac auth.AuthContext) *store.Store {
+		storeCfg = cfg
+		return store.New(cfg, ac)
+	})
+	defer restore()
+
+	_, err = overlord.New()
+	c.Assert(err, IsNil)
+	c.Assert(storeCfg, NotNil)
+	c.Check(storeCfg.DetailsURI.String(), Equals, "http://mirror.internal:8080/snaps/details/")
+	c.Check(storeCfg.AssertionsURI.String(), Equals, "http://mirror.internal:8080/assertions/")
+	c.Check(storeCfg.CacheDir, Equals, dirs.SnapDownloadCacheDir)
+}
+
 func (ovs *overlordSuite) TestNewWithInvalidState(c *C) {
 	fakeState := []byte(``)
 	err := ioutil.WriteFile(dirs.SnapStateFile, fakeState, 0600)
 	c.Assert(err, IsNil)
 
 	_, err = overlord.New()
-	c.Assert(err, ErrorMatches, "EOF")
+	c.Assert(err, ErrorMatches, "cannot read the state file: cannot decode state snapshot: unexpected end of JSON input")
 }
 
 func (ovs *overlordSuite) TestNewWithPatches(c *C) {
@@ -385,6 +456,13 @@ func (ovs *overlordSuite) TestEnsureLoopPrune(c *C) {
 	c.Assert(st.Change(chg2.ID()), IsNil)
 
 	c.Assert(t1.Status(), Equals, state.HoldStatus)
+
+	// the pruned change was archived
+	entries, err := o.ChangeHistory().Entries(nil)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+	c.Check(entries[0].ID, Equals, chg2.ID())
+	c.Check(entries[0].Kind, Equals, "prune")
 }
 
 func (ovs *overlordSuite) TestEnsureLoopPruneRunsMultipleTimes(c *C) {
@@ -446,7 +524,31 @@ func (ovs *overlordSuite) TestCheckpoint(c *C) {
 	st, err := os.Stat(dirs.SnapStateFile)
 	c.Assert(err, IsNil)
 	c.Assert(st.Mode(), Equals, os.FileMode(0600))
+	st, err = os.Stat(dirs.SnapStateFile + ".journal")
+	c.Assert(err, IsNil)
+	c.Assert(st.Mode(), Equals, os.FileMode(0600))
+
+	content, err := state.ReadJournal(dirs.SnapStateFile)
+	c.Assert(err, IsNil)
+	c.Check(string(content), testutil.Contains, `"mark":1`)
+}
 
+func (ovs *overlordSuite) TestStopCompactsJournal(c *C) {
+	o, err := overlord.New()
+	c.Assert(err, IsNil)
+
+	s := o.State()
+	s.Lock()
+	s.Set("mark", 1)
+	s.Unlock()
+
+	o.Loop()
+	err = o.Stop()
+	c.Assert(err, IsNil)
+
+	st, err := os.Stat(dirs.SnapStateFile + ".journal")
+	c.Assert(err, IsNil)
+	c.Check(st.Size(), Equals, int64(0))
 	content, err := ioutil.ReadFile(dirs.SnapStateFile)
 	c.Assert(err, IsNil)
 	c.Check(string(content), testutil.Contains, `"mark":1`)
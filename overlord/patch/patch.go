@@ -20,8 +20,11 @@
 package patch
 
 import (
+	"encoding/json"
 	"fmt"
+	"time"
 
+	"github.com/snapcore/snapd/cmd"
 	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/overlord/state"
 )
@@ -29,9 +32,41 @@ import (
 // Level is the current implemented patch level of the state format and content.
 var Level = 6
 
+// Sublevel is the current implemented sublevel of Level.
+//
+// Sublevel patches must leave the state usable by a snapd implementing
+// an earlier sublevel of the same level, and must be idempotent: if
+// such a snapd cannot reverse them, it carries on and they get applied
+// again once the newer snapd comes back.
+var Sublevel = 0
+
 // patches maps from patch level L to the function that moves from L-1 to L.
 var patches = make(map[int]func(s *state.State) error)
 
+// sublevelPatches maps from patch level L to the functions that move
+// through its sublevels, the i-th one from sublevel i to i+1.
+var sublevelPatches = make(map[int][]func(s *state.State) error)
+
+// historyStep is the record, kept in the patch history of the state, of a
+// patch that was applied, along with how to reverse it.
+type historyStep struct {
+	Level    int       `json:"level"`
+	Sublevel int       `json:"sublevel"`
+	Version  string    `json:"version,omitempty"`
+	Time     time.Time `json:"time"`
+
+	// Undo holds the previous values of the state entries modified by
+	// the patch and Remove the entries it added. A patch that
+	// modified changes or tasks is irreversible.
+	Undo         map[string]*json.RawMessage `json:"undo,omitempty"`
+	Remove       []string                    `json:"remove,omitempty"`
+	Irreversible bool                        `json:"irreversible,omitempty"`
+}
+
+func (step *historyStep) String() string {
+	return fmt.Sprintf("%d.%d", step.Level, step.Sublevel)
+}
+
 // Init initializes an empty state to the current implemented patch level.
 func Init(s *state.State) {
 	s.Lock()
@@ -40,54 +75,224 @@ func Init(s *state.State) {
 		panic("internal error: expected empty state, attempting to override patch-level without actual patching")
 	}
 	s.Set("patch-level", Level)
+	if Sublevel > 0 {
+		s.Set("patch-sublevel", Sublevel)
+	}
+}
+
+func getLevel(s *state.State) (level, sublevel int, err error) {
+	s.Lock()
+	defer s.Unlock()
+	err = s.Get("patch-level", &level)
+	if err != nil && err != state.ErrNoState {
+		return 0, 0, err
+	}
+	err = s.Get("patch-sublevel", &sublevel)
+	if err != nil && err != state.ErrNoState {
+		return 0, 0, err
+	}
+	return level, sublevel, nil
 }
 
 // Apply applies any necessary patches to update the provided state to
 // conventions required by the current patch level of the system.
+//
+// If the state is at a later level, as left by a newer snapd, the
+// patches recorded in its history are reversed instead, if possible.
 func Apply(s *state.State) error {
-	var stateLevel int
-	s.Lock()
-	err := s.Get("patch-level", &stateLevel)
-	s.Unlock()
-	if err != nil && err != state.ErrNoState {
+	stateLevel, stateSublevel, err := getLevel(s)
+	if err != nil {
 		return err
 	}
-	if stateLevel == Level {
+	if stateLevel == Level && stateSublevel == Sublevel {
 		// already at right level, nothing to do
 		return nil
 	}
-	if stateLevel > Level {
-		return fmt.Errorf("cannot downgrade: snapd is too old for the current system state (patch level %d)", stateLevel)
+	if stateLevel > Level || stateLevel == Level && stateSublevel > Sublevel {
+		return reverse(s, stateLevel, stateSublevel)
 	}
 
 	level := stateLevel
-	for level < Level {
+	sublevel := stateSublevel
+	for {
+		if sublevel < len(sublevelPatches[level]) && (level < Level || sublevel < Sublevel) {
+			logger.Noticef("Patching system state level %d from sublevel %d to %d", level, sublevel, sublevel+1)
+			err := applyOne(sublevelPatches[level][sublevel], s, level, sublevel+1)
+			if err != nil {
+				logger.Noticef("Cannot patch: %v", err)
+				return fmt.Errorf("cannot patch system state level %d from sublevel %d to %d: %v", level, sublevel, sublevel+1, err)
+			}
+			sublevel++
+			continue
+		}
+		if level == Level {
+			break
+		}
+
 		logger.Noticef("Patching system state from level %d to %d", level, level+1)
 		patch := patches[level+1]
 		if patch == nil {
 			return fmt.Errorf("cannot upgrade: snapd is too new for the current system state (patch level %d)", level)
 		}
-		err := applyOne(patch, s, level)
+		err := applyOne(patch, s, level+1, 0)
 		if err != nil {
 			logger.Noticef("Cannot patch: %v", err)
 			return fmt.Errorf("cannot patch system state from level %d to %d: %v", level, level+1, err)
 		}
 		level++
+		sublevel = 0
 	}
 
 	return nil
 }
 
-func applyOne(patch func(s *state.State) error, s *state.State, level int) error {
+// snapshot is the serialized state, as compared before and after a
+// patch to record how to reverse it.
+type snapshot struct {
+	Data    map[string]*json.RawMessage `json:"data"`
+	Changes map[string]*json.RawMessage `json:"changes"`
+	Tasks   map[string]*json.RawMessage `json:"tasks"`
+}
+
+func takeSnapshot(s *state.State) (*snapshot, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+func rawEqual(a, b *json.RawMessage) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return string(*a) == string(*b)
+}
+
+func entriesEqual(a, b map[string]*json.RawMessage) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if !rawEqual(v, b[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+// record fills in how to get from the after snapshot back to before.
+func (step *historyStep) record(before, after *snapshot) {
+	if !entriesEqual(before.Changes, after.Changes) || !entriesEqual(before.Tasks, after.Tasks) {
+		step.Irreversible = true
+		return
+	}
+	for k, v := range before.Data {
+		if rawEqual(v, after.Data[k]) {
+			continue
+		}
+		if step.Undo == nil {
+			step.Undo = make(map[string]*json.RawMessage)
+		}
+		step.Undo[k] = v
+	}
+	for k := range after.Data {
+		if _, ok := before.Data[k]; !ok {
+			step.Remove = append(step.Remove, k)
+		}
+	}
+}
+
+func applyOne(patch func(s *state.State) error, s *state.State, level, sublevel int) error {
 	s.Lock()
 	defer s.Unlock()
 
-	err := patch(s)
+	before, err := takeSnapshot(s)
+	if err != nil {
+		return err
+	}
+	err = patch(s)
+	if err != nil {
+		return err
+	}
+	after, err := takeSnapshot(s)
 	if err != nil {
 		return err
 	}
 
-	s.Set("patch-level", level+1)
+	step := &historyStep{
+		Level:    level,
+		Sublevel: sublevel,
+		Version:  cmd.Version,
+		Time:     time.Now(),
+	}
+	step.record(before, after)
+
+	var history []*historyStep
+	if err := s.Get("patch-history", &history); err != nil && err != state.ErrNoState {
+		return err
+	}
+	s.Set("patch-history", append(history, step))
+	s.Set("patch-level", level)
+	s.Set("patch-sublevel", sublevel)
+	return nil
+}
+
+// reverse brings the state back from a later level to the current one,
+// reversing the patches recorded in its history.
+func reverse(s *state.State, stateLevel, stateSublevel int) error {
+	s.Lock()
+	defer s.Unlock()
+
+	var history []*historyStep
+	if err := s.Get("patch-history", &history); err != nil && err != state.ErrNoState {
+		return err
+	}
+
+	// the steps to reverse are the latest ones, past the current level
+	i := len(history)
+	for i > 0 && (history[i-1].Level > Level || history[i-1].Level == Level && history[i-1].Sublevel > Sublevel) {
+		i--
+	}
+	steps := history[i:]
+
+	complete := len(steps) > 0 && steps[len(steps)-1].Level == stateLevel && steps[len(steps)-1].Sublevel == stateSublevel &&
+		(steps[0].Level == Level && steps[0].Sublevel == Sublevel+1 || steps[0].Level == Level+1 && steps[0].Sublevel == 0)
+	if stateLevel > Level {
+		if !complete {
+			return fmt.Errorf("cannot downgrade: snapd is too old for the current system state (patch level %d)", stateLevel)
+		}
+		for _, step := range steps {
+			if step.Irreversible {
+				return fmt.Errorf("cannot downgrade: snapd is too old for the current system state (patch level %d), and the patch to level %s by snapd %s cannot be reversed", stateLevel, step, step.Version)
+			}
+		}
+	}
+
+	for j := len(steps) - 1; j >= 0; j-- {
+		step := steps[j]
+		if step.Irreversible {
+			// only for sublevels of the current level, which
+			// keep the state usable
+			logger.Noticef("Cannot reverse patch to level %s of the system state, keeping it", step)
+			continue
+		}
+		logger.Noticef("Reversing patch to level %s of the system state, applied by snapd %s", step, step.Version)
+		for k, v := range step.Undo {
+			s.Set(k, v)
+		}
+		for _, k := range step.Remove {
+			s.Delete(k)
+		}
+	}
+
+	s.Set("patch-history", history[:i])
+	s.Set("patch-level", Level)
+	s.Set("patch-sublevel", Sublevel)
 	return nil
 }
 
@@ -102,3 +307,15 @@ func Mock(level int, p map[int]func(*state.State) error) (restore func()) {
 		patches = oldPatches
 	}
 }
+
+// MockSublevels mocks the current patch sublevel and available sublevel patches.
+func MockSublevels(sublevel int, p map[int][]func(*state.State) error) (restore func()) {
+	oldSublevel := Sublevel
+	oldPatches := sublevelPatches
+	Sublevel = sublevel
+	sublevelPatches = p
+	return func() {
+		Sublevel = oldSublevel
+		sublevelPatches = oldPatches
+	}
+}
@@ -189,4 +189,161 @@ func (s *patchSuite) TestSanity(c *C) {
 	}
 	// ends at implemented patch level
 	c.Check(levels[len(levels)-1], Equals, patch.Level)
+	// and sublevel
+	c.Check(patch.SublevelPatchesForTest()[patch.Level], HasLen, patch.Sublevel)
+}
+
+func incPatch(key string) func(st *state.State) error {
+	return func(st *state.State) error {
+		var n int
+		st.Get(key, &n)
+		st.Set(key, n+1)
+		return nil
+	}
+}
+
+func (s *patchSuite) TestApplySublevels(c *C) {
+	restore := patch.Mock(3, map[int]func(*state.State) error{
+		3: incPatch("a"),
+	})
+	defer restore()
+	restore = patch.MockSublevels(1, map[int][]func(*state.State) error{
+		2: {incPatch("b"), incPatch("b")},
+		3: {incPatch("c")},
+	})
+	defer restore()
+
+	st := state.New(nil)
+	st.Lock()
+	st.Set("patch-level", 2)
+	st.Set("patch-sublevel", 1)
+	st.Unlock()
+	c.Assert(patch.Apply(st), IsNil)
+
+	st.Lock()
+	defer st.Unlock()
+
+	var level, sublevel, a, b, cc int
+	c.Assert(st.Get("patch-level", &level), IsNil)
+	c.Assert(st.Get("patch-sublevel", &sublevel), IsNil)
+	c.Check(level, Equals, 3)
+	c.Check(sublevel, Equals, 1)
+	c.Assert(st.Get("a", &a), IsNil)
+	c.Assert(st.Get("b", &b), IsNil)
+	c.Assert(st.Get("c", &cc), IsNil)
+	// only the second sublevel patch of level 2 was applied
+	c.Check([]int{a, b, cc}, DeepEquals, []int{1, 1, 1})
+
+	var history []map[string]interface{}
+	c.Assert(st.Get("patch-history", &history), IsNil)
+	c.Assert(history, HasLen, 3)
+	for i, lv := range [][]float64{{2, 2}, {3, 0}, {3, 1}} {
+		c.Check(history[i]["level"], Equals, lv[0])
+		c.Check(history[i]["sublevel"], Equals, lv[1])
+	}
+}
+
+func (s *patchSuite) TestDowngradeReverses(c *C) {
+	restore := patch.Mock(4, map[int]func(*state.State) error{
+		3: incPatch("a"),
+		4: func(st *state.State) error {
+			st.Set("new", true)
+			return incPatch("a")(st)
+		},
+	})
+	defer restore()
+	restore = patch.MockSublevels(0, nil)
+	defer restore()
+
+	st := state.New(nil)
+	st.Lock()
+	st.Set("patch-level", 2)
+	st.Unlock()
+	c.Assert(patch.Apply(st), IsNil)
+
+	// an older snapd, that knows nothing of the patches to levels 3
+	// and 4, starts on the patched state
+	restore = patch.Mock(2, map[int]func(*state.State) error{
+		1: incPatch("x"),
+		2: incPatch("x"),
+	})
+	defer restore()
+	c.Assert(patch.Apply(st), IsNil)
+
+	st.Lock()
+	defer st.Unlock()
+
+	var level int
+	c.Assert(st.Get("patch-level", &level), IsNil)
+	c.Check(level, Equals, 2)
+	var a int
+	c.Check(st.Get("a", &a), Equals, state.ErrNoState)
+	var isNew bool
+	c.Check(st.Get("new", &isNew), Equals, state.ErrNoState)
+	var history []interface{}
+	c.Assert(st.Get("patch-history", &history), IsNil)
+	c.Check(history, HasLen, 0)
+}
+
+func (s *patchSuite) TestDowngradeIrreversible(c *C) {
+	restore := patch.Mock(3, map[int]func(*state.State) error{
+		3: func(st *state.State) error {
+			st.NewChange("foo", "...")
+			return nil
+		},
+	})
+	defer restore()
+	restore = patch.MockSublevels(0, nil)
+	defer restore()
+
+	st := state.New(nil)
+	st.Lock()
+	st.Set("patch-level", 2)
+	st.Unlock()
+	c.Assert(patch.Apply(st), IsNil)
+
+	restore = patch.MockLevel(2)
+	defer restore()
+	err := patch.Apply(st)
+	c.Assert(err, ErrorMatches, `cannot downgrade: snapd is too old for the current system state \(patch level 3\), and the patch to level 3.0 by snapd .* cannot be reversed`)
+
+	st.Lock()
+	defer st.Unlock()
+	var level int
+	c.Assert(st.Get("patch-level", &level), IsNil)
+	c.Check(level, Equals, 3)
+}
+
+func (s *patchSuite) TestDowngradeSublevel(c *C) {
+	restore := patch.Mock(2, nil)
+	defer restore()
+	restore = patch.MockSublevels(2, map[int][]func(*state.State) error{
+		2: {incPatch("a"), func(st *state.State) error {
+			st.NewChange("foo", "...")
+			return nil
+		}},
+	})
+	defer restore()
+
+	st := state.New(nil)
+	st.Lock()
+	st.Set("patch-level", 2)
+	st.Unlock()
+	c.Assert(patch.Apply(st), IsNil)
+
+	// an older snapd of the same level, that knows nothing of the
+	// sublevel patches, carries on, reversing what it can
+	restore = patch.MockSublevels(0, map[int][]func(*state.State) error{})
+	defer restore()
+	c.Assert(patch.Apply(st), IsNil)
+
+	st.Lock()
+	defer st.Unlock()
+	var level, sublevel, a int
+	c.Assert(st.Get("patch-level", &level), IsNil)
+	c.Assert(st.Get("patch-sublevel", &sublevel), IsNil)
+	c.Check(level, Equals, 2)
+	c.Check(sublevel, Equals, 0)
+	c.Check(st.Get("a", &a), Equals, state.ErrNoState)
+	c.Check(st.Changes(), HasLen, 1)
 }
@@ -83,8 +83,9 @@ func (ops fakeOps) First(op string) *fakeOp {
 }
 
 type fakeDownload struct {
-	name     string
-	macaroon string
+	name      string
+	macaroon  string
+	rateLimit int64
 }
 
 type fakeStore struct {
@@ -105,6 +106,33 @@ func (f *fakeStore) pokeStateLock() {
 func (f *fakeStore) SnapInfo(spec store.SnapSpec, user *auth.UserState) (*snap.Info, error) {
 	f.pokeStateLock()
 
+	switch spec.Name {
+	case "snap-not-in-store":
+		return nil, store.ErrSnapNotFound
+	case "snap-store-unreachable":
+		return nil, fmt.Errorf("cannot reach the store")
+	}
+
+	var channels map[string]*snap.ChannelSnapInfo
+	var tracks []string
+	if spec.Channel == "" && spec.Revision.Unset() {
+		// like the real store, only report the channel map
+		// when no specific channel or revision is asked for
+		channels = map[string]*snap.ChannelSnapInfo{
+			"stable":        {Channel: "stable", Revision: snap.R(11)},
+			"edge":          {Channel: "edge", Revision: snap.R(11)},
+			"channel-for-7": {Channel: "channel-for-7", Revision: snap.R(7)},
+			"2.x/candidate": {Channel: "2.x/candidate", Revision: snap.R(11)},
+		}
+		tracks = []string{"latest", "2.x"}
+		if spec.Name == "snap-without-tracks" {
+			// like a store that doesn't send the full channel
+			// map, only the default track is known
+			delete(channels, "2.x/candidate")
+			tracks = nil
+		}
+	}
+
 	if spec.Revision.Unset() {
 		spec.Revision = snap.R(11)
 		if spec.Channel == "channel-for-7" {
@@ -139,6 +167,8 @@ func (f *fakeStore) SnapInfo(spec store.SnapSpec, user *auth.UserState) (*snap.I
 		},
 		Confinement: confinement,
 		Type:        typ,
+		Channels:    channels,
+		Tracks:      tracks,
 	}
 	f.fakeBackend.ops = append(f.fakeBackend.ops, fakeOp{op: "storesvc-snap", name: spec.Name, revno: spec.Revision})
 
@@ -172,9 +202,12 @@ func (f *fakeStore) ListRefresh(cands []*store.RefreshCandidate, _ *auth.UserSta
 		}
 
 		var name string
-		if snapID == "some-snap-id" {
+		switch snapID {
+		case "some-snap-id":
 			name = "some-snap"
-		} else {
+		case "some-other-snap-id":
+			name = "some-other-snap"
+		default:
 			panic(fmt.Sprintf("ListRefresh: unknown snap-id: %s", snapID))
 		}
 
@@ -231,16 +264,21 @@ func (f *fakeStore) SuggestedCurrency() string {
 	return "XTS"
 }
 
-func (f *fakeStore) Download(ctx context.Context, name, targetFn string, snapInfo *snap.DownloadInfo, pb progress.Meter, user *auth.UserState) error {
+func (f *fakeStore) Download(ctx context.Context, name, targetFn string, snapInfo *snap.DownloadInfo, pb progress.Meter, user *auth.UserState, dlOpts *store.DownloadOptions) error {
 	f.pokeStateLock()
 
 	var macaroon string
 	if user != nil {
 		macaroon = user.StoreMacaroon
 	}
+	var rateLimit int64
+	if dlOpts != nil {
+		rateLimit = dlOpts.RateLimit()
+	}
 	f.downloads = append(f.downloads, fakeDownload{
-		macaroon: macaroon,
-		name:     name,
+		macaroon:  macaroon,
+		name:      name,
+		rateLimit: rateLimit,
 	})
 	f.fakeBackend.ops = append(f.fakeBackend.ops, fakeOp{op: "storesvc-download", name: name})
 
@@ -266,6 +304,10 @@ func (f *fakeStore) Sections(user *auth.UserState) ([]string, error) {
 	panic("Sections called")
 }
 
+func (f *fakeStore) ConnectivityCheck() ([]*store.ConnectivityStatus, error) {
+	panic("ConnectivityCheck called")
+}
+
 type fakeSnappyBackend struct {
 	ops fakeOps
 
@@ -88,7 +88,7 @@ func UpdateBootRevisions(st *state.State) error {
 		}
 		info, err := CurrentInfo(st, name)
 		if err != nil {
-			logger.Noticef("cannot get info for %q: %s", name, err)
+			st.Warnf("cannot get info for %q: %s", name, err)
 			continue
 		}
 		if rev != info.SideInfo.Revision {
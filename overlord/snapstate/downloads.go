@@ -0,0 +1,232 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapstate
+
+import (
+	"sync"
+	"time"
+
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/store"
+)
+
+var (
+	// defaultMaxParallelDownloads is how many snaps are downloaded at
+	// once unless configured otherwise via:
+	// $ snap set core download.max-parallel=<n>
+	defaultMaxParallelDownloads = 4
+
+	// downloadProgressInterval is how often the progress of the
+	// running downloads is reported to their tasks
+	downloadProgressInterval = 500 * time.Millisecond
+
+	// rateLimitCheckInterval is how often the running downloads read
+	// the rate limit setting again
+	rateLimitCheckInterval = time.Second
+)
+
+// maxParallelDownloads returns how many download-snap tasks can run at
+// once. It must be called with the state lock held.
+func maxParallelDownloads(st *state.State) int {
+	var max int
+	tr := config.NewTransaction(st)
+	err := tr.Get("core", "download.max-parallel", &max)
+	if err != nil && !config.IsNoOption(err) {
+		logger.Noticef("Cannot get download.max-parallel setting: %v", err)
+	}
+	if max <= 0 {
+		return defaultMaxParallelDownloads
+	}
+	return max
+}
+
+// rateLimit returns the limit set with:
+// $ snap set core refresh.rate-limit=<bytes per second>
+// An invalid setting is ignored, so that it does not break refreshes.
+// It must be called with the state lock held.
+func rateLimit(st *state.State) int64 {
+	var rateLimit int64
+	tr := config.NewTransaction(st)
+	err := tr.Get("core", "refresh.rate-limit", &rateLimit)
+	if err != nil && !config.IsNoOption(err) {
+		logger.Noticef("Cannot get refresh.rate-limit setting: %v", err)
+		return 0
+	}
+	if rateLimit < 0 {
+		return 0
+	}
+	return rateLimit
+}
+
+// downloadOptions returns the options for a rate limited download. The
+// rate limit setting is read again every rateLimitCheckInterval while
+// downloading, so that changing it applies to the downloads in
+// progress. It must be called with the state lock held.
+func downloadOptions(st *state.State) *store.DownloadOptions {
+	var mu sync.Mutex
+	limit := rateLimit(st)
+	checked := time.Now()
+	return &store.DownloadOptions{
+		RateLimit: func() int64 {
+			mu.Lock()
+			defer mu.Unlock()
+			if time.Since(checked) >= rateLimitCheckInterval {
+				st.Lock()
+				limit = rateLimit(st)
+				st.Unlock()
+				checked = time.Now()
+			}
+			return limit
+		},
+	}
+}
+
+// downloadScheduler collects the progress of the running downloads and
+// reports it to their tasks in batches, so that concurrent downloads do
+// not take the state lock for every chunk they write.
+type downloadScheduler struct {
+	st *state.State
+
+	mu        sync.Mutex
+	meters    map[*downloadMeter]bool
+	lastFlush time.Time
+}
+
+func newDownloadScheduler(st *state.State) *downloadScheduler {
+	return &downloadScheduler{
+		st:     st,
+		meters: make(map[*downloadMeter]bool),
+	}
+}
+
+// meter returns a progress.Meter for the download done by the task t.
+// The meter must be released with done once the download is over.
+func (ds *downloadScheduler) meter(t *state.Task) *downloadMeter {
+	m := &downloadMeter{sched: ds, task: t}
+	ds.mu.Lock()
+	ds.meters[m] = true
+	ds.mu.Unlock()
+	return m
+}
+
+// done reports the last progress of the meter and forgets about it.
+func (ds *downloadScheduler) done(m *downloadMeter) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	delete(ds.meters, m)
+	ds.st.Lock()
+	m.report()
+	ds.st.Unlock()
+}
+
+// update is called whenever a meter changed; the progress of all the
+// running downloads is reported at most every downloadProgressInterval
+// unless force is set.
+func (ds *downloadScheduler) update(force bool) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	now := time.Now()
+	if !force && now.Sub(ds.lastFlush) < downloadProgressInterval {
+		return
+	}
+	ds.lastFlush = now
+
+	// the state lock is always taken after ds.mu, never the other way
+	// around
+	ds.st.Lock()
+	defer ds.st.Unlock()
+	for m := range ds.meters {
+		m.report()
+	}
+}
+
+// downloadMeter is the progress.Meter of a download-snap task.
+type downloadMeter struct {
+	sched *downloadScheduler
+	task  *state.Task
+
+	// protected by sched.mu
+	label   string
+	total   float64
+	current float64
+	dirty   bool
+}
+
+// report sets the task progress, it must be called with sched.mu and
+// the state lock held.
+func (m *downloadMeter) report() {
+	if !m.dirty {
+		return
+	}
+	m.task.SetProgress(m.label, int(m.current), int(m.total))
+	m.dirty = false
+}
+
+func (m *downloadMeter) set(f func()) {
+	m.sched.mu.Lock()
+	f()
+	m.dirty = true
+	m.sched.mu.Unlock()
+}
+
+// Start sets the label and total
+func (m *downloadMeter) Start(label string, total float64) {
+	m.set(func() {
+		m.label = label
+		m.total = total
+	})
+}
+
+// Set sets the current progress
+func (m *downloadMeter) Set(current float64) {
+	m.set(func() { m.current = current })
+	m.sched.update(false)
+}
+
+// SetTotal sets the maximum progress
+func (m *downloadMeter) SetTotal(total float64) {
+	m.set(func() { m.total = total })
+}
+
+// Finished sets the progress to 100%
+func (m *downloadMeter) Finished() {
+	m.set(func() { m.current = m.total })
+	m.sched.update(true)
+}
+
+// Write adds to the current progress
+func (m *downloadMeter) Write(p []byte) (n int, err error) {
+	m.set(func() { m.current += float64(len(p)) })
+	m.sched.update(false)
+	return len(p), nil
+}
+
+// Notify logs the message to the task
+func (m *downloadMeter) Notify(msg string) {
+	m.task.State().Lock()
+	defer m.task.State().Unlock()
+	m.task.Logf(msg)
+}
+
+// Spin does nothing
+func (m *downloadMeter) Spin(msg string) {
+}
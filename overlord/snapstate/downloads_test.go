@@ -0,0 +1,145 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapstate
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+type downloadsTestSuite struct {
+	st *state.State
+}
+
+var _ = Suite(&downloadsTestSuite{})
+
+func (s *downloadsTestSuite) SetUpTest(c *C) {
+	s.st = state.New(nil)
+}
+
+func (s *downloadsTestSuite) TestMaxParallelDownloads(c *C) {
+	s.st.Lock()
+	defer s.st.Unlock()
+
+	c.Check(maxParallelDownloads(s.st), Equals, defaultMaxParallelDownloads)
+
+	tr := config.NewTransaction(s.st)
+	c.Assert(tr.Set("core", "download.max-parallel", 1), IsNil)
+	tr.Commit()
+	c.Check(maxParallelDownloads(s.st), Equals, 1)
+
+	// nonsense gets the default
+	tr = config.NewTransaction(s.st)
+	c.Assert(tr.Set("core", "download.max-parallel", -1), IsNil)
+	tr.Commit()
+	c.Check(maxParallelDownloads(s.st), Equals, defaultMaxParallelDownloads)
+}
+
+func (s *downloadsTestSuite) TestDownloadOptionsRateLimitChanges(c *C) {
+	s.st.Lock()
+	tr := config.NewTransaction(s.st)
+	c.Assert(tr.Set("core", "refresh.rate-limit", 1024), IsNil)
+	tr.Commit()
+	dlOpts := downloadOptions(s.st)
+	s.st.Unlock()
+
+	c.Check(dlOpts.RateLimit(), Equals, int64(1024))
+
+	s.st.Lock()
+	tr = config.NewTransaction(s.st)
+	c.Assert(tr.Set("core", "refresh.rate-limit", 0), IsNil)
+	tr.Commit()
+	s.st.Unlock()
+
+	// the setting is not read again for every chunk downloaded ...
+	c.Check(dlOpts.RateLimit(), Equals, int64(1024))
+
+	// ... but often enough for changes to apply to running downloads
+	restore := rateLimitCheckInterval
+	rateLimitCheckInterval = 0
+	defer func() { rateLimitCheckInterval = restore }()
+	c.Check(dlOpts.RateLimit(), Equals, int64(0))
+}
+
+func (s *downloadsTestSuite) TestMeterReportsInBatches(c *C) {
+	restore := downloadProgressInterval
+	downloadProgressInterval = time.Hour
+	defer func() { downloadProgressInterval = restore }()
+
+	s.st.Lock()
+	t1 := s.st.NewTask("download-snap", "...")
+	t2 := s.st.NewTask("download-snap", "...")
+	s.st.Unlock()
+
+	ds := newDownloadScheduler(s.st)
+	m1 := ds.meter(t1)
+	m2 := ds.meter(t2)
+
+	m1.Start("foo", 100)
+	m2.Start("bar", 10)
+	// the first update reports the progress of all downloads
+	m1.Write(make([]byte, 20))
+
+	s.st.Lock()
+	label, done, total := t1.Progress()
+	c.Check(label, Equals, "foo")
+	c.Check(done, Equals, 20)
+	c.Check(total, Equals, 100)
+	label, done, total = t2.Progress()
+	c.Check(label, Equals, "bar")
+	c.Check(done, Equals, 0)
+	c.Check(total, Equals, 10)
+	s.st.Unlock()
+
+	// later ones are batched
+	m1.Write(make([]byte, 20))
+	m2.Set(5)
+
+	s.st.Lock()
+	_, done, _ = t1.Progress()
+	c.Check(done, Equals, 20)
+	_, done, _ = t2.Progress()
+	c.Check(done, Equals, 0)
+	s.st.Unlock()
+
+	// until a download finishes
+	m2.Finished()
+
+	s.st.Lock()
+	_, done, _ = t1.Progress()
+	c.Check(done, Equals, 40)
+	_, done, _ = t2.Progress()
+	c.Check(done, Equals, 10)
+	s.st.Unlock()
+
+	// or is done with
+	m1.Write(make([]byte, 10))
+	ds.done(m1)
+
+	s.st.Lock()
+	_, done, _ = t1.Progress()
+	c.Check(done, Equals, 50)
+	s.st.Unlock()
+	c.Check(ds.meters, HasLen, 1)
+}
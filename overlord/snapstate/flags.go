@@ -19,6 +19,10 @@
 
 package snapstate
 
+import (
+	"fmt"
+)
+
 // Flags are used to pass additional flags to operations and to keep track of snap modes.
 type Flags struct {
 	// DevMode switches confinement to non-enforcing mode.
@@ -45,6 +49,36 @@ type Flags struct {
 	// Required is set to mark that a snap is required
 	// and cannot be removed
 	Required bool `json:"required,omitempty"`
+
+	// Transaction controls how the task sets of a multi-snap
+	// operation are undone when one of them fails.
+	Transaction TransactionType `json:"transaction,omitempty"`
+
+	// NoRateLimit is set when the user requested as one-off to
+	// download without the refresh.rate-limit bandwidth limit.
+	NoRateLimit bool `json:"no-rate-limit,omitempty"`
+}
+
+// TransactionType determines whether the snaps of a multi-snap
+// operation are undone independently or all together.
+type TransactionType string
+
+const (
+	// TransactionPerSnap undoes only the snap that failed; this is
+	// the default.
+	TransactionPerSnap TransactionType = "per-snap"
+	// TransactionAllSnaps undoes all the snaps of the operation when
+	// any of them fails.
+	TransactionAllSnaps TransactionType = "all-snaps"
+)
+
+// ValidateTransaction checks that the given transaction type is known.
+func ValidateTransaction(transaction TransactionType) error {
+	switch transaction {
+	case "", TransactionPerSnap, TransactionAllSnaps:
+		return nil
+	}
+	return fmt.Errorf("unknown transaction type %q", transaction)
 }
 
 // DevModeAllowed returns whether a snap can be installed with devmode confinement (either set or overridden)
@@ -32,6 +32,7 @@ import (
 	"gopkg.in/tomb.v2"
 
 	"github.com/snapcore/snapd/boot"
+	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/errtracker"
 	"github.com/snapcore/snapd/i18n"
 	"github.com/snapcore/snapd/logger"
@@ -79,6 +80,16 @@ var (
 	errtrackerReport = errtracker.Report
 )
 
+// Kinds of the events emitted by the snap manager, see state.Event.
+const (
+	// EventSnapInstalled is emitted when a revision of a snap was
+	// made the current one, with "snap" and "revision" data
+	EventSnapInstalled = "snap-installed"
+	// EventSnapRemoved is emitted when the last revision of a snap
+	// was removed, with "snap" data
+	EventSnapRemoved = "snap-removed"
+)
+
 // SnapManager is responsible for the installation and removal of snaps.
 type SnapManager struct {
 	state   *state.State
@@ -89,7 +100,10 @@ type SnapManager struct {
 
 	lastUbuntuCoreTransitionAttempt time.Time
 
-	runner *state.TaskRunner
+	lastPartialDownloadsPrune time.Time
+
+	runner    *state.TaskRunner
+	downloads *downloadScheduler
 }
 
 // SnapSetup holds the necessary snap details to perform most snap manager tasks.
@@ -367,6 +381,8 @@ func Manager(st *state.State) (*SnapManager, error) {
 		backend: backend.Backend{},
 		runner:  runner,
 
+		downloads: newDownloadScheduler(st),
+
 		refreshRandomness: time.Duration(rand.Int63n(int64(defaultRefreshRandomness))),
 	}
 	logger.Debugf("snapmgr refresh randomness %s", m.refreshRandomness)
@@ -386,6 +402,7 @@ func Manager(st *state.State) (*SnapManager, error) {
 	runner.AddHandler("link-snap", m.doLinkSnap, m.undoLinkSnap)
 	runner.AddHandler("start-snap-services", m.startSnapServices, m.stopSnapServices)
 	runner.AddHandler("switch-snap-channel", m.doSwitchSnapChannel, nil)
+	runner.AddHandler("wait-approval", m.doWaitApproval, nil)
 
 	// FIXME: drop the task entirely after a while
 	// (having this wart here avoids yet-another-patch)
@@ -405,7 +422,11 @@ func Manager(st *state.State) (*SnapManager, error) {
 	runner.AddHandler("remove-aliases", m.doRemoveAliases, m.doSetupAliases)
 
 	// control serialisation
-	runner.SetBlocked(m.blockedTask)
+	runner.AddBlocked(blockedAliasTask)
+	// bound how many snaps are downloaded at once
+	runner.SetConcurrencyLimit("download-snap", func() int {
+		return maxParallelDownloads(st)
+	})
 
 	// test handlers
 	runner.AddHandler("fake-install-snap", func(t *state.Task, _ *tomb.Tomb) error {
@@ -423,8 +444,9 @@ func diskAliasTask(t *state.Task) bool {
 	return kind == "setup-aliases" || kind == "remove-aliases" || kind == "alias"
 }
 
-func (m *SnapManager) blockedTask(cand *state.Task, running []*state.Task) bool {
-	// aliases are global, serialize tasks operating on them
+// blockedAliasTask serializes the tasks operating on aliases, as they
+// are global.
+func blockedAliasTask(cand *state.Task, running []*state.Task) bool {
 	if diskAliasTask(cand) {
 		for _, t := range running {
 			if diskAliasTask(t) {
@@ -494,12 +516,17 @@ func (m *SnapManager) ensureRefreshes() error {
 	m.lastRefreshAttempt = time.Now()
 	updated, tasksets, err := AutoRefresh(m.state)
 	if err != nil {
+		m.state.Warnf("cannot auto-refresh snaps: %v", err)
 		return err
 	}
 
 	// Do setLastRefresh() only if the store (in AutoRefresh) gave
 	// us no error.
 	setLastRefresh(m.state)
+	// the store replied, so the network is up
+	if len(m.state.Resume(state.WaitNetwork)) > 0 {
+		m.state.EnsureBefore(0)
+	}
 
 	var msg string
 	switch len(updated) {
@@ -518,6 +545,8 @@ func (m *SnapManager) ensureRefreshes() error {
 	}
 
 	chg := m.state.NewChange("auto-refresh", msg)
+	// let interactive requests go ahead of it
+	chg.SetPriority(state.LowPriority)
 	for _, ts := range tasksets {
 		chg.AddAll(ts)
 	}
@@ -566,6 +595,27 @@ func (m *SnapManager) ensureForceDevmodeDropsDevmodeFromState() error {
 	return nil
 }
 
+var (
+	partialDownloadsPruneInterval = 24 * time.Hour
+	partialDownloadsMaxAge        = 7 * 24 * time.Hour
+)
+
+// ensurePartialDownloadsPruned removes, once a day, the partial
+// downloads that were left behind by interrupted downloads and not
+// resumed for a week.
+func (m *SnapManager) ensurePartialDownloadsPruned() error {
+	now := time.Now()
+	if now.Sub(m.lastPartialDownloadsPrune) < partialDownloadsPruneInterval {
+		return nil
+	}
+	m.lastPartialDownloadsPrune = now
+
+	if err := store.PrunePartialDownloads(dirs.SnapBlobDir, partialDownloadsMaxAge); err != nil {
+		logger.Noticef("Cannot prune partial downloads: %v", err)
+	}
+	return nil
+}
+
 // ensureUbuntuCoreTransition will migrate systems that use "ubuntu-core"
 // to the new "core" snap
 func (m *SnapManager) ensureUbuntuCoreTransition() error {
@@ -630,6 +680,7 @@ func (m *SnapManager) Ensure() error {
 		m.ensureForceDevmodeDropsDevmodeFromState(),
 		m.ensureUbuntuCoreTransition(),
 		m.ensureRefreshes(),
+		m.ensurePartialDownloadsPruned(),
 	}
 
 	m.runner.Ensure()
@@ -679,6 +730,12 @@ func TaskSnapSetup(t *state.Task) (*SnapSetup, error) {
 	return &snapsup, nil
 }
 
+// taskLogger returns a logger entry to log messages about the given
+// task, and the snap it operates on, with.
+func taskLogger(t *state.Task, snapsup *SnapSetup) *logger.Entry {
+	return logger.WithFields(t.LogFields()).WithFields(logger.Fields{"snap": snapsup.Name()})
+}
+
 func snapSetupAndState(t *state.Task) (*SnapSetup, *SnapState, error) {
 	snapsup, err := TaskSnapSetup(t)
 	if err != nil {
@@ -788,9 +845,9 @@ func (m *SnapManager) undoPrepareSnap(t *state.Task, _ *tomb.Tomb) error {
 	oopsid, err := errtrackerReport(snapsup.SideInfo.RealName, strings.Join(logMsg, "\n"), strings.Join(dupSig, "\n"), extra)
 	st.Lock()
 	if err == nil {
-		logger.Noticef("Reported problem as %s", oopsid)
+		taskLogger(t, snapsup).Noticef("Reported problem as %s", oopsid)
 	} else {
-		logger.Debugf("Cannot report problem: %s", err)
+		taskLogger(t, snapsup).Debugf("Cannot report problem: %s", err)
 	}
 
 	return nil
@@ -805,11 +862,16 @@ func (m *SnapManager) doDownloadSnap(t *state.Task, tomb *tomb.Tomb) error {
 		return err
 	}
 
-	meter := &TaskProgressAdapter{task: t}
+	meter := m.downloads.meter(t)
+	defer m.downloads.done(meter)
 
 	st.Lock()
 	theStore := Store(st)
 	user, err := userFromUserID(st, snapsup.UserID)
+	var dlOpts *store.DownloadOptions
+	if err == nil && !snapsup.NoRateLimit {
+		dlOpts = downloadOptions(st)
+	}
 	st.Unlock()
 	if err != nil {
 		return err
@@ -830,10 +892,10 @@ func (m *SnapManager) doDownloadSnap(t *state.Task, tomb *tomb.Tomb) error {
 		if err != nil {
 			return err
 		}
-		err = theStore.Download(tomb.Context(nil), snapsup.Name(), targetFn, &storeInfo.DownloadInfo, meter, user)
+		err = theStore.Download(tomb.Context(nil), snapsup.Name(), targetFn, &storeInfo.DownloadInfo, meter, user, dlOpts)
 		snapsup.SideInfo = &storeInfo.SideInfo
 	} else {
-		err = theStore.Download(tomb.Context(nil), snapsup.Name(), targetFn, snapsup.DownloadInfo, meter, user)
+		err = theStore.Download(tomb.Context(nil), snapsup.Name(), targetFn, snapsup.DownloadInfo, meter, user, dlOpts)
 	}
 	if err != nil {
 		return err
@@ -965,6 +1027,12 @@ func (m *SnapManager) doDiscardSnap(t *state.Task, _ *tomb.Tomb) error {
 	}
 	st.Lock()
 	Set(st, snapsup.Name(), snapst)
+	if len(snapst.Sequence) == 0 {
+		t.EmitEvent(&state.Event{
+			Kind: EventSnapRemoved,
+			Data: map[string]string{"snap": snapsup.Name()},
+		})
+	}
 	st.Unlock()
 	return nil
 }
@@ -1028,7 +1096,7 @@ func (m *SnapManager) doMountSnap(t *state.Task, _ *tomb.Tomb) error {
 
 	if snapsup.Flags.RemoveSnapPath {
 		if err := os.Remove(snapsup.SnapPath); err != nil {
-			logger.Noticef("Failed to cleanup %s: %s", snapsup.SnapPath, err)
+			taskLogger(t, snapsup).Noticef("Failed to cleanup %s: %s", snapsup.SnapPath, err)
 		}
 	}
 
@@ -1222,6 +1290,14 @@ func (m *SnapManager) doLinkSnap(t *state.Task, _ *tomb.Tomb) error {
 	// Make sure if state commits and snapst is mutated we won't be rerun
 	t.SetStatus(state.DoneStatus)
 
+	t.EmitEvent(&state.Event{
+		Kind: EventSnapInstalled,
+		Data: map[string]string{
+			"snap":     snapsup.Name(),
+			"revision": cand.Revision.String(),
+		},
+	})
+
 	// if we just installed a core snap, request a restart
 	// so that we switch executing its snapd
 	if release.OnClassic && newInfo.Type == snap.TypeOS {
@@ -1329,6 +1405,25 @@ func (m *SnapManager) undoLinkSnap(t *state.Task, _ *tomb.Tomb) error {
 	return nil
 }
 
+// doWaitApproval holds the change until it is approved, e.g. with:
+// $ snap approve <change-id>
+func (m *SnapManager) doWaitApproval(t *state.Task, _ *tomb.Tomb) error {
+	st := t.State()
+	st.Lock()
+	defer st.Unlock()
+
+	var requested bool
+	if err := t.Get("approval-requested", &requested); err != nil && err != state.ErrNoState {
+		return err
+	}
+	if requested {
+		// only approving the change resumes the task
+		return nil
+	}
+	t.Set("approval-requested", true)
+	return &state.Wait{On: state.WaitApproval, Reason: i18n.G("change approval")}
+}
+
 func (m *SnapManager) doSwitchSnapChannel(t *state.Task, _ *tomb.Tomb) error {
 	st := t.State()
 	st.Lock()
@@ -33,6 +33,7 @@ import (
 	. "gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/overlord/auth"
 	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/snapstate"
@@ -132,6 +133,7 @@ const (
 	unlinkBefore = 1 << iota
 	cleanupAfter
 	maybeCore
+	waitApproval
 )
 
 func taskKinds(tasks []*state.Task) []string {
@@ -150,6 +152,9 @@ func verifyInstallUpdateTasks(c *C, opts, discards int, ts *state.TaskSet, st *s
 		"validate-snap",
 		"mount-snap",
 	}
+	if opts&waitApproval != 0 {
+		expected = append(expected, "wait-approval")
+	}
 	if opts&unlinkBefore != 0 {
 		expected = append(expected,
 			"stop-snap-services",
@@ -400,7 +405,7 @@ func (s *snapmgrTestSuite) TestUpdateMany(c *C) {
 		SnapType: "app",
 	})
 
-	updates, tts, err := snapstate.UpdateMany(s.state, nil, 0)
+	updates, tts, err := snapstate.UpdateMany(s.state, nil, 0, nil)
 	c.Assert(err, IsNil)
 	c.Assert(tts, HasLen, 1)
 	c.Check(updates, DeepEquals, []string{"some-snap"})
@@ -410,6 +415,111 @@ func (s *snapmgrTestSuite) TestUpdateMany(c *C) {
 	c.Assert(s.state.TaskCount(), Equals, len(ts.Tasks()))
 }
 
+func (s *snapmgrTestSuite) setupTwoRefreshableSnaps() {
+	for _, name := range []string{"some-snap", "some-other-snap"} {
+		snapstate.Set(s.state, name, &snapstate.SnapState{
+			Active: true,
+			Sequence: []*snap.SideInfo{
+				{RealName: name, SnapID: name + "-id", Revision: snap.R(1)},
+			},
+			Current:  snap.R(1),
+			SnapType: "app",
+		})
+	}
+}
+
+func (s *snapmgrTestSuite) TestUpdateManyPerSnapLanes(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupTwoRefreshableSnaps()
+
+	updates, tts, err := snapstate.UpdateMany(s.state, nil, 0, &snapstate.Flags{Transaction: snapstate.TransactionPerSnap})
+	c.Assert(err, IsNil)
+	c.Check(updates, HasLen, 2)
+	c.Assert(tts, HasLen, 2)
+
+	lanes0 := tts[0].Tasks()[0].Lanes()
+	lanes1 := tts[1].Tasks()[0].Lanes()
+	c.Assert(lanes0, HasLen, 1)
+	c.Assert(lanes1, HasLen, 1)
+	c.Check(lanes0[0], Not(Equals), lanes1[0])
+}
+
+func (s *snapmgrTestSuite) TestUpdateManyNoRateLimit(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupTwoRefreshableSnaps()
+
+	_, tts, err := snapstate.UpdateMany(s.state, nil, 0, &snapstate.Flags{NoRateLimit: true})
+	c.Assert(err, IsNil)
+	c.Assert(tts, HasLen, 2)
+	for _, ts := range tts {
+		snapsup, err := snapstate.TaskSnapSetup(ts.Tasks()[0])
+		c.Assert(err, IsNil)
+		c.Check(snapsup.NoRateLimit, Equals, true)
+	}
+}
+
+func (s *snapmgrTestSuite) TestUpdateManyTransactionAllSnapsSharesLane(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupTwoRefreshableSnaps()
+
+	updates, tts, err := snapstate.UpdateMany(s.state, nil, 0, &snapstate.Flags{Transaction: snapstate.TransactionAllSnaps})
+	c.Assert(err, IsNil)
+	c.Check(updates, HasLen, 2)
+	c.Assert(tts, HasLen, 2)
+
+	lane := tts[0].Tasks()[0].Lanes()
+	c.Assert(lane, HasLen, 1)
+	for _, ts := range tts {
+		for _, t := range ts.Tasks() {
+			c.Check(t.Lanes(), DeepEquals, lane, Commentf(t.Summary()))
+		}
+	}
+}
+
+func (s *snapmgrTestSuite) TestUpdateManyTransactionAllSnapsUndoesAll(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupTwoRefreshableSnaps()
+
+	chg := s.state.NewChange("refresh", "refresh two snaps")
+	_, tts, err := snapstate.UpdateMany(s.state, nil, 0, &snapstate.Flags{Transaction: snapstate.TransactionAllSnaps})
+	c.Assert(err, IsNil)
+	c.Assert(tts, HasLen, 2)
+	for _, ts := range tts {
+		chg.AddAll(ts)
+	}
+
+	// make only the first refresh fail once it is done
+	tasks := tts[0].Tasks()
+	last := tasks[len(tasks)-1]
+	terr := s.state.NewTask("error-trigger", "provoking total undo")
+	terr.WaitFor(last)
+	terr.JoinLane(last.Lanes()[0])
+	chg.AddTask(terr)
+
+	s.state.Unlock()
+	defer s.snapmgr.Stop()
+	s.settle()
+	s.state.Lock()
+
+	c.Check(chg.Status(), Equals, state.ErrorStatus)
+	for _, name := range []string{"some-snap", "some-other-snap"} {
+		var snapst snapstate.SnapState
+		err := snapstate.Get(s.state, name, &snapst)
+		c.Assert(err, IsNil)
+		c.Check(snapst.Active, Equals, true, Commentf(name))
+		c.Check(snapst.Current, Equals, snap.R(1), Commentf(name))
+		c.Check(snapst.Sequence, HasLen, 1, Commentf(name))
+	}
+}
+
 func (s *snapmgrTestSuite) TestUpdateManyDevModeConfinementFiltering(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -423,7 +533,7 @@ func (s *snapmgrTestSuite) TestUpdateManyDevModeConfinementFiltering(c *C) {
 	})
 
 	// updated snap is devmode, updatemany doesn't update it
-	_, tts, _ := snapstate.UpdateMany(s.state, []string{"some-snap"}, s.user.ID)
+	_, tts, _ := snapstate.UpdateMany(s.state, []string{"some-snap"}, s.user.ID, nil)
 	// FIXME: UpdateMany will not error out in this case (daemon catches this case, with a weird error)
 	c.Assert(tts, HasLen, 0)
 }
@@ -441,7 +551,7 @@ func (s *snapmgrTestSuite) TestUpdateManyClassicConfinementFiltering(c *C) {
 	})
 
 	// if a snap installed without --classic gets a classic update it isn't installed
-	_, tts, _ := snapstate.UpdateMany(s.state, []string{"some-snap"}, s.user.ID)
+	_, tts, _ := snapstate.UpdateMany(s.state, []string{"some-snap"}, s.user.ID, nil)
 	// FIXME: UpdateMany will not error out in this case (daemon catches this case, with a weird error)
 	c.Assert(tts, HasLen, 0)
 }
@@ -460,7 +570,7 @@ func (s *snapmgrTestSuite) TestUpdateManyClassic(c *C) {
 	})
 
 	// snap installed with classic: refresh gets classic
-	_, tts, err := snapstate.UpdateMany(s.state, []string{"some-snap"}, s.user.ID)
+	_, tts, err := snapstate.UpdateMany(s.state, []string{"some-snap"}, s.user.ID, nil)
 	c.Assert(err, IsNil)
 	c.Assert(tts, HasLen, 1)
 }
@@ -479,7 +589,7 @@ func (s *snapmgrTestSuite) TestUpdateManyDevMode(c *C) {
 		SnapType: "app",
 	})
 
-	updates, _, err := snapstate.UpdateMany(s.state, []string{"some-snap"}, 0)
+	updates, _, err := snapstate.UpdateMany(s.state, []string{"some-snap"}, 0, nil)
 	c.Assert(err, IsNil)
 	c.Check(updates, HasLen, 1)
 }
@@ -498,7 +608,7 @@ func (s *snapmgrTestSuite) TestUpdateAllDevMode(c *C) {
 		SnapType: "app",
 	})
 
-	updates, _, err := snapstate.UpdateMany(s.state, nil, 0)
+	updates, _, err := snapstate.UpdateMany(s.state, nil, 0, nil)
 	c.Assert(err, IsNil)
 	c.Check(updates, HasLen, 0)
 }
@@ -527,7 +637,7 @@ func (s *snapmgrTestSuite) TestUpdateManyValidateRefreshes(c *C) {
 	// hook it up
 	snapstate.ValidateRefreshes = validateRefreshes
 
-	updates, tts, err := snapstate.UpdateMany(s.state, nil, 0)
+	updates, tts, err := snapstate.UpdateMany(s.state, nil, 0, nil)
 	c.Assert(err, IsNil)
 	c.Assert(tts, HasLen, 1)
 	c.Check(updates, DeepEquals, []string{"some-snap"})
@@ -559,13 +669,13 @@ func (s *snapmgrTestSuite) TestUpdateManyValidateRefreshesUnhappy(c *C) {
 	snapstate.ValidateRefreshes = validateRefreshes
 
 	// refresh all => no error
-	updates, tts, err := snapstate.UpdateMany(s.state, nil, 0)
+	updates, tts, err := snapstate.UpdateMany(s.state, nil, 0, nil)
 	c.Assert(err, IsNil)
 	c.Check(tts, HasLen, 0)
 	c.Check(updates, HasLen, 0)
 
 	// refresh some-snap => report error
-	updates, tts, err = snapstate.UpdateMany(s.state, []string{"some-snap"}, 0)
+	updates, tts, err = snapstate.UpdateMany(s.state, []string{"some-snap"}, 0, nil)
 	c.Assert(err, Equals, validateErr)
 	c.Check(tts, HasLen, 0)
 	c.Check(updates, HasLen, 0)
@@ -698,6 +808,34 @@ func (s *snapmgrTestSuite) TestDisableConflict(c *C) {
 	c.Assert(err, ErrorMatches, `snap "some-snap" has changes in progress`)
 }
 
+func (s *snapmgrTestSuite) TestScheduledChangeConflicts(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	snapstate.Set(s.state, "some-snap", &snapstate.SnapState{
+		Sequence: []*snap.SideInfo{
+			{RealName: "some-snap", Revision: snap.R(11)},
+		},
+		Current: snap.R(11),
+		Active:  true,
+	})
+
+	ts, err := snapstate.Disable(s.state, "some-snap")
+	c.Assert(err, IsNil)
+	chg := s.state.NewChange("disable", "...")
+	chg.AddAll(ts)
+	// scheduled for later, so not started yet
+	for _, t := range ts.Tasks() {
+		if len(t.WaitTasks()) == 0 {
+			t.At(time.Now().Add(time.Hour))
+		}
+	}
+	c.Assert(chg.Status(), Equals, state.DoStatus)
+
+	_, err = snapstate.Remove(s.state, "some-snap", snap.R(0))
+	c.Assert(err, ErrorMatches, `snap "some-snap" has changes in progress`)
+}
+
 func (s *snapmgrTestSuite) TestDoInstallChannelDefault(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -863,6 +1001,56 @@ func (s *snapmgrTestSuite) TestUpdateTasks(c *C) {
 	c.Check(snapsup.Channel, Equals, "some-channel")
 }
 
+func (s *snapmgrTestSuite) TestUpdateKernelTasksWaitForApproval(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	// a kernel as far as the snap state is concerned
+	snapstate.Set(s.state, "some-snap", &snapstate.SnapState{
+		Active:   true,
+		Sequence: []*snap.SideInfo{{RealName: "some-snap", SnapID: "some-snap-id", Revision: snap.R(7)}},
+		Current:  snap.R(7),
+		SnapType: "kernel",
+	})
+
+	ts, err := snapstate.Update(s.state, "some-snap", "some-channel", snap.R(0), s.user.ID, snapstate.Flags{})
+	c.Assert(err, IsNil)
+	verifyInstallUpdateTasks(c, unlinkBefore|cleanupAfter, 0, ts, s.state)
+
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "refresh.kernel-approval", true)
+	tr.Commit()
+
+	ts, err = snapstate.Update(s.state, "some-snap", "some-channel", snap.R(0), s.user.ID, snapstate.Flags{})
+	c.Assert(err, IsNil)
+	verifyInstallUpdateTasks(c, waitApproval|unlinkBefore|cleanupAfter, 0, ts, s.state)
+}
+
+func (s *snapmgrTestSuite) TestWaitApproval(c *C) {
+	s.state.Lock()
+	chg := s.state.NewChange("refresh", "...")
+	t := s.state.NewTask("wait-approval", "...")
+	chg.AddTask(t)
+	s.state.Unlock()
+
+	s.snapmgr.Ensure()
+	s.snapmgr.Wait()
+
+	s.state.Lock()
+	c.Check(t.Status(), Equals, state.WaitStatus)
+	c.Check(t.WaitOn(), Equals, state.WaitApproval)
+	c.Check(t.WaitReason(), Equals, "change approval")
+	chg.Resume(state.WaitApproval)
+	s.state.Unlock()
+
+	s.snapmgr.Ensure()
+	s.snapmgr.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(t.Status(), Equals, state.DoneStatus)
+}
+
 func (s *snapmgrTestSuite) TestUpdateDevModeConfinementFiltering(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -1091,6 +1279,65 @@ func (s *snapmgrTestSuite) TestRemoveConflict(c *C) {
 	c.Assert(err, ErrorMatches, `snap "some-snap" has changes in progress`)
 }
 
+func (s *snapmgrTestSuite) TestInstallRateLimited(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	tr := config.NewTransaction(s.state)
+	c.Assert(tr.Set("core", "refresh.rate-limit", 1024), IsNil)
+	tr.Commit()
+
+	chg := s.state.NewChange("install", "install a snap")
+	ts, err := snapstate.Install(s.state, "some-snap", "some-channel", snap.R(42), 0, snapstate.Flags{})
+	c.Assert(err, IsNil)
+	chg.AddAll(ts)
+
+	chg = s.state.NewChange("install", "install a snap")
+	ts, err = snapstate.Install(s.state, "some-other-snap", "some-channel", snap.R(42), 0, snapstate.Flags{NoRateLimit: true})
+	c.Assert(err, IsNil)
+	chg.AddAll(ts)
+
+	s.state.Unlock()
+	defer s.snapmgr.Stop()
+	s.settle()
+	s.state.Lock()
+
+	c.Assert(s.fakeStore.downloads, HasLen, 2)
+	for _, dl := range s.fakeStore.downloads {
+		switch dl.name {
+		case "some-snap":
+			c.Check(dl.rateLimit, Equals, int64(1024))
+		case "some-other-snap":
+			c.Check(dl.rateLimit, Equals, int64(0))
+		default:
+			c.Errorf("unexpected download of %q", dl.name)
+		}
+	}
+}
+
+func (s *snapmgrTestSuite) TestInstallInvalidRateLimitIgnored(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	tr := config.NewTransaction(s.state)
+	c.Assert(tr.Set("core", "refresh.rate-limit", "1M"), IsNil)
+	tr.Commit()
+
+	chg := s.state.NewChange("install", "install a snap")
+	ts, err := snapstate.Install(s.state, "some-snap", "some-channel", snap.R(42), 0, snapstate.Flags{})
+	c.Assert(err, IsNil)
+	chg.AddAll(ts)
+
+	s.state.Unlock()
+	defer s.snapmgr.Stop()
+	s.settle()
+	s.state.Lock()
+
+	c.Check(chg.Status(), Equals, state.DoneStatus)
+	c.Assert(s.fakeStore.downloads, HasLen, 1)
+	c.Check(s.fakeStore.downloads[0].rateLimit, Equals, int64(0))
+}
+
 func (s *snapmgrTestSuite) TestInstallRunThrough(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -1878,6 +2125,188 @@ func (s *snapmgrTestSuite) TestUpdateSameRevisionSwitchChannelRunThrough(c *C) {
 	})
 }
 
+func (s *snapmgrTestSuite) setupSwitchableSnap(name string) {
+	si := snap.SideInfo{
+		RealName: name,
+		SnapID:   "some-snap-id",
+		Channel:  "stable",
+		Revision: snap.R(7),
+	}
+	snapstate.Set(s.state, name, &snapstate.SnapState{
+		Active:   true,
+		Sequence: []*snap.SideInfo{&si},
+		Channel:  "stable",
+		Current:  si.Revision,
+	})
+}
+
+func (s *snapmgrTestSuite) TestSwitchTasks(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupSwitchableSnap("some-snap")
+
+	ts, err := snapstate.Switch(s.state, "some-snap", "edge", s.user.ID)
+	c.Assert(err, IsNil)
+	c.Assert(ts.Tasks(), HasLen, 1)
+	task := ts.Tasks()[0]
+	c.Check(task.Kind(), Equals, "switch-snap-channel")
+	c.Check(task.Summary(), Equals, `Switch snap "some-snap" from stable to edge`)
+
+	// only the channel map was asked for
+	c.Check(s.fakeBackend.ops, DeepEquals, fakeOps{
+		{op: "storesvc-snap", name: "some-snap", revno: snap.R(11)},
+	})
+}
+
+func (s *snapmgrTestSuite) TestSwitchRunThrough(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupSwitchableSnap("some-snap")
+
+	ts, err := snapstate.Switch(s.state, "some-snap", "edge", s.user.ID)
+	c.Assert(err, IsNil)
+	chg := s.state.NewChange("switch-snap", "switch snap to edge")
+	chg.AddAll(ts)
+
+	s.state.Unlock()
+	defer s.snapmgr.Stop()
+	s.settle()
+	s.state.Lock()
+
+	c.Assert(chg.Err(), IsNil)
+	c.Assert(chg.IsReady(), Equals, true)
+
+	// nothing was downloaded or linked
+	c.Check(s.fakeBackend.ops.Ops(), DeepEquals, []string{"storesvc-snap"})
+
+	var snapst snapstate.SnapState
+	err = snapstate.Get(s.state, "some-snap", &snapst)
+	c.Assert(err, IsNil)
+	c.Check(snapst.Channel, Equals, "edge")
+	c.Check(snapst.Current, Equals, snap.R(7))
+	// the revision we have still came from stable
+	c.Check(snapst.Sequence, DeepEquals, []*snap.SideInfo{{
+		RealName: "some-snap",
+		SnapID:   "some-snap-id",
+		Channel:  "stable",
+		Revision: snap.R(7),
+	}})
+}
+
+func (s *snapmgrTestSuite) TestSwitchUnknownChannel(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupSwitchableSnap("some-snap")
+
+	_, err := snapstate.Switch(s.state, "some-snap", "no-such-channel", s.user.ID)
+	c.Assert(err, ErrorMatches, `cannot switch snap "some-snap" to channel "no-such-channel": no such channel`)
+}
+
+func (s *snapmgrTestSuite) TestSwitchTrackFallback(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupSwitchableSnap("some-snap")
+
+	// 2.x/stable is closed, but 2.x/candidate is open
+	ts, err := snapstate.Switch(s.state, "some-snap", "2.x", s.user.ID)
+	c.Assert(err, IsNil)
+	c.Check(ts.Tasks(), HasLen, 1)
+
+	_, err = snapstate.Switch(s.state, "some-snap", "2.x/edge", s.user.ID)
+	c.Assert(err, ErrorMatches, `cannot switch snap "some-snap" to channel "2.x/edge": no such channel`)
+
+	_, err = snapstate.Switch(s.state, "some-snap", "2.x/foo", s.user.ID)
+	c.Assert(err, ErrorMatches, `cannot switch snap "some-snap": invalid risk in channel name "2.x/foo": "foo"`)
+}
+
+func (s *snapmgrTestSuite) TestSwitchTrackNotReported(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupSwitchableSnap("snap-without-tracks")
+
+	// the store did not report the other tracks, so they cannot be
+	// checked
+	ts, err := snapstate.Switch(s.state, "snap-without-tracks", "2.x/edge", s.user.ID)
+	c.Assert(err, IsNil)
+	c.Check(ts.Tasks(), HasLen, 1)
+}
+
+func (s *snapmgrTestSuite) TestSwitchNotInStore(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupSwitchableSnap("snap-not-in-store")
+
+	_, err := snapstate.Switch(s.state, "snap-not-in-store", "edge", s.user.ID)
+	c.Assert(err, ErrorMatches, `cannot switch snap "snap-not-in-store": snap not found in the store`)
+}
+
+func (s *snapmgrTestSuite) TestSwitchStoreUnreachable(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupSwitchableSnap("snap-store-unreachable")
+
+	// without the store the channel cannot be checked, but the
+	// switch goes ahead
+	ts, err := snapstate.Switch(s.state, "snap-store-unreachable", "no-such-channel", s.user.ID)
+	c.Assert(err, IsNil)
+	c.Check(ts.Tasks(), HasLen, 1)
+}
+
+func (s *snapmgrTestSuite) TestSwitchNotInstalled(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	_, err := snapstate.Switch(s.state, "some-snap", "edge", s.user.ID)
+	c.Assert(err, ErrorMatches, `snap "some-snap" is not installed`)
+}
+
+func (s *snapmgrTestSuite) TestSwitchLocalSnap(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	si := snap.SideInfo{RealName: "some-snap", Revision: snap.R(-1)}
+	snapstate.Set(s.state, "some-snap", &snapstate.SnapState{
+		Active:   true,
+		Sequence: []*snap.SideInfo{&si},
+		Current:  si.Revision,
+	})
+
+	_, err := snapstate.Switch(s.state, "some-snap", "edge", s.user.ID)
+	c.Assert(err, ErrorMatches, `cannot switch channel of local snap "some-snap"`)
+}
+
+func (s *snapmgrTestSuite) TestSwitchEmptyChannel(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupSwitchableSnap("some-snap")
+
+	_, err := snapstate.Switch(s.state, "some-snap", "", s.user.ID)
+	c.Assert(err, ErrorMatches, `cannot switch snap "some-snap" to an empty channel`)
+}
+
+func (s *snapmgrTestSuite) TestSwitchConflict(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setupSwitchableSnap("some-snap")
+
+	ts, err := snapstate.Switch(s.state, "some-snap", "edge", s.user.ID)
+	c.Assert(err, IsNil)
+	chg := s.state.NewChange("switch-snap", "switch snap to edge")
+	chg.AddAll(ts)
+
+	_, err = snapstate.Switch(s.state, "some-snap", "channel-for-7", s.user.ID)
+	c.Assert(err, ErrorMatches, `snap "some-snap" has changes in progress`)
+}
+
 func (s *snapmgrTestSuite) TestUpdateValidateRefreshesSaysNo(c *C) {
 	si := snap.SideInfo{
 		RealName: "some-snap",
@@ -2003,7 +2432,7 @@ func (s *snapmgrTestSuite) TestMultiUpdateBlockedRevision(c *C) {
 		Current:  si7.Revision,
 	})
 
-	updates, _, err := snapstate.UpdateMany(s.state, []string{"some-snap"}, s.user.ID)
+	updates, _, err := snapstate.UpdateMany(s.state, []string{"some-snap"}, s.user.ID, nil)
 	c.Assert(err, IsNil)
 	c.Check(updates, DeepEquals, []string{"some-snap"})
 
@@ -2041,7 +2470,7 @@ func (s *snapmgrTestSuite) TestAllUpdateBlockedRevision(c *C) {
 		Current:  si7.Revision,
 	})
 
-	updates, _, err := snapstate.UpdateMany(s.state, nil, s.user.ID)
+	updates, _, err := snapstate.UpdateMany(s.state, nil, s.user.ID, nil)
 	c.Check(err, IsNil)
 	c.Check(updates, HasLen, 0)
 
@@ -2136,7 +2565,7 @@ func (s *snapmgrTestSuite) TestUpdateManyAutoAliasesScenarios(c *C) {
 		}
 		s.state.Set("aliases", aliases)
 
-		updates, tts, err := snapstate.UpdateMany(s.state, scenario.names, s.user.ID)
+		updates, tts, err := snapstate.UpdateMany(s.state, scenario.names, s.user.ID, nil)
 		c.Check(err, IsNil)
 
 		new, retiring, err := snapstate.AutoAliasesDelta(s.state, []string{"some-snap", "other-snap"})
@@ -2679,6 +3108,48 @@ version: 1.0`)
 	c.Assert(snapst.Required, Equals, true)
 }
 
+func (s *snapmgrTestSuite) TestInstallRemoveEmitEvents(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	var events []*state.Event
+	s.state.AddEventHandler(func(ev *state.Event) {
+		if ev.Kind == snapstate.EventSnapInstalled || ev.Kind == snapstate.EventSnapRemoved {
+			events = append(events, ev)
+		}
+	})
+
+	chg := s.state.NewChange("install", "install a snap")
+	ts, err := snapstate.Install(s.state, "some-snap", "some-channel", snap.R(42), s.user.ID, snapstate.Flags{})
+	c.Assert(err, IsNil)
+	chg.AddAll(ts)
+
+	s.state.Unlock()
+	s.settle()
+	s.state.Lock()
+
+	c.Assert(chg.Err(), IsNil)
+	c.Assert(events, HasLen, 1)
+	c.Check(events[0].Kind, Equals, snapstate.EventSnapInstalled)
+	c.Check(events[0].ChangeID, Equals, chg.ID())
+	c.Check(events[0].Data, DeepEquals, map[string]string{"snap": "some-snap", "revision": "42"})
+
+	chg = s.state.NewChange("remove", "remove a snap")
+	ts, err = snapstate.Remove(s.state, "some-snap", snap.R(0))
+	c.Assert(err, IsNil)
+	chg.AddAll(ts)
+
+	s.state.Unlock()
+	defer s.snapmgr.Stop()
+	s.settle()
+	s.state.Lock()
+
+	c.Assert(chg.Err(), IsNil)
+	c.Assert(events, HasLen, 2)
+	c.Check(events[1].Kind, Equals, snapstate.EventSnapRemoved)
+	c.Check(events[1].Data, DeepEquals, map[string]string{"snap": "some-snap"})
+}
+
 func (s *snapmgrTestSuite) TestRemoveRunThrough(c *C) {
 	si := snap.SideInfo{
 		RealName: "some-snap",
@@ -4095,6 +4566,22 @@ func (s *snapmgrTestSuite) verifyRefreshLast(c *C) {
 	c.Check(time.Now().Year(), Equals, lastRefresh.Year())
 }
 
+func (s *snapmgrTestSuite) TestEnsurePrunesPartialDownloads(c *C) {
+	c.Assert(os.MkdirAll(dirs.SnapBlobDir, 0755), IsNil)
+	old := filepath.Join(dirs.SnapBlobDir, "old.partial")
+	recent := filepath.Join(dirs.SnapBlobDir, "recent.partial")
+	for _, p := range []string{old, recent} {
+		c.Assert(ioutil.WriteFile(p, nil, 0644), IsNil)
+	}
+	mtime := time.Now().Add(-8 * 24 * time.Hour)
+	c.Assert(os.Chtimes(old, mtime, mtime), IsNil)
+
+	s.snapmgr.Ensure()
+
+	c.Check(osutil.FileExists(old), Equals, false)
+	c.Check(osutil.FileExists(recent), Equals, true)
+}
+
 func (s *snapmgrTestSuite) TestEnsureRefreshesNoUpdate(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -4143,6 +4630,7 @@ func (s *snapmgrTestSuite) TestEnsureRefreshesWithUpdate(c *C) {
 	chg := s.state.Changes()[0]
 	c.Check(chg.Kind(), Equals, "auto-refresh")
 	c.Check(chg.IsReady(), Equals, false)
+	c.Check(chg.Priority(), Equals, state.LowPriority)
 	s.verifyRefreshLast(c)
 }
 
@@ -4286,6 +4774,11 @@ func (s *snapmgrTestSuite) TestEnsureRefreshesWithUpdateStoreError(c *C) {
 	c.Check(s.state.Changes(), HasLen, 0)
 	c.Check(autoRefreshAssertionsCalled, Equals, 1)
 
+	// the failure is surfaced as a warning
+	warns := s.state.AllWarnings()
+	c.Assert(warns, HasLen, 1)
+	c.Check(warns[0].Message(), Equals, "cannot auto-refresh snaps: simulate store error")
+
 	// run Ensure() again and check that AutoRefresh() did not run
 	// again because to test that lastRefreshAttempt backoff is working
 	s.state.Unlock()
@@ -5310,7 +5803,7 @@ func (s *snapmgrTestSuite) TestInstallMany(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
 
-	installed, tts, err := snapstate.InstallMany(s.state, []string{"one", "two"}, 0)
+	installed, tts, err := snapstate.InstallMany(s.state, []string{"one", "two"}, 0, nil)
 	c.Assert(err, IsNil)
 	c.Assert(tts, HasLen, 2)
 	c.Check(installed, DeepEquals, []string{"one", "two"})
@@ -5320,6 +5813,40 @@ func (s *snapmgrTestSuite) TestInstallMany(c *C) {
 	}
 }
 
+func (s *snapmgrTestSuite) TestInstallManyTransactionAllSnapsUndoesAll(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	chg := s.state.NewChange("install", "install two snaps")
+	installed, tts, err := snapstate.InstallMany(s.state, []string{"one", "two"}, 0, &snapstate.Flags{Transaction: snapstate.TransactionAllSnaps})
+	c.Assert(err, IsNil)
+	c.Check(installed, DeepEquals, []string{"one", "two"})
+	c.Assert(tts, HasLen, 2)
+	for _, ts := range tts {
+		chg.AddAll(ts)
+	}
+	c.Check(tts[0].Tasks()[0].Lanes(), DeepEquals, tts[1].Tasks()[0].Lanes())
+
+	tasks := tts[1].Tasks()
+	last := tasks[len(tasks)-1]
+	terr := s.state.NewTask("error-trigger", "provoking total undo")
+	terr.WaitFor(last)
+	terr.JoinLane(last.Lanes()[0])
+	chg.AddTask(terr)
+
+	s.state.Unlock()
+	defer s.snapmgr.Stop()
+	s.settle()
+	s.state.Lock()
+
+	c.Check(chg.Status(), Equals, state.ErrorStatus)
+	for _, name := range []string{"one", "two"} {
+		var snapst snapstate.SnapState
+		err := snapstate.Get(s.state, name, &snapst)
+		c.Check(err, Equals, state.ErrNoState, Commentf(name))
+	}
+}
+
 func (s *snapmgrTestSuite) TestRemoveMany(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
@@ -30,6 +30,7 @@ import (
 	"github.com/snapcore/snapd/i18n/dumb"
 	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/release"
 	"github.com/snapcore/snapd/snap"
@@ -105,6 +106,19 @@ func doInstall(st *state.State, snapst *SnapState, snapsup *SnapSetup, flags int
 		prev = mount
 	}
 
+	// the kernel is only refreshed once approved, if so configured
+	if typ, err := snapst.Type(); err == nil && typ == snap.TypeKernel {
+		approval, err := kernelRefreshNeedsApproval(st)
+		if err != nil {
+			return nil, err
+		}
+		if approval {
+			waitApproval := st.NewTask("wait-approval", fmt.Sprintf(i18n.G("Wait for approval to refresh kernel %q%s"), snapsup.Name(), revisionStr))
+			addTask(waitApproval)
+			prev = waitApproval
+		}
+	}
+
 	if snapst.Active {
 		// unlink-current-snap (will stop services for copy-data)
 		stop := st.NewTask("stop-snap-services", fmt.Sprintf(i18n.G("Stop snap %q services"), snapsup.Name()))
@@ -236,6 +250,18 @@ func doInstall(st *state.State, snapst *SnapState, snapsup *SnapSetup, flags int
 	return installSet, nil
 }
 
+// kernelRefreshNeedsApproval returns whether refreshing the kernel
+// must wait for the change to be approved, as set with:
+// $ snap set core refresh.kernel-approval=true
+func kernelRefreshNeedsApproval(st *state.State) (bool, error) {
+	var approval bool
+	tr := config.NewTransaction(st)
+	if err := tr.Get("core", "refresh.kernel-approval", &approval); err != nil && !config.IsNoOption(err) {
+		return false, err
+	}
+	return approval, nil
+}
+
 var Configure = func(st *state.State, snapName string, patch map[string]interface{}) *state.TaskSet {
 	panic("internal error: snapstate.Configure is unset")
 }
@@ -244,6 +270,10 @@ var Configure = func(st *state.State, snapName string, patch map[string]interfac
 // changes that alters the snap (like remove, install, refresh) are in
 // progress. It also ensures that snapst (if not nil) did not get
 // modified. If a conflict is detected an error is returned.
+//
+// Changes scheduled to start later (see state.Task.At) conflict as
+// well before they start, as their tasks were computed against the
+// current state of the snap.
 func CheckChangeConflict(st *state.State, snapName string, snapst *SnapState) error {
 	for _, chg := range st.Changes() {
 		if chg.Status().Ready() {
@@ -257,7 +287,7 @@ func CheckChangeConflict(st *state.State, snapName string, snapst *SnapState) er
 	for _, task := range st.Tasks() {
 		k := task.Kind()
 		chg := task.Change()
-		if (k == "link-snap" || k == "unlink-snap" || k == "alias") && (chg == nil || !chg.Status().Ready()) {
+		if (k == "link-snap" || k == "unlink-snap" || k == "alias" || k == "switch-snap-channel") && (chg == nil || !chg.Status().Ready()) {
 			snapsup, err := TaskSnapSetup(task)
 			if err != nil {
 				return fmt.Errorf("internal error: cannot obtain snap setup from task: %s", task.Summary())
@@ -367,12 +397,19 @@ func Install(st *state.State, name, channel string, revision snap.Revision, user
 }
 
 // InstallMany installs everything from the given list of names.
+// If flags asks for an all-snaps transaction, the failure of any of
+// the snaps undoes all of them.
 // Note that the state must be locked by the caller.
-func InstallMany(st *state.State, names []string, userID int) ([]string, []*state.TaskSet, error) {
+func InstallMany(st *state.State, names []string, userID int, flags *Flags) ([]string, []*state.TaskSet, error) {
 	installed := make([]string, 0, len(names))
 	tasksets := make([]*state.TaskSet, 0, len(names))
+	transactionLane := newTransactionLane(st, flags)
+	var installFlags Flags
+	if flags != nil {
+		installFlags.NoRateLimit = flags.NoRateLimit
+	}
 	for _, name := range names {
-		ts, err := Install(st, name, "", snap.R(0), userID, Flags{})
+		ts, err := Install(st, name, "", snap.R(0), userID, installFlags)
 		// FIXME: is this expected behavior?
 		if _, ok := err.(*snap.AlreadyInstalledError); ok {
 			continue
@@ -380,6 +417,7 @@ func InstallMany(st *state.State, names []string, userID int) ([]string, []*stat
 		if err != nil {
 			return nil, nil, err
 		}
+		joinLane(st, ts, transactionLane)
 		installed = append(installed, name)
 		tasksets = append(tasksets, ts)
 	}
@@ -387,6 +425,25 @@ func InstallMany(st *state.State, names []string, userID int) ([]string, []*stat
 	return installed, tasksets, nil
 }
 
+// newTransactionLane returns the lane shared by all the task sets of
+// an all-snaps transaction, or 0 if each task set should get its own.
+func newTransactionLane(st *state.State, flags *Flags) int {
+	if flags == nil || flags.Transaction != TransactionAllSnaps {
+		return 0
+	}
+	return st.NewLane()
+}
+
+// joinLane makes ts join the given transaction lane, or a new lane of
+// its own if there is no transaction lane.
+func joinLane(st *state.State, ts *state.TaskSet, transactionLane int) {
+	if transactionLane == 0 {
+		ts.JoinLane(st.NewLane())
+		return
+	}
+	ts.JoinLane(transactionLane)
+}
+
 // contains determines whether the given string is contained in the
 // given list of strings, which must have been previously sorted using
 // sort.Strings.
@@ -477,8 +534,10 @@ var ValidateRefreshes func(st *state.State, refreshes []*snap.Info, userID int)
 
 // UpdateMany updates everything from the given list of names that the
 // store says is updateable. If the list is empty, update everything.
+// If flags asks for an all-snaps transaction, the failure of any of
+// the updates undoes all of them.
 // Note that the state must be locked by the caller.
-func UpdateMany(st *state.State, names []string, userID int) ([]string, []*state.TaskSet, error) {
+func UpdateMany(st *state.State, names []string, userID int, flags *Flags) ([]string, []*state.TaskSet, error) {
 	user, err := userFromUserID(st, userID)
 	if err != nil {
 		return nil, nil, err
@@ -507,11 +566,12 @@ func UpdateMany(st *state.State, names []string, userID int) ([]string, []*state
 
 	}
 
-	return doUpdate(st, names, updates, params, userID)
+	return doUpdate(st, names, updates, params, userID, flags)
 }
 
-func doUpdate(st *state.State, names []string, updates []*snap.Info, params func(*snap.Info) (channel string, flags Flags, snapst *SnapState), userID int) ([]string, []*state.TaskSet, error) {
+func doUpdate(st *state.State, names []string, updates []*snap.Info, params func(*snap.Info) (channel string, flags Flags, snapst *SnapState), userID int, globalFlags *Flags) ([]string, []*state.TaskSet, error) {
 	tasksets := make([]*state.TaskSet, 0, len(updates))
+	transactionLane := newTransactionLane(st, globalFlags)
 
 	refreshAll := len(names) == 0
 	var nameSet map[string]bool
@@ -540,6 +600,9 @@ func doUpdate(st *state.State, names []string, updates []*snap.Info, params func
 		if err != nil {
 			return nil, nil, err
 		}
+		if transactionLane != 0 {
+			retiredAutoAliasesTs.JoinLane(transactionLane)
+		}
 		tasksets = append(tasksets, retiredAutoAliasesTs)
 	}
 
@@ -562,6 +625,10 @@ func doUpdate(st *state.State, names []string, updates []*snap.Info, params func
 			return nil, nil, err
 		}
 
+		if globalFlags != nil && globalFlags.NoRateLimit {
+			flags.NoRateLimit = true
+		}
+
 		snapsup := &SnapSetup{
 			Channel:      channel,
 			UserID:       userID,
@@ -579,7 +646,7 @@ func doUpdate(st *state.State, names []string, updates []*snap.Info, params func
 			}
 			return nil, nil, err
 		}
-		ts.JoinLane(st.NewLane())
+		joinLane(st, ts, transactionLane)
 
 		scheduleUpdate(update.Name(), ts)
 		tasksets = append(tasksets, ts)
@@ -590,6 +657,9 @@ func doUpdate(st *state.State, names []string, updates []*snap.Info, params func
 		if err != nil {
 			return nil, nil, err
 		}
+		if transactionLane != 0 {
+			addAutoAliasesTs.JoinLane(transactionLane)
+		}
 		tasksets = append(tasksets, addAutoAliasesTs)
 	}
 
@@ -741,7 +811,7 @@ func Update(st *state.State, name, channel string, revision snap.Revision, userI
 		return channel, flags, &snapst
 	}
 
-	_, tts, err := doUpdate(st, []string{name}, updates, params, userID)
+	_, tts, err := doUpdate(st, []string{name}, updates, params, userID, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -778,6 +848,83 @@ func Update(st *state.State, name, channel string, revision snap.Revision, userI
 	return flat, nil
 }
 
+// Switch returns a set of tasks for changing the channel tracked by
+// the given snap, without refreshing it. The next refresh will
+// follow the new channel.
+// Note that the state must be locked by the caller.
+func Switch(st *state.State, name, channel string, userID int) (*state.TaskSet, error) {
+	if channel == "" {
+		return nil, fmt.Errorf("cannot switch snap %q to an empty channel", name)
+	}
+
+	var snapst SnapState
+	err := Get(st, name, &snapst)
+	if err != nil && err != state.ErrNoState {
+		return nil, err
+	}
+	if !snapst.HasCurrent() {
+		return nil, &snap.NotInstalledError{Snap: name}
+	}
+
+	if snapst.CurrentSideInfo().SnapID == "" {
+		return nil, fmt.Errorf("cannot switch channel of local snap %q", name)
+	}
+
+	if err := checkSwitchChannel(st, name, channel, userID); err != nil {
+		return nil, err
+	}
+
+	if err := CheckChangeConflict(st, name, &snapst); err != nil {
+		return nil, err
+	}
+
+	snapsup := &SnapSetup{
+		// only the tracked channel is switched, so the
+		// SideInfo.Channel of the current revision is left alone
+		SideInfo: &snap.SideInfo{RealName: name},
+		Channel:  channel,
+	}
+
+	switchSnap := st.NewTask("switch-snap-channel", fmt.Sprintf(i18n.G("Switch snap %q from %s to %s"), name, snapst.Channel, channel))
+	switchSnap.Set("snap-setup", &snapsup)
+
+	return state.NewTaskSet(switchSnap), nil
+}
+
+// checkSwitchChannel checks that channel is in the channel map the
+// store reports for the snap. If the store cannot be reached, or it
+// reports just the channels of the default track and channel is in
+// another one, the channel is accepted as is.
+func checkSwitchChannel(st *state.State, name, channel string, userID int) error {
+	// asking for no specific channel gets us the channel map
+	info, err := snapInfo(st, name, "", snap.R(0), userID)
+	if err == store.ErrSnapNotFound {
+		return fmt.Errorf("cannot switch snap %q: snap not found in the store", name)
+	}
+	if err != nil {
+		logger.Noticef("cannot check channel %q for snap %q, switching anyway: %v", channel, name, err)
+		return nil
+	}
+	if len(info.Channels) == 0 {
+		return nil
+	}
+	if _, ok := info.Channels[channel]; ok {
+		return nil
+	}
+	// a closed channel is fine as long as one of its fallbacks is open
+	ch, err := snap.ParseChannel(channel)
+	if err != nil {
+		return fmt.Errorf("cannot switch snap %q: %v", name, err)
+	}
+	if len(info.Tracks) == 0 && ch.Track != snap.DefaultTrack {
+		return nil
+	}
+	if ch.Lookup(info.Channels) == nil {
+		return fmt.Errorf("cannot switch snap %q to channel %q: no such channel", name, channel)
+	}
+	return nil
+}
+
 func infoForUpdate(st *state.State, snapst *SnapState, name, channel string, revision snap.Revision, userID int, flags Flags) (*snap.Info, error) {
 	if revision.Unset() {
 		// good ol' refresh
@@ -1421,7 +1568,8 @@ var AutoRefreshAssertions func(st *state.State, userID int) error
 
 // AutoRefresh is the wrapper that will do a refresh of all the installed
 // snaps on the system. In addition to that it will also refresh important
-// assertions.
+// assertions. The refreshes are grouped as configured by the
+// refresh.transaction core option.
 func AutoRefresh(st *state.State) ([]string, []*state.TaskSet, error) {
 	userID := 0
 
@@ -1431,7 +1579,16 @@ func AutoRefresh(st *state.State) ([]string, []*state.TaskSet, error) {
 		}
 	}
 
-	return UpdateMany(st, nil, userID)
+	var transaction TransactionType
+	tr := config.NewTransaction(st)
+	if err := tr.Get("core", "refresh.transaction", &transaction); err != nil && !config.IsNoOption(err) {
+		return nil, nil, err
+	}
+	if err := ValidateTransaction(transaction); err != nil {
+		return nil, nil, fmt.Errorf("cannot use refresh.transaction: %v", err)
+	}
+
+	return UpdateMany(st, nil, userID, &Flags{Transaction: transaction})
 }
 
 // CoreInfo finds the current OS snap's info. If both
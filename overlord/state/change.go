@@ -25,6 +25,8 @@ import (
 	"fmt"
 	"strings"
 	"time"
+
+	"github.com/snapcore/snapd/logger"
 )
 
 // Status is used for status values for changes and tasks.
@@ -65,6 +67,10 @@ const (
 	// ErrorStatus means the change or task has errored out while running or being undone.
 	ErrorStatus Status = 9
 
+	// WaitStatus means the task is waiting for a named event, such as a
+	// system restart or an approval, before being run again. See Task.SetToWait.
+	WaitStatus Status = 10
+
 	nStatuses = iota
 )
 
@@ -100,6 +106,8 @@ func (s Status) String() string {
 		return "Hold"
 	case ErrorStatus:
 		return "Error"
+	case WaitStatus:
+		return "Wait"
 	}
 	panic(fmt.Sprintf("internal error: unknown task status code: %d", s))
 }
@@ -126,6 +134,13 @@ type Change struct {
 	lanes   int
 	ready   chan struct{}
 
+	// priority orders the tasks of the change against the ones of
+	// other changes when they compete to run
+	priority int
+
+	// lastEmittedStatus is the status last reported with an event
+	lastEmittedStatus Status
+
 	spawnTime time.Time
 	readyTime time.Time
 }
@@ -159,6 +174,8 @@ type marshalledChange struct {
 	TaskIDs []string                    `json:"task-ids,omitempty"`
 	Lanes   int                         `json:"lanes,omitempty"`
 
+	Priority int `json:"priority,omitempty"`
+
 	SpawnTime time.Time  `json:"spawn-time"`
 	ReadyTime *time.Time `json:"ready-time,omitempty"`
 }
@@ -180,6 +197,8 @@ func (c *Change) MarshalJSON() ([]byte, error) {
 		TaskIDs: c.taskIDs,
 		Lanes:   c.lanes,
 
+		Priority: c.priority,
+
 		SpawnTime: c.spawnTime,
 		ReadyTime: readyTime,
 	})
@@ -207,6 +226,7 @@ func (c *Change) UnmarshalJSON(data []byte) error {
 	c.data = custData
 	c.taskIDs = unmarshalled.TaskIDs
 	c.lanes = unmarshalled.Lanes
+	c.priority = unmarshalled.Priority
 	c.ready = make(chan struct{})
 	c.spawnTime = unmarshalled.SpawnTime
 	if unmarshalled.ReadyTime != nil {
@@ -232,15 +252,46 @@ func (c *Change) Kind() string {
 	return c.kind
 }
 
+// LogFields returns the fields identifying the change, to log messages
+// about it with.
+func (c *Change) LogFields() logger.Fields {
+	return logger.Fields{
+		"change-id":   c.id,
+		"change-kind": c.kind,
+	}
+}
+
 // Summary returns a summary describing what the change is about.
 func (c *Change) Summary() string {
 	return c.summary
 }
 
+// Priorities of changes. When tasks of different changes compete to
+// run, the ones of the changes with the higher priority are started
+// first.
+const (
+	LowPriority     = -1
+	DefaultPriority = 0
+	HighPriority    = 1
+)
+
+// SetPriority sets the priority of the change, DefaultPriority if not
+// set.
+func (c *Change) SetPriority(priority int) {
+	c.writing()
+	c.priority = priority
+}
+
+// Priority returns the priority of the change.
+func (c *Change) Priority() int {
+	c.state.reading()
+	return c.priority
+}
+
 // Set associates value with key for future consulting by managers.
 // The provided value must properly marshal and unmarshal with encoding/json.
 func (c *Change) Set(key string, value interface{}) {
-	c.state.writing()
+	c.writing()
 	c.data.set(key, value)
 }
 
@@ -256,6 +307,7 @@ var statusOrder = []Status{
 	UndoingStatus,
 	UndoStatus,
 	DoingStatus,
+	WaitStatus,
 	DoStatus,
 	ErrorStatus,
 	UndoneStatus,
@@ -300,11 +352,30 @@ func (c *Change) Status() Status {
 
 // SetStatus sets the change status, overriding the default behavior (see Status method).
 func (c *Change) SetStatus(s Status) {
-	c.state.writing()
+	c.writing()
 	c.status = s
 	if s.Ready() {
 		c.markReady()
 	}
+	c.emitStatusIfChanged()
+}
+
+// emitStatusIfChanged emits a change status event if the status of the
+// change differs from the one reported last.
+func (c *Change) emitStatusIfChanged() {
+	if !c.state.hasEventHandlers() {
+		return
+	}
+	status := c.Status()
+	if status == c.lastEmittedStatus {
+		return
+	}
+	c.lastEmittedStatus = status
+	c.state.EmitEvent(&Event{
+		Kind:     EventChangeStatus,
+		ChangeID: c.id,
+		Status:   status,
+	})
 }
 
 func (c *Change) markReady() {
@@ -315,6 +386,13 @@ func (c *Change) markReady() {
 	}
 	if c.readyTime.IsZero() {
 		c.readyTime = timeNow()
+		status := c.Status()
+		changeDuration.Observe(c.readyTime.Sub(c.spawnTime).Seconds(), c.kind, status.String())
+		if status == ErrorStatus {
+			logger.WithFields(c.LogFields()).Noticef("Change %q failed", c.summary)
+		} else {
+			logger.WithFields(c.LogFields()).Debugf("Change %q finished with status %s", c.summary, status)
+		}
 	}
 }
 
@@ -341,6 +419,7 @@ func (c *Change) taskStatusChanged(t *Task, old, new Status) {
 	if c.IsReady() && !c.Status().Ready() {
 		panic(fmt.Errorf("change %s unexpectedly became unready (%s)", c.ID(), c.Status()))
 	}
+	c.state.dirty.addChange(c.id)
 	c.markReady()
 }
 
@@ -379,6 +458,7 @@ func (c *Change) taskCleanChanged() {
 			return
 		}
 	}
+	c.state.dirty.addChange(c.id)
 	c.clean = true
 }
 
@@ -451,21 +531,28 @@ func (c *Change) State() *State {
 	return c.state
 }
 
+// writing marks the change as modified, see State.writing.
+func (c *Change) writing() {
+	c.state.writing()
+	c.state.dirty.addChange(c.id)
+}
+
 // AddTask registers a task as required for the state change to
 // be accomplished.
 func (c *Change) AddTask(t *Task) {
-	c.state.writing()
+	c.writing()
 	if t.change != "" {
 		panic(fmt.Sprintf("internal error: cannot add one %q task to multiple changes", t.Kind()))
 	}
 	t.change = c.id
+	c.state.dirty.addTask(t.id)
 	c.taskIDs = addOnce(c.taskIDs, t.ID())
 }
 
 // AddAll registers all tasks in the set as required for the state
 // change to be accomplished.
 func (c *Change) AddAll(ts *TaskSet) {
-	c.state.writing()
+	c.writing()
 	for _, t := range ts.tasks {
 		c.AddTask(t)
 	}
@@ -477,10 +564,18 @@ func (c *Change) Tasks() []*Task {
 	return c.state.tasksIn(c.taskIDs)
 }
 
+// Resume moves the tasks of the change waiting for the named event
+// back to the status they had before, so that they are run again at
+// the next ensure pass. It returns the resumed tasks. See Task.SetToWait.
+func (c *Change) Resume(event string) []*Task {
+	c.writing()
+	return c.state.resume(c.Tasks(), event)
+}
+
 // Abort flags the change for cancellation, whether in progress or not.
 // Cancellation will proceed at the next ensure pass.
 func (c *Change) Abort() {
-	c.state.writing()
+	c.writing()
 	tasks := make([]*Task, len(c.taskIDs))
 	for i, tid := range c.taskIDs {
 		tasks[i] = c.state.tasks[tid]
@@ -492,7 +587,7 @@ func (c *Change) Abort() {
 // except for tasks that are also in a healthy lane (not aborted, and not waiting
 // on aborted).
 func (c *Change) AbortLanes(lanes []int) {
-	c.state.writing()
+	c.writing()
 	c.abortLanes(lanes, make(map[int]bool))
 }
 
@@ -508,6 +603,11 @@ NextChangeTask:
 		switch t.Status() {
 		case DoStatus, DoingStatus, DoneStatus:
 			live = true
+		case WaitStatus:
+			switch t.waitedStatus {
+			case DoStatus, DoingStatus:
+				live = true
+			}
 		}
 
 		for _, tlane := range t.Lanes() {
@@ -562,6 +662,14 @@ func (c *Change) abortTasks(tasks []*Task, abortedLanes map[int]bool) {
 		case DoneStatus:
 			// Already done so undo it.
 			t.SetStatus(UndoStatus)
+		case WaitStatus:
+			// Stop waiting unless already undoing.
+			switch t.waitedStatus {
+			case DoStatus:
+				t.SetStatus(HoldStatus)
+			case DoingStatus:
+				t.SetStatus(AbortStatus)
+			}
 		}
 
 		for _, lane := range t.Lanes() {
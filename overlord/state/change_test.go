@@ -20,6 +20,8 @@
 package state_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"strconv"
 	"strings"
 	"time"
@@ -66,7 +68,7 @@ func (cs *changeSuite) TestReadyTime(c *C) {
 }
 
 func (cs *changeSuite) TestStatusString(c *C) {
-	for s := state.Status(0); s < state.ErrorStatus+1; s++ {
+	for s := state.Status(0); s < state.WaitStatus+1; s++ {
 		c.Assert(s.String(), Matches, ".+")
 	}
 }
@@ -155,7 +157,7 @@ func (cs *changeSuite) TestStatusDerivedFromTasks(c *C) {
 
 	tasks := make(map[state.Status]*state.Task)
 
-	for s := state.DefaultStatus + 1; s < state.ErrorStatus+1; s++ {
+	for s := state.DefaultStatus + 1; s < state.WaitStatus+1; s++ {
 		t := st.NewTask("download", s.String())
 		t.SetStatus(s)
 		chg.AddTask(t)
@@ -167,6 +169,7 @@ func (cs *changeSuite) TestStatusDerivedFromTasks(c *C) {
 		state.UndoingStatus,
 		state.UndoStatus,
 		state.DoingStatus,
+		state.WaitStatus,
 		state.DoStatus,
 		state.ErrorStatus,
 		state.UndoneStatus,
@@ -360,7 +363,7 @@ func (cs *changeSuite) TestAbort(c *C) {
 
 	chg := st.NewChange("install", "...")
 
-	for s := state.DefaultStatus + 1; s < state.ErrorStatus+1; s++ {
+	for s := state.DefaultStatus + 1; s < state.WaitStatus+1; s++ {
 		t := st.NewTask("download", s.String())
 		t.SetStatus(s)
 		t.Set("old-status", s)
@@ -389,6 +392,40 @@ func (cs *changeSuite) TestAbort(c *C) {
 	}
 }
 
+func (cs *changeSuite) TestAbortWaiting(c *C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	chg := st.NewChange("install", "...")
+
+	statuses := []state.Status{state.DoStatus, state.DoingStatus, state.UndoingStatus}
+	var tasks []*state.Task
+	for _, s := range statuses {
+		t := st.NewTask("download", s.String())
+		chg.AddTask(t)
+		t.SetStatus(s)
+		t.SetToWait(state.WaitRestart, "system restart")
+		c.Assert(t.Status(), Equals, state.WaitStatus)
+		tasks = append(tasks, t)
+	}
+	c.Check(chg.Status(), Equals, state.WaitStatus)
+
+	chg.Abort()
+
+	// not started yet
+	c.Check(tasks[0].Status(), Equals, state.HoldStatus)
+	// stopped and to be undone
+	c.Check(tasks[1].Status(), Equals, state.AbortStatus)
+	c.Check(tasks[1].WaitOn(), Equals, "")
+	// the undo keeps waiting
+	c.Check(tasks[2].Status(), Equals, state.WaitStatus)
+	c.Check(tasks[2].WaitOn(), Equals, state.WaitRestart)
+
+	c.Check(chg.Resume(state.WaitRestart), DeepEquals, []*state.Task{tasks[2]})
+	c.Check(tasks[2].Status(), Equals, state.UndoingStatus)
+}
+
 // Task wait order:
 //
 //             => t21 => t22
@@ -589,3 +626,23 @@ func (ts *taskRunnerSuite) TestAbortLanes(c *C) {
 		c.Assert(strings.Join(obtained, " "), Equals, strings.Join(expected, " "), Commentf("setup: %s", test.setup))
 	}
 }
+
+func (cs *changeSuite) TestPriority(c *C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	chg := st.NewChange("auto-refresh", "...")
+	c.Check(chg.Priority(), Equals, state.DefaultPriority)
+	chg.SetPriority(state.LowPriority)
+	c.Check(chg.Priority(), Equals, state.LowPriority)
+
+	// it's persisted
+	data, err := json.Marshal(st)
+	c.Assert(err, IsNil)
+	st2, err := state.ReadState(nil, bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	st2.Lock()
+	defer st2.Unlock()
+	c.Check(st2.Change(chg.ID()).Priority(), Equals, state.LowPriority)
+}
@@ -0,0 +1,102 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package state
+
+import (
+	"time"
+)
+
+// Kinds of the events emitted by the state itself.
+const (
+	// EventChangeStatus is emitted when the aggregated status of a
+	// change changes
+	EventChangeStatus = "change-status"
+	// EventTaskStatus is emitted when the status of a task changes
+	EventTaskStatus = "task-status"
+	// EventTaskProgress is emitted when the progress of a task is set
+	EventTaskProgress = "task-progress"
+)
+
+// EventProgress is the progress of a task as carried by an event.
+type EventProgress struct {
+	Label string
+	Done  int
+	Total int
+}
+
+// Event describes a transition in the state, either observed by the
+// state itself (changes and tasks) or emitted by the managers with
+// EmitEvent (e.g. a snap got installed).
+type Event struct {
+	Kind string
+	Time time.Time
+
+	// ChangeID and TaskID identify what the event is about, if any
+	ChangeID string
+	TaskID   string
+
+	// Status is set for status events
+	Status Status
+	// Progress is set for task progress events
+	Progress *EventProgress
+
+	// Data holds the kind-specific details of events from the managers
+	Data map[string]string
+}
+
+// AddEventHandler registers f to be called with each event from now
+// on, and returns an id that can be given to RemoveEventHandler.
+//
+// f is called with the state locked and so must neither block nor
+// access the state.
+func (s *State) AddEventHandler(f func(*Event)) int {
+	s.reading()
+	s.lastEventHandlerID++
+	if s.eventHandlers == nil {
+		s.eventHandlers = make(map[int]func(*Event))
+	}
+	s.eventHandlers[s.lastEventHandlerID] = f
+	return s.lastEventHandlerID
+}
+
+// RemoveEventHandler unregisters the event handler with the given id.
+func (s *State) RemoveEventHandler(id int) {
+	s.reading()
+	delete(s.eventHandlers, id)
+}
+
+// EmitEvent delivers ev to the registered event handlers, setting its
+// time if unset.
+func (s *State) EmitEvent(ev *Event) {
+	s.reading()
+	if len(s.eventHandlers) == 0 {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = timeNow()
+	}
+	for _, f := range s.eventHandlers {
+		f(ev)
+	}
+}
+
+func (s *State) hasEventHandlers() bool {
+	return len(s.eventHandlers) != 0
+}
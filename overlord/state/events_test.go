@@ -0,0 +1,121 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package state_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+type eventsSuite struct{}
+
+var _ = Suite(&eventsSuite{})
+
+func (es *eventsSuite) TestStatusAndProgressEvents(c *C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	chg := st.NewChange("install", "...")
+	t1 := st.NewTask("download", "1...")
+	t2 := st.NewTask("link", "2...")
+	chg.AddTask(t1)
+	chg.AddTask(t2)
+
+	var events []*state.Event
+	st.AddEventHandler(func(ev *state.Event) {
+		c.Check(ev.Time.IsZero(), Equals, false)
+		ev.Time = ev.Time.UTC()
+		events = append(events, ev)
+	})
+
+	// no actual change
+	t1.SetStatus(state.DoStatus)
+	c.Check(events, HasLen, 0)
+
+	t1.SetStatus(state.DoingStatus)
+	t1.SetProgress("snap", 1, 10)
+	t1.SetStatus(state.DoneStatus)
+	t2.SetStatus(state.DoneStatus)
+
+	type simpleEvent struct {
+		kind     string
+		taskID   string
+		status   state.Status
+		progress *state.EventProgress
+	}
+	var got []simpleEvent
+	for _, ev := range events {
+		c.Check(ev.ChangeID, Equals, chg.ID())
+		got = append(got, simpleEvent{ev.Kind, ev.TaskID, ev.Status, ev.Progress})
+	}
+	c.Check(got, DeepEquals, []simpleEvent{
+		{state.EventTaskStatus, t1.ID(), state.DoingStatus, nil},
+		{state.EventChangeStatus, "", state.DoingStatus, nil},
+		{state.EventTaskProgress, t1.ID(), state.DefaultStatus, &state.EventProgress{Label: "snap", Done: 1, Total: 10}},
+		{state.EventTaskStatus, t1.ID(), state.DoneStatus, nil},
+		// nothing is running in between
+		{state.EventChangeStatus, "", state.DoStatus, nil},
+		{state.EventTaskStatus, t2.ID(), state.DoneStatus, nil},
+		{state.EventChangeStatus, "", state.DoneStatus, nil},
+	})
+}
+
+func (es *eventsSuite) TestEmitEventAndRemoveHandler(c *C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	var got1, got2 []string
+	id1 := st.AddEventHandler(func(ev *state.Event) {
+		got1 = append(got1, ev.Kind+":"+ev.Data["snap"])
+	})
+	st.AddEventHandler(func(ev *state.Event) {
+		got2 = append(got2, ev.Kind+":"+ev.Data["snap"])
+	})
+
+	st.EmitEvent(&state.Event{Kind: "snap-installed", Data: map[string]string{"snap": "foo"}})
+	st.RemoveEventHandler(id1)
+	st.EmitEvent(&state.Event{Kind: "snap-removed", Data: map[string]string{"snap": "foo"}})
+
+	c.Check(got1, DeepEquals, []string{"snap-installed:foo"})
+	c.Check(got2, DeepEquals, []string{"snap-installed:foo", "snap-removed:foo"})
+}
+
+func (es *eventsSuite) TestChangeSetStatusEvent(c *C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	chg := st.NewChange("install", "...")
+
+	var events []*state.Event
+	st.AddEventHandler(func(ev *state.Event) {
+		events = append(events, ev)
+	})
+
+	chg.SetStatus(state.ErrorStatus)
+	chg.SetStatus(state.ErrorStatus)
+	c.Assert(events, HasLen, 1)
+	c.Check(events[0].Kind, Equals, state.EventChangeStatus)
+	c.Check(events[0].ChangeID, Equals, chg.ID())
+	c.Check(events[0].Status, Equals, state.ErrorStatus)
+}
@@ -44,3 +44,21 @@ func MockTaskTimes(t *Task, spawnTime, readyTime time.Time) {
 	t.spawnTime = spawnTime
 	t.readyTime = readyTime
 }
+
+// MockJournalCompactMinSize changes the size the journal log must reach before being compacted.
+func MockJournalCompactMinSize(size int64) (restore func()) {
+	old := journalCompactMinSize
+	journalCompactMinSize = size
+	return func() {
+		journalCompactMinSize = old
+	}
+}
+
+// MockJournalCompactMaxAge changes how long records can stay in the journal log before it's compacted.
+func MockJournalCompactMaxAge(age time.Duration) (restore func()) {
+	old := journalCompactMaxAge
+	journalCompactMaxAge = age
+	return func() {
+		journalCompactMaxAge = old
+	}
+}
@@ -0,0 +1,503 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package state
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+)
+
+// journalCompactMinSize is the size the journal log must reach, and
+// also exceed the size of the snapshot, before it's compacted.
+var journalCompactMinSize int64 = 256 * 1024
+
+// journalCompactMaxAge is how long records can stay in the log before
+// it's compacted, see CompactStale.
+var journalCompactMaxAge = 5 * time.Minute
+
+// journalHeaderSize is the size of the header of each journal record:
+// the length of the payload followed by its CRC32 checksum.
+const journalHeaderSize = 8
+
+type journalMeta struct {
+	Warnings *json.RawMessage `json:"warnings,omitempty"`
+
+	LastChangeId int `json:"last-change-id"`
+	LastTaskId   int `json:"last-task-id"`
+	LastLaneId   int `json:"last-lane-id"`
+}
+
+// journalSnapshot mirrors marshalledState, keeping the top-level
+// entries, changes and tasks serialized.
+type journalSnapshot struct {
+	Data    map[string]*json.RawMessage `json:"data"`
+	Changes map[string]*json.RawMessage `json:"changes"`
+	Tasks   map[string]*json.RawMessage `json:"tasks"`
+
+	// Generation is renewed by each compaction, and only log records
+	// of the same generation are replayed over the snapshot
+	Generation int64 `json:"journal-generation,omitempty"`
+
+	journalMeta
+}
+
+func (snap *journalSnapshot) init() {
+	if snap.Data == nil {
+		snap.Data = make(map[string]*json.RawMessage)
+	}
+	if snap.Changes == nil {
+		snap.Changes = make(map[string]*json.RawMessage)
+	}
+	if snap.Tasks == nil {
+		snap.Tasks = make(map[string]*json.RawMessage)
+	}
+}
+
+// journalRecord holds the entries set and deleted by one checkpoint.
+type journalRecord struct {
+	Data    map[string]*json.RawMessage `json:"data,omitempty"`
+	Changes map[string]*json.RawMessage `json:"changes,omitempty"`
+	Tasks   map[string]*json.RawMessage `json:"tasks,omitempty"`
+
+	DeletedData    []string `json:"deleted-data,omitempty"`
+	DeletedChanges []string `json:"deleted-changes,omitempty"`
+	DeletedTasks   []string `json:"deleted-tasks,omitempty"`
+
+	Meta *journalMeta `json:"meta,omitempty"`
+
+	Generation int64 `json:"generation"`
+}
+
+func (rec *journalRecord) empty() bool {
+	return len(rec.Data) == 0 && len(rec.Changes) == 0 && len(rec.Tasks) == 0 &&
+		len(rec.DeletedData) == 0 && len(rec.DeletedChanges) == 0 && len(rec.DeletedTasks) == 0 &&
+		rec.Meta == nil
+}
+
+func rawEqual(a, b *json.RawMessage) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return bytes.Equal(*a, *b)
+}
+
+func diffEntries(old, next map[string]*json.RawMessage) (set map[string]*json.RawMessage, deleted []string) {
+	for k, v := range next {
+		if oldv, ok := old[k]; ok && rawEqual(oldv, v) {
+			continue
+		}
+		if set == nil {
+			set = make(map[string]*json.RawMessage)
+		}
+		set[k] = v
+	}
+	for k := range old {
+		if _, ok := next[k]; !ok {
+			deleted = append(deleted, k)
+		}
+	}
+	sort.Strings(deleted)
+	return set, deleted
+}
+
+func (snap *journalSnapshot) sameMeta(meta *journalMeta) bool {
+	return rawEqual(snap.Warnings, meta.Warnings) && snap.LastChangeId == meta.LastChangeId && snap.LastTaskId == meta.LastTaskId && snap.LastLaneId == meta.LastLaneId
+}
+
+func (snap *journalSnapshot) diff(next *journalSnapshot) *journalRecord {
+	rec := &journalRecord{}
+	rec.Data, rec.DeletedData = diffEntries(snap.Data, next.Data)
+	rec.Changes, rec.DeletedChanges = diffEntries(snap.Changes, next.Changes)
+	rec.Tasks, rec.DeletedTasks = diffEntries(snap.Tasks, next.Tasks)
+	if meta := next.journalMeta; !snap.sameMeta(&meta) {
+		rec.Meta = &meta
+	}
+	return rec
+}
+
+// unchangedDropped returns set and deleted without the entries that
+// are already like that in m.
+func unchangedDropped(m, set map[string]*json.RawMessage, deleted []string) (map[string]*json.RawMessage, []string) {
+	var newSet map[string]*json.RawMessage
+	for k, v := range set {
+		if oldv, ok := m[k]; ok && rawEqual(oldv, v) {
+			continue
+		}
+		if newSet == nil {
+			newSet = make(map[string]*json.RawMessage)
+		}
+		newSet[k] = v
+	}
+	var newDeleted []string
+	for _, k := range deleted {
+		if _, ok := m[k]; ok {
+			newDeleted = append(newDeleted, k)
+		}
+	}
+	return newSet, newDeleted
+}
+
+func applyEntries(m, set map[string]*json.RawMessage, deleted []string) {
+	for _, k := range deleted {
+		delete(m, k)
+	}
+	for k, v := range set {
+		m[k] = v
+	}
+}
+
+// apply applies the record to the snapshot. Applying a record again
+// yields the same result, so replaying the log over a snapshot that
+// already includes some of it is safe.
+func (snap *journalSnapshot) apply(rec *journalRecord) {
+	snap.init()
+	applyEntries(snap.Data, rec.Data, rec.DeletedData)
+	applyEntries(snap.Changes, rec.Changes, rec.DeletedChanges)
+	applyEntries(snap.Tasks, rec.Tasks, rec.DeletedTasks)
+	if rec.Meta != nil {
+		snap.journalMeta = *rec.Meta
+	}
+}
+
+// replay applies the well-formed records of the same generation at the
+// start of log to the snapshot, returning the size of that prefix and
+// how many records were applied. Anything after it is a torn or
+// corrupted write, or stale.
+func (snap *journalSnapshot) replay(log []byte) (good int64, n int) {
+	for {
+		rest := log[good:]
+		if len(rest) < journalHeaderSize {
+			return good, n
+		}
+		size := int64(binary.LittleEndian.Uint32(rest[0:4]))
+		sum := binary.LittleEndian.Uint32(rest[4:8])
+		if size > int64(len(rest)-journalHeaderSize) {
+			return good, n
+		}
+		payload := rest[journalHeaderSize : journalHeaderSize+size]
+		if crc32.ChecksumIEEE(payload) != sum {
+			return good, n
+		}
+		var rec journalRecord
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			return good, n
+		}
+		if rec.Generation != snap.Generation {
+			return good, n
+		}
+		snap.apply(&rec)
+		good += journalHeaderSize + size
+		n++
+	}
+}
+
+func journalLogPath(path string) string {
+	return path + ".journal"
+}
+
+// loadJournal reads the snapshot at path, if any, and replays over it
+// the records of the log. It returns whether any state was found and
+// the size of the torn, corrupted or stale tail of the log, if any.
+func loadJournal(path string) (j *Journal, found bool, torn int64, err error) {
+	j = &Journal{
+		path:    path,
+		current: &journalSnapshot{},
+	}
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, j.current); err != nil {
+			return nil, false, 0, fmt.Errorf("cannot decode state snapshot: %v", err)
+		}
+		j.snapshotSize = int64(len(data))
+		j.hasSnapshot = true
+		found = true
+	case !os.IsNotExist(err):
+		return nil, false, 0, err
+	}
+
+	log, err := ioutil.ReadFile(journalLogPath(path))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, false, 0, err
+	}
+	good, n := j.current.replay(log)
+	if n > 0 {
+		found = true
+	}
+	j.logSize = good
+	return j, found, int64(len(log)) - good, nil
+}
+
+// Journal persists the state incrementally: each checkpoint appends
+// to a log only the top-level entries, changes and tasks that were
+// modified since the previous one, and the log is compacted into a
+// full snapshot of the state once it outgrows it.
+//
+// The snapshot is kept in the same format as a plain state file, so
+// an existing state file is simply taken as the initial snapshot.
+//
+// An older snapd, after a downgrade, knows nothing about the log and
+// reads just the snapshot, so the log is compacted into it on opening
+// and it's expected to be compacted again on shutdown. As snapd might
+// not get to shut down cleanly, the log is also expected to be
+// compacted regularly with CompactStale, so that the snapshot never
+// lags far behind. The snapshot
+// also carries a generation, renewed on each compaction and dropped
+// by an older snapd rewriting it, and only log records of the same
+// generation as the snapshot are replayed, so that a leftover log
+// isn't applied over what an older snapd did to the state meanwhile,
+// such as reversing patches (see overlord/patch).
+type Journal struct {
+	mu sync.Mutex
+
+	path         string
+	log          *os.File
+	logSize      int64
+	snapshotSize int64
+	hasSnapshot  bool
+	// logSince is when the oldest record in the log was appended
+	logSince time.Time
+	// needsCompact is set when nothing can be appended to the log
+	// before compacting it, e.g. when it might have been left
+	// inconsistent by a failed write
+	needsCompact bool
+
+	current *journalSnapshot
+}
+
+// OpenJournal opens the journal with its snapshot at path and its log
+// next to it, returning the state reconstructed from them, or nil if
+// there is no state yet. A torn or corrupted tail of the log, left by
+// a crash in the middle of a write, is discarded, and so is a stale
+// one. The log is then compacted into the snapshot.
+func OpenJournal(path string) (*Journal, []byte, error) {
+	j, found, torn, err := loadJournal(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	j.log, err = os.OpenFile(journalLogPath(path), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	if torn > 0 {
+		logger.Noticef("Discarding %d bytes of torn, corrupted or stale state journal %q.", torn, j.log.Name())
+		if err := j.log.Truncate(j.logSize); err != nil {
+			j.log.Close()
+			return nil, nil, err
+		}
+	}
+
+	if !found {
+		return j, nil, nil
+	}
+	if err := j.compact(j.current); err != nil {
+		// the next checkpoint will try again
+		logger.Noticef("Cannot compact state journal: %v", err)
+		j.needsCompact = true
+	}
+	data, err := json.Marshal(j.current)
+	if err != nil {
+		j.log.Close()
+		return nil, nil, err
+	}
+	return j, data, nil
+}
+
+// ReadJournal returns the state persisted by the journal with its
+// snapshot at path, without modifying it.
+func ReadJournal(path string) ([]byte, error) {
+	j, found, _, err := loadJournal(path)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	return json.Marshal(j.current)
+}
+
+// Checkpoint persists the given serialized state, appending to the
+// log what changed since the previous checkpoint.
+func (j *Journal) Checkpoint(data []byte) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	next := &journalSnapshot{}
+	if err := json.Unmarshal(data, next); err != nil {
+		return fmt.Errorf("cannot decode state to checkpoint: %v", err)
+	}
+
+	if !j.hasSnapshot || j.needsCompact {
+		return j.compact(next)
+	}
+	return j.append(j.current.diff(next))
+}
+
+// A Delta holds the top-level entries, changes and tasks of the state
+// modified since the previous checkpoint, see DeltaBackend.
+type Delta struct {
+	rec *journalRecord
+	// size is the size of the persisted state after the delta was
+	// checkpointed, if known, otherwise -1
+	size int64
+}
+
+// CheckpointDelta persists what was modified in the state since the
+// previous checkpoint, appending it to the log.
+func (j *Journal) CheckpointDelta(delta *Delta) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// what was modified might have been set back as it was
+	rec := *delta.rec
+	rec.Data, rec.DeletedData = unchangedDropped(j.current.Data, rec.Data, rec.DeletedData)
+	rec.Changes, rec.DeletedChanges = unchangedDropped(j.current.Changes, rec.Changes, rec.DeletedChanges)
+	rec.Tasks, rec.DeletedTasks = unchangedDropped(j.current.Tasks, rec.Tasks, rec.DeletedTasks)
+	if rec.Meta != nil && j.current.sameMeta(rec.Meta) {
+		rec.Meta = nil
+	}
+
+	if !j.hasSnapshot || j.needsCompact {
+		// applying the record again on a retry is harmless
+		j.current.apply(&rec)
+		if err := j.compact(j.current); err != nil {
+			return err
+		}
+	} else if err := j.append(&rec); err != nil {
+		return err
+	}
+	delta.size = j.snapshotSize + j.logSize
+	return nil
+}
+
+// append appends the record to the log and applies it to the current
+// snapshot, compacting the log if it grew enough.
+func (j *Journal) append(rec *journalRecord) error {
+	if rec.empty() {
+		return nil
+	}
+	rec.Generation = j.current.Generation
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, journalHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	copy(buf[journalHeaderSize:], payload)
+
+	if _, err := j.log.Write(buf); err != nil {
+		j.discardTail()
+		return err
+	}
+	if err := j.log.Sync(); err != nil {
+		j.discardTail()
+		return err
+	}
+	if j.logSize == 0 {
+		j.logSince = time.Now()
+	}
+	j.logSize += int64(len(buf))
+	j.current.apply(rec)
+
+	if j.logSize >= journalCompactMinSize && j.logSize >= j.snapshotSize {
+		if err := j.compact(j.current); err != nil {
+			// the log is still good
+			logger.Noticef("Cannot compact state journal: %v", err)
+		}
+	}
+	return nil
+}
+
+// discardTail drops a partially written record, so that it doesn't
+// hide the ones written after it on replay.
+func (j *Journal) discardTail() {
+	if err := j.log.Truncate(j.logSize); err != nil {
+		j.needsCompact = true
+	}
+}
+
+// compact writes snap as the new snapshot and then empties the log.
+func (j *Journal) compact(snap *journalSnapshot) error {
+	next := *snap
+	// the generation must differ from any left in a stale log, so it
+	// can't just be counted up from one rewritten by an older snapd
+	next.Generation = time.Now().UnixNano()
+	if next.Generation <= j.current.Generation {
+		next.Generation = j.current.Generation + 1
+	}
+	data, err := json.Marshal(&next)
+	if err != nil {
+		return err
+	}
+	if err := osutil.AtomicWriteFile(j.path, data, 0600, 0); err != nil {
+		return err
+	}
+	j.current = &next
+	j.hasSnapshot = true
+	j.snapshotSize = int64(len(data))
+	// the snapshot includes everything in the log, which is of an
+	// older generation and won't be replayed over it, so it's fine to
+	// crash before this
+	if err := j.log.Truncate(0); err != nil {
+		j.needsCompact = true
+		return err
+	}
+	j.logSize = 0
+	j.needsCompact = false
+	return nil
+}
+
+// Compact folds the log into the snapshot.
+func (j *Journal) Compact() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.compact(j.current)
+}
+
+// CompactStale folds the log into the snapshot if it holds records
+// older than journalCompactMaxAge.
+func (j *Journal) CompactStale() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.logSize == 0 || time.Since(j.logSince) < journalCompactMaxAge {
+		return nil
+	}
+	return j.compact(j.current)
+}
+
+// Close closes the log of the journal.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.log.Close()
+}
@@ -0,0 +1,509 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package state_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/metrics"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type journalSuite struct {
+	path string
+}
+
+var _ = Suite(&journalSuite{})
+
+func (js *journalSuite) SetUpTest(c *C) {
+	js.path = filepath.Join(c.MkDir(), "state.json")
+}
+
+type journalBackend struct {
+	*state.Journal
+}
+
+func (b journalBackend) EnsureBefore(d time.Duration)       {}
+func (b journalBackend) RequestRestart(t state.RestartType) {}
+
+// open opens the journal and the state persisted in it.
+func (js *journalSuite) open(c *C) (*state.Journal, *state.State) {
+	j, data, err := state.OpenJournal(js.path)
+	c.Assert(err, IsNil)
+	if data == nil {
+		return j, state.New(journalBackend{j})
+	}
+	st, err := state.ReadState(journalBackend{j}, bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	return j, st
+}
+
+func (js *journalSuite) logSize(c *C) int64 {
+	fi, err := os.Stat(js.path + ".journal")
+	c.Assert(err, IsNil)
+	return fi.Size()
+}
+
+func (js *journalSuite) snapshot(c *C) string {
+	data, err := ioutil.ReadFile(js.path)
+	c.Assert(err, IsNil)
+	return string(data)
+}
+
+func (js *journalSuite) TestRoundTrip(c *C) {
+	j, st := js.open(c)
+	st.Lock()
+	st.Set("a", 1)
+	chg := st.NewChange("install", "...")
+	chg.AddTask(st.NewTask("download", "..."))
+	st.Unlock()
+
+	st.Lock()
+	st.Set("b", "two")
+	st.Unlock()
+	c.Assert(j.Close(), IsNil)
+
+	j, st = js.open(c)
+	defer j.Close()
+	st.Lock()
+	defer st.Unlock()
+	var a int
+	var b string
+	c.Check(st.Get("a", &a), IsNil)
+	c.Check(a, Equals, 1)
+	c.Check(st.Get("b", &b), IsNil)
+	c.Check(b, Equals, "two")
+	c.Assert(st.Changes(), HasLen, 1)
+	c.Check(st.Changes()[0].Tasks(), HasLen, 1)
+	c.Check(st.Changes()[0].Tasks()[0].Kind(), Equals, "download")
+	// ids keep going from where they were
+	c.Check(st.NewChange("remove", "...").ID(), Equals, "2")
+}
+
+func (js *journalSuite) TestNoState(c *C) {
+	j, data, err := state.OpenJournal(js.path)
+	c.Assert(err, IsNil)
+	defer j.Close()
+	c.Check(data, IsNil)
+
+	_, err = state.ReadJournal(js.path)
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (js *journalSuite) TestFirstCheckpointWritesSnapshot(c *C) {
+	j, st := js.open(c)
+	defer j.Close()
+	st.Lock()
+	st.Set("a", 1)
+	st.Unlock()
+
+	fi, err := os.Stat(js.path)
+	c.Assert(err, IsNil)
+	c.Check(fi.Mode().Perm(), Equals, os.FileMode(0600))
+	c.Check(js.snapshot(c), testutil.Contains, `"a":1`)
+	c.Check(js.logSize(c), Equals, int64(0))
+}
+
+func (js *journalSuite) TestAppendsOnlyChanges(c *C) {
+	j, st := js.open(c)
+	defer j.Close()
+	st.Lock()
+	st.Set("big", bytes.Repeat([]byte("x"), 4096))
+	st.Set("small", 1)
+	st.Unlock()
+	c.Check(js.logSize(c), Equals, int64(0))
+
+	st.Lock()
+	st.Set("small", 2)
+	st.Unlock()
+	size := js.logSize(c)
+	c.Check(size > 0, Equals, true)
+	c.Check(size < 1024, Equals, true)
+
+	log, err := ioutil.ReadFile(js.path + ".journal")
+	c.Assert(err, IsNil)
+	c.Check(string(log), testutil.Contains, `"small":2`)
+	c.Check(string(log), Not(testutil.Contains), `"big"`)
+	// the snapshot is untouched
+	c.Check(js.snapshot(c), testutil.Contains, `"small":1`)
+
+	// nothing is appended if nothing changed
+	st.Lock()
+	st.Set("small", 2)
+	st.Unlock()
+	c.Check(js.logSize(c), Equals, size)
+
+	data, err := state.ReadJournal(js.path)
+	c.Assert(err, IsNil)
+	c.Check(string(data), testutil.Contains, `"small":2`)
+}
+
+func (js *journalSuite) TestDeletions(c *C) {
+	j, st := js.open(c)
+	st.Lock()
+	st.Set("a", 1)
+	st.Set("b", 2)
+	chg := st.NewChange("install", "...")
+	chg.AddTask(st.NewTask("download", "..."))
+	st.Unlock()
+
+	st.Lock()
+	st.Set("a", nil)
+	st.Set("b", 3)
+	for _, t := range chg.Tasks() {
+		t.SetStatus(state.DoneStatus)
+	}
+	chg.SetStatus(state.DoneStatus)
+	st.Unlock()
+
+	st.Lock()
+	st.Prune(0, 0, 0)
+	c.Assert(st.Changes(), HasLen, 0)
+	st.Unlock()
+	c.Assert(j.Close(), IsNil)
+
+	j, st = js.open(c)
+	defer j.Close()
+	st.Lock()
+	defer st.Unlock()
+	var v int
+	c.Check(st.Get("a", &v), Equals, state.ErrNoState)
+	c.Check(st.Get("b", &v), IsNil)
+	c.Check(v, Equals, 3)
+	c.Check(st.Changes(), HasLen, 0)
+	c.Check(st.Tasks(), HasLen, 0)
+}
+
+func (js *journalSuite) TestTornTailIsDiscarded(c *C) {
+	j, st := js.open(c)
+	st.Lock()
+	st.Set("a", 1)
+	st.Unlock()
+	st.Lock()
+	st.Set("a", 2)
+	st.Unlock()
+	c.Assert(j.Close(), IsNil)
+
+	// simulate a crash in the middle of writing another record
+	j, st = js.open(c)
+	st.Lock()
+	st.Set("a", 3)
+	st.Unlock()
+	c.Assert(j.Close(), IsNil)
+	c.Assert(os.Truncate(js.path+".journal", js.logSize(c)-5), IsNil)
+
+	j, st = js.open(c)
+	c.Check(js.logSize(c), Equals, int64(0))
+	st.Lock()
+	var v int
+	c.Check(st.Get("a", &v), IsNil)
+	c.Check(v, Equals, 2)
+	// and it can carry on from there
+	st.Set("a", 4)
+	st.Unlock()
+	c.Assert(j.Close(), IsNil)
+
+	j, st = js.open(c)
+	defer j.Close()
+	st.Lock()
+	defer st.Unlock()
+	c.Check(st.Get("a", &v), IsNil)
+	c.Check(v, Equals, 4)
+}
+
+func (js *journalSuite) TestCorruptedRecordIsDiscarded(c *C) {
+	j, st := js.open(c)
+	st.Lock()
+	st.Set("a", 1)
+	st.Unlock()
+	st.Lock()
+	st.Set("a", 2)
+	st.Unlock()
+	c.Assert(j.Close(), IsNil)
+
+	log, err := ioutil.ReadFile(js.path + ".journal")
+	c.Assert(err, IsNil)
+	log[len(log)-2] ^= 0xff
+	c.Assert(ioutil.WriteFile(js.path+".journal", log, 0600), IsNil)
+
+	j, st = js.open(c)
+	defer j.Close()
+	c.Check(js.logSize(c), Equals, int64(0))
+	st.Lock()
+	defer st.Unlock()
+	var v int
+	c.Check(st.Get("a", &v), IsNil)
+	c.Check(v, Equals, 1)
+}
+
+func (js *journalSuite) TestCompaction(c *C) {
+	restore := state.MockJournalCompactMinSize(512)
+	defer restore()
+
+	j, st := js.open(c)
+	st.Lock()
+	st.Set("a", 0)
+	st.Unlock()
+
+	compacted := false
+	for i := 1; i < 100; i++ {
+		st.Lock()
+		st.Set("a", i)
+		st.Unlock()
+		if js.logSize(c) == 0 {
+			compacted = true
+			c.Check(js.snapshot(c), testutil.Contains, `"a":`)
+			break
+		}
+	}
+	c.Check(compacted, Equals, true)
+
+	st.Lock()
+	st.Set("a", 1000)
+	st.Unlock()
+	c.Assert(j.Compact(), IsNil)
+	c.Check(js.logSize(c), Equals, int64(0))
+	c.Check(js.snapshot(c), testutil.Contains, `"a":1000`)
+	c.Assert(j.Close(), IsNil)
+
+	j, st = js.open(c)
+	defer j.Close()
+	st.Lock()
+	defer st.Unlock()
+	var v int
+	c.Check(st.Get("a", &v), IsNil)
+	c.Check(v, Equals, 1000)
+}
+
+func (js *journalSuite) TestCompactStale(c *C) {
+	j, st := js.open(c)
+	defer j.Close()
+	st.Lock()
+	st.Set("a", 0)
+	st.Unlock()
+	st.Lock()
+	st.Set("a", 1)
+	st.Unlock()
+	c.Assert(js.logSize(c), Not(Equals), int64(0))
+
+	// recent records are left in the log
+	c.Assert(j.CompactStale(), IsNil)
+	c.Check(js.logSize(c), Not(Equals), int64(0))
+	c.Check(js.snapshot(c), Not(testutil.Contains), `"a":1`)
+
+	restore := state.MockJournalCompactMaxAge(0)
+	defer restore()
+	c.Assert(j.CompactStale(), IsNil)
+	c.Check(js.logSize(c), Equals, int64(0))
+	c.Check(js.snapshot(c), testutil.Contains, `"a":1`)
+}
+
+func (js *journalSuite) TestCheckpointSizeMetric(c *C) {
+	j, st := js.open(c)
+	defer j.Close()
+	st.Lock()
+	st.Set("a", 0)
+	st.Unlock()
+
+	checkpointSize := func() string {
+		var buf bytes.Buffer
+		c.Assert(metrics.WriteText(&buf), IsNil)
+		m := regexp.MustCompile(`(?m)^snapd_state_checkpoint_size_bytes (\S+)$`).FindStringSubmatch(buf.String())
+		c.Assert(m, HasLen, 2)
+		return m[1]
+	}
+	before := checkpointSize()
+
+	// checkpointing just the changes still reports the size of the
+	// state on disk, journal included
+	st.Lock()
+	st.Set("b", "some more state")
+	st.Unlock()
+	after := checkpointSize()
+	c.Check(after, Not(Equals), before)
+
+	fi, err := os.Stat(js.path)
+	c.Assert(err, IsNil)
+	c.Check(after, Equals, strconv.FormatInt(fi.Size()+js.logSize(c), 10))
+}
+
+func (js *journalSuite) TestCrashBetweenSnapshotAndTruncate(c *C) {
+	j, st := js.open(c)
+	st.Lock()
+	st.Set("a", 1)
+	st.Unlock()
+	st.Lock()
+	st.Set("a", 2)
+	st.Set("b", 1)
+	st.Unlock()
+	st.Lock()
+	st.Set("b", nil)
+	st.Unlock()
+	log, err := ioutil.ReadFile(js.path + ".journal")
+	c.Assert(err, IsNil)
+	c.Assert(j.Compact(), IsNil)
+	c.Assert(j.Close(), IsNil)
+
+	// the new snapshot was written but the log was not truncated
+	c.Assert(ioutil.WriteFile(js.path+".journal", log, 0600), IsNil)
+
+	j, st = js.open(c)
+	defer j.Close()
+	st.Lock()
+	defer st.Unlock()
+	var v int
+	c.Check(st.Get("a", &v), IsNil)
+	c.Check(v, Equals, 2)
+	c.Check(st.Get("b", &v), Equals, state.ErrNoState)
+}
+
+func (js *journalSuite) TestMigrateFromStateFile(c *C) {
+	old := []byte(`{"data":{"a":1},"changes":{},"tasks":{},"last-change-id":3,"last-task-id":7,"last-lane-id":0}`)
+	c.Assert(ioutil.WriteFile(js.path, old, 0600), IsNil)
+
+	j, st := js.open(c)
+	defer j.Close()
+	st.Lock()
+	var v int
+	c.Check(st.Get("a", &v), IsNil)
+	c.Check(v, Equals, 1)
+	c.Check(st.NewChange("install", "...").ID(), Equals, "4")
+	st.Unlock()
+
+	// the state file is taken as the snapshot and changes go to the log
+	c.Check(js.snapshot(c), testutil.Contains, `"data":{"a":1}`)
+	c.Check(js.snapshot(c), testutil.Contains, `"last-change-id":3`)
+	c.Check(js.logSize(c) > 0, Equals, true)
+
+	data, err := state.ReadJournal(js.path)
+	c.Assert(err, IsNil)
+	c.Check(string(data), testutil.Contains, `"last-change-id":4`)
+}
+
+func (js *journalSuite) TestCompactsOnOpen(c *C) {
+	j, st := js.open(c)
+	st.Lock()
+	st.Set("a", 1)
+	st.Unlock()
+	st.Lock()
+	st.Set("a", 2)
+	st.Unlock()
+	c.Assert(j.Close(), IsNil)
+	c.Check(js.logSize(c) > 0, Equals, true)
+
+	j, _ = js.open(c)
+	defer j.Close()
+	c.Check(js.logSize(c), Equals, int64(0))
+	c.Check(js.snapshot(c), testutil.Contains, `"a":2`)
+}
+
+func (js *journalSuite) TestStaleLogIsDiscarded(c *C) {
+	j, st := js.open(c)
+	st.Lock()
+	st.Set("a", 1)
+	st.Unlock()
+	st.Lock()
+	st.Set("a", 2)
+	st.Unlock()
+	c.Assert(j.Close(), IsNil)
+	log, err := ioutil.ReadFile(js.path + ".journal")
+	c.Assert(err, IsNil)
+
+	// an older snapd, knowing nothing about the journal, rewrites the
+	// state file without the generation
+	older := []byte(`{"data":{"a":10},"changes":{},"tasks":{},"last-change-id":0,"last-task-id":0,"last-lane-id":0}`)
+	c.Assert(ioutil.WriteFile(js.path, older, 0600), IsNil)
+
+	j, st = js.open(c)
+	defer j.Close()
+	c.Check(js.logSize(c), Equals, int64(0))
+	st.Lock()
+	defer st.Unlock()
+	var v int
+	c.Check(st.Get("a", &v), IsNil)
+	c.Check(v, Equals, 10)
+
+	// nor is it replayed over later snapshots
+	c.Assert(ioutil.WriteFile(js.path+".journal", log, 0600), IsNil)
+	data, err := state.ReadJournal(js.path)
+	c.Assert(err, IsNil)
+	c.Check(string(data), testutil.Contains, `"a":10`)
+}
+
+func (js *journalSuite) TestCheckpointsOnlyModified(c *C) {
+	j, st := js.open(c)
+	defer j.Close()
+	st.Lock()
+	chg := st.NewChange("install", "...")
+	var tasks []*state.Task
+	for i := 0; i < 3; i++ {
+		t := st.NewTask("download", "...")
+		chg.AddTask(t)
+		tasks = append(tasks, t)
+	}
+	st.Unlock()
+	c.Check(js.logSize(c), Equals, int64(0))
+
+	st.Lock()
+	tasks[1].Set("marker", "here")
+	st.Unlock()
+
+	log, err := ioutil.ReadFile(js.path + ".journal")
+	c.Assert(err, IsNil)
+	c.Check(string(log), testutil.Contains, `"marker":"here"`)
+	c.Check(string(log), testutil.Contains, `"tasks":{"`+tasks[1].ID()+`":`)
+	c.Check(string(log), Not(testutil.Contains), `"changes"`)
+
+	// the journal ends up with the same state
+	st.Lock()
+	tasks[0].SetStatus(state.DoneStatus)
+	tasks[2].WaitFor(tasks[0])
+	st.Set("a", 1)
+	st.Unlock()
+	st.Lock()
+	tasks[1].SetStatus(state.DoneStatus)
+	tasks[2].SetStatus(state.DoneStatus)
+	st.Delete("a")
+	st.Unlock()
+	st.Lock()
+	tasks[2].SetClean()
+	st.Prune(0, 0, 0)
+	expected, err := json.Marshal(st)
+	st.Unlock()
+	c.Assert(err, IsNil)
+
+	data, err := state.ReadJournal(js.path)
+	c.Assert(err, IsNil)
+	var got, want map[string]interface{}
+	c.Assert(json.Unmarshal(data, &got), IsNil)
+	c.Assert(json.Unmarshal(expected, &want), IsNil)
+	delete(got, "journal-generation")
+	c.Check(got, DeepEquals, want)
+}
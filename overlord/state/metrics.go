@@ -0,0 +1,39 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package state
+
+import (
+	"github.com/snapcore/snapd/metrics"
+)
+
+var (
+	taskDuration = metrics.NewHistogramVec("snapd_task_duration_seconds",
+		"Time from the spawning of tasks to their becoming ready, by kind and final status.",
+		metrics.DefaultBuckets, "kind", "status")
+	changeDuration = metrics.NewHistogramVec("snapd_change_duration_seconds",
+		"Time from the spawning of changes to their becoming ready, by kind and final status.",
+		metrics.DefaultBuckets, "kind", "status")
+
+	checkpointDuration = metrics.NewHistogramVec("snapd_state_checkpoint_duration_seconds",
+		"Time taken to checkpoint the state to disk.",
+		metrics.DefaultBuckets)
+	checkpointSize = metrics.NewGauge("snapd_state_checkpoint_size_bytes",
+		"Size of the state as last checkpointed, including the journal of the changes to it.")
+)
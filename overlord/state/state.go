@@ -43,6 +43,14 @@ type Backend interface {
 	RequestRestart(t RestartType)
 }
 
+// A DeltaBackend is a Backend that can also persist only what was
+// modified in the state since the previous checkpoint, in which case
+// the state passes just that to it instead of its full serialization.
+type DeltaBackend interface {
+	Backend
+	CheckpointDelta(delta *Delta) error
+}
+
 type customData map[string]*json.RawMessage
 
 func (data customData) get(key string, value interface{}) error {
@@ -95,10 +103,18 @@ type State struct {
 	changes map[string]*Change
 	tasks   map[string]*Task
 
+	warnings map[string]*Warning
+
 	modified bool
+	dirty    dirtyEntries
 
 	cache map[interface{}]interface{}
 
+	eventHandlers      map[int]func(*Event)
+	lastEventHandlerID int
+
+	changeArchiver func(chgs []*Change) error
+
 	restarting bool
 	restartLck sync.Mutex
 }
@@ -111,10 +127,40 @@ func New(backend Backend) *State {
 		changes:  make(map[string]*Change),
 		tasks:    make(map[string]*Task),
 		modified: true,
+		dirty:    dirtyEntries{all: true},
 		cache:    make(map[interface{}]interface{}),
 	}
 }
 
+// dirtyEntries tracks the top-level entries, changes and tasks
+// modified since the last checkpoint, see DeltaBackend.
+type dirtyEntries struct {
+	// all is set when everything is to be taken as modified
+	all     bool
+	data    map[string]bool
+	changes map[string]bool
+	tasks   map[string]bool
+}
+
+func markDirty(m *map[string]bool, id string) {
+	if *m == nil {
+		*m = make(map[string]bool)
+	}
+	(*m)[id] = true
+}
+
+func (d *dirtyEntries) addData(key string) {
+	markDirty(&d.data, key)
+}
+
+func (d *dirtyEntries) addChange(id string) {
+	markDirty(&d.changes, id)
+}
+
+func (d *dirtyEntries) addTask(id string) {
+	markDirty(&d.tasks, id)
+}
+
 // Modified returns whether the state was modified since the last checkpoint.
 func (s *State) Modified() bool {
 	return s.modified
@@ -149,6 +195,8 @@ type marshalledState struct {
 	Changes map[string]*Change          `json:"changes"`
 	Tasks   map[string]*Task            `json:"tasks"`
 
+	Warnings []*Warning `json:"warnings,omitempty"`
+
 	LastChangeId int `json:"last-change-id"`
 	LastTaskId   int `json:"last-task-id"`
 	LastLaneId   int `json:"last-lane-id"`
@@ -162,6 +210,8 @@ func (s *State) MarshalJSON() ([]byte, error) {
 		Changes: s.changes,
 		Tasks:   s.tasks,
 
+		Warnings: s.flattenWarnings(),
+
 		LastTaskId:   s.lastTaskId,
 		LastChangeId: s.lastChangeId,
 		LastLaneId:   s.lastLaneId,
@@ -171,6 +221,7 @@ func (s *State) MarshalJSON() ([]byte, error) {
 // UnmarshalJSON makes State a json.Unmarshaller
 func (s *State) UnmarshalJSON(data []byte) error {
 	s.writing()
+	s.dirty.all = true
 	var unmarshalled marshalledState
 	err := json.Unmarshal(data, &unmarshalled)
 	if err != nil {
@@ -182,6 +233,7 @@ func (s *State) UnmarshalJSON(data []byte) error {
 	s.lastChangeId = unmarshalled.LastChangeId
 	s.lastTaskId = unmarshalled.LastTaskId
 	s.lastLaneId = unmarshalled.LastLaneId
+	s.unflattenWarnings(unmarshalled.Warnings)
 	// backlink state again
 	for _, t := range s.tasks {
 		t.state = s
@@ -202,6 +254,68 @@ func (s *State) checkpointData() []byte {
 	return data
 }
 
+// marshalDirty serializes the values found by lookup for the given
+// dirty ids, returning separately the ids for which none was found.
+func marshalDirty(what string, dirty map[string]bool, lookup func(id string) interface{}) (set map[string]*json.RawMessage, deleted []string) {
+	for id := range dirty {
+		value := lookup(id)
+		if value == nil {
+			deleted = append(deleted, id)
+			continue
+		}
+		serialized, err := json.Marshal(value)
+		if err != nil {
+			logger.Panicf("internal error: could not marshal %s %q for checkpointing: %v", what, id, err)
+		}
+		if set == nil {
+			set = make(map[string]*json.RawMessage)
+		}
+		raw := json.RawMessage(serialized)
+		set[id] = &raw
+	}
+	sort.Strings(deleted)
+	return set, deleted
+}
+
+// checkpointDelta returns what was modified since the last checkpoint.
+func (s *State) checkpointDelta() *Delta {
+	rec := &journalRecord{}
+	rec.Data, rec.DeletedData = marshalDirty("state entry", s.dirty.data, func(key string) interface{} {
+		if entryJSON, ok := s.data[key]; ok {
+			return entryJSON
+		}
+		return nil
+	})
+	rec.Changes, rec.DeletedChanges = marshalDirty("change", s.dirty.changes, func(id string) interface{} {
+		if chg := s.changes[id]; chg != nil {
+			return chg
+		}
+		return nil
+	})
+	rec.Tasks, rec.DeletedTasks = marshalDirty("task", s.dirty.tasks, func(id string) interface{} {
+		if t := s.tasks[id]; t != nil {
+			return t
+		}
+		return nil
+	})
+
+	// the rest is small enough to be passed along every time
+	rec.Meta = &journalMeta{
+		LastChangeId: s.lastChangeId,
+		LastTaskId:   s.lastTaskId,
+		LastLaneId:   s.lastLaneId,
+	}
+	if warnings := s.flattenWarnings(); len(warnings) > 0 {
+		serialized, err := json.Marshal(warnings)
+		if err != nil {
+			logger.Panicf("internal error: could not marshal warnings for checkpointing: %v", err)
+		}
+		raw := json.RawMessage(serialized)
+		rec.Meta.Warnings = &raw
+	}
+	return &Delta{rec: rec, size: -1}
+}
+
 // unlock checkpoint retry parameters (5 mins of retries by default)
 var (
 	unlockCheckpointRetryMaxTime  = 5 * time.Minute
@@ -211,6 +325,9 @@ var (
 // Unlock releases the state lock and checkpoints the state.
 // It does not return until the state is correctly checkpointed.
 // After too many unsuccessful checkpoint attempts, it panics.
+//
+// With a DeltaBackend only what was modified since the previous
+// checkpoint is passed to the backend.
 func (s *State) Unlock() {
 	defer s.unlock()
 
@@ -218,12 +335,35 @@ func (s *State) Unlock() {
 		return
 	}
 
-	data := s.checkpointData()
+	var checkpoint func() error
+	// size returns the size of the checkpointed state, if known,
+	// otherwise -1
+	var size func() int64
+	if deltaBackend, ok := s.backend.(DeltaBackend); ok && !s.dirty.all {
+		delta := s.checkpointDelta()
+		checkpoint = func() error {
+			return deltaBackend.CheckpointDelta(delta)
+		}
+		size = func() int64 { return delta.size }
+	} else {
+		data := s.checkpointData()
+		checkpoint = func() error {
+			return s.backend.Checkpoint(data)
+		}
+		size = func() int64 { return int64(len(data)) }
+	}
+
 	var err error
 	start := time.Now()
 	for time.Since(start) <= unlockCheckpointRetryMaxTime {
-		if err = s.backend.Checkpoint(data); err == nil {
+		attempt := time.Now()
+		if err = checkpoint(); err == nil {
+			checkpointDuration.Observe(time.Since(attempt).Seconds())
+			if n := size(); n >= 0 {
+				checkpointSize.Set(float64(n))
+			}
 			s.modified = false
+			s.dirty = dirtyEntries{}
 			return
 		}
 		time.Sleep(unlockCheckpointRetryInterval)
@@ -278,9 +418,17 @@ func (s *State) Get(key string, value interface{}) error {
 // The provided value must properly marshal and unmarshal with encoding/json.
 func (s *State) Set(key string, value interface{}) {
 	s.writing()
+	s.dirty.addData(key)
 	s.data.set(key, value)
 }
 
+// Delete removes the entry associated with key, if any.
+func (s *State) Delete(key string) {
+	s.writing()
+	s.dirty.addData(key)
+	delete(s.data, key)
+}
+
 // Cached returns the cached value associated with the provided key.
 // It returns nil if there is no entry for key.
 func (s *State) Cached(key interface{}) interface{} {
@@ -306,6 +454,7 @@ func (s *State) NewChange(kind, summary string) *Change {
 	id := strconv.Itoa(s.lastChangeId)
 	chg := newChange(s, id, kind, summary)
 	s.changes[id] = chg
+	s.dirty.addChange(id)
 	return chg
 }
 
@@ -341,6 +490,7 @@ func (s *State) NewTask(kind, summary string) *Task {
 	id := strconv.Itoa(s.lastTaskId)
 	t := newTask(s, id, kind, summary)
 	s.tasks[id] = t
+	s.dirty.addTask(id)
 	return t
 }
 
@@ -357,6 +507,25 @@ func (s *State) Tasks() []*Task {
 	return res
 }
 
+// Resume moves all the tasks waiting for the named event back to the
+// status they had before, so that they are run again at the next
+// ensure pass. It returns the resumed tasks. See Task.SetToWait.
+func (s *State) Resume(event string) []*Task {
+	s.writing()
+	return s.resume(s.Tasks(), event)
+}
+
+func (s *State) resume(tasks []*Task, event string) []*Task {
+	var resumed []*Task
+	for _, t := range tasks {
+		if t.Status() == WaitStatus && t.waitOn == event {
+			t.resume()
+			resumed = append(resumed, t)
+		}
+	}
+	return resumed
+}
+
 // Task returns the task for the given ID if the task has been linked to a change.
 func (s *State) Task(id string) *Task {
 	s.reading()
@@ -409,13 +578,14 @@ func (s *State) Prune(pruneWait, abortWait time.Duration, maxReadyChanges int) {
 		readyChangesCount++
 	}
 
+	var pruned []*Change
 	for _, chg := range changes {
 		spawnTime := chg.SpawnTime()
 		readyTime := chg.ReadyTime()
 		if readyTime.IsZero() {
 			if spawnTime.Before(pruneLimit) && len(chg.Tasks()) == 0 {
 				chg.Abort()
-				delete(s.changes, chg.ID())
+				pruned = append(pruned, chg)
 			} else if spawnTime.Before(abortLimit) {
 				chg.Abort()
 			}
@@ -423,24 +593,45 @@ func (s *State) Prune(pruneWait, abortWait time.Duration, maxReadyChanges int) {
 		}
 		// change old or we have too many changes
 		if readyTime.Before(pruneLimit) || readyChangesCount > maxReadyChanges {
-			s.writing()
-			for _, t := range chg.Tasks() {
-				delete(s.tasks, t.ID())
-			}
-			delete(s.changes, chg.ID())
+			pruned = append(pruned, chg)
 			readyChangesCount--
 		}
 	}
 
+	if len(pruned) > 0 && s.changeArchiver != nil {
+		if err := s.changeArchiver(pruned); err != nil {
+			logger.Noticef("Cannot archive changes, keeping them until next prune: %v", err)
+			pruned = nil
+		}
+	}
+	for _, chg := range pruned {
+		s.writing()
+		for _, t := range chg.Tasks() {
+			delete(s.tasks, t.ID())
+			s.dirty.addTask(t.ID())
+		}
+		delete(s.changes, chg.ID())
+		s.dirty.addChange(chg.ID())
+	}
+
 	for tid, t := range s.tasks {
 		// TODO: this could be done more aggressively
 		if t.Change() == nil && t.SpawnTime().Before(pruneLimit) {
 			s.writing()
 			delete(s.tasks, tid)
+			s.dirty.addTask(tid)
 		}
 	}
 }
 
+// SetChangeArchiver sets archive to be called by Prune with the
+// changes it is about to remove, so that a record of them can be kept.
+// If archive fails the changes are kept until the next Prune.
+func (s *State) SetChangeArchiver(archive func(chgs []*Change) error) {
+	s.reading()
+	s.changeArchiver = archive
+}
+
 // ReadState returns the state deserialized from r.
 func ReadState(backend Backend, r io.Reader) (*State, error) {
 	s := new(State)
@@ -453,6 +644,7 @@ func ReadState(backend Backend, r io.Reader) (*State, error) {
 	}
 	s.backend = backend
 	s.modified = false
+	s.dirty = dirtyEntries{}
 	s.cache = make(map[interface{}]interface{})
 	return s, err
 }
@@ -55,6 +55,19 @@ func (ss *stateSuite) TestLockUnlock(c *C) {
 	st.Unlock()
 }
 
+func (ss *stateSuite) TestDelete(c *C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	st.Set("a", 1)
+	st.Delete("a")
+	st.Delete("b")
+
+	var v int
+	c.Check(st.Get("a", &v), Equals, state.ErrNoState)
+}
+
 func (ss *stateSuite) TestGetAndSet(c *C) {
 	st := state.New(nil)
 	st.Lock()
@@ -773,6 +786,70 @@ func (ss *stateSuite) TestPruneEmptyChange(c *C) {
 	c.Assert(st.Change(chg.ID()), IsNil)
 }
 
+func (ss *stateSuite) TestPruneArchivesChanges(c *C) {
+	st := state.New(&fakeStateBackend{})
+	st.Lock()
+	defer st.Unlock()
+
+	now := time.Now()
+	pruneWait := 1 * time.Hour
+	abortWait := 3 * time.Hour
+
+	chg1 := st.NewChange("prune", "...")
+	t1 := st.NewTask("foo", "...")
+	chg1.AddTask(t1)
+	state.MockChangeTimes(chg1, now.Add(-pruneWait), now.Add(-pruneWait))
+
+	chg2 := st.NewChange("recent", "...")
+	chg2.AddTask(st.NewTask("foo", "..."))
+	state.MockChangeTimes(chg2, now, now)
+
+	var archived []string
+	st.SetChangeArchiver(func(chgs []*state.Change) error {
+		for _, chg := range chgs {
+			// still there
+			c.Check(st.Change(chg.ID()), Equals, chg)
+			c.Check(chg.Tasks(), HasLen, 1)
+			archived = append(archived, chg.Kind())
+		}
+		return nil
+	})
+
+	st.Prune(pruneWait, abortWait, 100)
+	c.Check(archived, DeepEquals, []string{"prune"})
+	c.Check(st.Change(chg1.ID()), IsNil)
+	c.Check(st.Task(t1.ID()), IsNil)
+	c.Check(st.Change(chg2.ID()), Equals, chg2)
+}
+
+func (ss *stateSuite) TestPruneKeepsChangesIfArchivingFails(c *C) {
+	st := state.New(&fakeStateBackend{})
+	st.Lock()
+	defer st.Unlock()
+
+	now := time.Now()
+	pruneWait := 1 * time.Hour
+	abortWait := 3 * time.Hour
+
+	chg := st.NewChange("prune", "...")
+	t := st.NewTask("foo", "...")
+	chg.AddTask(t)
+	state.MockChangeTimes(chg, now.Add(-pruneWait), now.Add(-pruneWait))
+
+	st.SetChangeArchiver(func(chgs []*state.Change) error {
+		return errors.New("disk full")
+	})
+	st.Prune(pruneWait, abortWait, 100)
+	c.Check(st.Change(chg.ID()), Equals, chg)
+	c.Check(st.Task(t.ID()), Equals, t)
+
+	st.SetChangeArchiver(func(chgs []*state.Change) error {
+		return nil
+	})
+	st.Prune(pruneWait, abortWait, 100)
+	c.Check(st.Change(chg.ID()), IsNil)
+}
+
 func (ss *stateSuite) TestPruneMaxChangesHappy(c *C) {
 	st := state.New(&fakeStateBackend{})
 	st.Lock()
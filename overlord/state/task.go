@@ -56,6 +56,12 @@ type Task struct {
 	readyTime time.Time
 
 	atTime time.Time
+
+	// waitOn and waitReason are the event a task in WaitStatus is
+	// waiting for and why, and waitedStatus the status it had before
+	waitOn       string
+	waitReason   string
+	waitedStatus Status
 }
 
 func newTask(state *State, id, kind, summary string) *Task {
@@ -88,6 +94,10 @@ type marshalledTask struct {
 	ReadyTime *time.Time `json:"ready-time,omitempty"`
 
 	AtTime *time.Time `json:"at-time,omitempty"`
+
+	WaitOn       string `json:"wait-on,omitempty"`
+	WaitReason   string `json:"wait-reason,omitempty"`
+	WaitedStatus Status `json:"waited-status,omitempty"`
 }
 
 // MarshalJSON makes Task a json.Marshaller
@@ -119,6 +129,10 @@ func (t *Task) MarshalJSON() ([]byte, error) {
 		ReadyTime: readyTime,
 
 		AtTime: atTime,
+
+		WaitOn:       t.waitOn,
+		WaitReason:   t.waitReason,
+		WaitedStatus: t.waitedStatus,
 	})
 }
 
@@ -155,6 +169,9 @@ func (t *Task) UnmarshalJSON(data []byte) error {
 	if unmarshalled.AtTime != nil {
 		t.atTime = *unmarshalled.AtTime
 	}
+	t.waitOn = unmarshalled.WaitOn
+	t.waitReason = unmarshalled.WaitReason
+	t.waitedStatus = unmarshalled.WaitedStatus
 	return nil
 }
 
@@ -184,16 +201,75 @@ func (t *Task) Status() Status {
 
 // SetStatus sets the task status, overriding the default behavior (see Status method).
 func (t *Task) SetStatus(new Status) {
-	t.state.writing()
+	t.writing()
 	old := t.status
+	oldStatus := t.Status()
 	t.status = new
+	if new != WaitStatus {
+		t.waitOn = ""
+		t.waitReason = ""
+		t.waitedStatus = DefaultStatus
+	}
 	if !old.Ready() && new.Ready() {
 		t.readyTime = timeNow()
+		taskDuration.Observe(t.readyTime.Sub(t.spawnTime).Seconds(), t.kind, new.String())
 	}
 	chg := t.Change()
 	if chg != nil {
 		chg.taskStatusChanged(t, old, new)
 	}
+	if t.Status() != oldStatus {
+		t.EmitEvent(&Event{
+			Kind:   EventTaskStatus,
+			Status: t.Status(),
+		})
+		if chg != nil {
+			chg.emitStatusIfChanged()
+		}
+	}
+}
+
+// SetToWait puts the task in WaitStatus until the named event is
+// resumed with State.Resume or Change.Resume, at which point the task
+// goes back to the status it had, to be run again. The reason tells
+// the user what the task is waiting for.
+//
+// Handlers can instead return a Wait error to the same effect.
+func (t *Task) SetToWait(event, reason string) {
+	t.writing()
+	if t.Status() != WaitStatus {
+		t.waitedStatus = t.Status()
+	}
+	t.waitOn = event
+	t.waitReason = reason
+	t.SetStatus(WaitStatus)
+}
+
+// WaitOn returns the name of the event the task is waiting for, if
+// it's in WaitStatus.
+func (t *Task) WaitOn() string {
+	t.state.reading()
+	return t.waitOn
+}
+
+// WaitReason returns what the task is waiting for, if it's in
+// WaitStatus.
+func (t *Task) WaitReason() string {
+	t.state.reading()
+	return t.waitReason
+}
+
+// resume moves the task out of WaitStatus back to the status it had.
+func (t *Task) resume() {
+	t.SetStatus(t.waitedStatus)
+}
+
+// EmitEvent delivers ev, as being about the task and its change, to
+// the event handlers of the state. See State.EmitEvent.
+func (t *Task) EmitEvent(ev *Event) {
+	ev.ChangeID = t.change
+	ev.TaskID = t.id
+	t.state.EmitEvent(ev)
 }
 
 // IsClean returns whether the task has been cleaned. See SetClean.
@@ -206,7 +282,7 @@ func (t *Task) IsClean() bool {
 //
 // Cleaning a task must only be done after the change is ready.
 func (t *Task) SetClean() {
-	t.state.writing()
+	t.writing()
 	if t.clean {
 		return
 	}
@@ -222,12 +298,28 @@ func (t *Task) State() *State {
 	return t.state
 }
 
+// writing marks the task as modified, see State.writing.
+func (t *Task) writing() {
+	t.state.writing()
+	t.state.dirty.addTask(t.id)
+}
+
 // Change returns the change the task is registered with.
 func (t *Task) Change() *Change {
 	t.state.reading()
 	return t.state.changes[t.change]
 }
 
+// LogFields returns the fields identifying the task and its change, to
+// log messages about the task with.
+func (t *Task) LogFields() logger.Fields {
+	return logger.Fields{
+		"change-id": t.change,
+		"task-id":   t.id,
+		"task-kind": t.kind,
+	}
+}
+
 // Progress returns the current progress for the task.
 // If progress is not explicitly set, it returns
 // (0, 1) if the status is DoStatus and (1, 1) otherwise.
@@ -246,7 +338,7 @@ func (t *Task) Progress() (label string, done, total int) {
 func (t *Task) SetProgress(label string, done, total int) {
 	// Only mark state for checkpointing if progress is final.
 	if total > 0 && done == total {
-		t.state.writing()
+		t.writing()
 	} else {
 		t.state.reading()
 	}
@@ -256,6 +348,12 @@ func (t *Task) SetProgress(label string, done, total int) {
 	} else {
 		t.progress = &progress{Label: label, Done: done, Total: total}
 	}
+	if t.progress != nil {
+		t.EmitEvent(&Event{
+			Kind:     EventTaskProgress,
+			Progress: &EventProgress{Label: label, Done: done, Total: total},
+		})
+	}
 }
 
 // SpawnTime returns the time when the change was created.
@@ -300,7 +398,11 @@ func (t *Task) addLog(kind, format string, args []interface{}) {
 	tstr := timeNow().Format(time.RFC3339)
 	msg := fmt.Sprintf(tstr+" "+kind+" "+format, args...)
 	t.log = append(t.log, msg)
-	logger.Debugf(msg)
+	if kind == LogError {
+		logger.WithFields(t.LogFields()).Noticef("%s", msg)
+	} else {
+		logger.WithFields(t.LogFields()).Debugf("%s", msg)
+	}
 }
 
 // Log returns the most recent messages logged into the task.
@@ -321,20 +423,20 @@ func (t *Task) Log() []string {
 
 // Logf logs information about the progress of the task.
 func (t *Task) Logf(format string, args ...interface{}) {
-	t.state.writing()
+	t.writing()
 	t.addLog(LogInfo, format, args)
 }
 
 // Errorf logs error information about the progress of the task.
 func (t *Task) Errorf(format string, args ...interface{}) {
-	t.state.writing()
+	t.writing()
 	t.addLog(LogError, format, args)
 }
 
 // Set associates value with key for future consulting by managers.
 // The provided value must properly marshal and unmarshal with encoding/json.
 func (t *Task) Set(key string, value interface{}) {
-	t.state.writing()
+	t.writing()
 	t.data.set(key, value)
 }
 
@@ -347,7 +449,7 @@ func (t *Task) Get(key string, value interface{}) error {
 
 // Clear disassociates the value from key.
 func (t *Task) Clear(key string) {
-	t.state.writing()
+	t.writing()
 	delete(t.data, key)
 }
 
@@ -362,8 +464,9 @@ func addOnce(set []string, s string) []string {
 
 // WaitFor registers another task as a requirement for t to make progress.
 func (t *Task) WaitFor(another *Task) {
-	t.state.writing()
+	t.writing()
 	t.waitTasks = addOnce(t.waitTasks, another.id)
+	t.state.dirty.addTask(another.id)
 	another.haltTasks = addOnce(another.haltTasks, t.id)
 }
 
@@ -399,13 +502,13 @@ func (t *Task) Lanes() []int {
 // JoinLane registers the task in the provided lane. Tasks in different lanes
 // abort independently on errors. See Change.AbortLane for details.
 func (t *Task) JoinLane(lane int) {
-	t.state.writing()
+	t.writing()
 	t.lanes = append(t.lanes, lane)
 }
 
 // At schedules the task, if it's not ready, to happen no earlier than when, if when is the zero time any previous special scheduling is suppressed.
 func (t *Task) At(when time.Time) {
-	t.state.writing()
+	t.writing()
 	iszero := when.IsZero()
 	if t.Status().Ready() && !iszero {
 		return
@@ -20,11 +20,13 @@
 package state_test
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 
 	. "gopkg.in/check.v1"
 
+	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/testutil"
 	"time"
@@ -67,6 +69,26 @@ func (cs *taskSuite) TestReadyTime(c *C) {
 	c.Check(t.Before(now.Add(5*time.Second)), Equals, true)
 }
 
+func (ts *taskSuite) TestLogFields(c *C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	chg := st.NewChange("install", "...")
+	t := st.NewTask("download", "...")
+	chg.AddTask(t)
+
+	c.Check(t.LogFields(), DeepEquals, logger.Fields{
+		"change-id": chg.ID(),
+		"task-id":   t.ID(),
+		"task-kind": "download",
+	})
+	c.Check(chg.LogFields(), DeepEquals, logger.Fields{
+		"change-id":   chg.ID(),
+		"change-kind": "install",
+	})
+}
+
 func (ts *taskSuite) TestGetSet(c *C) {
 	st := state.New(nil)
 	st.Lock()
@@ -235,6 +257,35 @@ func (ts *taskSuite) TestTaskMarshalsWaitFor(c *C) {
 	c.Assert(string(d), testutil.Contains, needle)
 }
 
+func (ts *taskSuite) TestTaskMarshalsWaitReason(c *C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	chg := st.NewChange("install", "...")
+	t := st.NewTask("download", "1...")
+	chg.AddTask(t)
+	t.SetStatus(state.DoingStatus)
+	t.SetToWait(state.WaitNetwork, "network connection")
+
+	d, err := t.MarshalJSON()
+	c.Assert(err, IsNil)
+	c.Check(string(d), testutil.Contains, `"wait-on":"network","wait-reason":"network connection","waited-status":3`)
+
+	d, err = json.Marshal(st)
+	c.Assert(err, IsNil)
+	st2, err := state.ReadState(nil, bytes.NewReader(d))
+	c.Assert(err, IsNil)
+	st2.Lock()
+	defer st2.Unlock()
+
+	t2 := st2.Task(t.ID())
+	c.Assert(t2, NotNil)
+	c.Check(t2.Status(), Equals, state.WaitStatus)
+	c.Check(t2.WaitOn(), Equals, state.WaitNetwork)
+	c.Check(t2.WaitReason(), Equals, "network connection")
+}
+
 func (ts *taskSuite) TestTaskWaitFor(c *C) {
 	st := state.New(nil)
 	st.Lock()
@@ -20,6 +20,9 @@
 package state
 
 import (
+	"fmt"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
@@ -44,6 +47,28 @@ func (r *Retry) Error() string {
 	return "task should be retried"
 }
 
+// Wait is returned from a handler to put the task in WaitStatus until
+// the event named by On is resumed, instead of retrying it over and
+// over. The handler is then run again. See Task.SetToWait.
+type Wait struct {
+	On     string
+	Reason string
+}
+
+func (w *Wait) Error() string {
+	return fmt.Sprintf("task should wait for %s", w.On)
+}
+
+// Events tasks commonly wait for.
+const (
+	// WaitRestart is resumed once the system restarted.
+	WaitRestart = "system-restart"
+	// WaitNetwork is resumed once the network is known to be up.
+	WaitNetwork = "network"
+	// WaitApproval is resumed once a user approved the change.
+	WaitApproval = "approval"
+)
+
 // TaskRunner controls the running of goroutines to execute known task kinds.
 type TaskRunner struct {
 	state *State
@@ -54,7 +79,8 @@ type TaskRunner struct {
 	cleanups map[string]HandlerFunc
 	stopped  bool
 
-	blocked     func(t *Task, running []*Task) bool
+	blocked     []func(t *Task, running []*Task) bool
+	limits      map[string]func() int
 	someBlocked bool
 
 	// go-routines lifecycle
@@ -71,6 +97,7 @@ func NewTaskRunner(s *State) *TaskRunner {
 		state:    s,
 		handlers: make(map[string]handlerPair),
 		cleanups: make(map[string]HandlerFunc),
+		limits:   make(map[string]func() int),
 		tombs:    make(map[string]*tomb.Tomb),
 	}
 }
@@ -106,11 +133,100 @@ func (r *TaskRunner) AddCleanup(kind string, cleanup HandlerFunc) {
 }
 
 // SetBlocked sets a predicate function to decide whether to block a task from running based on the current running tasks. It can be used to control task serialisation.
+// It replaces any predicate set or added before.
 func (r *TaskRunner) SetBlocked(pred func(t *Task, running []*Task) bool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.blocked = pred
+	r.blocked = []func(t *Task, running []*Task) bool{pred}
+}
+
+// AddBlocked adds a predicate function to decide whether to block a
+// task from running based on the current running tasks. A task is
+// blocked if any of the predicates says so.
+func (r *TaskRunner) AddBlocked(pred func(t *Task, running []*Task) bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.blocked = append(r.blocked, pred)
+}
+
+// SetConcurrencyLimit sets a function returning how many tasks of the
+// given kind can run at the same time. It's called with the state lock
+// held on every Ensure, so the limit can change over time. A limit of 0
+// means no limit, the default, as does a nil function.
+//
+// The handler for tasks of the provided kind must have been previously
+// registered before SetConcurrencyLimit is called for it.
+func (r *TaskRunner) SetConcurrencyLimit(kind string, limit func() int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.handlers[kind]; !ok {
+		panic("internal error: attempted to set concurrency limit for unknown task kind")
+	}
+	if limit == nil {
+		delete(r.limits, kind)
+		return
+	}
+	r.limits[kind] = limit
+}
+
+// currentLimits returns the concurrency limits that apply right now, by
+// task kind.
+func (r *TaskRunner) currentLimits() map[string]int {
+	limits := make(map[string]int, len(r.limits))
+	for kind, limit := range r.limits {
+		limits[kind] = limit()
+	}
+	return limits
+}
+
+// isBlocked returns whether t must wait, either for the running tasks
+// of its kind to go under the limit or because of a blocked predicate.
+func (r *TaskRunner) isBlocked(t *Task, running []*Task, limits map[string]int) bool {
+	if limit := limits[t.Kind()]; limit > 0 {
+		n := 0
+		for _, rt := range running {
+			if rt.Kind() == t.Kind() {
+				n++
+			}
+		}
+		if n >= limit {
+			return true
+		}
+	}
+	for _, pred := range r.blocked {
+		if pred(t, running) {
+			return true
+		}
+	}
+	return false
+}
+
+func taskPriority(t *Task) int {
+	if chg := t.Change(); chg != nil {
+		return chg.Priority()
+	}
+	return DefaultPriority
+}
+
+// byPriority sorts tasks by the priority of their changes, highest
+// first, and then by creation.
+type byPriority []*Task
+
+func (a byPriority) Len() int      { return len(a) }
+func (a byPriority) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byPriority) Less(i, j int) bool {
+	pi, pj := taskPriority(a[i]), taskPriority(a[j])
+	if pi != pj {
+		return pi > pj
+	}
+	idi, erri := strconv.Atoi(a[i].ID())
+	idj, errj := strconv.Atoi(a[j].ID())
+	if erri != nil || errj != nil {
+		return a[i].ID() < a[j].ID()
+	}
+	return idi < idj
 }
 
 // run must be called with the state lock in place
@@ -169,6 +285,13 @@ func (r *TaskRunner) run(t *Task) {
 			} else if x.After != 0 {
 				t.At(timeNow().Add(x.After))
 			}
+		case *Wait:
+			if t.Status() == AbortStatus {
+				// Aborted while running, don't bother waiting.
+				r.tryUndo(t)
+			} else {
+				t.SetToWait(x.On, x.Reason)
+			}
 		case nil:
 			var next []*Task
 			switch t.Status() {
@@ -226,7 +349,7 @@ func (r *TaskRunner) clean(t *Task) {
 		delete(r.tombs, t.ID())
 
 		if tomb.Err() != nil {
-			logger.Debugf("Cleaning task %s: %s", t.ID(), tomb.Err())
+			logger.WithFields(t.LogFields()).Debugf("Cleaning task: %s", tomb.Err())
 		} else {
 			t.SetClean()
 		}
@@ -293,6 +416,7 @@ func (r *TaskRunner) Ensure() {
 
 	ensureTime := timeNow()
 	nextTaskTime := time.Time{}
+	var runnable []*Task
 	for _, t := range r.state.Tasks() {
 		handlers, ok := r.handlers[t.Kind()]
 		if !ok {
@@ -322,6 +446,10 @@ func (r *TaskRunner) Ensure() {
 			}
 			continue
 		}
+		if status == WaitStatus {
+			// Only resuming the event it waits for moves it on.
+			continue
+		}
 		if status == UndoStatus && handlers.undo == nil {
 			// Cannot undo. Revert to done status.
 			t.SetStatus(DoneStatus)
@@ -345,12 +473,20 @@ func (r *TaskRunner) Ensure() {
 			continue
 		}
 
-		if r.blocked != nil && r.blocked(t, running) {
+		runnable = append(runnable, t)
+	}
+
+	// when tasks compete to run, favour the ones of the changes with
+	// higher priority, and then the older ones
+	sort.Sort(byPriority(runnable))
+	limits := r.currentLimits()
+	for _, t := range runnable {
+		if r.isBlocked(t, running, limits) {
 			r.someBlocked = true
 			continue
 		}
 
-		logger.Debugf("Running task %s on %s: %s", t.ID(), t.Status(), t.Summary())
+		logger.WithFields(t.LogFields()).Debugf("Running task on %s: %s", t.Status(), t.Summary())
 		r.run(t)
 
 		running = append(running, t)
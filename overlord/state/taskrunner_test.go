@@ -590,6 +590,300 @@ func (ts *taskRunnerSuite) TestTaskSerialization(c *C) {
 	c.Check(ensureBeforeTick, HasLen, 0)
 }
 
+func (ts *taskRunnerSuite) TestAddBlockedComposes(c *C) {
+	sb := &stateBackend{}
+	st := state.New(sb)
+	r := state.NewTaskRunner(st)
+	defer r.Stop()
+
+	var ran []string
+	for _, kind := range []string{"do1", "do2", "do3"} {
+		r.AddHandler(kind, func(t *state.Task, _ *tomb.Tomb) error {
+			st.Lock()
+			defer st.Unlock()
+			ran = append(ran, t.Kind())
+			return nil
+		}, nil)
+	}
+	r.AddBlocked(func(t *state.Task, running []*state.Task) bool {
+		return t.Kind() == "do1"
+	})
+	r.AddBlocked(func(t *state.Task, running []*state.Task) bool {
+		return t.Kind() == "do2"
+	})
+
+	st.Lock()
+	chg := st.NewChange("install", "...")
+	for _, kind := range []string{"do1", "do2", "do3"} {
+		chg.AddTask(st.NewTask(kind, "..."))
+	}
+	st.Unlock()
+
+	r.Ensure()
+	r.Wait()
+	c.Check(ran, DeepEquals, []string{"do3"})
+
+	// SetBlocked replaces them all
+	r.SetBlocked(func(t *state.Task, running []*state.Task) bool {
+		return t.Kind() == "do2"
+	})
+	r.Ensure()
+	r.Wait()
+	c.Check(ran, DeepEquals, []string{"do3", "do1"})
+}
+
+func (ts *taskRunnerSuite) TestConcurrencyLimit(c *C) {
+	sb := &stateBackend{}
+	st := state.New(sb)
+	r := state.NewTaskRunner(st)
+	defer r.Stop()
+
+	release := make(chan bool)
+	started := make(chan string, 3)
+	r.AddHandler("download", func(t *state.Task, _ *tomb.Tomb) error {
+		started <- t.ID()
+		<-release
+		return nil
+	}, nil)
+	r.SetConcurrencyLimit("download", func() int { return 2 })
+
+	st.Lock()
+	chg := st.NewChange("install", "...")
+	for i := 0; i < 3; i++ {
+		chg.AddTask(st.NewTask("download", "..."))
+	}
+	st.Unlock()
+
+	r.Ensure()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(2 * time.Second):
+			c.Fatal("download wasn't started")
+		}
+	}
+	r.Ensure() // won't start the third one
+	c.Check(started, HasLen, 0)
+
+	release <- true
+	// wait for the task to be done
+	for i := 0; i < 100; i++ {
+		st.Lock()
+		n := 0
+		for _, t := range chg.Tasks() {
+			if t.Status() == state.DoneStatus {
+				n++
+			}
+		}
+		st.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	r.Ensure()
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		c.Fatal("third download wasn't started")
+	}
+	release <- true
+	release <- true
+	r.Wait()
+}
+
+func (ts *taskRunnerSuite) TestConcurrencyLimitChanges(c *C) {
+	sb := &stateBackend{}
+	st := state.New(sb)
+	r := state.NewTaskRunner(st)
+	defer r.Stop()
+
+	release := make(chan bool)
+	started := make(chan string, 2)
+	r.AddHandler("download", func(t *state.Task, _ *tomb.Tomb) error {
+		started <- t.ID()
+		<-release
+		return nil
+	}, nil)
+	limit := 1
+	r.SetConcurrencyLimit("download", func() int { return limit })
+
+	st.Lock()
+	chg := st.NewChange("install", "...")
+	for i := 0; i < 2; i++ {
+		chg.AddTask(st.NewTask("download", "..."))
+	}
+	st.Unlock()
+
+	r.Ensure()
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		c.Fatal("download wasn't started")
+	}
+	r.Ensure() // won't start the second one
+	c.Check(started, HasLen, 0)
+
+	// the new limit applies right away
+	st.Lock()
+	limit = 2
+	st.Unlock()
+	r.Ensure()
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		c.Fatal("second download wasn't started")
+	}
+	release <- true
+	release <- true
+	r.Wait()
+}
+
+func (ts *taskRunnerSuite) TestSetConcurrencyLimitUnknownKindPanics(c *C) {
+	st := state.New(nil)
+	r := state.NewTaskRunner(st)
+	c.Check(func() { r.SetConcurrencyLimit("unknown", func() int { return 1 }) }, PanicMatches, "internal error: attempted to set concurrency limit for unknown task kind")
+}
+
+func (ts *taskRunnerSuite) TestPriority(c *C) {
+	sb := &stateBackend{}
+	st := state.New(sb)
+	r := state.NewTaskRunner(st)
+	defer r.Stop()
+
+	var ran []string
+	r.AddHandler("download", func(t *state.Task, _ *tomb.Tomb) error {
+		st.Lock()
+		defer st.Unlock()
+		ran = append(ran, t.Change().Kind()+":"+t.Summary())
+		return nil
+	}, nil)
+	r.SetConcurrencyLimit("download", func() int { return 1 })
+
+	st.Lock()
+	refresh := st.NewChange("auto-refresh", "...")
+	refresh.SetPriority(state.LowPriority)
+	refresh.AddTask(st.NewTask("download", "1"))
+	refresh.AddTask(st.NewTask("download", "2"))
+	install := st.NewChange("install", "...")
+	install.AddTask(st.NewTask("download", "1"))
+	urgent := st.NewChange("urgent", "...")
+	urgent.SetPriority(state.HighPriority)
+	urgent.AddTask(st.NewTask("download", "1"))
+	st.Unlock()
+
+	for i := 0; i < 4; i++ {
+		r.Ensure()
+		r.Wait()
+	}
+	c.Check(ran, DeepEquals, []string{"urgent:1", "install:1", "auto-refresh:1", "auto-refresh:2"})
+}
+
+func (ts *taskRunnerSuite) TestWait(c *C) {
+	sb := &stateBackend{}
+	st := state.New(sb)
+	r := state.NewTaskRunner(st)
+	defer r.Stop()
+
+	calls := 0
+	r.AddHandler("wait", func(t *state.Task, _ *tomb.Tomb) error {
+		calls++
+		if calls == 1 {
+			return &state.Wait{On: state.WaitApproval, Reason: "operator approval"}
+		}
+		return nil
+	}, nil)
+	r.AddHandler("after", func(t *state.Task, _ *tomb.Tomb) error { return nil }, nil)
+
+	st.Lock()
+	chg := st.NewChange("install", "...")
+	t1 := st.NewTask("wait", "...")
+	t2 := st.NewTask("after", "...")
+	t2.WaitFor(t1)
+	chg.AddTask(t1)
+	chg.AddTask(t2)
+	st.Unlock()
+
+	// the task waits, and keeps waiting, without being run again
+	for i := 0; i < 3; i++ {
+		r.Ensure()
+		r.Wait()
+	}
+
+	st.Lock()
+	c.Check(calls, Equals, 1)
+	c.Check(t1.Status(), Equals, state.WaitStatus)
+	c.Check(t1.WaitOn(), Equals, state.WaitApproval)
+	c.Check(t1.WaitReason(), Equals, "operator approval")
+	c.Check(t2.Status(), Equals, state.DoStatus)
+	c.Check(chg.Status(), Equals, state.WaitStatus)
+	c.Check(chg.IsReady(), Equals, false)
+
+	c.Check(st.Resume(state.WaitNetwork), HasLen, 0)
+	c.Check(chg.Resume(state.WaitApproval), DeepEquals, []*state.Task{t1})
+	c.Check(t1.Status(), Equals, state.DoingStatus)
+	c.Check(t1.WaitOn(), Equals, "")
+	c.Check(t1.WaitReason(), Equals, "")
+	st.Unlock()
+
+	for i := 0; i < 3; i++ {
+		r.Ensure()
+		r.Wait()
+	}
+
+	st.Lock()
+	defer st.Unlock()
+	c.Check(calls, Equals, 2)
+	c.Check(t1.Status(), Equals, state.DoneStatus)
+	c.Check(t2.Status(), Equals, state.DoneStatus)
+	c.Check(chg.Status(), Equals, state.DoneStatus)
+}
+
+func (ts *taskRunnerSuite) TestAbortWaiting(c *C) {
+	sb := &stateBackend{}
+	st := state.New(sb)
+	r := state.NewTaskRunner(st)
+	defer r.Stop()
+
+	var undone bool
+	r.AddHandler("wait", func(t *state.Task, _ *tomb.Tomb) error {
+		return &state.Wait{On: state.WaitRestart, Reason: "system restart"}
+	}, func(t *state.Task, _ *tomb.Tomb) error {
+		undone = true
+		return nil
+	})
+
+	st.Lock()
+	chg := st.NewChange("install", "...")
+	t := st.NewTask("wait", "...")
+	chg.AddTask(t)
+	st.Unlock()
+
+	r.Ensure()
+	r.Wait()
+
+	st.Lock()
+	c.Check(t.Status(), Equals, state.WaitStatus)
+	chg.Abort()
+	c.Check(t.Status(), Equals, state.AbortStatus)
+	c.Check(t.WaitOn(), Equals, "")
+	st.Unlock()
+
+	for i := 0; i < 3; i++ {
+		r.Ensure()
+		r.Wait()
+	}
+
+	st.Lock()
+	defer st.Unlock()
+	c.Check(undone, Equals, true)
+	c.Check(t.Status(), Equals, state.UndoneStatus)
+	c.Check(chg.Status(), Equals, state.UndoneStatus)
+
+	// nothing to resume anymore
+	c.Check(st.Resume(state.WaitRestart), HasLen, 0)
+}
+
 func (ts *taskRunnerSuite) TestPrematureChangeReady(c *C) {
 	sb := &stateBackend{}
 	st := state.New(sb)
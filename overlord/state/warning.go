@@ -0,0 +1,295 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/snapcore/snapd/logger"
+)
+
+var (
+	// DefaultRepeatAfter is how long after being okayed a warning
+	// that is added again is shown again
+	DefaultRepeatAfter = 24 * time.Hour
+	// DefaultExpireAfter is how long after being added last a warning
+	// is forgotten about
+	DefaultExpireAfter = 28 * 24 * time.Hour
+)
+
+// A Warning is a problem that is worth surfacing to the users of the
+// system, as opposed to only logging it. Warnings with the same
+// message are collapsed into one, remembering when it was added first
+// and last.
+type Warning struct {
+	message    string
+	firstAdded time.Time
+	lastAdded  time.Time
+	// lastShown is when the warning was last acknowledged as seen
+	lastShown   time.Time
+	expireAfter time.Duration
+	repeatAfter time.Duration
+}
+
+type marshalledWarning struct {
+	Message     string     `json:"message"`
+	FirstAdded  time.Time  `json:"first-added"`
+	LastAdded   time.Time  `json:"last-added"`
+	LastShown   *time.Time `json:"last-shown,omitempty"`
+	ExpireAfter string     `json:"expire-after,omitempty"`
+	RepeatAfter string     `json:"repeat-after,omitempty"`
+}
+
+func (w *Warning) String() string {
+	return w.message
+}
+
+// MarshalJSON makes Warning a json.Marshaller
+func (w *Warning) MarshalJSON() ([]byte, error) {
+	jw := marshalledWarning{
+		Message:     w.message,
+		FirstAdded:  w.firstAdded,
+		LastAdded:   w.lastAdded,
+		ExpireAfter: w.expireAfter.String(),
+		RepeatAfter: w.repeatAfter.String(),
+	}
+	if !w.lastShown.IsZero() {
+		jw.LastShown = &w.lastShown
+	}
+	return json.Marshal(jw)
+}
+
+// UnmarshalJSON makes Warning a json.Unmarshaller
+func (w *Warning) UnmarshalJSON(data []byte) error {
+	var jw marshalledWarning
+	if err := json.Unmarshal(data, &jw); err != nil {
+		return err
+	}
+	w.message = jw.Message
+	w.firstAdded = jw.FirstAdded
+	w.lastAdded = jw.LastAdded
+	if jw.LastShown != nil {
+		w.lastShown = *jw.LastShown
+	}
+	w.expireAfter = DefaultExpireAfter
+	if jw.ExpireAfter != "" {
+		d, err := time.ParseDuration(jw.ExpireAfter)
+		if err != nil {
+			return err
+		}
+		w.expireAfter = d
+	}
+	w.repeatAfter = DefaultRepeatAfter
+	if jw.RepeatAfter != "" {
+		d, err := time.ParseDuration(jw.RepeatAfter)
+		if err != nil {
+			return err
+		}
+		w.repeatAfter = d
+	}
+	return nil
+}
+
+// Message returns the message of the warning.
+func (w *Warning) Message() string {
+	return w.message
+}
+
+// FirstAdded returns when the warning was first added.
+func (w *Warning) FirstAdded() time.Time {
+	return w.firstAdded
+}
+
+// LastAdded returns when the warning was last added.
+func (w *Warning) LastAdded() time.Time {
+	return w.lastAdded
+}
+
+// LastShown returns when the warning was last acknowledged as seen,
+// or the zero time if never.
+func (w *Warning) LastShown() time.Time {
+	return w.lastShown
+}
+
+// ExpireAfter returns how long after being added last the warning is
+// forgotten about.
+func (w *Warning) ExpireAfter() time.Duration {
+	return w.expireAfter
+}
+
+// RepeatAfter returns how long after being acknowledged the warning is
+// shown again, if added again.
+func (w *Warning) RepeatAfter() time.Duration {
+	return w.repeatAfter
+}
+
+// IsExpired returns whether the warning is to be forgotten about at
+// the given time.
+func (w *Warning) IsExpired(now time.Time) bool {
+	return w.lastAdded.Add(w.expireAfter).Before(now)
+}
+
+// IsPending returns whether the warning still needs to be acknowledged
+// at the given time: it never was, or it was added again since and its
+// repeat delay passed.
+func (w *Warning) IsPending(now time.Time) bool {
+	if w.IsExpired(now) {
+		return false
+	}
+	if w.lastShown.IsZero() {
+		return true
+	}
+	return w.lastAdded.After(w.lastShown) && !w.lastShown.Add(w.repeatAfter).After(now)
+}
+
+// Warnf records a warning, with a message formatted as with
+// fmt.Sprintf. A warning with the same message as an existing one
+// just updates when it was last added. The warning is also logged.
+func (s *State) Warnf(template string, args ...interface{}) {
+	message := fmt.Sprintf(template, args...)
+	s.addWarning(message, timeNow())
+}
+
+func (s *State) addWarning(message string, now time.Time) {
+	s.writing()
+	s.pruneWarnings(now)
+	logger.Noticef("WARNING: %s", message)
+
+	if w, ok := s.warnings[message]; ok {
+		w.lastAdded = now
+		return
+	}
+	if s.warnings == nil {
+		s.warnings = make(map[string]*Warning)
+	}
+	s.warnings[message] = &Warning{
+		message:     message,
+		firstAdded:  now,
+		lastAdded:   now,
+		expireAfter: DefaultExpireAfter,
+		repeatAfter: DefaultRepeatAfter,
+	}
+}
+
+func (s *State) pruneWarnings(now time.Time) {
+	for k, w := range s.warnings {
+		if w.IsExpired(now) {
+			delete(s.warnings, k)
+		}
+	}
+}
+
+func (s *State) flattenWarnings() []*Warning {
+	if len(s.warnings) == 0 {
+		return nil
+	}
+	return s.selectWarnings(func(*Warning) bool { return true })
+}
+
+func (s *State) unflattenWarnings(flat []*Warning) {
+	s.warnings = nil
+	if len(flat) == 0 {
+		return
+	}
+	s.warnings = make(map[string]*Warning, len(flat))
+	for _, w := range flat {
+		s.warnings[w.message] = w
+	}
+}
+
+type byLastAdded []*Warning
+
+func (a byLastAdded) Len() int      { return len(a) }
+func (a byLastAdded) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byLastAdded) Less(i, j int) bool {
+	if !a[i].lastAdded.Equal(a[j].lastAdded) {
+		return a[i].lastAdded.Before(a[j].lastAdded)
+	}
+	if !a[i].firstAdded.Equal(a[j].firstAdded) {
+		return a[i].firstAdded.Before(a[j].firstAdded)
+	}
+	return a[i].message < a[j].message
+}
+
+func (s *State) selectWarnings(pick func(*Warning) bool) []*Warning {
+	var ws []*Warning
+	for _, w := range s.warnings {
+		if pick(w) {
+			ws = append(ws, w)
+		}
+	}
+	sort.Sort(byLastAdded(ws))
+	return ws
+}
+
+// AllWarnings returns all the warnings that did not expire, sorted by
+// when they were last added.
+func (s *State) AllWarnings() []*Warning {
+	s.reading()
+	now := timeNow()
+	return s.selectWarnings(func(w *Warning) bool {
+		return !w.IsExpired(now)
+	})
+}
+
+// PendingWarnings returns the warnings still needing to be
+// acknowledged, sorted by when they were last added.
+func (s *State) PendingWarnings() []*Warning {
+	s.reading()
+	now := timeNow()
+	return s.selectWarnings(func(w *Warning) bool {
+		return w.IsPending(now)
+	})
+}
+
+// WarningsSummary returns how many warnings are pending and when the
+// last of them was added.
+func (s *State) WarningsSummary() (count int, latest time.Time) {
+	s.reading()
+	now := timeNow()
+	for _, w := range s.warnings {
+		if !w.IsPending(now) {
+			continue
+		}
+		count++
+		if w.lastAdded.After(latest) {
+			latest = w.lastAdded
+		}
+	}
+	return count, latest
+}
+
+// OkayWarnings acknowledges as seen the pending warnings that were last
+// added no later than t, and returns how many there were.
+func (s *State) OkayWarnings(t time.Time) int {
+	s.writing()
+	now := timeNow()
+	n := 0
+	for _, w := range s.warnings {
+		if w.IsPending(now) && !w.lastAdded.After(t) {
+			w.lastShown = now
+			n++
+		}
+	}
+	return n
+}
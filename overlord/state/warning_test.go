@@ -0,0 +1,149 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package state_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+type warningSuite struct{}
+
+var _ = Suite(&warningSuite{})
+
+func (ws *warningSuite) TestWarnfAddsAndCollapses(c *C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	t0 := time.Date(2017, 4, 21, 1, 2, 3, 0, time.UTC)
+	restore := state.MockTime(t0)
+	st.Warnf("hello %s", "world")
+	restore()
+	restore = state.MockTime(t0.Add(time.Minute))
+	defer restore()
+	st.Warnf("hello %s", "world")
+	st.Warnf("something else")
+
+	all := st.AllWarnings()
+	c.Assert(all, HasLen, 2)
+	c.Check(all[0].Message(), Equals, "hello world")
+	c.Check(all[0].FirstAdded(), Equals, t0)
+	c.Check(all[0].LastAdded(), Equals, t0.Add(time.Minute))
+	c.Check(all[0].LastShown().IsZero(), Equals, true)
+	c.Check(all[0].ExpireAfter(), Equals, state.DefaultExpireAfter)
+	c.Check(all[0].RepeatAfter(), Equals, state.DefaultRepeatAfter)
+	c.Check(all[1].Message(), Equals, "something else")
+
+	count, latest := st.WarningsSummary()
+	c.Check(count, Equals, 2)
+	c.Check(latest, Equals, t0.Add(time.Minute))
+}
+
+func (ws *warningSuite) TestOkayAndRepeat(c *C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	t0 := time.Date(2017, 4, 21, 1, 2, 3, 0, time.UTC)
+	restore := state.MockTime(t0)
+	defer restore()
+	st.Warnf("one")
+	state.MockTime(t0.Add(time.Minute))
+	st.Warnf("two")
+
+	// only what was seen is okayed
+	c.Check(st.OkayWarnings(t0), Equals, 1)
+	pending := st.PendingWarnings()
+	c.Assert(pending, HasLen, 1)
+	c.Check(pending[0].Message(), Equals, "two")
+	c.Check(st.OkayWarnings(t0.Add(time.Minute)), Equals, 1)
+	c.Check(st.PendingWarnings(), HasLen, 0)
+	c.Check(st.AllWarnings(), HasLen, 2)
+
+	// added again, but too soon to be shown again
+	state.MockTime(t0.Add(time.Hour))
+	st.Warnf("one")
+	c.Check(st.PendingWarnings(), HasLen, 0)
+
+	// shown again once the repeat delay passed
+	state.MockTime(t0.Add(time.Minute + state.DefaultRepeatAfter))
+	count, latest := st.WarningsSummary()
+	c.Check(count, Equals, 1)
+	c.Check(latest, Equals, t0.Add(time.Hour))
+}
+
+func (ws *warningSuite) TestExpiry(c *C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	t0 := time.Date(2017, 4, 21, 1, 2, 3, 0, time.UTC)
+	restore := state.MockTime(t0)
+	defer restore()
+	st.Warnf("old")
+
+	state.MockTime(t0.Add(state.DefaultExpireAfter + time.Second))
+	c.Check(st.AllWarnings(), HasLen, 0)
+	c.Check(st.PendingWarnings(), HasLen, 0)
+
+	// expired warnings are pruned when adding
+	st.Warnf("new")
+	data, err := json.Marshal(st)
+	c.Assert(err, IsNil)
+	var m map[string]interface{}
+	c.Assert(json.Unmarshal(data, &m), IsNil)
+	c.Check(m["warnings"], HasLen, 1)
+}
+
+func (ws *warningSuite) TestRoundTrip(c *C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	t0 := time.Date(2017, 4, 21, 1, 2, 3, 0, time.UTC)
+	restore := state.MockTime(t0)
+	defer restore()
+	st.Warnf("one")
+	st.OkayWarnings(t0)
+	state.MockTime(t0.Add(time.Minute))
+	st.Warnf("two")
+
+	data, err := json.Marshal(st)
+	c.Assert(err, IsNil)
+
+	st2, err := state.ReadState(nil, bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	st2.Lock()
+	defer st2.Unlock()
+
+	all := st2.AllWarnings()
+	c.Assert(all, HasLen, 2)
+	c.Check(all[0].Message(), Equals, "one")
+	c.Check(all[0].LastShown(), Equals, t0)
+	c.Check(all[0].ExpireAfter(), Equals, state.DefaultExpireAfter)
+	c.Check(all[1].Message(), Equals, "two")
+	c.Check(all[1].LastShown().IsZero(), Equals, true)
+}
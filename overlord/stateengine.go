@@ -22,12 +22,18 @@ package overlord
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/metrics"
 
 	"github.com/snapcore/snapd/overlord/state"
 )
 
+var ensureDuration = metrics.NewHistogramVec("snapd_ensure_duration_seconds",
+	"Time taken by the ensure passes of the state engine.",
+	metrics.DefaultBuckets)
+
 // StateManager is implemented by types responsible for observing
 // the system and manipulating it to reflect the desired state.
 type StateManager interface {
@@ -91,6 +97,10 @@ func (se *StateEngine) Ensure() error {
 	if se.stopped {
 		return fmt.Errorf("state engine already stopped")
 	}
+	start := time.Now()
+	defer func() {
+		ensureDuration.Observe(time.Since(start).Seconds())
+	}()
 	var errs []error
 	for _, m := range se.managers {
 		err := m.Ensure()
@@ -0,0 +1,134 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package polkit asks the polkit authority whether processes are
+// authorized to perform privileged actions.
+//
+// The authority is asked through pkcheck, which ships with polkit and
+// makes the org.freedesktop.PolicyKit1.Authority.CheckAuthorization
+// call on the system bus for us, with the same subject, details and
+// interaction flag, and reports its result through documented exit
+// codes. This keeps a D-Bus client library out of snapd for a check
+// that only happens when a non-root user asks for a privileged action,
+// where the cost of running pkcheck is small next to that of asking the
+// user to authenticate.
+package polkit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/snapcore/snapd/osutil"
+)
+
+// CheckFlags modify how authorization is checked.
+type CheckFlags uint32
+
+const (
+	CheckNone CheckFlags = 0
+	// CheckAllowInteraction lets polkit ask the user to authenticate,
+	// e.g. by typing their password, if the action requires it
+	CheckAllowInteraction CheckFlags = 1
+)
+
+// ErrDismissed is returned when the user dismissed the
+// authentication dialog.
+var ErrDismissed = errors.New("authorization request dismissed")
+
+var (
+	procRoot   = "/proc"
+	pkcheckCmd = "pkcheck"
+)
+
+// processStartTime returns the start time of the process, in clock
+// ticks since boot, which together with its pid identifies it to
+// polkit without races against pid reuse.
+func processStartTime(pid uint32) (uint64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(procRoot, strconv.FormatUint(uint64(pid), 10), "stat"))
+	if err != nil {
+		return 0, err
+	}
+	// the command name can contain spaces and parens, skip it
+	idx := bytes.LastIndexByte(data, ')')
+	if idx < 0 {
+		return 0, fmt.Errorf("cannot parse stat of process %d", pid)
+	}
+	// fields after the command name start with the third one, state,
+	// and the start time is the 22nd
+	fields := strings.Fields(string(data[idx+1:]))
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("cannot parse stat of process %d", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// CheckAuthorization asks the polkit authority whether the process pid
+// owned by uid is authorized for actionID. details are passed along to be used in the
+// authentication dialog, if interaction is allowed by flags.
+func CheckAuthorization(pid, uid uint32, actionID string, details map[string]string, flags CheckFlags) (bool, error) {
+	startTime, err := processStartTime(pid)
+	if err != nil {
+		return false, fmt.Errorf("cannot identify process %d for polkit: %v", pid, err)
+	}
+
+	args := []string{
+		"--action-id", actionID,
+		"--process", fmt.Sprintf("%d,%d,%d", pid, startTime, uid),
+	}
+	keys := make([]string, 0, len(details))
+	for k := range details {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, "--detail", k, details[k])
+	}
+	if flags&CheckAllowInteraction != 0 {
+		args = append(args, "--allow-user-interaction")
+	}
+
+	cmd := exec.Command(pkcheckCmd, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err == nil {
+		return true, nil
+	}
+	code, err := osutil.ExitCode(err)
+	if err != nil {
+		return false, fmt.Errorf("cannot check authorization with polkit: %v", err)
+	}
+	// see pkcheck(1) for the meaning of the exit codes
+	switch code {
+	case 1, 2:
+		// not authorized, or only after authentication that
+		// was not allowed
+		return false, nil
+	case 3:
+		return false, ErrDismissed
+	}
+	return false, fmt.Errorf("cannot check authorization with polkit: %v", osutil.OutputErr(stderr.Bytes(), fmt.Errorf("exit status %d", code)))
+}
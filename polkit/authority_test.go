@@ -0,0 +1,113 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package polkit_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/polkit"
+	"github.com/snapcore/snapd/testutil"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type polkitSuite struct {
+	restore func()
+}
+
+var _ = Suite(&polkitSuite{})
+
+func (s *polkitSuite) SetUpTest(c *C) {
+	root := c.MkDir()
+	s.restore = polkit.MockProcRoot(root)
+	c.Assert(os.MkdirAll(filepath.Join(root, "42"), 0755), IsNil)
+	// the command name can contain spaces and parens
+	stat := "42 (my (cmd) x) S 1 42 42 0 -1 4194560 1 0 0 0 0 0 0 0 20 0 1 0 12345 0 0\n"
+	c.Assert(ioutil.WriteFile(filepath.Join(root, "42", "stat"), []byte(stat), 0644), IsNil)
+}
+
+func (s *polkitSuite) TearDownTest(c *C) {
+	s.restore()
+}
+
+func (s *polkitSuite) TestProcessStartTime(c *C) {
+	t, err := polkit.ProcessStartTime(42)
+	c.Assert(err, IsNil)
+	c.Check(t, Equals, uint64(12345))
+
+	_, err = polkit.ProcessStartTime(43)
+	c.Check(err, NotNil)
+}
+
+func (s *polkitSuite) TestCheckAuthorized(c *C) {
+	cmd := testutil.MockCommand(c, "pkcheck", "")
+	defer cmd.Restore()
+
+	ok, err := polkit.CheckAuthorization(42, 1000, "io.snapcraft.snapd.install", map[string]string{"snap": "foo"}, polkit.CheckAllowInteraction)
+	c.Assert(err, IsNil)
+	c.Check(ok, Equals, true)
+	c.Check(cmd.Calls(), DeepEquals, [][]string{{
+		"pkcheck",
+		"--action-id", "io.snapcraft.snapd.install",
+		"--process", "42,12345,1000",
+		"--detail", "snap", "foo",
+		"--allow-user-interaction",
+	}})
+}
+
+func (s *polkitSuite) TestCheckNotAuthorized(c *C) {
+	for _, code := range []string{"1", "2"} {
+		cmd := testutil.MockCommand(c, "pkcheck", "exit "+code)
+		ok, err := polkit.CheckAuthorization(42, 1000, "io.snapcraft.snapd.remove", nil, polkit.CheckNone)
+		c.Check(err, IsNil)
+		c.Check(ok, Equals, false)
+		c.Check(cmd.Calls(), DeepEquals, [][]string{{
+			"pkcheck",
+			"--action-id", "io.snapcraft.snapd.remove",
+			"--process", "42,12345,1000",
+		}})
+		cmd.Restore()
+	}
+}
+
+func (s *polkitSuite) TestCheckDismissed(c *C) {
+	cmd := testutil.MockCommand(c, "pkcheck", "exit 3")
+	defer cmd.Restore()
+
+	ok, err := polkit.CheckAuthorization(42, 1000, "io.snapcraft.snapd.install", nil, polkit.CheckAllowInteraction)
+	c.Check(err, Equals, polkit.ErrDismissed)
+	c.Check(ok, Equals, false)
+}
+
+func (s *polkitSuite) TestCheckError(c *C) {
+	cmd := testutil.MockCommand(c, "pkcheck", "echo 'no authority' >&2; exit 4")
+	defer cmd.Restore()
+
+	_, err := polkit.CheckAuthorization(42, 1000, "io.snapcraft.snapd.install", nil, polkit.CheckNone)
+	c.Check(err, ErrorMatches, "cannot check authorization with polkit: no authority")
+
+	_, err = polkit.CheckAuthorization(43, 1000, "io.snapcraft.snapd.install", nil, polkit.CheckNone)
+	c.Check(err, ErrorMatches, "cannot identify process 43 for polkit: .*")
+}
@@ -0,0 +1,156 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snap
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultTrack is the track used when a channel does not name one.
+const DefaultTrack = "latest"
+
+// Risks lists the channel risk levels, from most to least stable.
+var Risks = []string{"stable", "candidate", "beta", "edge"}
+
+func isRisk(s string) bool {
+	for _, risk := range Risks {
+		if s == risk {
+			return true
+		}
+	}
+	return false
+}
+
+// Channel is a parsed store channel of the form track/risk/branch.
+type Channel struct {
+	Track  string
+	Risk   string
+	Branch string
+}
+
+// ParseChannel parses a channel name. The track and branch are
+// optional, the risk defaults to stable if only a track is given, so
+// for example "edge", "2.x", "2.x/beta" and "stable/hotfix-1" are all
+// valid channels.
+func ParseChannel(s string) (Channel, error) {
+	if s == "" {
+		return Channel{}, fmt.Errorf("channel name cannot be empty")
+	}
+	parts := strings.Split(s, "/")
+	for _, part := range parts {
+		if part == "" {
+			return Channel{}, fmt.Errorf("invalid channel name %q", s)
+		}
+	}
+
+	ch := Channel{Track: DefaultTrack}
+	switch len(parts) {
+	case 1:
+		if isRisk(parts[0]) {
+			ch.Risk = parts[0]
+		} else {
+			ch.Track = parts[0]
+			ch.Risk = "stable"
+		}
+	case 2:
+		if isRisk(parts[0]) {
+			ch.Risk = parts[0]
+			ch.Branch = parts[1]
+		} else {
+			ch.Track = parts[0]
+			ch.Risk = parts[1]
+		}
+	case 3:
+		ch.Track = parts[0]
+		ch.Risk = parts[1]
+		ch.Branch = parts[2]
+	default:
+		return Channel{}, fmt.Errorf("invalid channel name %q", s)
+	}
+
+	if !isRisk(ch.Risk) {
+		return Channel{}, fmt.Errorf("invalid risk in channel name %q: %q", s, ch.Risk)
+	}
+
+	return ch, nil
+}
+
+// String returns the shortest name of the channel, leaving out the
+// default track.
+func (c Channel) String() string {
+	name := c.Risk
+	if c.Track != "" && c.Track != DefaultTrack {
+		name = c.Track + "/" + name
+	}
+	if c.Branch != "" {
+		name += "/" + c.Branch
+	}
+	return name
+}
+
+// Full returns the name of the channel always including the track.
+func (c Channel) Full() string {
+	track := c.Track
+	if track == "" {
+		track = DefaultTrack
+	}
+	name := track + "/" + c.Risk
+	if c.Branch != "" {
+		name += "/" + c.Branch
+	}
+	return name
+}
+
+// Fallbacks returns the channels to try, in order, when this channel
+// is closed: a branch falls back to the risk it was branched from,
+// and a closed risk falls back to the next risk in the same track,
+// so e.g. 2.x/stable is followed by 2.x/candidate.
+func (c Channel) Fallbacks() []Channel {
+	var fallbacks []Channel
+	if c.Branch != "" {
+		fallbacks = append(fallbacks, Channel{Track: c.Track, Risk: c.Risk})
+	}
+	found := false
+	for _, risk := range Risks {
+		if found {
+			fallbacks = append(fallbacks, Channel{Track: c.Track, Risk: risk})
+		}
+		if risk == c.Risk {
+			found = true
+		}
+	}
+	return fallbacks
+}
+
+// Lookup finds the snap served for this channel in the given channel
+// map, as reported by the store, following the fallbacks for closed
+// channels. It returns nil if nothing is served.
+func (c Channel) Lookup(channels map[string]*ChannelSnapInfo) *ChannelSnapInfo {
+	for _, ch := range append([]Channel{c}, c.Fallbacks()...) {
+		if info := channels[ch.String()]; info != nil {
+			return info
+		}
+		if info := channels[ch.Full()]; info != nil {
+			return info
+		}
+	}
+	return nil
+}
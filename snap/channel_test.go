@@ -0,0 +1,125 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snap_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/snap"
+)
+
+type channelSuite struct{}
+
+var _ = Suite(&channelSuite{})
+
+func (s *channelSuite) TestParseChannel(c *C) {
+	for _, t := range []struct {
+		name  string
+		ch    snap.Channel
+		short string
+		full  string
+	}{
+		{"stable", snap.Channel{Track: "latest", Risk: "stable"}, "stable", "latest/stable"},
+		{"edge", snap.Channel{Track: "latest", Risk: "edge"}, "edge", "latest/edge"},
+		{"2.x", snap.Channel{Track: "2.x", Risk: "stable"}, "2.x/stable", "2.x/stable"},
+		{"2.x/beta", snap.Channel{Track: "2.x", Risk: "beta"}, "2.x/beta", "2.x/beta"},
+		{"latest/beta", snap.Channel{Track: "latest", Risk: "beta"}, "beta", "latest/beta"},
+		{"candidate/hotfix-1", snap.Channel{Track: "latest", Risk: "candidate", Branch: "hotfix-1"}, "candidate/hotfix-1", "latest/candidate/hotfix-1"},
+		{"2.x/stable/hotfix-1", snap.Channel{Track: "2.x", Risk: "stable", Branch: "hotfix-1"}, "2.x/stable/hotfix-1", "2.x/stable/hotfix-1"},
+	} {
+		ch, err := snap.ParseChannel(t.name)
+		c.Assert(err, IsNil, Commentf(t.name))
+		c.Check(ch, Equals, t.ch, Commentf(t.name))
+		c.Check(ch.String(), Equals, t.short, Commentf(t.name))
+		c.Check(ch.Full(), Equals, t.full, Commentf(t.name))
+	}
+}
+
+func (s *channelSuite) TestParseChannelErrors(c *C) {
+	for _, t := range []struct {
+		name string
+		err  string
+	}{
+		{"", `channel name cannot be empty`},
+		{"/", `invalid channel name "/"`},
+		{"2.x/", `invalid channel name "2.x/"`},
+		{"a/stable/b/c", `invalid channel name "a/stable/b/c"`},
+		{"2.x/foo", `invalid risk in channel name "2.x/foo": "foo"`},
+		{"2.x/foo/bar", `invalid risk in channel name "2.x/foo/bar": "foo"`},
+	} {
+		_, err := snap.ParseChannel(t.name)
+		c.Check(err, ErrorMatches, t.err, Commentf(t.name))
+	}
+}
+
+func (s *channelSuite) TestFallbacks(c *C) {
+	names := func(chs []snap.Channel) []string {
+		var l []string
+		for _, ch := range chs {
+			l = append(l, ch.String())
+		}
+		return l
+	}
+
+	ch, err := snap.ParseChannel("2.x/stable")
+	c.Assert(err, IsNil)
+	c.Check(names(ch.Fallbacks()), DeepEquals, []string{"2.x/candidate", "2.x/beta", "2.x/edge"})
+
+	ch, err = snap.ParseChannel("beta/hotfix-1")
+	c.Assert(err, IsNil)
+	c.Check(names(ch.Fallbacks()), DeepEquals, []string{"beta", "edge"})
+
+	ch, err = snap.ParseChannel("edge")
+	c.Assert(err, IsNil)
+	c.Check(ch.Fallbacks(), HasLen, 0)
+}
+
+func (s *channelSuite) TestLookup(c *C) {
+	channels := map[string]*snap.ChannelSnapInfo{
+		"stable":        {Channel: "stable", Revision: snap.R(1)},
+		"2.x/candidate": {Channel: "2.x/candidate", Revision: snap.R(2)},
+		"latest/edge":   {Channel: "latest/edge", Revision: snap.R(3)},
+	}
+
+	for _, t := range []struct {
+		name string
+		rev  snap.Revision
+	}{
+		{"stable", snap.R(1)},
+		{"latest/stable", snap.R(1)},
+		{"stable/hotfix-1", snap.R(1)},
+		// 2.x/stable is closed, candidate is served instead
+		{"2.x", snap.R(2)},
+		{"2.x/candidate", snap.R(2)},
+		// latest/candidate and beta are closed
+		{"candidate", snap.R(3)},
+		{"edge", snap.R(3)},
+	} {
+		ch, err := snap.ParseChannel(t.name)
+		c.Assert(err, IsNil)
+		info := ch.Lookup(channels)
+		c.Assert(info, NotNil, Commentf(t.name))
+		c.Check(info.Revision, Equals, t.rev, Commentf(t.name))
+	}
+
+	ch, err := snap.ParseChannel("2.x/edge")
+	c.Assert(err, IsNil)
+	c.Check(ch.Lookup(channels), IsNil)
+}
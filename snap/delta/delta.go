@@ -0,0 +1,129 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package delta generates and applies binary deltas between snap files.
+package delta
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+)
+
+const (
+	// FormatXdelta3 is the delta format produced by xdelta3 from the
+	// snap files as they are.
+	FormatXdelta3 = "xdelta3"
+	// FormatSquashfs is the delta format produced by xdelta3 from the
+	// uncompressed contents of the snaps, which is rebuilt into the
+	// target snap with mksquashfs.
+	FormatSquashfs = "squashfs"
+)
+
+// xdelta3Magic is how VCDIFF files, as written by xdelta3, start.
+var xdelta3Magic = []byte{0xd6, 0xc3, 0xc4}
+
+// Xdelta3Cmd returns a command running xdelta3 with the given
+// arguments, either from the PATH or from the core snap.
+func Xdelta3Cmd(args ...string) (*exec.Cmd, error) {
+	switch {
+	case osutil.ExecutableExists("xdelta3"):
+		return exec.Command("xdelta3", args...), nil
+	case osutil.FileExists(filepath.Join(dirs.SnapMountDir, "/core/current/usr/bin/xdelta3")):
+		return osutil.CommandFromCore("/usr/bin/xdelta3", args...)
+	}
+	return nil, fmt.Errorf("cannot find xdelta3 binary in PATH or core snap")
+}
+
+// Generate writes to deltaPath a delta in the given format that turns
+// the snap at sourcePath into the one at targetPath.
+func Generate(format, sourcePath, targetPath, deltaPath string) error {
+	switch format {
+	case FormatXdelta3:
+		return generateXdelta3(sourcePath, targetPath, deltaPath)
+	case FormatSquashfs:
+		return generateSquashfs(sourcePath, targetPath, deltaPath)
+	}
+	return fmt.Errorf("cannot generate unsupported delta format %q", format)
+}
+
+// Apply writes to targetPath the snap obtained by applying the delta
+// in the given format at deltaPath to the snap at sourcePath.
+func Apply(format, sourcePath, deltaPath, targetPath string) error {
+	switch format {
+	case FormatXdelta3:
+		return applyXdelta3(sourcePath, deltaPath, targetPath)
+	case FormatSquashfs:
+		return applySquashfs(sourcePath, deltaPath, targetPath)
+	}
+	return fmt.Errorf("cannot apply unsupported delta format %q", format)
+}
+
+// FormatOf returns the format of the delta at deltaPath.
+func FormatOf(deltaPath string) (string, error) {
+	f, err := os.Open(deltaPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, len(squashfsDeltaMagic))
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", fmt.Errorf("cannot read delta: %v", err)
+	}
+	buf = buf[:n]
+	switch {
+	case bytes.Equal(buf, []byte(squashfsDeltaMagic)):
+		return FormatSquashfs, nil
+	case bytes.HasPrefix(buf, xdelta3Magic):
+		return FormatXdelta3, nil
+	}
+	return "", fmt.Errorf("cannot determine the format of delta %q", deltaPath)
+}
+
+func generateXdelta3(sourcePath, targetPath, deltaPath string) error {
+	cmd, err := Xdelta3Cmd("-e", "-s", sourcePath, targetPath, deltaPath)
+	if err != nil {
+		return err
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("cannot generate delta: %v", osutil.OutputErr(output, err))
+	}
+
+	return nil
+}
+
+func applyXdelta3(sourcePath, deltaPath, targetPath string) error {
+	cmd, err := Xdelta3Cmd("-d", "-s", sourcePath, deltaPath, targetPath)
+	if err != nil {
+		return err
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("cannot apply delta: %v", osutil.OutputErr(output, err))
+	}
+
+	return nil
+}
@@ -0,0 +1,200 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package delta_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/snap/delta"
+	"github.com/snapcore/snapd/testutil"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type deltaSuite struct {
+	mockXdelta3 *testutil.MockCmd
+}
+
+var _ = Suite(&deltaSuite{})
+
+func (s *deltaSuite) SetUpTest(c *C) {
+	s.mockXdelta3 = testutil.MockCommand(c, "xdelta3", "")
+}
+
+func (s *deltaSuite) TearDownTest(c *C) {
+	s.mockXdelta3.Restore()
+}
+
+func (s *deltaSuite) TestGenerate(c *C) {
+	err := delta.Generate("xdelta3", "foo_1.snap", "foo_2.snap", "foo_1-to-2.delta")
+	c.Assert(err, IsNil)
+	c.Check(s.mockXdelta3.Calls(), DeepEquals, [][]string{
+		{"xdelta3", "-e", "-s", "foo_1.snap", "foo_2.snap", "foo_1-to-2.delta"},
+	})
+}
+
+func (s *deltaSuite) TestApply(c *C) {
+	err := delta.Apply("xdelta3", "foo_1.snap", "foo_1-to-2.delta", "foo_2.snap")
+	c.Assert(err, IsNil)
+	c.Check(s.mockXdelta3.Calls(), DeepEquals, [][]string{
+		{"xdelta3", "-d", "-s", "foo_1.snap", "foo_1-to-2.delta", "foo_2.snap"},
+	})
+}
+
+func (s *deltaSuite) TestUnsupportedFormat(c *C) {
+	err := delta.Generate("nodelta", "foo_1.snap", "foo_2.snap", "foo_1-to-2.delta")
+	c.Check(err, ErrorMatches, `cannot generate unsupported delta format "nodelta"`)
+	err = delta.Apply("nodelta", "foo_1.snap", "foo_1-to-2.delta", "foo_2.snap")
+	c.Check(err, ErrorMatches, `cannot apply unsupported delta format "nodelta"`)
+	c.Check(s.mockXdelta3.Calls(), HasLen, 0)
+}
+
+func (s *deltaSuite) TestXdelta3Fails(c *C) {
+	s.mockXdelta3.Restore()
+	s.mockXdelta3 = testutil.MockCommand(c, "xdelta3", "echo 'target window checksum mismatch'; exit 1")
+
+	err := delta.Apply("xdelta3", "foo_1.snap", "foo_1-to-2.delta", "foo_2.snap")
+	c.Check(err, ErrorMatches, `cannot apply delta: target window checksum mismatch`)
+}
+
+// makeSquashfs writes a file at path that starts like a squashfs snap
+// built with xz compression, 128KiB blocks, no fragments, no xattrs,
+// duplicates and exports, followed by content.
+func makeSquashfs(c *C, path, content string) {
+	var buf bytes.Buffer
+	sb := struct {
+		Magic         [4]byte
+		Inodes        uint32
+		MkfsTime      uint32
+		BlockSize     uint32
+		Fragments     uint32
+		CompressionID uint16
+		BlockLog      uint16
+		Flags         uint16
+	}{
+		Magic:         [4]byte{'h', 's', 'q', 's'},
+		MkfsTime:      1234,
+		BlockSize:     131072,
+		CompressionID: 4,
+		BlockLog:      17,
+		Flags:         0x10 | 0x200 | 0x40 | 0x80,
+	}
+	c.Assert(binary.Write(&buf, binary.LittleEndian, &sb), IsNil)
+	buf.WriteString(content)
+	c.Assert(ioutil.WriteFile(path, buf.Bytes(), 0644), IsNil)
+}
+
+func (s *deltaSuite) TestGenerateApplySquashfs(c *C) {
+	// the mocks below pass the contents of the snaps through as
+	// they are
+	s.mockXdelta3.Restore()
+	s.mockXdelta3 = testutil.MockCommand(c, "xdelta3", `cp "$4" "$5"`)
+	unsquashfs := testutil.MockCommand(c, "unsquashfs", `mkdir -p "$3" && cp "$4" "$3/content"`)
+	defer unsquashfs.Restore()
+	mksquashfs := testutil.MockCommand(c, "mksquashfs", `cp "$1/content" "$2"`)
+	defer mksquashfs.Restore()
+
+	d := c.MkDir()
+	source := filepath.Join(d, "foo_1.snap")
+	target := filepath.Join(d, "foo_2.snap")
+	deltaPath := filepath.Join(d, "foo_1-to-2.delta")
+	result := filepath.Join(d, "result.snap")
+	makeSquashfs(c, source, "source")
+	makeSquashfs(c, target, "target")
+
+	err := delta.Generate("squashfs", source, target, deltaPath)
+	c.Assert(err, IsNil)
+	format, err := delta.FormatOf(deltaPath)
+	c.Assert(err, IsNil)
+	c.Check(format, Equals, "squashfs")
+	c.Check(unsquashfs.Calls(), HasLen, 2)
+	c.Check(s.mockXdelta3.Calls(), HasLen, 1)
+	c.Check(s.mockXdelta3.Calls()[0][:2], DeepEquals, []string{"xdelta3", "-e"})
+
+	err = delta.Apply("squashfs", source, deltaPath, result)
+	c.Assert(err, IsNil)
+	content, err := ioutil.ReadFile(result)
+	c.Assert(err, IsNil)
+	expected, err := ioutil.ReadFile(target)
+	c.Assert(err, IsNil)
+	c.Check(content, DeepEquals, expected)
+
+	c.Check(s.mockXdelta3.Calls(), HasLen, 2)
+	c.Check(s.mockXdelta3.Calls()[1][:2], DeepEquals, []string{"xdelta3", "-d"})
+	calls := mksquashfs.Calls()
+	c.Assert(calls, HasLen, 1)
+	c.Check(calls[0][2:], DeepEquals, []string{
+		result,
+		"-noappend", "-comp", "xz", "-b", "131072", "-mkfs-time", "1234", "-all-root",
+		"-no-fragments", "-no-xattrs",
+	})
+}
+
+func (s *deltaSuite) TestGenerateSquashfsNotSquashfs(c *C) {
+	d := c.MkDir()
+	target := filepath.Join(d, "foo_2.snap")
+	c.Assert(ioutil.WriteFile(target, bytes.Repeat([]byte("x"), 100), 0644), IsNil)
+
+	err := delta.Generate("squashfs", "foo_1.snap", target, "foo_1-to-2.delta")
+	c.Check(err, ErrorMatches, `cannot use ".*/foo_2.snap": not a squashfs snap`)
+	c.Check(s.mockXdelta3.Calls(), HasLen, 0)
+}
+
+func (s *deltaSuite) TestApplySquashfsNotSquashfsDelta(c *C) {
+	deltaPath := filepath.Join(c.MkDir(), "foo_1-to-2.delta")
+	c.Assert(ioutil.WriteFile(deltaPath, []byte("\xd6\xc3\xc4\x00"), 0644), IsNil)
+
+	err := delta.Apply("squashfs", "foo_1.snap", deltaPath, "foo_2.snap")
+	c.Check(err, ErrorMatches, `cannot apply delta: not a squashfs delta`)
+	c.Check(s.mockXdelta3.Calls(), HasLen, 0)
+}
+
+func (s *deltaSuite) TestFormatOf(c *C) {
+	d := c.MkDir()
+	for _, t := range []struct {
+		content string
+		format  string
+		err     string
+	}{
+		{"\xd6\xc3\xc4\x00rest", "xdelta3", ""},
+		{"snap-squashfs-delta\n{}\n", "squashfs", ""},
+		{"snap-squashfs", "", `cannot determine the format of delta ".*"`},
+		{"", "", `cannot determine the format of delta ".*"`},
+	} {
+		deltaPath := filepath.Join(d, "foo.delta")
+		c.Assert(ioutil.WriteFile(deltaPath, []byte(t.content), 0644), IsNil)
+		format, err := delta.FormatOf(deltaPath)
+		if t.err != "" {
+			c.Check(err, ErrorMatches, t.err)
+			continue
+		}
+		c.Check(err, IsNil)
+		c.Check(format, Equals, t.format)
+	}
+
+	_, err := delta.FormatOf(filepath.Join(d, "missing.delta"))
+	c.Check(err, NotNil)
+}
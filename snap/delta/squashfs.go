@@ -0,0 +1,480 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package delta
+
+import (
+	"archive/tar"
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/snapcore/snapd/osutil"
+)
+
+// A squashfs delta is an xdelta3 delta between tar streams of the
+// contents of the source and target snaps, which unlike the snap files
+// themselves are not compressed and so have much more in common. It
+// starts with squashfsDeltaMagic and a line with the squashfsHeader
+// describing how to rebuild the target snap from its contents.
+//
+// Rebuilding gives back the very same target snap only if mksquashfs
+// does so given the same contents and options, which the caller must
+// check, e.g. against the digest of the target snap.
+const squashfsDeltaMagic = "snap-squashfs-delta\n"
+
+// squashfsHeader holds what the superblock of the target snap tells
+// about the options it was built with.
+type squashfsHeader struct {
+	Compression string `json:"compression"`
+	BlockSize   uint32 `json:"block-size"`
+	Flags       uint16 `json:"flags"`
+	MkfsTime    uint32 `json:"mkfs-time"`
+}
+
+// squashfs superblock flags, see squashfs_fs.h
+const (
+	squashfsNoInodeCompression    = 0x0001
+	squashfsNoDataCompression     = 0x0002
+	squashfsNoFragmentCompression = 0x0008
+	squashfsNoFragments           = 0x0010
+	squashfsAlwaysFragments       = 0x0020
+	squashfsDuplicates            = 0x0040
+	squashfsExportable            = 0x0080
+	squashfsNoXattrCompression    = 0x0100
+	squashfsNoXattrs              = 0x0200
+)
+
+var squashfsCompressions = map[uint16]string{
+	1: "gzip",
+	2: "lzma",
+	3: "lzo",
+	4: "xz",
+	5: "lz4",
+	6: "zstd",
+}
+
+// readSquashfsHeader reads the header for the snap at snapPath from
+// its superblock.
+func readSquashfsHeader(snapPath string) (*squashfsHeader, error) {
+	f, err := os.Open(snapPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var sb struct {
+		Magic         [4]byte
+		Inodes        uint32
+		MkfsTime      uint32
+		BlockSize     uint32
+		Fragments     uint32
+		CompressionID uint16
+		BlockLog      uint16
+		Flags         uint16
+	}
+	if err := binary.Read(f, binary.LittleEndian, &sb); err != nil {
+		return nil, fmt.Errorf("cannot read squashfs superblock of %q: %v", snapPath, err)
+	}
+	if string(sb.Magic[:]) != "hsqs" {
+		return nil, fmt.Errorf("cannot use %q: not a squashfs snap", snapPath)
+	}
+	compression, ok := squashfsCompressions[sb.CompressionID]
+	if !ok {
+		return nil, fmt.Errorf("cannot use %q: unknown squashfs compression %d", snapPath, sb.CompressionID)
+	}
+	return &squashfsHeader{
+		Compression: compression,
+		BlockSize:   sb.BlockSize,
+		Flags:       sb.Flags,
+		MkfsTime:    sb.MkfsTime,
+	}, nil
+}
+
+// mksquashfsArgs returns the options for mksquashfs to build a snap
+// like the one the header was read from. Files in snaps are owned by
+// root, ownership is not kept in the delta.
+func (h *squashfsHeader) mksquashfsArgs() []string {
+	args := []string{
+		"-noappend",
+		"-comp", h.Compression,
+		"-b", strconv.FormatUint(uint64(h.BlockSize), 10),
+		"-mkfs-time", strconv.FormatUint(uint64(h.MkfsTime), 10),
+		"-all-root",
+	}
+	for _, opt := range []struct {
+		flag uint16
+		set  bool
+		arg  string
+	}{
+		{squashfsNoInodeCompression, true, "-noI"},
+		{squashfsNoDataCompression, true, "-noD"},
+		{squashfsNoFragmentCompression, true, "-noF"},
+		{squashfsNoXattrCompression, true, "-noX"},
+		{squashfsNoFragments, true, "-no-fragments"},
+		{squashfsAlwaysFragments, true, "-always-use-fragments"},
+		{squashfsNoXattrs, true, "-no-xattrs"},
+		{squashfsDuplicates, false, "-no-duplicates"},
+		{squashfsExportable, false, "-no-exports"},
+	} {
+		if (h.Flags&opt.flag != 0) == opt.set {
+			args = append(args, opt.arg)
+		}
+	}
+	return args
+}
+
+func unsquashfs(snapPath, dir string) error {
+	output, err := exec.Command("unsquashfs", "-no-progress", "-d", dir, snapPath).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("cannot unpack %q: %v", snapPath, osutil.OutputErr(output, err))
+	}
+	return nil
+}
+
+// tarModeBits maps the special bits of modes in tar headers to those
+// of os.FileMode.
+var tarModeBits = map[int64]os.FileMode{
+	04000: os.ModeSetuid,
+	02000: os.ModeSetgid,
+	01000: os.ModeSticky,
+}
+
+func tarMode(mode int64) os.FileMode {
+	m := os.FileMode(mode).Perm()
+	for bit, special := range tarModeBits {
+		if mode&bit != 0 {
+			m |= special
+		}
+	}
+	return m
+}
+
+// writeTree writes the contents of dir to tarPath as a tar stream that
+// only depends on the names, types, modes, times and contents of the
+// files in it, so that the stream is the same wherever and by whomever
+// the snap was unpacked into dir.
+func writeTree(dir, tarPath string) error {
+	f, err := os.Create(tarPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	// hard links are kept as such
+	inodes := make(map[uint64]string)
+	err = filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    int64(fi.Mode().Perm()),
+			ModTime: fi.ModTime(),
+		}
+		for bit, mode := range tarModeBits {
+			if fi.Mode()&mode != 0 {
+				hdr.Mode |= bit
+			}
+		}
+
+		switch {
+		case fi.Mode().IsDir():
+			hdr.Typeflag = tar.TypeDir
+		case fi.Mode()&os.ModeSymlink != 0:
+			hdr.Typeflag = tar.TypeSymlink
+			if hdr.Linkname, err = os.Readlink(path); err != nil {
+				return err
+			}
+		case fi.Mode().IsRegular():
+			st, ok := fi.Sys().(*syscall.Stat_t)
+			if ok && st.Nlink > 1 {
+				if first, ok := inodes[st.Ino]; ok {
+					hdr.Typeflag = tar.TypeLink
+					hdr.Linkname = first
+					return tw.WriteHeader(hdr)
+				}
+				inodes[st.Ino] = name
+			}
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = fi.Size()
+		default:
+			return fmt.Errorf("cannot handle %q: unsupported file type %s", name, fi.Mode().String())
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		content, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+		_, err = io.Copy(tw, content)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// readTree unpacks a tar stream written by writeTree into dir.
+func readTree(tarPath, dir string) error {
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// modes and times of directories are set once nothing else is
+	// added to them
+	var dirs []*tar.Header
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, hdr.Name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			dirs = append(dirs, hdr)
+			continue
+		case tar.TypeSymlink:
+			if err := os.Symlink(hdr.Linkname, path); err != nil {
+				return err
+			}
+			if err := lutimes(path, hdr.ModTime); err != nil {
+				return err
+			}
+			continue
+		case tar.TypeLink:
+			if err := os.Link(filepath.Join(dir, hdr.Linkname), path); err != nil {
+				return err
+			}
+			continue
+		case tar.TypeReg:
+			content, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(content, tr)
+			if cerr := content.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+			if err := os.Chmod(path, tarMode(hdr.Mode)); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("cannot handle %q: unsupported tar entry type %q", hdr.Name, hdr.Typeflag)
+		}
+		if err := os.Chtimes(path, hdr.ModTime, hdr.ModTime); err != nil {
+			return err
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		path := filepath.Join(dir, dirs[i].Name)
+		if err := os.Chmod(path, tarMode(dirs[i].Mode)); err != nil {
+			return err
+		}
+		if err := os.Chtimes(path, dirs[i].ModTime, dirs[i].ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const (
+	atFdcwd           = -100
+	atSymlinkNofollow = 0x100
+)
+
+// lutimes sets the times of the symlink at path, not of its target.
+func lutimes(path string, t time.Time) error {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	ts := [2]syscall.Timespec{syscall.NsecToTimespec(t.UnixNano()), syscall.NsecToTimespec(t.UnixNano())}
+	fd := atFdcwd
+	_, _, errno := syscall.Syscall6(syscall.SYS_UTIMENSAT, uintptr(fd), uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&ts[0])), atSymlinkNofollow, 0, 0)
+	if errno != 0 {
+		return &os.PathError{Op: "lutimes", Path: path, Err: errno}
+	}
+	return nil
+}
+
+// snapToTree unpacks the snap at snapPath and writes its contents to
+// tarPath, see writeTree.
+func snapToTree(snapPath, tarPath string) error {
+	dir := tarPath + ".d"
+	if err := unsquashfs(snapPath, dir); err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	return writeTree(dir, tarPath)
+}
+
+// generateSquashfs writes a squashfs delta, see squashfsDeltaMagic.
+func generateSquashfs(sourcePath, targetPath, deltaPath string) error {
+	header, err := readSquashfsHeader(targetPath)
+	if err != nil {
+		return err
+	}
+
+	tmpdir, err := ioutil.TempDir("", "snap-delta-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	sourceTree := filepath.Join(tmpdir, "source.tar")
+	targetTree := filepath.Join(tmpdir, "target.tar")
+	treeDelta := filepath.Join(tmpdir, "delta")
+	if err := snapToTree(sourcePath, sourceTree); err != nil {
+		return err
+	}
+	if err := snapToTree(targetPath, targetTree); err != nil {
+		return err
+	}
+	if err := generateXdelta3(sourceTree, targetTree, treeDelta); err != nil {
+		return err
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(treeDelta)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(deltaPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := io.WriteString(out, squashfsDeltaMagic); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(out, "%s\n", headerJSON); err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// applySquashfs applies a squashfs delta, see squashfsDeltaMagic.
+func applySquashfs(sourcePath, deltaPath, targetPath string) error {
+	in, err := os.Open(deltaPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	r := bufio.NewReader(in)
+	magic, err := r.ReadString('\n')
+	if err != nil || magic != squashfsDeltaMagic {
+		return fmt.Errorf("cannot apply delta: not a squashfs delta")
+	}
+	headerJSON, err := r.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("cannot apply delta: cannot read header: %v", err)
+	}
+	var header squashfsHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return fmt.Errorf("cannot apply delta: cannot decode header: %v", err)
+	}
+
+	tmpdir, err := ioutil.TempDir("", "snap-delta-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	treeDelta := filepath.Join(tmpdir, "delta")
+	out, err := os.Create(treeDelta)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	sourceTree := filepath.Join(tmpdir, "source.tar")
+	targetTree := filepath.Join(tmpdir, "target.tar")
+	targetDir := filepath.Join(tmpdir, "target")
+	if err := snapToTree(sourcePath, sourceTree); err != nil {
+		return err
+	}
+	if err := applyXdelta3(sourceTree, treeDelta, targetTree); err != nil {
+		return err
+	}
+	if err := os.Mkdir(targetDir, 0755); err != nil {
+		return err
+	}
+	if err := readTree(targetTree, targetDir); err != nil {
+		return fmt.Errorf("cannot unpack delta target: %v", err)
+	}
+
+	args := append([]string{targetDir, targetPath}, header.mksquashfsArgs()...)
+	if output, err := exec.Command("mksquashfs", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("cannot build %q: %v", targetPath, osutil.OutputErr(output, err))
+	}
+	return nil
+}
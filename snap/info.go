@@ -168,6 +168,9 @@ type Info struct {
 
 	Screenshots []ScreenshotInfo
 	Channels    map[string]*ChannelSnapInfo
+	// Tracks are the tracks of the snap, if the store reported them
+	// along with the channels
+	Tracks []string
 }
 
 // ChannelSnapInfo is the minimum information that can be used to clearly
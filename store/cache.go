@@ -0,0 +1,193 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultCacheMaxSize is how many bytes of snaps that are not in
+	// use anywhere else the cache keeps by default.
+	defaultCacheMaxSize = 1024 * 1024 * 1024
+	// defaultCacheMaxAge is for how long an unused entry is kept.
+	defaultCacheMaxAge = 30 * 24 * time.Hour
+)
+
+// downloadCache is the interface of the cache of downloaded snaps
+// consulted by the store before downloading.
+type downloadCache interface {
+	// Get makes the cached file with the given key available at
+	// targetPath.
+	Get(cacheKey, targetPath string) error
+	// Put adds the file at sourcePath to the cache under the given
+	// key.
+	Put(cacheKey, sourcePath string) error
+}
+
+// nullCache is used when there is no cache of downloads.
+type nullCache struct{}
+
+func (nullCache) Get(cacheKey, targetPath string) error {
+	return fmt.Errorf("cannot get items from the nullCache")
+}
+
+func (nullCache) Put(cacheKey, sourcePath string) error {
+	return nil
+}
+
+// CacheManager keeps downloaded snaps in a directory, hardlinked under
+// their SHA3-384 digest, so the same file is never downloaded twice
+// while it is in the cache.
+type CacheManager struct {
+	cacheDir string
+	maxSize  int64
+	maxAge   time.Duration
+}
+
+// NewCacheManager returns a CacheManager for the given directory.
+// When the cache is pruned, entries not used for longer than maxAge
+// are removed, and then the least recently used ones until the space
+// taken by entries not also linked elsewhere is at most maxSize bytes.
+// A zero maxAge disables pruning by age.
+func NewCacheManager(cacheDir string, maxSize int64, maxAge time.Duration) *CacheManager {
+	return &CacheManager{
+		cacheDir: cacheDir,
+		maxSize:  maxSize,
+		maxAge:   maxAge,
+	}
+}
+
+func (cm *CacheManager) path(cacheKey string) (string, error) {
+	if cacheKey == "" || strings.ContainsRune(cacheKey, '/') || strings.HasPrefix(cacheKey, ".") {
+		return "", fmt.Errorf("invalid cache key %q", cacheKey)
+	}
+	return filepath.Join(cm.cacheDir, cacheKey), nil
+}
+
+// Get hardlinks the cached file with the given key to targetPath.
+func (cm *CacheManager) Get(cacheKey, targetPath string) error {
+	path, err := cm.path(cacheKey)
+	if err != nil {
+		return err
+	}
+	if err := os.Link(path, targetPath); err != nil {
+		return err
+	}
+	// mark the entry as used, for pruning
+	now := time.Now()
+	return os.Chtimes(path, now, now)
+}
+
+// Put hardlinks the file at sourcePath into the cache under the given
+// key, and prunes the cache.
+func (cm *CacheManager) Put(cacheKey, sourcePath string) error {
+	path, err := cm.path(cacheKey)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cm.cacheDir, 0700); err != nil {
+		return err
+	}
+	if err := os.Link(sourcePath, path); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return cm.prune()
+}
+
+type byModTime []os.FileInfo
+
+func (fis byModTime) Len() int           { return len(fis) }
+func (fis byModTime) Swap(i, j int)      { fis[i], fis[j] = fis[j], fis[i] }
+func (fis byModTime) Less(i, j int) bool { return fis[i].ModTime().Before(fis[j].ModTime()) }
+
+// onlyInCache returns whether removing the entry frees its space, that
+// is, whether it is not linked from anywhere else, e.g. the installed
+// snaps.
+func onlyInCache(fi os.FileInfo) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return !ok || st.Nlink <= 1
+}
+
+func (cm *CacheManager) prune() error {
+	fis, err := ioutil.ReadDir(cm.cacheDir)
+	if err != nil {
+		return err
+	}
+	sort.Sort(byModTime(fis))
+
+	var size int64
+	for _, fi := range fis {
+		if onlyInCache(fi) {
+			size += fi.Size()
+		}
+	}
+
+	now := time.Now()
+	for _, fi := range fis {
+		tooOld := cm.maxAge > 0 && now.Sub(fi.ModTime()) > cm.maxAge
+		tooBig := size > cm.maxSize && onlyInCache(fi)
+		if !tooOld && !tooBig {
+			continue
+		}
+		if err := os.Remove(filepath.Join(cm.cacheDir, fi.Name())); err != nil {
+			return err
+		}
+		if onlyInCache(fi) {
+			size -= fi.Size()
+		}
+	}
+
+	return nil
+}
+
+// PrunePartialDownloads removes the partial downloads in dir not
+// written to for longer than maxAge, which are left behind by
+// downloads that were interrupted and never retried.
+func PrunePartialDownloads(dir string, maxAge time.Duration) error {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.partial"))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, path := range matches {
+		fi, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if now.Sub(fi.ModTime()) <= maxAge {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
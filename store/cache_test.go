@@ -0,0 +1,158 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/osutil"
+)
+
+type cacheSuite struct {
+	cm  *CacheManager
+	tmp string
+}
+
+var _ = Suite(&cacheSuite{})
+
+func (s *cacheSuite) SetUpTest(c *C) {
+	s.tmp = c.MkDir()
+	s.cm = NewCacheManager(filepath.Join(s.tmp, "cache"), 10, time.Hour)
+}
+
+func (s *cacheSuite) makeFile(c *C, name, content string, age time.Duration) string {
+	p := filepath.Join(s.tmp, name)
+	c.Assert(ioutil.WriteFile(p, []byte(content), 0644), IsNil)
+	mtime := time.Now().Add(-age)
+	c.Assert(os.Chtimes(p, mtime, mtime), IsNil)
+	return p
+}
+
+func (s *cacheSuite) cached(c *C) []string {
+	fis, err := ioutil.ReadDir(s.cm.cacheDir)
+	c.Assert(err, IsNil)
+	names := make([]string, len(fis))
+	for i, fi := range fis {
+		names[i] = fi.Name()
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *cacheSuite) TestPutGet(c *C) {
+	p := s.makeFile(c, "foo.snap", "foo", 0)
+	c.Assert(s.cm.Put("sha3-foo", p), IsNil)
+	c.Check(s.cached(c), DeepEquals, []string{"sha3-foo"})
+
+	// putting it again is fine
+	c.Assert(s.cm.Put("sha3-foo", p), IsNil)
+
+	target := filepath.Join(s.tmp, "target.snap")
+	c.Assert(s.cm.Get("sha3-foo", target), IsNil)
+	content, err := ioutil.ReadFile(target)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "foo")
+
+	fi1, err := os.Stat(p)
+	c.Assert(err, IsNil)
+	fi2, err := os.Stat(target)
+	c.Assert(err, IsNil)
+	c.Check(os.SameFile(fi1, fi2), Equals, true)
+}
+
+func (s *cacheSuite) TestGetMissing(c *C) {
+	err := s.cm.Get("sha3-missing", filepath.Join(s.tmp, "target.snap"))
+	c.Check(os.IsNotExist(err), Equals, true)
+	c.Check(osutil.FileExists(filepath.Join(s.tmp, "target.snap")), Equals, false)
+}
+
+func (s *cacheSuite) TestInvalidKeys(c *C) {
+	p := s.makeFile(c, "foo.snap", "foo", 0)
+	for _, key := range []string{"", "../foo", "a/b", ".hidden"} {
+		c.Check(s.cm.Put(key, p), ErrorMatches, `invalid cache key .*`)
+		c.Check(s.cm.Get(key, filepath.Join(s.tmp, "target")), ErrorMatches, `invalid cache key .*`)
+	}
+}
+
+func (s *cacheSuite) TestPruneBySize(c *C) {
+	for _, t := range []struct {
+		key string
+		age time.Duration
+	}{
+		{"oldest", 3 * time.Minute},
+		{"older", 2 * time.Minute},
+		{"newest", time.Minute},
+	} {
+		p := s.makeFile(c, t.key, "123456", t.age)
+		c.Assert(s.cm.Put(t.key, p), IsNil)
+		// only the cache has it now
+		c.Assert(os.Remove(p), IsNil)
+	}
+
+	// only 10 bytes fit, the least recently used entry went
+	c.Check(s.cached(c), DeepEquals, []string{"newest", "older"})
+}
+
+func (s *cacheSuite) TestPruneBySizeKeepsLinkedEntries(c *C) {
+	for _, key := range []string{"a", "b", "c"} {
+		p := s.makeFile(c, key, "12345", time.Minute)
+		c.Assert(s.cm.Put(key, p), IsNil)
+	}
+
+	// all are also linked outside of the cache and free nothing
+	c.Check(s.cached(c), DeepEquals, []string{"a", "b", "c"})
+}
+
+func (s *cacheSuite) TestPruneByAge(c *C) {
+	p := s.makeFile(c, "stale", "1", 2*time.Hour)
+	c.Assert(s.cm.Put("stale", p), IsNil)
+	p = s.makeFile(c, "fresh", "2", time.Minute)
+	c.Assert(s.cm.Put("fresh", p), IsNil)
+
+	c.Check(s.cached(c), DeepEquals, []string{"fresh"})
+}
+
+func (s *cacheSuite) TestGetMarksUsed(c *C) {
+	p := s.makeFile(c, "old", "12345", 50*time.Minute)
+	c.Assert(s.cm.Put("old", p), IsNil)
+	c.Assert(os.Remove(p), IsNil)
+
+	c.Assert(s.cm.Get("old", filepath.Join(s.tmp, "target")), IsNil)
+	fi, err := os.Stat(filepath.Join(s.cm.cacheDir, "old"))
+	c.Assert(err, IsNil)
+	c.Check(time.Since(fi.ModTime()) < time.Minute, Equals, true)
+}
+
+func (s *cacheSuite) TestPrunePartialDownloads(c *C) {
+	old := s.makeFile(c, "old.partial", "x", 8*24*time.Hour)
+	recent := s.makeFile(c, "recent.partial", "x", time.Minute)
+	snap := s.makeFile(c, "foo_1.snap", "x", 8*24*time.Hour)
+
+	c.Assert(PrunePartialDownloads(s.tmp, 7*24*time.Hour), IsNil)
+	c.Check(osutil.FileExists(old), Equals, false)
+	c.Check(osutil.FileExists(recent), Equals, true)
+	c.Check(osutil.FileExists(snap), Equals, true)
+}
@@ -0,0 +1,228 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package store
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/httputil"
+)
+
+var (
+	connectivityLookupHost = net.LookupHost
+	connectivityProxy      = http.ProxyFromEnvironment
+	connectivityTimeout    = 10 * time.Second
+)
+
+// ConnectivityStatus is the outcome of probing one of the endpoints
+// used to talk to the store.
+type ConnectivityStatus struct {
+	Endpoint string `json:"endpoint"`
+	URL      string `json:"url,omitempty"`
+	// Proxy is set if the endpoint is reached via a proxy
+	Proxy string `json:"proxy,omitempty"`
+
+	// DNS is "ok" or "failed" once the host, or the proxy, was
+	// looked up
+	DNS string `json:"dns,omitempty"`
+	// TLS is "ok" or "failed" once the TLS handshake with an https
+	// endpoint was attempted; through a proxy it is only known to be
+	// "ok" once the endpoint replied
+	TLS string `json:"tls,omitempty"`
+	// HTTPStatus is the status code replied by the endpoint
+	HTTPStatus int `json:"http-status,omitempty"`
+
+	// Error says what failed, if anything
+	Error string `json:"error,omitempty"`
+}
+
+// ConnectivityCheck probes the endpoints of the store, through the
+// configured proxies, and reports for each whether its host could be
+// looked up, a TLS connection made and how it replied to HTTP.
+func (s *Store) ConnectivityCheck() ([]*ConnectivityStatus, error) {
+	// use the same proxies as reported in the statuses
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy: func(req *http.Request) (*url.URL, error) {
+				return connectivityProxy(req)
+			},
+			TLSHandshakeTimeout: connectivityTimeout,
+		},
+		Timeout: connectivityTimeout,
+	}
+
+	var statuses []*ConnectivityStatus
+	probe := func(endpoint string, u *url.URL, method, accept string) (*ConnectivityStatus, []byte) {
+		st, body := s.probe(client, endpoint, u, method, accept)
+		statuses = append(statuses, st)
+		return st, body
+	}
+
+	if s.searchURI != nil {
+		u := *s.searchURI
+		q := u.Query()
+		q.Set("q", "core")
+		u.RawQuery = q.Encode()
+		probe("search", &u, "GET", halJsonContentType)
+	}
+
+	var downloadURL string
+	if s.detailsURI != nil {
+		u, err := s.detailsURI.Parse("core")
+		if err != nil {
+			return nil, err
+		}
+		q := s.detailsURI.Query()
+		q.Set("channel", "stable")
+		u.RawQuery = q.Encode()
+		st, body := probe("details", u, "GET", halJsonContentType)
+		if st.HTTPStatus == http.StatusOK {
+			var details snapDetails
+			if err := json.Unmarshal(body, &details); err == nil {
+				downloadURL = details.AnonDownloadURL
+			}
+		}
+	}
+
+	if s.assertionsURI != nil {
+		u, err := s.assertionsURI.Parse("account/canonical")
+		if err != nil {
+			return nil, err
+		}
+		probe("assertions", u, "GET", asserts.MediaType)
+	}
+
+	if downloadURL != "" {
+		u, err := url.Parse(downloadURL)
+		if err != nil {
+			return nil, err
+		}
+		probe("download", u, "HEAD", "*/*")
+	} else {
+		statuses = append(statuses, &ConnectivityStatus{
+			Endpoint: "download",
+			Error:    "cannot find the download host without store details",
+		})
+	}
+
+	u, err := url.Parse(MyAppsDeviceSessionAPI)
+	if err != nil {
+		return nil, err
+	}
+	probe("device-service", u, "GET", jsonContentType)
+
+	return statuses, nil
+}
+
+// probe checks in turn the steps needed to talk to the endpoint at u,
+// stopping at the first that fails, and returns the first bytes of the
+// body it replied with.
+func (s *Store) probe(client *http.Client, endpoint string, u *url.URL, method, accept string) (*ConnectivityStatus, []byte) {
+	st := &ConnectivityStatus{
+		Endpoint: endpoint,
+		URL:      u.String(),
+	}
+
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		st.Error = err.Error()
+		return st, nil
+	}
+	req.Header.Set("User-Agent", httputil.UserAgent())
+	req.Header.Set("Accept", accept)
+	req.Header.Set("X-Ubuntu-Architecture", s.architecture)
+	req.Header.Set("X-Ubuntu-Series", s.series)
+
+	proxy, err := connectivityProxy(req)
+	if err != nil {
+		st.Error = fmt.Sprintf("cannot determine proxy: %v", err)
+		return st, nil
+	}
+
+	// with a proxy only the proxy needs to be resolved, and TLS is
+	// tunnelled through it
+	dnsHost := hostOnly(u)
+	if proxy != nil {
+		st.Proxy = proxy.String()
+		dnsHost = hostOnly(proxy)
+	}
+	if _, err := connectivityLookupHost(dnsHost); err != nil {
+		st.DNS = "failed"
+		st.Error = fmt.Sprintf("cannot resolve %q: %v", dnsHost, err)
+		return st, nil
+	}
+	st.DNS = "ok"
+
+	if u.Scheme == "https" && proxy == nil {
+		dialer := &net.Dialer{Timeout: connectivityTimeout}
+		conn, err := tls.DialWithDialer(dialer, "tcp", hostPort(u), &tls.Config{ServerName: hostOnly(u)})
+		if err != nil {
+			st.TLS = "failed"
+			st.Error = fmt.Sprintf("cannot establish TLS connection: %v", err)
+			return st, nil
+		}
+		conn.Close()
+		st.TLS = "ok"
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		st.Error = fmt.Sprintf("cannot talk to endpoint: %v", err)
+		return st, nil
+	}
+	defer resp.Body.Close()
+	if u.Scheme == "https" && proxy != nil {
+		st.TLS = "ok"
+	}
+	st.HTTPStatus = resp.StatusCode
+	if resp.StatusCode >= 500 {
+		st.Error = fmt.Sprintf("endpoint replied with %s", resp.Status)
+	}
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	return st, body
+}
+
+func hostOnly(u *url.URL) string {
+	host, _, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return u.Host
+	}
+	return host
+}
+
+func hostPort(u *url.URL) string {
+	if _, _, err := net.SplitHostPort(u.Host); err == nil {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Host, "443")
+	}
+	return net.JoinHostPort(u.Host, "80")
+}
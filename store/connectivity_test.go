@@ -0,0 +1,202 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package store
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	. "gopkg.in/check.v1"
+)
+
+type connectivitySuite struct {
+	server  *httptest.Server
+	lookups []string
+
+	restore func()
+}
+
+var _ = Suite(&connectivitySuite{})
+
+func (s *connectivitySuite) SetUpTest(c *C) {
+	s.lookups = nil
+
+	oldLookup := connectivityLookupHost
+	oldProxy := connectivityProxy
+	oldDeviceSessionAPI := MyAppsDeviceSessionAPI
+	connectivityLookupHost = func(host string) ([]string, error) {
+		s.lookups = append(s.lookups, host)
+		if host == "unknown.invalid" {
+			return nil, errors.New("no such host")
+		}
+		return []string{"127.0.0.1"}, nil
+	}
+	connectivityProxy = func(*http.Request) (*url.URL, error) {
+		return nil, nil
+	}
+	s.restore = func() {
+		connectivityLookupHost = oldLookup
+		connectivityProxy = oldProxy
+		MyAppsDeviceSessionAPI = oldDeviceSessionAPI
+	}
+}
+
+func (s *connectivitySuite) TearDownTest(c *C) {
+	if s.server != nil {
+		s.server.Close()
+		s.server = nil
+	}
+	s.restore()
+}
+
+func (s *connectivitySuite) storeHandler(c *C) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/snaps/search":
+			c.Check(r.URL.Query().Get("q"), Equals, "core")
+			w.Write([]byte(`{}`))
+		case "/api/v1/snaps/details/core":
+			c.Check(r.URL.Query().Get("channel"), Equals, "stable")
+			fmt.Fprintf(w, `{"anon_download_url": "%s/download/core_1.snap"}`, s.server.URL)
+		case "/v1/assertions/account/canonical":
+			w.WriteHeader(404)
+		case "/download/core_1.snap":
+			c.Check(r.Method, Equals, "HEAD")
+		case "/identity/api/v1/sessions":
+			w.WriteHeader(405)
+		default:
+			c.Errorf("unexpected request to %q", r.URL.Path)
+			w.WriteHeader(500)
+		}
+	}
+}
+
+func (s *connectivitySuite) newStore(c *C, baseURL string) *Store {
+	parse := func(p string) *url.URL {
+		u, err := url.Parse(baseURL + p)
+		c.Assert(err, IsNil)
+		return u
+	}
+	MyAppsDeviceSessionAPI = baseURL + "/identity/api/v1/sessions"
+	return New(&Config{
+		SearchURI:     parse("/api/v1/snaps/search"),
+		DetailsURI:    parse("/api/v1/snaps/details/"),
+		AssertionsURI: parse("/v1/assertions/"),
+	}, nil)
+}
+
+func (s *connectivitySuite) TestConnectivityCheck(c *C) {
+	s.server = httptest.NewServer(s.storeHandler(c))
+	sto := s.newStore(c, s.server.URL)
+
+	statuses, err := sto.ConnectivityCheck()
+	c.Assert(err, IsNil)
+	c.Assert(statuses, HasLen, 5)
+
+	expected := []struct {
+		endpoint string
+		status   int
+	}{
+		{"search", 200},
+		{"details", 200},
+		{"assertions", 404},
+		{"download", 200},
+		{"device-service", 405},
+	}
+	for i, exp := range expected {
+		st := statuses[i]
+		c.Check(st.Endpoint, Equals, exp.endpoint)
+		c.Check(st.DNS, Equals, "ok")
+		c.Check(st.TLS, Equals, "")
+		c.Check(st.Proxy, Equals, "")
+		c.Check(st.HTTPStatus, Equals, exp.status, Commentf(exp.endpoint))
+		c.Check(st.Error, Equals, "", Commentf(exp.endpoint))
+	}
+	c.Check(statuses[3].URL, Equals, s.server.URL+"/download/core_1.snap")
+	c.Check(s.lookups, HasLen, 5)
+	c.Check(s.lookups[0], Equals, "127.0.0.1")
+}
+
+func (s *connectivitySuite) TestConnectivityCheckDNSFailure(c *C) {
+	sto := s.newStore(c, "http://unknown.invalid")
+
+	statuses, err := sto.ConnectivityCheck()
+	c.Assert(err, IsNil)
+	c.Assert(statuses, HasLen, 5)
+	for _, st := range statuses {
+		if st.Endpoint == "download" {
+			c.Check(st.DNS, Equals, "")
+			c.Check(st.Error, Equals, "cannot find the download host without store details")
+			continue
+		}
+		c.Check(st.DNS, Equals, "failed")
+		c.Check(st.HTTPStatus, Equals, 0)
+		c.Check(st.Error, Equals, `cannot resolve "unknown.invalid": no such host`)
+	}
+}
+
+func (s *connectivitySuite) TestConnectivityCheckTLSFailure(c *C) {
+	// the certificate of the test server is not trusted
+	s.server = httptest.NewTLSServer(s.storeHandler(c))
+	sto := s.newStore(c, s.server.URL)
+
+	statuses, err := sto.ConnectivityCheck()
+	c.Assert(err, IsNil)
+	c.Assert(statuses, HasLen, 5)
+	st := statuses[0]
+	c.Check(st.Endpoint, Equals, "search")
+	c.Check(st.DNS, Equals, "ok")
+	c.Check(st.TLS, Equals, "failed")
+	c.Check(st.HTTPStatus, Equals, 0)
+	c.Check(st.Error, Matches, "cannot establish TLS connection: .*certificate.*")
+}
+
+func (s *connectivitySuite) TestConnectivityCheckViaProxy(c *C) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// proxied requests carry the full URL
+		proxied = append(proxied, r.URL.String())
+		w.WriteHeader(502)
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	c.Assert(err, IsNil)
+	connectivityProxy = func(*http.Request) (*url.URL, error) {
+		return proxyURL, nil
+	}
+
+	sto := s.newStore(c, "http://unknown.invalid")
+
+	statuses, err := sto.ConnectivityCheck()
+	c.Assert(err, IsNil)
+	c.Assert(statuses, HasLen, 5)
+	st := statuses[0]
+	c.Check(st.Endpoint, Equals, "search")
+	c.Check(st.Proxy, Equals, proxy.URL)
+	// only the proxy needs resolving
+	c.Check(st.DNS, Equals, "ok")
+	c.Check(st.HTTPStatus, Equals, 502)
+	c.Check(st.Error, Equals, "endpoint replied with 502 Bad Gateway")
+	c.Check(s.lookups[0], Equals, "127.0.0.1")
+	c.Check(proxied[0], Matches, `http://unknown.invalid/api/v1/snaps/search\?.*q=core.*`)
+}
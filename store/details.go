@@ -60,6 +60,26 @@ type snapDetails struct {
 
 	Private     bool   `json:"private"`
 	Confinement string `json:"confinement"`
+
+	ChannelMapList []channelMap `json:"channel_maps_list,omitempty"`
+}
+
+// channelMap holds the channels of one of the tracks of a snap.
+type channelMap struct {
+	Track       string              `json:"track"`
+	SnapDetails []channelMapDetails `json:"map"`
+}
+
+// channelMapDetails describes what a channel in a channelMap serves,
+// Info being "released" if it serves a revision of its own.
+type channelMapDetails struct {
+	Info         string `json:"info"`
+	Revision     int    `json:"revision"`
+	Confinement  string `json:"confinement"`
+	Version      string `json:"version"`
+	Channel      string `json:"channel"`
+	Epoch        string `json:"epoch"`
+	DownloadSize int64  `json:"binary_filesize"`
 }
 
 type snapDeltaDetail struct {
@@ -0,0 +1,155 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package store
+
+import (
+	"strconv"
+
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/snap"
+)
+
+// storeSnap holds the information sent as JSON by the store for a snap
+// by the v2 API.
+type storeSnap struct {
+	Architectures []string          `json:"architectures"`
+	Confinement   string            `json:"confinement"`
+	Contact       string            `json:"contact"`
+	CreatedAt     string            `json:"created-at"`
+	Description   string            `json:"description"`
+	Download      storeSnapDownload `json:"download"`
+	License       string            `json:"license"`
+	Name          string            `json:"name"`
+	Prices        map[string]string `json:"prices"`
+	Private       bool              `json:"private"`
+	Publisher     storeAccount      `json:"publisher"`
+	Revision      int               `json:"revision"`
+	SnapID        string            `json:"snap-id"`
+	Summary       string            `json:"summary"`
+	Title         string            `json:"title"`
+	Type          snap.Type         `json:"type"`
+	Version       string            `json:"version"`
+	Media         []storeSnapMedia  `json:"media"`
+}
+
+type storeSnapDownload struct {
+	Sha3_384 string           `json:"sha3-384"`
+	Size     int64            `json:"size"`
+	URL      string           `json:"url"`
+	Deltas   []storeSnapDelta `json:"deltas"`
+}
+
+type storeSnapDelta struct {
+	Format   string `json:"format"`
+	Sha3_384 string `json:"sha3-384"`
+	Size     int64  `json:"size"`
+	Source   int    `json:"source"`
+	Target   int    `json:"target"`
+	URL      string `json:"url"`
+}
+
+type storeAccount struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display-name"`
+}
+
+type storeSnapMedia struct {
+	Type   string `json:"type"` // icon/screenshot
+	URL    string `json:"url"`
+	Width  int64  `json:"width"`
+	Height int64  `json:"height"`
+}
+
+// snapActionFields are the fields of storeSnap asked for in snap action
+// requests.
+var snapActionFields = getStructFields(storeSnap{})
+
+func infoFromStoreSnap(d *storeSnap) *snap.Info {
+	info := &snap.Info{}
+	info.RealName = d.Name
+	info.Revision = snap.R(d.Revision)
+	info.SnapID = d.SnapID
+	info.EditedSummary = d.Summary
+	info.EditedDescription = d.Description
+	info.Private = d.Private
+	info.Contact = d.Contact
+	info.Architectures = d.Architectures
+	info.Type = d.Type
+	info.Version = d.Version
+	info.Epoch = "0"
+	info.Confinement = snap.ConfinementType(d.Confinement)
+	info.PublisherID = d.Publisher.ID
+	info.Publisher = d.Publisher.Username
+	info.DownloadURL = d.Download.URL
+	info.AnonDownloadURL = d.Download.URL
+	info.Size = d.Download.Size
+	info.Sha3_384 = d.Download.Sha3_384
+
+	if len(d.Download.Deltas) > 0 {
+		deltas := make([]snap.DeltaInfo, len(d.Download.Deltas))
+		for i, d := range d.Download.Deltas {
+			deltas[i] = snap.DeltaInfo{
+				FromRevision:    d.Source,
+				ToRevision:      d.Target,
+				Format:          d.Format,
+				AnonDownloadURL: d.URL,
+				DownloadURL:     d.URL,
+				Size:            d.Size,
+				Sha3_384:        d.Sha3_384,
+			}
+		}
+		info.Deltas = deltas
+	}
+
+	// fill in the plain store prices
+	if len(d.Prices) > 0 {
+		prices := make(map[string]float64, len(d.Prices))
+		for currency, priceStr := range d.Prices {
+			price, err := strconv.ParseFloat(priceStr, 64)
+			if err != nil {
+				logger.Noticef("Cannot parse %s price %q of snap %q: %v", currency, priceStr, d.Name, err)
+				continue
+			}
+			prices[currency] = price
+		}
+		info.Prices = prices
+	}
+
+	// media
+	screenshots := make([]snap.ScreenshotInfo, 0, len(d.Media))
+	for _, mediaObj := range d.Media {
+		switch mediaObj.Type {
+		case "icon":
+			if info.IconURL == "" {
+				info.IconURL = mediaObj.URL
+			}
+		case "screenshot":
+			screenshots = append(screenshots, snap.ScreenshotInfo{
+				URL:    mediaObj.URL,
+				Width:  mediaObj.Width,
+				Height: mediaObj.Height,
+			})
+		}
+	}
+	info.Screenshots = screenshots
+
+	return info
+}
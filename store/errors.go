@@ -38,6 +38,9 @@ var (
 	// ErrSnapNotFound is returned when a snap can not be found
 	ErrSnapNotFound = errors.New("snap not found")
 
+	// ErrNoUpdateAvailable is returned when a snap has no update to refresh to
+	ErrNoUpdateAvailable = errors.New("snap has no updates available")
+
 	// ErrUnauthenticated is returned when authentication is needed to complete the query
 	ErrUnauthenticated = errors.New("you need to log in first")
 
@@ -0,0 +1,296 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package mirror
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/asserts/snapasserts"
+	"github.com/snapcore/snapd/asserts/sysdb"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/snap"
+)
+
+// The layout of a mirror directory:
+//
+//	<dir>/index.json           the snaps, their revisions and channels
+//	<dir>/snaps/<name>_<rev>.snap
+//	<dir>/asserts/*.assert     streams of assertions, as written by
+//	                           snap download
+const (
+	indexFile  = "index.json"
+	snapsDir   = "snaps"
+	assertsDir = "asserts"
+)
+
+// RevisionInfo holds what the mirror needs to know about a revision of
+// a snap to describe it to clients.
+type RevisionInfo struct {
+	Revision    int       `json:"revision"`
+	File        string    `json:"file"`
+	Version     string    `json:"version"`
+	Summary     string    `json:"summary,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Type        snap.Type `json:"type"`
+	Confinement string    `json:"confinement"`
+	Epoch       string    `json:"epoch"`
+	Sha3_384    string    `json:"sha3-384"`
+	Size        uint64    `json:"size"`
+	DeveloperID string    `json:"developer-id"`
+	Developer   string    `json:"developer"`
+}
+
+// SnapIndex holds the revisions of a snap and the revision released
+// to each of its channels.
+type SnapIndex struct {
+	SnapID    string          `json:"snap-id"`
+	Revisions []*RevisionInfo `json:"revisions"`
+	Channels  map[string]int  `json:"channels"`
+}
+
+func (si *SnapIndex) revision(rev int) *RevisionInfo {
+	for _, ri := range si.Revisions {
+		if ri.Revision == rev {
+			return ri
+		}
+	}
+	return nil
+}
+
+// inChannel returns the revision that a client following the channel
+// gets, following the fallbacks of closed channels like the main store
+// does.
+func (si *SnapIndex) inChannel(channel string) *RevisionInfo {
+	if channel == "" {
+		channel = "stable"
+	}
+	ch, err := snap.ParseChannel(channel)
+	if err != nil {
+		return nil
+	}
+	channels := make(map[string]*snap.ChannelSnapInfo, len(si.Channels))
+	for name, rev := range si.Channels {
+		channels[name] = &snap.ChannelSnapInfo{Revision: snap.R(rev)}
+	}
+	info := ch.Lookup(channels)
+	if info == nil {
+		return nil
+	}
+	return si.revision(info.Revision.N)
+}
+
+// Index holds the snaps served by a mirror, by name.
+type Index struct {
+	Snaps map[string]*SnapIndex `json:"snaps"`
+}
+
+func (idx *Index) bySnapID(snapID string) (string, *SnapIndex) {
+	for name, si := range idx.Snaps {
+		if si.SnapID == snapID {
+			return name, si
+		}
+	}
+	return "", nil
+}
+
+func readIndex(dir string) (*Index, error) {
+	idx := &Index{Snaps: make(map[string]*SnapIndex)}
+	f, err := os.Open(filepath.Join(dir, indexFile))
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(idx); err != nil {
+		return nil, fmt.Errorf("cannot read mirror index: %v", err)
+	}
+	return idx, nil
+}
+
+func writeIndex(dir string, idx *Index) error {
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	return osutil.AtomicWriteFile(filepath.Join(dir, indexFile), data, 0644, 0)
+}
+
+var readInfo = func(snapPath string, si *snap.SideInfo) (*snap.Info, error) {
+	snapf, err := snap.Open(snapPath)
+	if err != nil {
+		return nil, err
+	}
+	return snap.ReadInfoFromSnapFile(snapf, si)
+}
+
+func hexify(digest string) (string, error) {
+	bs, err := base64.RawURLEncoding.DecodeString(digest)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", bs), nil
+}
+
+// checkedAssertions adds the assertions in the stream at assertPath to
+// a database trusting the same authorities as snapd, which checks
+// their signatures.
+func checkedAssertions(assertPath string) (*asserts.Database, error) {
+	db, err := asserts.OpenDatabase(&asserts.DatabaseConfig{
+		Backstore: asserts.NewMemoryBackstore(),
+		Trusted:   sysdb.Trusted(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(assertPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read assertions for the snap: %v", err)
+	}
+	defer f.Close()
+
+	dec := asserts.NewDecoder(f)
+	for {
+		a, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode assertions in %s: %v", assertPath, err)
+		}
+		if err := db.Add(a); err != nil {
+			if _, ok := err.(*asserts.RevisionError); ok {
+				continue
+			}
+			return nil, fmt.Errorf("cannot add assertion %v: %v", a.Ref(), err)
+		}
+	}
+	return db, nil
+}
+
+// Import adds the snap at snapPath to the mirror in dir, together with
+// the assertions that snap download saved next to it, and releases it
+// to the given channels. The snap must be signed by the store.
+func Import(dir, snapPath string, channels []string) (*snap.SideInfo, error) {
+	assertPath := strings.TrimSuffix(snapPath, filepath.Ext(snapPath)) + ".assert"
+	db, err := checkedAssertions(assertPath)
+	if err != nil {
+		return nil, err
+	}
+
+	si, err := snapasserts.DeriveSideInfo(snapPath, db)
+	if err == asserts.ErrNotFound {
+		return nil, fmt.Errorf("cannot find signatures with metadata for snap %q", snapPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := readInfo(snapPath, si)
+	if err != nil {
+		return nil, err
+	}
+
+	digest, size, err := asserts.SnapFileSHA3_384(snapPath)
+	if err != nil {
+		return nil, err
+	}
+	sha3_384, err := hexify(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := db.Find(asserts.SnapRevisionType, map[string]string{"snap-sha3-384": digest})
+	if err != nil {
+		return nil, err
+	}
+	developerID := a.(*asserts.SnapRevision).DeveloperID()
+	var developer string
+	a, err = db.Find(asserts.AccountType, map[string]string{"account-id": developerID})
+	if err == nil {
+		developer = a.(*asserts.Account).Username()
+	}
+
+	for i, channel := range channels {
+		ch, err := snap.ParseChannel(channel)
+		if err != nil {
+			return nil, err
+		}
+		channels[i] = ch.String()
+	}
+
+	for _, d := range []string{snapsDir, assertsDir} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			return nil, err
+		}
+	}
+	baseName := fmt.Sprintf("%s_%s", si.RealName, si.Revision)
+	snapFile := baseName + ".snap"
+	if err := osutil.CopyFile(snapPath, filepath.Join(dir, snapsDir, snapFile), osutil.CopyFlagOverwrite|osutil.CopyFlagSync); err != nil {
+		return nil, err
+	}
+	if err := osutil.CopyFile(assertPath, filepath.Join(dir, assertsDir, baseName+".assert"), osutil.CopyFlagOverwrite|osutil.CopyFlagSync); err != nil {
+		return nil, err
+	}
+
+	idx, err := readIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+	sidx := idx.Snaps[si.RealName]
+	if sidx == nil {
+		sidx = &SnapIndex{Channels: make(map[string]int)}
+		idx.Snaps[si.RealName] = sidx
+	}
+	sidx.SnapID = si.SnapID
+	if sidx.revision(si.Revision.N) == nil {
+		sidx.Revisions = append(sidx.Revisions, &RevisionInfo{
+			Revision:    si.Revision.N,
+			File:        snapFile,
+			Version:     info.Version,
+			Summary:     info.Summary(),
+			Description: info.Description(),
+			Type:        info.Type,
+			Confinement: string(info.Confinement),
+			Epoch:       info.Epoch,
+			Sha3_384:    sha3_384,
+			Size:        size,
+			DeveloperID: developerID,
+			Developer:   developer,
+		})
+	}
+	for _, channel := range channels {
+		sidx.Channels[channel] = si.Revision.N
+	}
+
+	if err := writeIndex(dir, idx); err != nil {
+		return nil, err
+	}
+	return si, nil
+}
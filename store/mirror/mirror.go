@@ -0,0 +1,615 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package mirror serves snaps and their assertions from a local
+// directory to systems that cannot reach the main store.
+package mirror
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/tylerb/graceful.v1"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/asserts/sysdb"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/store"
+)
+
+// Catalog provides the snaps and assertions a Mirror serves.
+type Catalog interface {
+	// Index returns the snaps to serve, with their revisions and
+	// channels.
+	Index() (*Index, error)
+	// Assertions returns the assertions to serve, including the
+	// ones snapd trusts.
+	Assertions() (asserts.Backstore, error)
+	// SnapsDir returns the directory with the files of the revisions
+	// in the index.
+	SnapsDir() string
+}
+
+// dirCatalog is the catalog of a mirror directory, as filled by Import.
+type dirCatalog string
+
+func (dir dirCatalog) Index() (*Index, error) {
+	return readIndex(string(dir))
+}
+
+func (dir dirCatalog) SnapsDir() string {
+	return filepath.Join(string(dir), snapsDir)
+}
+
+// Assertions returns the assertions snapd trusts and the ones in the
+// mirror directory.
+func (dir dirCatalog) Assertions() (asserts.Backstore, error) {
+	bs := asserts.NewMemoryBackstore()
+	for _, a := range sysdb.Trusted() {
+		if err := bs.Put(a.Type(), a); err != nil {
+			return nil, err
+		}
+	}
+
+	fns, err := filepath.Glob(filepath.Join(string(dir), assertsDir, "*.assert"))
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range fns {
+		if err := addAssertions(bs, fn); err != nil {
+			return nil, err
+		}
+	}
+	return bs, nil
+}
+
+// Mirror is a store serving the snaps and assertions of a catalog.
+type Mirror struct {
+	catalog Catalog
+	url     string
+
+	// fallback, if set, is asked for the assertions the mirror
+	// does not have
+	fallback *store.Store
+
+	mux *http.ServeMux
+	srv *graceful.Server
+}
+
+// New creates a mirror serving the directory dir on addr. If fallback
+// is not nil assertions missing from the mirror are fetched from it.
+func New(dir, addr string, fallback *store.Store) *Mirror {
+	return NewWithCatalog(dirCatalog(dir), addr, fallback)
+}
+
+// NewWithCatalog creates a mirror serving what catalog provides on
+// addr. If fallback is not nil assertions missing from the catalog are
+// fetched from it.
+func NewWithCatalog(catalog Catalog, addr string, fallback *store.Store) *Mirror {
+	mux := http.NewServeMux()
+	m := &Mirror{
+		catalog:  catalog,
+		fallback: fallback,
+		mux:      mux,
+		srv: &graceful.Server{
+			Timeout: 2 * time.Second,
+
+			Server: &http.Server{
+				Addr:    addr,
+				Handler: mux,
+			},
+		},
+	}
+
+	mux.HandleFunc("/snaps/search", m.searchEndpoint)
+	mux.HandleFunc("/snaps/details/", m.detailsEndpoint)
+	mux.HandleFunc("/snaps/metadata", m.bulkEndpoint)
+	mux.HandleFunc("/snaps/sections", m.sectionsEndpoint)
+	mux.HandleFunc("/v2/snaps/refresh", m.snapActionEndpoint)
+	mux.Handle("/download/", http.StripPrefix("/download/", http.FileServer(http.Dir(catalog.SnapsDir()))))
+	mux.HandleFunc("/assertions/", m.assertionsEndpoint)
+
+	return m
+}
+
+// HandleFunc registers handler for the requests matching pattern, in
+// addition to the ones the mirror answers.
+func (m *Mirror) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.mux.HandleFunc(pattern, handler)
+}
+
+// URL returns the base URL the mirror is serving on, to be used as
+// the store.url of the systems using it. It is only valid once the
+// mirror was started.
+func (m *Mirror) URL() string {
+	return m.url
+}
+
+// Start starts serving.
+func (m *Mirror) Start() error {
+	l, err := net.Listen("tcp", m.srv.Addr)
+	if err != nil {
+		return err
+	}
+	// keep the host the mirror was asked to serve on, if any, and
+	// take the port from the listener in case it was picked by it
+	host, _, err := net.SplitHostPort(m.srv.Addr)
+	if err != nil {
+		l.Close()
+		return err
+	}
+	lhost, port, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		l.Close()
+		return err
+	}
+	if host == "" {
+		host = lhost
+	}
+	m.url = fmt.Sprintf("http://%s/", net.JoinHostPort(host, port))
+
+	go m.srv.Serve(l)
+	return nil
+}
+
+// Stop stops serving.
+func (m *Mirror) Stop() error {
+	timeout := 2 * time.Second
+	m.srv.Stop(timeout / 2)
+
+	select {
+	case <-m.srv.StopChan():
+	case <-time.After(timeout):
+		return fmt.Errorf("mirror failed to stop after %s", timeout)
+	}
+	return nil
+}
+
+type detailsReplyJSON struct {
+	Architectures   []string `json:"architecture"`
+	SnapID          string   `json:"snap_id"`
+	PackageName     string   `json:"package_name"`
+	Developer       string   `json:"origin"`
+	DeveloperID     string   `json:"developer_id"`
+	AnonDownloadURL string   `json:"anon_download_url"`
+	DownloadURL     string   `json:"download_url"`
+	Version         string   `json:"version"`
+	Revision        int      `json:"revision"`
+	DownloadDigest  string   `json:"download_sha3_384"`
+	DownloadSize    uint64   `json:"binary_filesize"`
+	Summary         string   `json:"summary,omitempty"`
+	Description     string   `json:"description,omitempty"`
+	Type            string   `json:"content,omitempty"`
+	Confinement     string   `json:"confinement"`
+	Epoch           string   `json:"epoch"`
+	Channel         string   `json:"channel,omitempty"`
+}
+
+type packagesJSON struct {
+	Packages []detailsReplyJSON `json:"clickindex:package"`
+}
+
+type packagesReplyJSON struct {
+	Payload packagesJSON `json:"_embedded"`
+}
+
+func (m *Mirror) details(name, snapID, channel string, ri *RevisionInfo) detailsReplyJSON {
+	url := m.url + "download/" + ri.File
+	return detailsReplyJSON{
+		Architectures:   []string{"all"},
+		SnapID:          snapID,
+		PackageName:     name,
+		Developer:       ri.Developer,
+		DeveloperID:     ri.DeveloperID,
+		AnonDownloadURL: url,
+		DownloadURL:     url,
+		Version:         ri.Version,
+		Revision:        ri.Revision,
+		DownloadDigest:  ri.Sha3_384,
+		DownloadSize:    ri.Size,
+		Summary:         ri.Summary,
+		Description:     ri.Description,
+		Type:            string(ri.Type),
+		Confinement:     ri.Confinement,
+		Epoch:           ri.Epoch,
+		Channel:         channel,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/hal+json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Noticef("cannot write mirror reply: %v", err)
+	}
+}
+
+func writeNotFound(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte(`{"status": 404}`))
+}
+
+func (m *Mirror) index(w http.ResponseWriter) *Index {
+	idx, err := m.catalog.Index()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("internal error reading index: %v", err), http.StatusInternalServerError)
+		return nil
+	}
+	return idx
+}
+
+func (m *Mirror) detailsEndpoint(w http.ResponseWriter, req *http.Request) {
+	name := strings.TrimPrefix(req.URL.Path, "/snaps/details/")
+	idx := m.index(w)
+	if idx == nil {
+		return
+	}
+
+	sidx := idx.Snaps[name]
+	if sidx == nil {
+		writeNotFound(w)
+		return
+	}
+
+	query := req.URL.Query()
+	channel := query.Get("channel")
+	var ri *RevisionInfo
+	if revStr := query.Get("revision"); revStr != "" {
+		rev, err := strconv.Atoi(revStr)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid revision %q", revStr), http.StatusBadRequest)
+			return
+		}
+		ri = sidx.revision(rev)
+	} else {
+		ri = sidx.inChannel(channel)
+	}
+	if ri == nil {
+		writeNotFound(w)
+		return
+	}
+
+	writeJSON(w, m.details(name, sidx.SnapID, channel, ri))
+}
+
+type candidateSnap struct {
+	SnapID  string `json:"snap_id"`
+	Channel string `json:"channel"`
+}
+
+type bulkReqJSON struct {
+	CandidateSnaps []candidateSnap `json:"snaps"`
+}
+
+// bulkEndpoint replies with the revision in the channel followed by
+// each of the candidates; like the main store it does not filter out
+// the revisions the candidates already have.
+func (m *Mirror) bulkEndpoint(w http.ResponseWriter, req *http.Request) {
+	var bulkReq bulkReqJSON
+	if err := json.NewDecoder(req.Body).Decode(&bulkReq); err != nil {
+		http.Error(w, fmt.Sprintf("cannot decode request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	idx := m.index(w)
+	if idx == nil {
+		return
+	}
+
+	reply := packagesReplyJSON{Payload: packagesJSON{Packages: []detailsReplyJSON{}}}
+	for _, cand := range bulkReq.CandidateSnaps {
+		name, sidx := idx.bySnapID(cand.SnapID)
+		if sidx == nil {
+			continue
+		}
+		ri := sidx.inChannel(cand.Channel)
+		if ri == nil {
+			continue
+		}
+		reply.Payload.Packages = append(reply.Payload.Packages, m.details(name, sidx.SnapID, cand.Channel, ri))
+	}
+
+	writeJSON(w, reply)
+}
+
+// searchEndpoint matches the snaps in the stable channel by name,
+// exactly when searching with name=, or by substring of their name or
+// summary when searching with q=.
+func (m *Mirror) searchEndpoint(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	if query.Get("section") != "" {
+		// the mirror has no sections
+		writeJSON(w, packagesReplyJSON{Payload: packagesJSON{Packages: []detailsReplyJSON{}}})
+		return
+	}
+
+	idx := m.index(w)
+	if idx == nil {
+		return
+	}
+
+	names := make([]string, 0, len(idx.Snaps))
+	for name := range idx.Snaps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	exact := query.Get("name")
+	term := strings.ToLower(query.Get("q"))
+	reply := packagesReplyJSON{Payload: packagesJSON{Packages: []detailsReplyJSON{}}}
+	for _, name := range names {
+		sidx := idx.Snaps[name]
+		ri := sidx.inChannel("stable")
+		if ri == nil {
+			continue
+		}
+		switch {
+		case exact != "":
+			if name != exact {
+				continue
+			}
+		case term != "":
+			if !strings.Contains(name, term) && !strings.Contains(strings.ToLower(ri.Summary), term) {
+				continue
+			}
+		}
+		reply.Payload.Packages = append(reply.Payload.Packages, m.details(name, sidx.SnapID, "stable", ri))
+	}
+
+	writeJSON(w, reply)
+}
+
+type snapActionContextJSON struct {
+	InstanceKey     string `json:"instance-key"`
+	TrackingChannel string `json:"tracking-channel"`
+}
+
+type snapActionJSON struct {
+	Action      string `json:"action"`
+	InstanceKey string `json:"instance-key"`
+	Name        string `json:"name"`
+	SnapID      string `json:"snap-id"`
+	Channel     string `json:"channel"`
+	Revision    int    `json:"revision"`
+}
+
+type snapActionReqJSON struct {
+	Context []snapActionContextJSON `json:"context"`
+	Actions []snapActionJSON        `json:"actions"`
+}
+
+type snapActionErrorJSON struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type publisherJSON struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+type downloadJSON struct {
+	Sha3_384 string `json:"sha3-384"`
+	Size     uint64 `json:"size"`
+	URL      string `json:"url"`
+}
+
+// snapJSON is the v2 store's description of a snap revision.
+type snapJSON struct {
+	Architectures []string      `json:"architectures"`
+	SnapID        string        `json:"snap-id"`
+	Name          string        `json:"name"`
+	Publisher     publisherJSON `json:"publisher"`
+	Download      downloadJSON  `json:"download"`
+	Version       string        `json:"version"`
+	Revision      int           `json:"revision"`
+	Summary       string        `json:"summary"`
+	Description   string        `json:"description"`
+	Type          string        `json:"type"`
+	Confinement   string        `json:"confinement"`
+}
+
+type snapActionResultJSON struct {
+	Result      string               `json:"result"`
+	InstanceKey string               `json:"instance-key"`
+	SnapID      string               `json:"snap-id,omitempty"`
+	Name        string               `json:"name,omitempty"`
+	Snap        *snapJSON            `json:"snap,omitempty"`
+	Error       *snapActionErrorJSON `json:"error,omitempty"`
+}
+
+type snapActionReplyJSON struct {
+	Results []snapActionResultJSON `json:"results"`
+}
+
+func (m *Mirror) snapV2(name, snapID string, ri *RevisionInfo) *snapJSON {
+	return &snapJSON{
+		Architectures: []string{"all"},
+		SnapID:        snapID,
+		Name:          name,
+		Publisher: publisherJSON{
+			ID:       ri.DeveloperID,
+			Username: ri.Developer,
+		},
+		Download: downloadJSON{
+			Sha3_384: ri.Sha3_384,
+			Size:     ri.Size,
+			URL:      m.url + "download/" + ri.File,
+		},
+		Version:     ri.Version,
+		Revision:    ri.Revision,
+		Summary:     ri.Summary,
+		Description: ri.Description,
+		Type:        string(ri.Type),
+		Confinement: ri.Confinement,
+	}
+}
+
+// snapActionEndpoint answers the refresh, install and download
+// actions of a batched v2 request.
+func (m *Mirror) snapActionEndpoint(w http.ResponseWriter, req *http.Request) {
+	var actReq snapActionReqJSON
+	if err := json.NewDecoder(req.Body).Decode(&actReq); err != nil {
+		http.Error(w, fmt.Sprintf("cannot decode request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	idx := m.index(w)
+	if idx == nil {
+		return
+	}
+
+	tracking := make(map[string]string, len(actReq.Context))
+	for _, cur := range actReq.Context {
+		tracking[cur.InstanceKey] = cur.TrackingChannel
+	}
+
+	reply := snapActionReplyJSON{Results: []snapActionResultJSON{}}
+	for _, action := range actReq.Actions {
+		var name string
+		var sidx *SnapIndex
+		notFound := "name-not-found"
+		channel := action.Channel
+		if action.Action == "refresh" {
+			name, sidx = idx.bySnapID(action.SnapID)
+			notFound = "id-not-found"
+			if channel == "" {
+				channel = tracking[action.InstanceKey]
+			}
+		} else {
+			name, sidx = action.Name, idx.Snaps[action.Name]
+		}
+
+		var ri *RevisionInfo
+		if sidx != nil {
+			if action.Revision != 0 {
+				ri = sidx.revision(action.Revision)
+				notFound = "revision-not-found"
+			} else {
+				ri = sidx.inChannel(channel)
+			}
+		}
+		if ri == nil {
+			reply.Results = append(reply.Results, snapActionResultJSON{
+				Result:      "error",
+				InstanceKey: action.InstanceKey,
+				SnapID:      action.SnapID,
+				Name:        action.Name,
+				Error:       &snapActionErrorJSON{Code: notFound, Message: "snap not found"},
+			})
+			continue
+		}
+
+		reply.Results = append(reply.Results, snapActionResultJSON{
+			Result:      action.Action,
+			InstanceKey: action.InstanceKey,
+			SnapID:      sidx.SnapID,
+			Name:        name,
+			Snap:        m.snapV2(name, sidx.SnapID, ri),
+		})
+	}
+
+	writeJSON(w, reply)
+}
+
+func (m *Mirror) sectionsEndpoint(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"_embedded": map[string]interface{}{
+			"clickindex:sections": []interface{}{},
+		},
+	})
+}
+
+func addAssertions(bs asserts.Backstore, fn string) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := asserts.NewDecoder(f)
+	for {
+		a, err := dec.Decode()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot decode assertions in %s: %v", fn, err)
+		}
+		if err := bs.Put(a.Type(), a); err != nil {
+			if _, ok := err.(*asserts.RevisionError); ok {
+				continue
+			}
+			return err
+		}
+	}
+}
+
+func isAssertNotFound(err error) bool {
+	if err == asserts.ErrNotFound {
+		return true
+	}
+	_, ok := err.(*store.AssertionNotFoundError)
+	return ok
+}
+
+func (m *Mirror) assertionsEndpoint(w http.ResponseWriter, req *http.Request) {
+	comps := strings.Split(strings.TrimPrefix(req.URL.Path, "/assertions/"), "/")
+
+	typ := asserts.Type(comps[0])
+	if typ == nil {
+		http.Error(w, fmt.Sprintf("unknown assertion type: %s", comps[0]), http.StatusBadRequest)
+		return
+	}
+	if len(typ.PrimaryKey) != len(comps)-1 {
+		http.Error(w, fmt.Sprintf("wrong primary key length: %v", comps), http.StatusBadRequest)
+		return
+	}
+
+	bs, err := m.catalog.Assertions()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("internal error collecting assertions: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	a, err := bs.Get(typ, comps[1:], typ.MaxSupportedFormat())
+	if err == asserts.ErrNotFound && m.fallback != nil {
+		a, err = m.fallback.Assertion(typ, comps[1:], nil)
+	}
+	if isAssertNotFound(err) {
+		writeNotFound(w)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cannot retrieve assertion %v: %v", comps, err), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", asserts.MediaType)
+	w.Write(asserts.Encode(a))
+}
@@ -0,0 +1,329 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package mirror_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/asserts/assertstest"
+	"github.com/snapcore/snapd/asserts/sysdb"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/store"
+	"github.com/snapcore/snapd/store/mirror"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type mirrorSuite struct {
+	storeSigning *assertstest.StoreStack
+	dev1Acct     *asserts.Account
+
+	srcDir string
+	dir    string
+
+	restore []func()
+}
+
+var _ = Suite(&mirrorSuite{})
+
+func (s *mirrorSuite) SetUpTest(c *C) {
+	rootPrivKey, _ := assertstest.GenerateKey(1024)
+	storePrivKey, _ := assertstest.GenerateKey(752)
+	s.storeSigning = assertstest.NewStoreStack("can0nical", rootPrivKey, storePrivKey)
+	s.dev1Acct = assertstest.NewAccount(s.storeSigning, "developer1", nil, "")
+
+	s.srcDir = c.MkDir()
+	s.dir = c.MkDir()
+
+	s.restore = []func(){
+		sysdb.InjectTrusted(s.storeSigning.Trusted),
+		mirror.MockReadInfo(func(snapPath string, si *snap.SideInfo) (*snap.Info, error) {
+			info, err := snap.InfoFromSnapYaml([]byte(fmt.Sprintf("name: %s\nversion: %d.0\nsummary: the %s snap", si.RealName, si.Revision.N, si.RealName)))
+			if err != nil {
+				return nil, err
+			}
+			info.SideInfo = *si
+			return info, nil
+		}),
+	}
+}
+
+func (s *mirrorSuite) TearDownTest(c *C) {
+	for _, restore := range s.restore {
+		restore()
+	}
+}
+
+// makeSnap writes a snap and its assertions like snap download does.
+func (s *mirrorSuite) makeSnap(c *C, name, snapID string, rev int) string {
+	content := []byte(fmt.Sprintf("hsqs________________%s_%d", name, rev))
+	snapPath := filepath.Join(s.srcDir, fmt.Sprintf("%s_%d.snap", name, rev))
+	c.Assert(ioutil.WriteFile(snapPath, content, 0644), IsNil)
+
+	digest, size, err := asserts.SnapFileSHA3_384(snapPath)
+	c.Assert(err, IsNil)
+
+	snapDecl, err := s.storeSigning.Sign(asserts.SnapDeclarationType, map[string]interface{}{
+		"series":       "16",
+		"snap-id":      snapID,
+		"snap-name":    name,
+		"publisher-id": s.dev1Acct.AccountID(),
+		"timestamp":    time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, IsNil)
+	snapRev, err := s.storeSigning.Sign(asserts.SnapRevisionType, map[string]interface{}{
+		"snap-id":       snapID,
+		"snap-sha3-384": digest,
+		"snap-size":     fmt.Sprintf("%d", size),
+		"snap-revision": fmt.Sprintf("%d", rev),
+		"developer-id":  s.dev1Acct.AccountID(),
+		"timestamp":     time.Now().Format(time.RFC3339),
+	}, nil, "")
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	enc := asserts.NewEncoder(&buf)
+	for _, a := range []asserts.Assertion{s.storeSigning.StoreAccountKey(""), s.dev1Acct, snapDecl, snapRev} {
+		c.Assert(enc.Encode(a), IsNil)
+	}
+	assertPath := filepath.Join(s.srcDir, fmt.Sprintf("%s_%d.assert", name, rev))
+	c.Assert(ioutil.WriteFile(assertPath, buf.Bytes(), 0644), IsNil)
+
+	return snapPath
+}
+
+func (s *mirrorSuite) TestImport(c *C) {
+	snapPath := s.makeSnap(c, "foo", "foo-id", 7)
+
+	si, err := mirror.Import(s.dir, snapPath, []string{"stable", "edge"})
+	c.Assert(err, IsNil)
+	c.Check(si.RealName, Equals, "foo")
+	c.Check(si.SnapID, Equals, "foo-id")
+	c.Check(si.Revision, Equals, snap.R(7))
+
+	for src, dst := range map[string]string{
+		snapPath:                                filepath.Join(s.dir, "snaps", "foo_7.snap"),
+		filepath.Join(s.srcDir, "foo_7.assert"): filepath.Join(s.dir, "asserts", "foo_7.assert"),
+	} {
+		expected, err := ioutil.ReadFile(src)
+		c.Assert(err, IsNil)
+		data, err := ioutil.ReadFile(dst)
+		c.Assert(err, IsNil)
+		c.Check(data, DeepEquals, expected)
+	}
+	_, err = os.Stat(filepath.Join(s.dir, "index.json"))
+	c.Check(err, IsNil)
+}
+
+func (s *mirrorSuite) TestImportWithoutAssertions(c *C) {
+	snapPath := s.makeSnap(c, "foo", "foo-id", 7)
+	c.Assert(os.Remove(filepath.Join(s.srcDir, "foo_7.assert")), IsNil)
+
+	_, err := mirror.Import(s.dir, snapPath, nil)
+	c.Check(err, ErrorMatches, "cannot read assertions for the snap: .*")
+}
+
+func (s *mirrorSuite) TestImportUnsigned(c *C) {
+	snapPath := s.makeSnap(c, "foo", "foo-id", 7)
+	// the snap does not match its snap-revision anymore
+	c.Assert(ioutil.WriteFile(snapPath, []byte("hsqs________________other"), 0644), IsNil)
+
+	_, err := mirror.Import(s.dir, snapPath, nil)
+	c.Check(err, ErrorMatches, `cannot find signatures with metadata for snap ".*/foo_7.snap"`)
+}
+
+func (s *mirrorSuite) TestImportInvalidChannel(c *C) {
+	snapPath := s.makeSnap(c, "foo", "foo-id", 7)
+
+	_, err := mirror.Import(s.dir, snapPath, []string{"foo/bar/baz/quux"})
+	c.Check(err, NotNil)
+	_, err = os.Stat(filepath.Join(s.dir, "index.json"))
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *mirrorSuite) serve(c *C) (*mirror.Mirror, *store.Store) {
+	m := mirror.New(s.dir, "127.0.0.1:0", nil)
+	c.Assert(m.Start(), IsNil)
+
+	u, err := url.Parse(m.URL())
+	c.Assert(err, IsNil)
+	cfg := store.DefaultConfig()
+	c.Assert(cfg.SetBaseURL(u), IsNil)
+	return m, store.New(cfg, nil)
+}
+
+func (s *mirrorSuite) TestSnapInfo(c *C) {
+	_, err := mirror.Import(s.dir, s.makeSnap(c, "foo", "foo-id", 7), []string{"stable"})
+	c.Assert(err, IsNil)
+	_, err = mirror.Import(s.dir, s.makeSnap(c, "foo", "foo-id", 8), []string{"beta"})
+	c.Assert(err, IsNil)
+
+	m, sto := s.serve(c)
+	defer m.Stop()
+
+	info, err := sto.SnapInfo(store.SnapSpec{Name: "foo", Channel: "stable"}, nil)
+	c.Assert(err, IsNil)
+	c.Check(info.Revision, Equals, snap.R(7))
+	c.Check(info.Version, Equals, "7.0")
+	c.Check(info.SnapID, Equals, "foo-id")
+	c.Check(info.Publisher, Equals, "developer1")
+	c.Check(info.AnonDownloadURL, Equals, m.URL()+"download/foo_7.snap")
+
+	info, err = sto.SnapInfo(store.SnapSpec{Name: "foo", Channel: "beta"}, nil)
+	c.Assert(err, IsNil)
+	c.Check(info.Revision, Equals, snap.R(8))
+
+	// candidate is closed and follows beta
+	info, err = sto.SnapInfo(store.SnapSpec{Name: "foo", Channel: "candidate"}, nil)
+	c.Assert(err, IsNil)
+	c.Check(info.Revision, Equals, snap.R(8))
+
+	info, err = sto.SnapInfo(store.SnapSpec{Name: "foo", Revision: snap.R(7)}, nil)
+	c.Assert(err, IsNil)
+	c.Check(info.Revision, Equals, snap.R(7))
+
+	_, err = sto.SnapInfo(store.SnapSpec{Name: "bar", Channel: "stable"}, nil)
+	c.Check(err, Equals, store.ErrSnapNotFound)
+}
+
+func (s *mirrorSuite) TestDownload(c *C) {
+	snapPath := s.makeSnap(c, "foo", "foo-id", 7)
+	_, err := mirror.Import(s.dir, snapPath, []string{"stable"})
+	c.Assert(err, IsNil)
+
+	m, _ := s.serve(c)
+	defer m.Stop()
+
+	resp, err := http.Get(m.URL() + "download/foo_7.snap")
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Check(resp.StatusCode, Equals, 200)
+	data, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	expected, err := ioutil.ReadFile(snapPath)
+	c.Assert(err, IsNil)
+	c.Check(data, DeepEquals, expected)
+}
+
+func (s *mirrorSuite) TestListRefresh(c *C) {
+	_, err := mirror.Import(s.dir, s.makeSnap(c, "foo", "foo-id", 7), []string{"stable"})
+	c.Assert(err, IsNil)
+	_, err = mirror.Import(s.dir, s.makeSnap(c, "foo", "foo-id", 8), []string{"candidate"})
+	c.Assert(err, IsNil)
+	_, err = mirror.Import(s.dir, s.makeSnap(c, "bar", "bar-id", 3), []string{"stable"})
+	c.Assert(err, IsNil)
+
+	m, sto := s.serve(c)
+	defer m.Stop()
+
+	infos, err := sto.ListRefresh([]*store.RefreshCandidate{
+		{SnapID: "foo-id", Revision: snap.R(7), Channel: "candidate"},
+		{SnapID: "bar-id", Revision: snap.R(3), Channel: "stable"},
+		{SnapID: "baz-id", Revision: snap.R(1), Channel: "stable"},
+	}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+	c.Check(infos[0].Name(), Equals, "foo")
+	c.Check(infos[0].Revision, Equals, snap.R(8))
+	c.Check(infos[0].Channel, Equals, "candidate")
+}
+
+func (s *mirrorSuite) TestFind(c *C) {
+	_, err := mirror.Import(s.dir, s.makeSnap(c, "foo", "foo-id", 7), []string{"stable"})
+	c.Assert(err, IsNil)
+	_, err = mirror.Import(s.dir, s.makeSnap(c, "bar", "bar-id", 3), []string{"stable"})
+	c.Assert(err, IsNil)
+
+	m, sto := s.serve(c)
+	defer m.Stop()
+
+	infos, err := sto.Find(&store.Search{Query: "the"}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 2)
+	c.Check(infos[0].Name(), Equals, "bar")
+	c.Check(infos[1].Name(), Equals, "foo")
+
+	infos, err = sto.Find(&store.Search{Query: "foo"}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+	c.Check(infos[0].Name(), Equals, "foo")
+}
+
+func (s *mirrorSuite) TestAssertion(c *C) {
+	_, err := mirror.Import(s.dir, s.makeSnap(c, "foo", "foo-id", 7), []string{"stable"})
+	c.Assert(err, IsNil)
+
+	m, sto := s.serve(c)
+	defer m.Stop()
+
+	a, err := sto.Assertion(asserts.SnapDeclarationType, []string{"16", "foo-id"}, nil)
+	c.Assert(err, IsNil)
+	c.Check(a.(*asserts.SnapDeclaration).SnapName(), Equals, "foo")
+
+	a, err = sto.Assertion(asserts.AccountType, []string{s.dev1Acct.AccountID()}, nil)
+	c.Assert(err, IsNil)
+	c.Check(a.(*asserts.Account).Username(), Equals, "developer1")
+
+	_, err = sto.Assertion(asserts.SnapDeclarationType, []string{"16", "bar-id"}, nil)
+	c.Check(err, FitsTypeOf, &store.AssertionNotFoundError{})
+}
+
+func (s *mirrorSuite) TestSnapAction(c *C) {
+	_, err := mirror.Import(s.dir, s.makeSnap(c, "foo", "foo-id", 7), []string{"stable"})
+	c.Assert(err, IsNil)
+	_, err = mirror.Import(s.dir, s.makeSnap(c, "foo", "foo-id", 8), []string{"candidate"})
+	c.Assert(err, IsNil)
+	_, err = mirror.Import(s.dir, s.makeSnap(c, "bar", "bar-id", 3), []string{"stable"})
+	c.Assert(err, IsNil)
+
+	m, sto := s.serve(c)
+	defer m.Stop()
+
+	infos, err := sto.SnapAction(context.TODO(), []*store.CurrentSnap{
+		{Name: "foo", SnapID: "foo-id", Revision: snap.R(7), TrackingChannel: "candidate"},
+	}, []*store.SnapAction{
+		{Action: "refresh", SnapID: "foo-id"},
+		{Action: "install", Name: "bar"},
+		{Action: "download", Name: "baz"},
+	}, nil)
+	c.Assert(err, FitsTypeOf, &store.SnapActionError{})
+	c.Check(err.(*store.SnapActionError).Download, DeepEquals, map[string]error{"baz": store.ErrSnapNotFound})
+
+	c.Assert(infos, HasLen, 2)
+	c.Check(infos[0].Name(), Equals, "foo")
+	c.Check(infos[0].Revision, Equals, snap.R(8))
+	c.Check(infos[0].PublisherID, Equals, s.dev1Acct.AccountID())
+	c.Check(infos[0].Publisher, Equals, "developer1")
+	c.Check(infos[0].DownloadURL, Equals, m.URL()+"download/foo_8.snap")
+	c.Check(infos[1].Name(), Equals, "bar")
+	c.Check(infos[1].Revision, Equals, snap.R(3))
+}
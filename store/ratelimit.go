@@ -0,0 +1,155 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package store
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+var (
+	rateLimitNow   = time.Now
+	rateLimitSleep = func(ctx context.Context, d time.Duration) error {
+		select {
+		case <-time.After(d):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+)
+
+// rateLimiter is a token bucket, holding up to a second's worth of
+// bytes, that is shared by all the downloads of a store so that
+// together they do not go over the rate limit.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{last: rateLimitNow()}
+}
+
+// setRate changes the rate of the bucket to rate bytes per second.
+func (rl *rateLimiter) setRate(rate int64) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if rl.rate == float64(rate) {
+		return
+	}
+	rl.refill()
+	if rl.rate == 0 {
+		// the bucket starts out full
+		rl.tokens = float64(rate)
+	}
+	rl.rate = float64(rate)
+	if rl.tokens > rl.rate {
+		rl.tokens = rl.rate
+	}
+}
+
+// refill must be called with mu held.
+func (rl *rateLimiter) refill() {
+	now := rateLimitNow()
+	rl.tokens += now.Sub(rl.last).Seconds() * rl.rate
+	if rl.tokens > rl.rate {
+		rl.tokens = rl.rate
+	}
+	rl.last = now
+}
+
+// take waits for at least one byte to be available and takes up to n
+// bytes from the bucket, returning how many were taken.
+func (rl *rateLimiter) take(ctx context.Context, n int) (int, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.refill()
+	for rl.tokens < 1 {
+		wait := time.Duration((1 - rl.tokens) / rl.rate * float64(time.Second))
+		rl.mu.Unlock()
+		err := rateLimitSleep(ctx, wait)
+		rl.mu.Lock()
+		if err != nil {
+			return 0, err
+		}
+		rl.refill()
+	}
+
+	if float64(n) > rl.tokens {
+		n = int(rl.tokens)
+	}
+	rl.tokens -= float64(n)
+	return n, nil
+}
+
+// giveBack returns n bytes that were taken but not used to the bucket.
+func (rl *rateLimiter) giveBack(n int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.tokens += float64(n)
+	if rl.tokens > rl.rate {
+		rl.tokens = rl.rate
+	}
+}
+
+// rateLimitReader limits reading from r to what the shared limiter
+// allows. The limit is got from rate for every read, so that changing
+// it applies to the readers already running; no limit is applied while
+// it is zero.
+type rateLimitReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rateLimiter
+	rate    func() int64
+}
+
+func newRateLimitReader(ctx context.Context, r io.Reader, limiter *rateLimiter, rate func() int64) *rateLimitReader {
+	return &rateLimitReader{
+		ctx:     ctx,
+		r:       r,
+		limiter: limiter,
+		rate:    rate,
+	}
+}
+
+func (rl *rateLimitReader) Read(p []byte) (int, error) {
+	rate := rl.rate()
+	if len(p) == 0 || rate <= 0 {
+		return rl.r.Read(p)
+	}
+
+	rl.limiter.setRate(rate)
+	allowed, err := rl.limiter.take(rl.ctx, len(p))
+	if err != nil {
+		return 0, err
+	}
+	n, err := rl.r.Read(p[:allowed])
+	if n < allowed {
+		rl.limiter.giveBack(allowed - n)
+	}
+	return n, err
+}
@@ -0,0 +1,154 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+)
+
+type rateLimitSuite struct {
+	now    time.Time
+	slept  time.Duration
+	nowFn  func() time.Time
+	sleepF func(context.Context, time.Duration) error
+}
+
+var _ = Suite(&rateLimitSuite{})
+
+func (s *rateLimitSuite) SetUpTest(c *C) {
+	s.now = time.Now()
+	s.slept = 0
+	s.nowFn = rateLimitNow
+	s.sleepF = rateLimitSleep
+	rateLimitNow = func() time.Time { return s.now }
+	rateLimitSleep = func(ctx context.Context, d time.Duration) error {
+		s.slept += d
+		s.now = s.now.Add(d)
+		return ctx.Err()
+	}
+}
+
+func (s *rateLimitSuite) TearDownTest(c *C) {
+	rateLimitNow = s.nowFn
+	rateLimitSleep = s.sleepF
+}
+
+func fixedRate(rate int64) func() int64 {
+	return func() int64 { return rate }
+}
+
+func (s *rateLimitSuite) TestRateLimitReader(c *C) {
+	data := bytes.Repeat([]byte("x"), 3000)
+	limiter := newRateLimiter()
+	rl := newRateLimitReader(context.TODO(), bytes.NewReader(data), limiter, fixedRate(1000))
+
+	read, err := ioutil.ReadAll(rl)
+	c.Assert(err, IsNil)
+	c.Check(read, DeepEquals, data)
+	// the first second's worth comes from the full bucket
+	c.Check(s.slept >= 2*time.Second-time.Millisecond, Equals, true, Commentf("slept %v", s.slept))
+	c.Check(s.slept <= 2*time.Second+time.Millisecond, Equals, true, Commentf("slept %v", s.slept))
+}
+
+func (s *rateLimitSuite) TestRateLimitReaderCancelled(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	limiter := newRateLimiter()
+	rl := newRateLimitReader(ctx, strings.NewReader("0123456789"), limiter, fixedRate(5))
+
+	buf := make([]byte, 10)
+	n, err := rl.Read(buf)
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, 5)
+
+	cancel()
+	_, err = rl.Read(buf)
+	c.Check(err, Equals, context.Canceled)
+}
+
+func (s *rateLimitSuite) TestRateLimitReadersShareLimiter(c *C) {
+	data := bytes.Repeat([]byte("x"), 3000)
+	limiter := newRateLimiter()
+	rl1 := newRateLimitReader(context.TODO(), bytes.NewReader(data), limiter, fixedRate(1000))
+	rl2 := newRateLimitReader(context.TODO(), bytes.NewReader(data), limiter, fixedRate(1000))
+
+	for _, rl := range []*rateLimitReader{rl1, rl2} {
+		read, err := ioutil.ReadAll(rl)
+		c.Assert(err, IsNil)
+		c.Check(read, DeepEquals, data)
+	}
+	// the second reader does not get a full bucket of its own, so
+	// both together are limited to 1000 bytes per second
+	c.Check(s.slept >= 5*time.Second-time.Millisecond, Equals, true, Commentf("slept %v", s.slept))
+	c.Check(s.slept <= 5*time.Second+time.Millisecond, Equals, true, Commentf("slept %v", s.slept))
+}
+
+func (s *rateLimitSuite) TestRateLimiterSetRate(c *C) {
+	limiter := newRateLimiter()
+	limiter.setRate(1000)
+	n, err := limiter.take(context.TODO(), 2000)
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, 1000)
+
+	// retuning applies to what is taken next
+	limiter.setRate(100)
+	n, err = limiter.take(context.TODO(), 2000)
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, 1)
+	s.now = s.now.Add(time.Second)
+	n, err = limiter.take(context.TODO(), 2000)
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, 100)
+}
+
+func (s *rateLimitSuite) TestRateLimitReaderRateChanges(c *C) {
+	rate := int64(1000)
+	limiter := newRateLimiter()
+	rl := newRateLimitReader(context.TODO(), bytes.NewReader(bytes.Repeat([]byte("x"), 10000)), limiter, func() int64 { return rate })
+
+	buf := make([]byte, 10000)
+	// a second's worth from the full bucket, then what a second refills
+	n, err := rl.Read(buf)
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, 1000)
+	s.now = s.now.Add(time.Second)
+	n, err = rl.Read(buf)
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, 1000)
+
+	// lowering the limit applies to the running reader
+	rate = 100
+	s.now = s.now.Add(time.Second)
+	n, err = rl.Read(buf)
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, 100)
+
+	// as does removing it
+	rate = 0
+	n, err = rl.Read(buf)
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, 7900)
+	c.Check(s.slept, Equals, time.Duration(0))
+}
@@ -0,0 +1,295 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/auth"
+	"github.com/snapcore/snapd/snap"
+)
+
+// CurrentSnap describes a snap installed on the system, as context for
+// the actions of a SnapAction request.
+type CurrentSnap struct {
+	Name            string
+	SnapID          string
+	Revision        snap.Revision
+	TrackingChannel string
+	CohortKey       string
+	// Block lists the revisions the snap must not be refreshed to
+	Block []snap.Revision
+}
+
+// SnapAction is one of the actions of a SnapAction request: "refresh"
+// an installed snap identified by its SnapID, or "install" or
+// "download" a snap identified by its Name.
+type SnapAction struct {
+	Action    string
+	Name      string
+	SnapID    string
+	Channel   string
+	Revision  snap.Revision
+	CohortKey string
+}
+
+// SnapActionError conveys errors that were reported on otherwise
+// overall successful SnapAction requests, keyed by snap name.
+type SnapActionError struct {
+	// NoResults is set if there were no results nor errors
+	NoResults bool
+
+	Refresh  map[string]error
+	Install  map[string]error
+	Download map[string]error
+}
+
+func (e *SnapActionError) Error() string {
+	if e.NoResults {
+		return "no install/refresh information results from the store"
+	}
+
+	var es []string
+	for _, errs := range []struct {
+		verb string
+		m    map[string]error
+	}{
+		{"refresh", e.Refresh},
+		{"install", e.Install},
+		{"download", e.Download},
+	} {
+		names := make([]string, 0, len(errs.m))
+		for name := range errs.m {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			es = append(es, fmt.Sprintf("cannot %s snap %q: %v", errs.verb, name, errs.m[name]))
+		}
+	}
+	return strings.Join(es, "\n")
+}
+
+type snapActionContextJSON struct {
+	InstanceKey     string `json:"instance-key"`
+	SnapID          string `json:"snap-id"`
+	Revision        int    `json:"revision"`
+	TrackingChannel string `json:"tracking-channel"`
+	CohortKey       string `json:"cohort-key,omitempty"`
+}
+
+type snapActionJSON struct {
+	Action      string `json:"action"`
+	InstanceKey string `json:"instance-key"`
+	Name        string `json:"name,omitempty"`
+	SnapID      string `json:"snap-id,omitempty"`
+	Channel     string `json:"channel,omitempty"`
+	Revision    int    `json:"revision,omitempty"`
+	CohortKey   string `json:"cohort-key,omitempty"`
+}
+
+type snapActionRequestJSON struct {
+	Context []snapActionContextJSON `json:"context"`
+	Actions []snapActionJSON        `json:"actions"`
+	Fields  []string                `json:"fields"`
+}
+
+type snapActionResultJSON struct {
+	Result      string     `json:"result"`
+	InstanceKey string     `json:"instance-key"`
+	SnapID      string     `json:"snap-id"`
+	Name        string     `json:"name"`
+	Snap        *storeSnap `json:"snap"`
+	Error       struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type snapActionResultListJSON struct {
+	Results []*snapActionResultJSON `json:"results"`
+}
+
+func snapActionErrorFromCode(code, message string) error {
+	switch code {
+	case "revision-not-found", "id-not-found", "name-not-found":
+		return ErrSnapNotFound
+	case "no-update":
+		return ErrNoUpdateAvailable
+	}
+	return fmt.Errorf("%s", message)
+}
+
+// SnapAction asks the store, in a single request to its v2 snaps
+// refresh endpoint, for the snaps to refresh, install or download
+// according to actions given the currently installed snaps. Refreshes
+// to the current or a blocked revision are not reported. Errors about
+// single actions are returned as a *SnapActionError alongside the
+// other results.
+func (s *Store) SnapAction(ctx context.Context, currentSnaps []*CurrentSnap, actions []*SnapAction, user *auth.UserState) ([]*snap.Info, error) {
+	if s.snapActionURI == nil {
+		return nil, fmt.Errorf("cannot send snap actions: no store endpoint configured")
+	}
+
+	curSnaps := make(map[string]*CurrentSnap, len(currentSnaps))
+	actionContext := make([]snapActionContextJSON, 0, len(currentSnaps))
+	for _, curSnap := range currentSnaps {
+		if curSnap.SnapID == "" || curSnap.Name == "" || curSnap.Revision.Unset() {
+			return nil, fmt.Errorf("internal error: invalid current snap information")
+		}
+		curSnaps[curSnap.SnapID] = curSnap
+
+		revision := curSnap.Revision.N
+		if !curSnap.Revision.Store() {
+			revision = 0
+		}
+		actionContext = append(actionContext, snapActionContextJSON{
+			InstanceKey:     curSnap.SnapID,
+			SnapID:          curSnap.SnapID,
+			Revision:        revision,
+			TrackingChannel: curSnap.TrackingChannel,
+			CohortKey:       curSnap.CohortKey,
+		})
+	}
+
+	actionJSONs := make([]snapActionJSON, len(actions))
+	for i, a := range actions {
+		var instanceKey string
+		switch a.Action {
+		case "refresh":
+			if curSnaps[a.SnapID] == nil {
+				return nil, fmt.Errorf("internal error: refresh of snap-id %q without current snap information", a.SnapID)
+			}
+			instanceKey = a.SnapID
+		case "install", "download":
+			if a.Name == "" {
+				return nil, fmt.Errorf("internal error: %s action without snap name", a.Action)
+			}
+			instanceKey = a.Action + "-" + a.Name
+		default:
+			return nil, fmt.Errorf("internal error: unsupported snap action %q", a.Action)
+		}
+		actionJSONs[i] = snapActionJSON{
+			Action:      a.Action,
+			InstanceKey: instanceKey,
+			Name:        a.Name,
+			SnapID:      a.SnapID,
+			Channel:     a.Channel,
+			Revision:    a.Revision.N,
+			CohortKey:   a.CohortKey,
+		}
+	}
+
+	jsonData, err := json.Marshal(snapActionRequestJSON{
+		Context: actionContext,
+		Actions: actionJSONs,
+		Fields:  snapActionFields,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reqOptions := &requestOptions{
+		Method:      "POST",
+		URL:         s.snapActionURI,
+		Accept:      jsonContentType,
+		ContentType: jsonContentType,
+		Data:        jsonData,
+		APILevel:    apiV2Endps,
+	}
+
+	if useDeltas() {
+		logger.Debugf("Deltas enabled. Adding header Snap-Accept-Delta-Format: %v", s.deltaFormat)
+		reqOptions.ExtraHeaders = map[string]string{
+			"Snap-Accept-Delta-Format": s.deltaFormat,
+		}
+	}
+
+	var results snapActionResultListJSON
+	resp, err := s.retryRequestDecodeJSON(ctx, s.client, reqOptions, user, &results, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, respToError(resp, "query the store for snap actions")
+	}
+
+	s.extractSuggestedCurrency(resp)
+
+	var infos []*snap.Info
+	var actErr SnapActionError
+	addErr := func(m *map[string]error, name string, err error) {
+		if *m == nil {
+			*m = make(map[string]error)
+		}
+		(*m)[name] = err
+	}
+	hasErrors := false
+	for _, r := range results.Results {
+		if r.Result == "error" {
+			hasErrors = true
+			err := snapActionErrorFromCode(r.Error.Code, r.Error.Message)
+			switch {
+			case curSnaps[r.InstanceKey] != nil:
+				addErr(&actErr.Refresh, curSnaps[r.InstanceKey].Name, err)
+			case strings.HasPrefix(r.InstanceKey, "install-"):
+				addErr(&actErr.Install, r.Name, err)
+			case strings.HasPrefix(r.InstanceKey, "download-"):
+				addErr(&actErr.Download, r.Name, err)
+			default:
+				return nil, fmt.Errorf("unexpected error result for %q from the store: %v", r.InstanceKey, err)
+			}
+			continue
+		}
+
+		if r.Snap == nil {
+			return nil, fmt.Errorf("unexpected %s result for %q from the store without snap information", r.Result, r.InstanceKey)
+		}
+		if r.Result == "refresh" {
+			cur := curSnaps[r.InstanceKey]
+			if cur == nil {
+				return nil, fmt.Errorf("unexpected refresh result for %q from the store", r.InstanceKey)
+			}
+			rrev := snap.R(r.Snap.Revision)
+			// the store might report the revisions we already
+			// have or that we reverted from
+			if rrev == cur.Revision || findRev(rrev, cur.Block) {
+				continue
+			}
+		}
+		infos = append(infos, infoFromStoreSnap(r.Snap))
+	}
+
+	if hasErrors {
+		return infos, &actErr
+	}
+	if len(results.Results) == 0 && len(actions) > 0 {
+		return nil, &SnapActionError{NoResults: true}
+	}
+	return infos, nil
+}
@@ -0,0 +1,337 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package store
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/arch"
+	"github.com/snapcore/snapd/release"
+	"github.com/snapcore/snapd/snap"
+)
+
+func (t *remoteRepoTestSuite) snapActionStore(c *C, handler http.HandlerFunc) (*Store, func()) {
+	mockServer := httptest.NewServer(handler)
+	c.Assert(mockServer, NotNil)
+
+	snapActionURI, err := url.Parse(mockServer.URL + "/v2/snaps/refresh")
+	c.Assert(err, IsNil)
+	cfg := Config{
+		SnapActionURI: snapActionURI,
+	}
+	return New(&cfg, nil), mockServer.Close
+}
+
+func snapActionResult(result, instanceKey, snapID, name string, revision int) map[string]interface{} {
+	return map[string]interface{}{
+		"result":       result,
+		"instance-key": instanceKey,
+		"snap-id":      snapID,
+		"name":         name,
+		"snap": map[string]interface{}{
+			"snap-id":  snapID,
+			"name":     name,
+			"revision": revision,
+			"version":  "1.0",
+			"publisher": map[string]interface{}{
+				"id":       helloWorldDeveloperID,
+				"username": "canonical",
+			},
+		},
+	}
+}
+
+func (t *remoteRepoTestSuite) TestSnapAction(c *C) {
+	n := 0
+	sto, cleanup := t.snapActionStore(c, func(w http.ResponseWriter, r *http.Request) {
+		n++
+		c.Check(r.Method, Equals, "POST")
+		c.Check(r.URL.Path, Equals, "/v2/snaps/refresh")
+		c.Check(r.Header.Get("Content-Type"), Equals, jsonContentType)
+		c.Check(r.Header.Get("Snap-Device-Series"), Equals, release.Series)
+		c.Check(r.Header.Get("Snap-Device-Architecture"), Equals, arch.UbuntuArchitecture())
+		c.Check(r.Header.Get("Snap-Classic"), Equals, strconv.FormatBool(release.OnClassic))
+		c.Check(r.Header.Get("X-Ubuntu-Series"), Equals, "")
+
+		var req map[string]interface{}
+		c.Assert(json.NewDecoder(r.Body).Decode(&req), IsNil)
+		// nothing beyond what the store knows of
+		c.Check(req, HasLen, 3)
+		var reqJSON struct {
+			Context []map[string]interface{} `json:"context"`
+			Actions []map[string]interface{} `json:"actions"`
+			Fields  []string                 `json:"fields"`
+		}
+		data, err := json.Marshal(req)
+		c.Assert(err, IsNil)
+		c.Assert(json.Unmarshal(data, &reqJSON), IsNil)
+		c.Check(reqJSON.Context, DeepEquals, []map[string]interface{}{{
+			"instance-key":     helloWorldSnapID,
+			"snap-id":          helloWorldSnapID,
+			"revision":         float64(1),
+			"tracking-channel": "beta",
+			"cohort-key":       "cohort",
+		}})
+		c.Check(reqJSON.Actions, DeepEquals, []map[string]interface{}{{
+			"action":       "refresh",
+			"instance-key": helloWorldSnapID,
+			"snap-id":      helloWorldSnapID,
+			"channel":      "stable",
+		}, {
+			"action":       "install",
+			"instance-key": "install-foo",
+			"name":         "foo",
+			"channel":      "edge",
+		}, {
+			"action":       "download",
+			"instance-key": "download-bar",
+			"name":         "bar",
+			"revision":     float64(3),
+		}})
+		c.Check(reqJSON.Fields, DeepEquals, snapActionFields)
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"results": []interface{}{
+				snapActionResult("refresh", helloWorldSnapID, helloWorldSnapID, "hello-world", 26),
+				snapActionResult("install", "install-foo", "foo-id", "foo", 7),
+				snapActionResult("download", "download-bar", "bar-id", "bar", 3),
+			},
+		})
+	})
+	defer cleanup()
+
+	infos, err := sto.SnapAction(context.TODO(), []*CurrentSnap{{
+		Name:            "hello-world",
+		SnapID:          helloWorldSnapID,
+		Revision:        snap.R(1),
+		TrackingChannel: "beta",
+		CohortKey:       "cohort",
+	}}, []*SnapAction{
+		{Action: "refresh", SnapID: helloWorldSnapID, Channel: "stable"},
+		{Action: "install", Name: "foo", Channel: "edge"},
+		{Action: "download", Name: "bar", Revision: snap.R(3)},
+	}, nil)
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, 1)
+	c.Assert(infos, HasLen, 3)
+	c.Check(infos[0].Name(), Equals, "hello-world")
+	c.Check(infos[0].Revision, Equals, snap.R(26))
+	c.Check(infos[0].PublisherID, Equals, helloWorldDeveloperID)
+	c.Check(infos[0].Publisher, Equals, "canonical")
+	c.Check(infos[1].Name(), Equals, "foo")
+	c.Check(infos[1].Revision, Equals, snap.R(7))
+	c.Check(infos[2].Name(), Equals, "bar")
+	c.Check(infos[2].SnapID, Equals, "bar-id")
+}
+
+func (t *remoteRepoTestSuite) TestSnapActionStoreSnap(c *C) {
+	sto, cleanup := t.snapActionStore(c, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"results": [{
+  "result": "install",
+  "instance-key": "install-foo",
+  "snap-id": "foo-id",
+  "name": "foo",
+  "snap": {
+    "architectures": ["amd64"],
+    "confinement": "strict",
+    "contact": "mailto:foo@example.com",
+    "description": "The foo snap",
+    "download": {
+      "sha3-384": "abcdef",
+      "size": 1024,
+      "url": "https://api.snapcraft.io/download/foo_7.snap",
+      "deltas": [{"format": "xdelta3", "sha3-384": "012345", "size": 10, "source": 6, "target": 7, "url": "https://api.snapcraft.io/download/foo_6_7.delta"}]
+    },
+    "name": "foo",
+    "prices": {"EUR": "0.99"},
+    "private": true,
+    "publisher": {"id": "foo-dev-id", "username": "foo-dev", "display-name": "Foo Dev"},
+    "revision": 7,
+    "snap-id": "foo-id",
+    "summary": "Foo",
+    "type": "app",
+    "version": "1.2",
+    "media": [{"type": "icon", "url": "https://example.com/icon.png"}, {"type": "screenshot", "url": "https://example.com/shot.png", "width": 640, "height": 480}]
+  }
+}]}`))
+	})
+	defer cleanup()
+
+	infos, err := sto.SnapAction(context.TODO(), nil, []*SnapAction{
+		{Action: "install", Name: "foo"},
+	}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 1)
+	info := infos[0]
+	c.Check(info.Name(), Equals, "foo")
+	c.Check(info.SnapID, Equals, "foo-id")
+	c.Check(info.Revision, Equals, snap.R(7))
+	c.Check(info.Version, Equals, "1.2")
+	c.Check(info.Type, Equals, snap.TypeApp)
+	c.Check(info.Architectures, DeepEquals, []string{"amd64"})
+	c.Check(info.Confinement, Equals, snap.StrictConfinement)
+	c.Check(info.Summary(), Equals, "Foo")
+	c.Check(info.Description(), Equals, "The foo snap")
+	c.Check(info.Contact, Equals, "mailto:foo@example.com")
+	c.Check(info.Private, Equals, true)
+	c.Check(info.Prices, DeepEquals, map[string]float64{"EUR": 0.99})
+	c.Check(info.PublisherID, Equals, "foo-dev-id")
+	c.Check(info.Publisher, Equals, "foo-dev")
+	c.Check(info.DownloadURL, Equals, "https://api.snapcraft.io/download/foo_7.snap")
+	c.Check(info.AnonDownloadURL, Equals, "https://api.snapcraft.io/download/foo_7.snap")
+	c.Check(info.Size, Equals, int64(1024))
+	c.Check(info.Sha3_384, Equals, "abcdef")
+	c.Check(info.Deltas, DeepEquals, []snap.DeltaInfo{{
+		FromRevision:    6,
+		ToRevision:      7,
+		Format:          "xdelta3",
+		AnonDownloadURL: "https://api.snapcraft.io/download/foo_6_7.delta",
+		DownloadURL:     "https://api.snapcraft.io/download/foo_6_7.delta",
+		Size:            10,
+		Sha3_384:        "012345",
+	}})
+	c.Check(info.IconURL, Equals, "https://example.com/icon.png")
+	c.Check(info.Screenshots, DeepEquals, []snap.ScreenshotInfo{{URL: "https://example.com/shot.png", Width: 640, Height: 480}})
+}
+
+func (t *remoteRepoTestSuite) TestSnapActionSkipCurrentAndBlocked(c *C) {
+	sto, cleanup := t.snapActionStore(c, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"results": []interface{}{
+				snapActionResult("refresh", "foo-id", "foo-id", "foo", 7),
+				snapActionResult("refresh", "bar-id", "bar-id", "bar", 3),
+			},
+		})
+	})
+	defer cleanup()
+
+	infos, err := sto.SnapAction(context.TODO(), []*CurrentSnap{
+		{Name: "foo", SnapID: "foo-id", Revision: snap.R(7)},
+		{Name: "bar", SnapID: "bar-id", Revision: snap.R(1), Block: []snap.Revision{snap.R(3)}},
+	}, []*SnapAction{
+		{Action: "refresh", SnapID: "foo-id"},
+		{Action: "refresh", SnapID: "bar-id"},
+	}, nil)
+	c.Assert(err, IsNil)
+	c.Check(infos, HasLen, 0)
+}
+
+func (t *remoteRepoTestSuite) TestSnapActionErrors(c *C) {
+	sto, cleanup := t.snapActionStore(c, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"results": []interface{}{
+				map[string]interface{}{
+					"result":       "error",
+					"instance-key": "foo-id",
+					"snap-id":      "foo-id",
+					"error":        map[string]interface{}{"code": "no-update", "message": "no updates"},
+				},
+				map[string]interface{}{
+					"result":       "error",
+					"instance-key": "install-bar",
+					"name":         "bar",
+					"error":        map[string]interface{}{"code": "name-not-found", "message": "not found"},
+				},
+				map[string]interface{}{
+					"result":       "error",
+					"instance-key": "download-baz",
+					"name":         "baz",
+					"error":        map[string]interface{}{"code": "other", "message": "store is on fire"},
+				},
+				snapActionResult("install", "install-quux", "quux-id", "quux", 2),
+			},
+		})
+	})
+	defer cleanup()
+
+	infos, err := sto.SnapAction(context.TODO(), []*CurrentSnap{
+		{Name: "foo", SnapID: "foo-id", Revision: snap.R(7)},
+	}, []*SnapAction{
+		{Action: "refresh", SnapID: "foo-id"},
+		{Action: "install", Name: "bar"},
+		{Action: "download", Name: "baz"},
+		{Action: "install", Name: "quux"},
+	}, nil)
+	c.Assert(err, FitsTypeOf, &SnapActionError{})
+	actErr := err.(*SnapActionError)
+	c.Check(actErr.Refresh, DeepEquals, map[string]error{"foo": ErrNoUpdateAvailable})
+	c.Check(actErr.Install, DeepEquals, map[string]error{"bar": ErrSnapNotFound})
+	c.Check(actErr.Download, HasLen, 1)
+	c.Check(err, ErrorMatches, `cannot refresh snap "foo": snap has no updates available
+cannot install snap "bar": snap not found
+cannot download snap "baz": store is on fire`)
+	// the other results are still there
+	c.Assert(infos, HasLen, 1)
+	c.Check(infos[0].Name(), Equals, "quux")
+}
+
+func (t *remoteRepoTestSuite) TestSnapActionNoResults(c *C) {
+	sto, cleanup := t.snapActionStore(c, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"results": []}`))
+	})
+	defer cleanup()
+
+	_, err := sto.SnapAction(context.TODO(), nil, []*SnapAction{
+		{Action: "install", Name: "foo"},
+	}, nil)
+	c.Check(err, DeepEquals, &SnapActionError{NoResults: true})
+}
+
+func (t *remoteRepoTestSuite) TestSnapActionInvalid(c *C) {
+	sto, cleanup := t.snapActionStore(c, func(w http.ResponseWriter, r *http.Request) {
+		c.Fatalf("unexpected request")
+	})
+	defer cleanup()
+
+	_, err := sto.SnapAction(context.TODO(), nil, []*SnapAction{
+		{Action: "refresh", SnapID: "foo-id"},
+	}, nil)
+	c.Check(err, ErrorMatches, `internal error: refresh of snap-id "foo-id" without current snap information`)
+
+	_, err = sto.SnapAction(context.TODO(), nil, []*SnapAction{
+		{Action: "remove", Name: "foo"},
+	}, nil)
+	c.Check(err, ErrorMatches, `internal error: unsupported snap action "remove"`)
+
+	_, err = sto.SnapAction(context.TODO(), []*CurrentSnap{{SnapID: "foo-id"}}, nil, nil)
+	c.Check(err, ErrorMatches, `internal error: invalid current snap information`)
+}
+
+func (t *remoteRepoTestSuite) TestSnapAction500(c *C) {
+	n := 0
+	sto, cleanup := t.snapActionStore(c, func(w http.ResponseWriter, r *http.Request) {
+		n++
+		w.WriteHeader(500)
+	})
+	defer cleanup()
+
+	_, err := sto.SnapAction(context.TODO(), nil, []*SnapAction{
+		{Action: "install", Name: "foo"},
+	}, nil)
+	c.Check(err, ErrorMatches, `cannot query the store for snap actions: got unexpected HTTP status code 500 via POST to "http://.*?/v2/snaps/refresh"`)
+	c.Check(n, Equals, 5)
+}
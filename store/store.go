@@ -30,7 +30,6 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"reflect"
@@ -45,11 +44,13 @@ import (
 	"github.com/snapcore/snapd/httputil"
 	"github.com/snapcore/snapd/i18n"
 	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/metrics"
 	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/overlord/auth"
 	"github.com/snapcore/snapd/progress"
 	"github.com/snapcore/snapd/release"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/delta"
 
 	"golang.org/x/net/context"
 	"golang.org/x/net/context/ctxhttp"
@@ -132,6 +133,7 @@ type Config struct {
 	OrdersURI      *url.URL
 	CustomersMeURI *url.URL
 	SectionsURI    *url.URL
+	SnapActionURI  *url.URL
 
 	// StoreID is the store id used if we can't get one through the AuthContext.
 	StoreID string
@@ -141,6 +143,14 @@ type Config struct {
 
 	DetailFields []string
 	DeltaFormat  string
+
+	// CacheDir is where downloaded snaps are cached, no cache is
+	// used if it is empty. The cache is pruned to CacheMaxSize bytes
+	// of snaps not in use otherwise, and to snaps used within
+	// CacheMaxAge; both have defaults if zero.
+	CacheDir     string
+	CacheMaxSize int64
+	CacheMaxAge  time.Duration
 }
 
 // Store represents the ubuntu snap store
@@ -152,6 +162,7 @@ type Store struct {
 	ordersURI      *url.URL
 	customersMeURI *url.URL
 	sectionsURI    *url.URL
+	snapActionURI  *url.URL
 
 	architecture string
 	series       string
@@ -165,6 +176,11 @@ type Store struct {
 	// reused http client
 	client *http.Client
 
+	cacher downloadCache
+
+	// rateLimiter is shared by all downloads, see DownloadOptions
+	rateLimiter *rateLimiter
+
 	authContext auth.AuthContext
 
 	mu                sync.Mutex
@@ -181,17 +197,21 @@ func respToError(resp *http.Response, msg string) error {
 	return fmt.Errorf(tpl, msg, resp.StatusCode, resp.Request.Method, resp.Request.URL)
 }
 
-func getStructFields(s interface{}) []string {
+func getStructFields(s interface{}, exceptions ...string) []string {
 	st := reflect.TypeOf(s)
 	num := st.NumField()
 	fields := make([]string, 0, num)
+	excluded := make(map[string]bool, len(exceptions))
+	for _, tag := range exceptions {
+		excluded[tag] = true
+	}
 	for i := 0; i < num; i++ {
 		tag := st.Field(i).Tag.Get("json")
 		idx := strings.IndexRune(tag, ',')
 		if idx > -1 {
 			tag = tag[:idx]
 		}
-		if tag != "" {
+		if tag != "" && !excluded[tag] {
 			fields = append(fields, tag)
 		}
 	}
@@ -203,7 +223,7 @@ func getStructFields(s interface{}) []string {
 func useDeltas() bool {
 	// only xdelta3 is supported for now, so check the binary exists here
 	// TODO: have a per-format checker instead
-	if _, err := getXdelta3Cmd(); err != nil {
+	if _, err := delta.Xdelta3Cmd(); err != nil {
 		return false
 	}
 
@@ -241,6 +261,17 @@ func authURL() string {
 	return "https://" + authLocation() + "/api/v2"
 }
 
+func apiURL() string {
+	if u := os.Getenv("SNAPPY_FORCE_API_URL"); u != "" {
+		return u
+	}
+	if useStaging() {
+		return "https://api.staging.snapcraft.io/"
+	}
+
+	return "https://api.snapcraft.io/"
+}
+
 func assertsURL() string {
 	if u := os.Getenv("SNAPPY_FORCE_SAS_URL"); u != "" {
 		return u
@@ -267,6 +298,41 @@ func DefaultConfig() *Config {
 	return &cfg
 }
 
+// SetBaseURL makes the configuration use the store and assertions
+// service at the given base URL, as served for instance by
+// snap-store-mirror. Purchases are not supported by such a store and
+// keep going to the main one.
+func (cfg *Config) SetBaseURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid store URL %q: only http and https are supported", u)
+	}
+	// the endpoints are relative to the base
+	if !strings.HasSuffix(u.Path, "/") {
+		base := *u
+		base.Path += "/"
+		u = &base
+	}
+
+	var err error
+	for _, ep := range []struct {
+		uri  **url.URL
+		path string
+	}{
+		{&cfg.SearchURI, "snaps/search"},
+		{&cfg.DetailsURI, "snaps/details/"},
+		{&cfg.BulkURI, "snaps/metadata"},
+		{&cfg.SectionsURI, "snaps/sections"},
+		{&cfg.AssertionsURI, "assertions/"},
+		{&cfg.SnapActionURI, "v2/snaps/refresh"},
+	} {
+		*ep.uri, err = u.Parse(ep.path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func init() {
 	storeBaseURI, err := url.Parse(cpiURL())
 	if err != nil {
@@ -313,6 +379,16 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+
+	apiBaseURI, err := url.Parse(apiURL())
+	if err != nil {
+		panic(err)
+	}
+
+	defaultConfig.SnapActionURI, err = apiBaseURI.Parse("v2/snaps/refresh")
+	if err != nil {
+		panic(err)
+	}
 }
 
 type searchResults struct {
@@ -327,8 +403,9 @@ type sectionResults struct {
 	} `json:"_embedded"`
 }
 
-// The fields we are interested in
-var detailFields = getStructFields(snapDetails{})
+// The fields we are interested in; the channel map is asked for only
+// by SnapInfo, when it's wanted
+var detailFields = getStructFields(snapDetails{}, "channel_maps_list")
 
 // The fields we are interested in for snap.ChannelSnapInfos
 var channelSnapInfoFields = getStructFields(channelSnapInfoDetails{})
@@ -388,6 +465,19 @@ func New(cfg *Config, authContext auth.AuthContext) *Store {
 		deltaFormat = defaultSupportedDeltaFormat
 	}
 
+	var cacher downloadCache = nullCache{}
+	if cfg.CacheDir != "" {
+		maxSize := cfg.CacheMaxSize
+		if maxSize == 0 {
+			maxSize = defaultCacheMaxSize
+		}
+		maxAge := cfg.CacheMaxAge
+		if maxAge == 0 {
+			maxAge = defaultCacheMaxAge
+		}
+		cacher = NewCacheManager(cfg.CacheDir, maxSize, maxAge)
+	}
+
 	// see https://wiki.ubuntu.com/AppStore/Interfaces/ClickPackageIndex
 	return &Store{
 		searchURI:       searchURI,
@@ -397,6 +487,7 @@ func New(cfg *Config, authContext auth.AuthContext) *Store {
 		ordersURI:       cfg.OrdersURI,
 		customersMeURI:  cfg.CustomersMeURI,
 		sectionsURI:     sectionsURI,
+		snapActionURI:   cfg.SnapActionURI,
 		series:          series,
 		architecture:    architecture,
 		noCDN:           osutil.GetenvBool("SNAPPY_STORE_NO_CDN"),
@@ -404,6 +495,8 @@ func New(cfg *Config, authContext auth.AuthContext) *Store {
 		detailFields:    fields,
 		authContext:     authContext,
 		deltaFormat:     deltaFormat,
+		cacher:          cacher,
+		rateLimiter:     newRateLimiter(),
 
 		client: httputil.NewHTTPClient(&httputil.ClientOpts{
 			Timeout:    10 * time.Second,
@@ -552,7 +645,7 @@ func authenticateDevice(r *http.Request, device *auth.DeviceState) {
 	}
 }
 
-func (s *Store) setStoreID(r *http.Request) {
+func (s *Store) setStoreID(r *http.Request, apiLevel apiLevel) {
 	storeID := s.fallbackStoreID
 	if s.authContext != nil {
 		cand, err := s.authContext.StoreID(storeID)
@@ -562,11 +655,25 @@ func (s *Store) setStoreID(r *http.Request) {
 			storeID = cand
 		}
 	}
-	if storeID != "" {
+	if storeID == "" {
+		return
+	}
+	if apiLevel == apiV2Endps {
+		r.Header.Set("Snap-Device-Store", storeID)
+	} else {
 		r.Header.Set("X-Ubuntu-Store", storeID)
 	}
 }
 
+// apiLevel is the level of the store API an endpoint belongs to, which
+// determines the headers sent to it.
+type apiLevel int
+
+const (
+	apiV1Endps apiLevel = iota
+	apiV2Endps
+)
+
 // requestOptions specifies parameters for store requests.
 type requestOptions struct {
 	Method       string
@@ -575,6 +682,7 @@ type requestOptions struct {
 	ContentType  string
 	ExtraHeaders map[string]string
 	Data         []byte
+	APILevel     apiLevel
 }
 
 func cancelled(ctx context.Context) bool {
@@ -600,6 +708,17 @@ func (s *Store) retryRequestDecodeJSON(ctx context.Context, client *http.Client,
 	})
 }
 
+var (
+	storeRequests = metrics.NewCounterVec("snapd_store_requests_total",
+		"Number of requests made to the store, retries included, by method and status code, or \"error\" if no response was received.",
+		"method", "code")
+	storeRequestErrors = metrics.NewCounterVec("snapd_store_request_errors_total",
+		"Number of requests to the store that failed even after retrying, by method.",
+		"method")
+	downloadedBytes = metrics.NewCounterVec("snapd_store_download_bytes_total",
+		"Number of bytes downloaded from the store.")
+)
+
 // retryRequest calls doRequest and decodes the response in a retry loop.
 func (s *Store) retryRequest(ctx context.Context, client *http.Client, reqOptions *requestOptions, user *auth.UserState, decode func(ok bool, resp *http.Response) error) (resp *http.Response, err error) {
 	var attempt *retry.Attempt
@@ -611,6 +730,7 @@ func (s *Store) retryRequest(ctx context.Context, client *http.Client, reqOption
 		}
 
 		resp, err = s.doRequest(ctx, client, reqOptions, user)
+		countRequest(reqOptions.Method, resp, err)
 		if err != nil {
 			if shouldRetryError(attempt, err) {
 				continue
@@ -642,10 +762,22 @@ func (s *Store) retryRequest(ctx context.Context, client *http.Client, reqOption
 		break
 	}
 	maybeLogRetrySummary(startTime, reqOptions.URL.String(), attempt, resp, err)
+	if err != nil || resp.StatusCode >= 400 {
+		storeRequestErrors.Inc(reqOptions.Method)
+	}
 
 	return resp, err
 }
 
+// countRequest counts a request made to the store with its outcome.
+func countRequest(method string, resp *http.Response, err error) {
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	storeRequests.Inc(method, code)
+}
+
 // doRequest does an authenticated request to the store handling a potential macaroon refresh required if needed
 func (s *Store) doRequest(ctx context.Context, client *http.Client, reqOptions *requestOptions, user *auth.UserState) (*http.Response, error) {
 	req, err := s.newRequest(reqOptions, user)
@@ -740,11 +872,17 @@ func (s *Store) newRequest(reqOptions *requestOptions, user *auth.UserState) (*h
 
 	req.Header.Set("User-Agent", httputil.UserAgent())
 	req.Header.Set("Accept", reqOptions.Accept)
-	req.Header.Set("X-Ubuntu-Architecture", s.architecture)
-	req.Header.Set("X-Ubuntu-Series", s.series)
-	req.Header.Set("X-Ubuntu-Classic", strconv.FormatBool(release.OnClassic))
-	req.Header.Set("X-Ubuntu-Wire-Protocol", UbuntuCoreWireProtocol)
-	req.Header.Set("X-Ubuntu-No-CDN", strconv.FormatBool(s.noCDN))
+	if reqOptions.APILevel == apiV2Endps {
+		req.Header.Set("Snap-Device-Architecture", s.architecture)
+		req.Header.Set("Snap-Device-Series", s.series)
+		req.Header.Set("Snap-Classic", strconv.FormatBool(release.OnClassic))
+	} else {
+		req.Header.Set("X-Ubuntu-Architecture", s.architecture)
+		req.Header.Set("X-Ubuntu-Series", s.series)
+		req.Header.Set("X-Ubuntu-Classic", strconv.FormatBool(release.OnClassic))
+		req.Header.Set("X-Ubuntu-Wire-Protocol", UbuntuCoreWireProtocol)
+		req.Header.Set("X-Ubuntu-No-CDN", strconv.FormatBool(s.noCDN))
+	}
 
 	if reqOptions.ContentType != "" {
 		req.Header.Set("Content-Type", reqOptions.ContentType)
@@ -754,7 +892,7 @@ func (s *Store) newRequest(reqOptions *requestOptions, user *auth.UserState) (*h
 		req.Header.Set(header, value)
 	}
 
-	s.setStoreID(req)
+	s.setStoreID(req, reqOptions.APILevel)
 
 	return req, nil
 }
@@ -926,6 +1064,37 @@ func (s *Store) fakeChannels(snapID string, user *auth.UserState) (map[string]*s
 	return channelInfos, nil
 }
 
+// channelsFromMapList returns the open channels in the channel map
+// sent by the store, named leaving out the default track, and the
+// tracks of the snap.
+func channelsFromMapList(mapList []channelMap) (map[string]*snap.ChannelSnapInfo, []string) {
+	channelInfos := make(map[string]*snap.ChannelSnapInfo)
+	tracks := make([]string, len(mapList))
+	for i, chMap := range mapList {
+		tracks[i] = chMap.Track
+		for _, item := range chMap.SnapDetails {
+			// closed channels, and those following another one,
+			// don't serve a revision of their own
+			if item.Info != "released" {
+				continue
+			}
+			name := strings.TrimPrefix(item.Channel, chMap.Track+"/")
+			if chMap.Track != snap.DefaultTrack {
+				name = chMap.Track + "/" + name
+			}
+			channelInfos[name] = &snap.ChannelSnapInfo{
+				Revision:    snap.R(item.Revision),
+				Confinement: snap.ConfinementType(item.Confinement),
+				Version:     item.Version,
+				Channel:     name,
+				Epoch:       item.Epoch,
+				Size:        item.DownloadSize,
+			}
+		}
+	}
+	return channelInfos, tracks
+}
+
 // A SnapSpec describes a single snap wanted from SnapInfo
 type SnapSpec struct {
 	Name     string
@@ -948,6 +1117,12 @@ func (s *Store) SnapInfo(snapSpec SnapSpec, user *auth.UserState) (*snap.Info, e
 		query.Set("channel", "")
 	}
 
+	// only get the channels when it makes sense as part of the reply
+	wantChannels := snapSpec.Channel == "" && snapSpec.Revision.Unset()
+	if fields := query.Get("fields"); wantChannels && fields != "" {
+		query.Set("fields", fields+",channel_maps_list")
+	}
+
 	u.RawQuery = query.Encode()
 
 	reqOptions := &requestOptions{
@@ -975,13 +1150,17 @@ func (s *Store) SnapInfo(snapSpec SnapSpec, user *auth.UserState) (*snap.Info, e
 
 	info := infoFromRemote(remote)
 
-	// only get the channels when it makes sense as part of the reply
-	if info.SnapID != "" && snapSpec.Channel == "" && snapSpec.Revision.Unset() {
-		channels, err := s.fakeChannels(info.SnapID, user)
-		if err != nil {
-			logger.Noticef("cannot get channels: %v", err)
+	if info.SnapID != "" && wantChannels {
+		if len(remote.ChannelMapList) > 0 {
+			info.Channels, info.Tracks = channelsFromMapList(remote.ChannelMapList)
 		} else {
-			info.Channels = channels
+			// the store didn't send the channel map
+			channels, err := s.fakeChannels(info.SnapID, user)
+			if err != nil {
+				logger.Noticef("cannot get channels: %v", err)
+			} else {
+				info.Channels = channels
+			}
 		}
 	}
 
@@ -1252,20 +1431,46 @@ func (e HashError) Error() string {
 	return fmt.Sprintf("sha3-384 mismatch after patching %q: got %s but expected %s", e.name, e.sha3_384, e.targetSha3_384)
 }
 
+// DownloadOptions carries options for downloading snaps.
+type DownloadOptions struct {
+	// RateLimit returns the maximum download speed in bytes per
+	// second, no limit is applied while it returns zero. It is called
+	// throughout the download so that changing the limit applies to
+	// downloads in progress, and so it should be cheap. The limit
+	// applies to all the rate limited downloads of the store together.
+	RateLimit func() int64
+}
+
+func (dlOpts *DownloadOptions) rateLimit() func() int64 {
+	if dlOpts == nil {
+		return nil
+	}
+	return dlOpts.RateLimit
+}
+
 // Download downloads the snap addressed by download info and returns its
 // filename.
 // The file is saved in temporary storage, and should be removed
 // after use to prevent the disk from running out of space.
-func (s *Store) Download(ctx context.Context, name string, targetPath string, downloadInfo *snap.DownloadInfo, pbar progress.Meter, user *auth.UserState) error {
+func (s *Store) Download(ctx context.Context, name string, targetPath string, downloadInfo *snap.DownloadInfo, pbar progress.Meter, user *auth.UserState, dlOpts *DownloadOptions) error {
 	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
 		return err
 	}
+
+	if downloadInfo.Sha3_384 != "" {
+		if err := s.cacher.Get(downloadInfo.Sha3_384, targetPath); err == nil {
+			logger.Debugf("Cache hit for SHA3_384 …%.5s.", downloadInfo.Sha3_384)
+			return nil
+		}
+	}
+
 	if useDeltas() {
 		logger.Debugf("Available deltas returned by store: %v", downloadInfo.Deltas)
 	}
 	if useDeltas() && len(downloadInfo.Deltas) == 1 {
-		err := s.downloadAndApplyDelta(name, targetPath, downloadInfo, pbar, user)
+		err := s.downloadAndApplyDelta(name, targetPath, downloadInfo, pbar, user, dlOpts)
 		if err == nil {
+			s.cacheDownload(downloadInfo.Sha3_384, targetPath)
 			return nil
 		}
 		// We revert to normal downloads if there is any error.
@@ -1273,6 +1478,12 @@ func (s *Store) Download(ctx context.Context, name string, targetPath string, do
 	}
 
 	partialPath := targetPath + ".partial"
+	if downloadInfo.Sha3_384 != "" {
+		// partial downloads are keyed by the expected digest, so
+		// they are only ever resumed for the very same file, also
+		// across restarts
+		partialPath = filepath.Join(filepath.Dir(targetPath), downloadInfo.Sha3_384+".partial")
+	}
 	w, err := os.OpenFile(partialPath, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
 		return err
@@ -1285,7 +1496,12 @@ func (s *Store) Download(ctx context.Context, name string, targetPath string, do
 		if cerr := w.Close(); cerr != nil && err == nil {
 			err = cerr
 		}
-		if err != nil {
+		if err == nil {
+			return
+		}
+		// keep what we got so far to resume from it the next time,
+		// unless it cannot be checked or is known to be broken
+		if _, ok := err.(HashError); ok || downloadInfo.Sha3_384 == "" {
 			os.Remove(w.Name())
 		}
 	}()
@@ -1295,7 +1511,7 @@ func (s *Store) Download(ctx context.Context, name string, targetPath string, do
 		url = downloadInfo.DownloadURL
 	}
 
-	err = download(ctx, name, downloadInfo.Sha3_384, url, user, s, w, resume, pbar)
+	err = download(ctx, name, downloadInfo.Sha3_384, url, user, s, w, resume, pbar, dlOpts)
 	// If sha3 checksum is incorrect and it was a resumed download, retry from scratch.
 	// Note that we will retry this way only once.
 	if _, ok := err.(HashError); ok && resume > 0 {
@@ -1308,7 +1524,7 @@ func (s *Store) Download(ctx context.Context, name string, targetPath string, do
 		if err != nil {
 			return err
 		}
-		err = download(ctx, name, downloadInfo.Sha3_384, url, user, s, w, 0, pbar)
+		err = download(ctx, name, downloadInfo.Sha3_384, url, user, s, w, 0, pbar, dlOpts)
 	}
 
 	if err != nil {
@@ -1319,11 +1535,27 @@ func (s *Store) Download(ctx context.Context, name string, targetPath string, do
 		return err
 	}
 
-	return w.Sync()
+	if err := w.Sync(); err != nil {
+		return err
+	}
+
+	s.cacheDownload(downloadInfo.Sha3_384, targetPath)
+	return nil
+}
+
+// cacheDownload adds a downloaded snap to the cache; failing to do so
+// does not fail the download.
+func (s *Store) cacheDownload(sha3_384, path string) {
+	if sha3_384 == "" {
+		return
+	}
+	if err := s.cacher.Put(sha3_384, path); err != nil {
+		logger.Debugf("Cannot cache download of %s: %v", path, err)
+	}
 }
 
 // download writes an http.Request showing a progress.Meter
-var download = func(ctx context.Context, name, sha3_384, downloadURL string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter) error {
+var download = func(ctx context.Context, name, sha3_384, downloadURL string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
 	storeURL, err := url.Parse(downloadURL)
 	if err != nil {
 		return err
@@ -1362,6 +1594,7 @@ var download = func(ctx context.Context, name, sha3_384, downloadURL string, use
 		}
 		var resp *http.Response
 		resp, finalErr = s.doRequest(ctx, httputil.NewHTTPClient(nil), reqOptions, user)
+		countRequest(reqOptions.Method, resp, finalErr)
 
 		if cancelled(ctx) {
 			return fmt.Errorf("The download has been cancelled: %s", ctx.Err())
@@ -1382,6 +1615,17 @@ var download = func(ctx context.Context, name, sha3_384, downloadURL string, use
 
 		switch resp.StatusCode {
 		case http.StatusOK, http.StatusPartialContent:
+		case http.StatusRequestedRangeNotSatisfiable:
+			if resume == 0 {
+				return &ErrDownload{Code: resp.StatusCode, URL: resp.Request.URL}
+			}
+			// nothing left to download, the partial file is
+			// complete already; check it like any other download
+			actualSha3 := fmt.Sprintf("%x", h.Sum(nil))
+			if sha3_384 != "" && sha3_384 != actualSha3 {
+				return HashError{name, actualSha3, sha3_384}
+			}
+			return nil
 		case http.StatusUnauthorized:
 
 			return fmt.Errorf("Please buy %s before installing it.", name)
@@ -1389,12 +1633,29 @@ var download = func(ctx context.Context, name, sha3_384, downloadURL string, use
 			return &ErrDownload{Code: resp.StatusCode, URL: resp.Request.URL}
 		}
 
+		if resume > 0 && resp.StatusCode == http.StatusOK {
+			// the server ignored the range and sends the whole
+			// file, so start over; the partial file is a prefix of
+			// it and gets overwritten completely
+			if _, err := w.Seek(0, os.SEEK_SET); err != nil {
+				return err
+			}
+			h = crypto.SHA3_384.New()
+			resume = 0
+		}
+
 		if pbar == nil {
 			pbar = &progress.NullProgress{}
 		}
 		pbar.Start(name, float64(resp.ContentLength))
 		mw := io.MultiWriter(w, h, pbar)
-		_, finalErr = io.Copy(mw, resp.Body)
+		var body io.Reader = resp.Body
+		if rate := dlOpts.rateLimit(); rate != nil {
+			body = newRateLimitReader(ctx, body, s.rateLimiter, rate)
+		}
+		var n int64
+		n, finalErr = io.Copy(mw, body)
+		downloadedBytes.Add(float64(n))
 		pbar.Finished()
 		if finalErr != nil {
 			if shouldRetryError(attempt, finalErr) {
@@ -1423,7 +1684,7 @@ var download = func(ctx context.Context, name, sha3_384, downloadURL string, use
 }
 
 // downloadDelta downloads the delta for the preferred format, returning the path.
-func (s *Store) downloadDelta(deltaName string, downloadInfo *snap.DownloadInfo, w io.ReadWriteSeeker, pbar progress.Meter, user *auth.UserState) error {
+func (s *Store) downloadDelta(deltaName string, downloadInfo *snap.DownloadInfo, w io.ReadWriteSeeker, pbar progress.Meter, user *auth.UserState, dlOpts *DownloadOptions) error {
 
 	if len(downloadInfo.Deltas) != 1 {
 		return errors.New("store returned more than one download delta")
@@ -1440,17 +1701,7 @@ func (s *Store) downloadDelta(deltaName string, downloadInfo *snap.DownloadInfo,
 		url = deltaInfo.DownloadURL
 	}
 
-	return download(context.TODO(), deltaName, deltaInfo.Sha3_384, url, user, s, w, 0, pbar)
-}
-
-func getXdelta3Cmd(args ...string) (*exec.Cmd, error) {
-	switch {
-	case osutil.ExecutableExists("xdelta3"):
-		return exec.Command("xdelta3", args...), nil
-	case osutil.FileExists(filepath.Join(dirs.SnapMountDir, "/core/current/usr/bin/xdelta3")):
-		return osutil.CommandFromCore("/usr/bin/xdelta3", args...)
-	}
-	return nil, fmt.Errorf("cannot find xdelta3 binary in PATH or core snap")
+	return download(context.TODO(), deltaName, deltaInfo.Sha3_384, url, user, s, w, 0, pbar, dlOpts)
 }
 
 // applyDelta generates a target snap from a previously downloaded snap and a downloaded delta.
@@ -1462,20 +1713,10 @@ var applyDelta = func(name string, deltaPath string, deltaInfo *snap.DeltaInfo,
 		return fmt.Errorf("snap %q revision %d not found at %s", name, deltaInfo.FromRevision, snapPath)
 	}
 
-	if deltaInfo.Format != "xdelta3" {
-		return fmt.Errorf("cannot apply unsupported delta format %q (only xdelta3 currently)", deltaInfo.Format)
-	}
-
 	partialTargetPath := targetPath + ".partial"
 
-	xdelta3Args := []string{"-d", "-s", snapPath, deltaPath, partialTargetPath}
-	cmd, err := getXdelta3Cmd(xdelta3Args...)
-	if err != nil {
-		return err
-	}
-
-	if err := cmd.Run(); err != nil {
-		if err := os.Remove(partialTargetPath); err != nil {
+	if err := delta.Apply(deltaInfo.Format, snapPath, deltaPath, partialTargetPath); err != nil {
+		if err := os.Remove(partialTargetPath); err != nil && !os.IsNotExist(err) {
 			logger.Noticef("failed to remove partial delta target %q: %s", partialTargetPath, err)
 		}
 		return err
@@ -1501,7 +1742,7 @@ var applyDelta = func(name string, deltaPath string, deltaInfo *snap.DeltaInfo,
 }
 
 // downloadAndApplyDelta downloads and then applies the delta to the current snap.
-func (s *Store) downloadAndApplyDelta(name, targetPath string, downloadInfo *snap.DownloadInfo, pbar progress.Meter, user *auth.UserState) error {
+func (s *Store) downloadAndApplyDelta(name, targetPath string, downloadInfo *snap.DownloadInfo, pbar progress.Meter, user *auth.UserState, dlOpts *DownloadOptions) error {
 	deltaInfo := &downloadInfo.Deltas[0]
 
 	deltaPath := fmt.Sprintf("%s.%s-%d-to-%d.partial", targetPath, deltaInfo.Format, deltaInfo.FromRevision, deltaInfo.ToRevision)
@@ -1518,7 +1759,7 @@ func (s *Store) downloadAndApplyDelta(name, targetPath string, downloadInfo *sna
 		os.Remove(deltaPath)
 	}()
 
-	err = s.downloadDelta(deltaName, downloadInfo, w, pbar, user)
+	err = s.downloadDelta(deltaName, downloadInfo, w, pbar, user, dlOpts)
 	if err != nil {
 		return err
 	}
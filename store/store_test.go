@@ -62,7 +62,7 @@ type remoteRepoTestSuite struct {
 	localUser *auth.UserState
 	device    *auth.DeviceState
 
-	origDownloadFunc func(context.Context, string, string, string, *auth.UserState, *Store, io.ReadWriteSeeker, int64, progress.Meter) error
+	origDownloadFunc func(context.Context, string, string, string, *auth.UserState, *Store, io.ReadWriteSeeker, int64, progress.Meter, *DownloadOptions) error
 	mockXDelta       *testutil.MockCmd
 }
 
@@ -274,7 +274,7 @@ func (t *remoteRepoTestSuite) expectedAuthorization(c *C, user *auth.UserState)
 
 func (t *remoteRepoTestSuite) TestDownloadOK(c *C) {
 
-	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter) error {
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
 		c.Check(url, Equals, "anon-url")
 		w.Write([]byte("I was downloaded"))
 		return nil
@@ -286,7 +286,7 @@ func (t *remoteRepoTestSuite) TestDownloadOK(c *C) {
 	snap.DownloadURL = "AUTH-URL"
 
 	path := filepath.Join(c.MkDir(), "downloaded-file")
-	err := t.store.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, nil)
+	err := t.store.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, nil, nil)
 	c.Assert(err, IsNil)
 	defer os.Remove(path)
 
@@ -295,10 +295,59 @@ func (t *remoteRepoTestSuite) TestDownloadOK(c *C) {
 	c.Assert(string(content), Equals, "I was downloaded")
 }
 
+func (t *remoteRepoTestSuite) TestDownloadCachesAndUsesCache(c *C) {
+	cacheDir := filepath.Join(c.MkDir(), "cache")
+	t.store = New(&Config{CacheDir: cacheDir}, nil)
+
+	n := 0
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
+		n++
+		w.Write([]byte("I was downloaded"))
+		return nil
+	}
+
+	snap := &snap.Info{}
+	snap.RealName = "foo"
+	snap.AnonDownloadURL = "anon-url"
+	snap.Sha3_384 = "abcdabcd"
+
+	dir := c.MkDir()
+	path := filepath.Join(dir, "foo_1.snap")
+	err := t.store.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, nil, nil)
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, 1)
+	c.Check(osutil.FileExists(filepath.Join(cacheDir, "abcdabcd")), Equals, true)
+
+	// a second download of the same blob is served by the cache
+	path = filepath.Join(dir, "foo_1-again.snap")
+	err = t.store.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, nil, nil)
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, 1)
+	content, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "I was downloaded")
+}
+
+func (t *remoteRepoTestSuite) TestDownloadPassesOptions(c *C) {
+	opts := &DownloadOptions{RateLimit: func() int64 { return 1024 }}
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
+		c.Check(dlOpts, Equals, opts)
+		return nil
+	}
+
+	snap := &snap.Info{}
+	snap.RealName = "foo"
+	snap.AnonDownloadURL = "anon-url"
+
+	path := filepath.Join(c.MkDir(), "downloaded-file")
+	err := t.store.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, nil, opts)
+	c.Assert(err, IsNil)
+}
+
 func (t *remoteRepoTestSuite) TestDownloadRangeRequest(c *C) {
 	partialContentStr := "partial content "
 
-	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter) error {
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
 		c.Check(resume, Equals, int64(len(partialContentStr)))
 		c.Check(url, Equals, "anon-url")
 		w.Write([]byte("was downloaded"))
@@ -311,23 +360,81 @@ func (t *remoteRepoTestSuite) TestDownloadRangeRequest(c *C) {
 	snap.DownloadURL = "AUTH-URL"
 	snap.Sha3_384 = "abcdabcd"
 
-	targetFn := filepath.Join(c.MkDir(), "foo_1.0_all.snap")
-	err := ioutil.WriteFile(targetFn+".partial", []byte(partialContentStr), 0644)
+	dir := c.MkDir()
+	targetFn := filepath.Join(dir, "foo_1.0_all.snap")
+	// partial downloads are found by the expected digest
+	err := ioutil.WriteFile(filepath.Join(dir, "abcdabcd.partial"), []byte(partialContentStr), 0644)
 	c.Assert(err, IsNil)
 
-	err = t.store.Download(context.TODO(), "foo", targetFn, &snap.DownloadInfo, nil, nil)
+	err = t.store.Download(context.TODO(), "foo", targetFn, &snap.DownloadInfo, nil, nil, nil)
 	c.Assert(err, IsNil)
 
 	content, err := ioutil.ReadFile(targetFn)
 	c.Assert(err, IsNil)
 	c.Assert(string(content), Equals, partialContentStr+"was downloaded")
+	c.Check(osutil.FileExists(filepath.Join(dir, "abcdabcd.partial")), Equals, false)
+}
+
+func (t *remoteRepoTestSuite) TestDownloadFailsKeepsPartial(c *C) {
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
+		c.Check(resume, Equals, int64(0))
+		w.Write([]byte("partial "))
+		return fmt.Errorf("connection reset by peer")
+	}
+
+	snap := &snap.Info{}
+	snap.RealName = "foo"
+	snap.AnonDownloadURL = "anon-url"
+	snap.Sha3_384 = "abcdabcd"
+
+	dir := c.MkDir()
+	targetFn := filepath.Join(dir, "foo_1.0_all.snap")
+	err := t.store.Download(context.TODO(), "foo", targetFn, &snap.DownloadInfo, nil, nil, nil)
+	c.Assert(err, ErrorMatches, "connection reset by peer")
+	c.Check(osutil.FileExists(targetFn), Equals, false)
+
+	// what was downloaded so far is kept ...
+	content, err := ioutil.ReadFile(filepath.Join(dir, "abcdabcd.partial"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "partial ")
+
+	// ... and the next attempt resumes from it
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
+		c.Check(resume, Equals, int64(len("partial ")))
+		w.Write([]byte("content"))
+		return nil
+	}
+	err = t.store.Download(context.TODO(), "foo", targetFn, &snap.DownloadInfo, nil, nil, nil)
+	c.Assert(err, IsNil)
+	content, err = ioutil.ReadFile(targetFn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "partial content")
+}
+
+func (t *remoteRepoTestSuite) TestDownloadHashErrorRemovesPartial(c *C) {
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
+		w.Write([]byte("broken content"))
+		return HashError{"foo", "1234", "abcdabcd"}
+	}
+
+	snap := &snap.Info{}
+	snap.RealName = "foo"
+	snap.AnonDownloadURL = "anon-url"
+	snap.Sha3_384 = "abcdabcd"
+
+	dir := c.MkDir()
+	targetFn := filepath.Join(dir, "foo_1.0_all.snap")
+	err := t.store.Download(context.TODO(), "foo", targetFn, &snap.DownloadInfo, nil, nil, nil)
+	c.Assert(err, FitsTypeOf, HashError{})
+	c.Check(osutil.FileExists(filepath.Join(dir, "abcdabcd.partial")), Equals, false)
+	c.Check(osutil.FileExists(targetFn), Equals, false)
 }
 
 func (t *remoteRepoTestSuite) TestDownloadRangeRequestRetryOnHashError(c *C) {
 	partialContentStr := "partial content "
 
 	n := 0
-	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter) error {
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
 		n++
 		if n == 1 {
 			// force sha3 error on first download
@@ -348,7 +455,7 @@ func (t *remoteRepoTestSuite) TestDownloadRangeRequestRetryOnHashError(c *C) {
 	err := ioutil.WriteFile(targetFn+".partial", []byte(partialContentStr), 0644)
 	c.Assert(err, IsNil)
 
-	err = t.store.Download(context.TODO(), "foo", targetFn, &snap.DownloadInfo, nil, nil)
+	err = t.store.Download(context.TODO(), "foo", targetFn, &snap.DownloadInfo, nil, nil, nil)
 	c.Assert(err, IsNil)
 	c.Assert(n, Equals, 2)
 
@@ -361,7 +468,7 @@ func (t *remoteRepoTestSuite) TestDownloadRangeRequestFailOnHashError(c *C) {
 	partialContentStr := "partial content "
 
 	n := 0
-	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter) error {
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
 		n++
 		return HashError{"foo", "1234", "5678"}
 	}
@@ -376,14 +483,14 @@ func (t *remoteRepoTestSuite) TestDownloadRangeRequestFailOnHashError(c *C) {
 	err := ioutil.WriteFile(targetFn+".partial", []byte(partialContentStr), 0644)
 	c.Assert(err, IsNil)
 
-	err = t.store.Download(context.TODO(), "foo", targetFn, &snap.DownloadInfo, nil, nil)
+	err = t.store.Download(context.TODO(), "foo", targetFn, &snap.DownloadInfo, nil, nil, nil)
 	c.Assert(err, NotNil)
 	c.Assert(err, ErrorMatches, `sha3-384 mismatch after patching "foo": got 1234 but expected 5678`)
 	c.Assert(n, Equals, 2)
 }
 
 func (t *remoteRepoTestSuite) TestAuthenticatedDownloadDoesNotUseAnonURL(c *C) {
-	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter) error {
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
 		// check user is pass and auth url is used
 		c.Check(user, Equals, t.user)
 		c.Check(url, Equals, "AUTH-URL")
@@ -398,7 +505,7 @@ func (t *remoteRepoTestSuite) TestAuthenticatedDownloadDoesNotUseAnonURL(c *C) {
 	snap.DownloadURL = "AUTH-URL"
 
 	path := filepath.Join(c.MkDir(), "downloaded-file")
-	err := t.store.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, t.user)
+	err := t.store.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, t.user, nil)
 	c.Assert(err, IsNil)
 	defer os.Remove(path)
 
@@ -408,7 +515,7 @@ func (t *remoteRepoTestSuite) TestAuthenticatedDownloadDoesNotUseAnonURL(c *C) {
 }
 
 func (t *remoteRepoTestSuite) TestLocalUserDownloadUsesAnonURL(c *C) {
-	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter) error {
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
 		c.Check(url, Equals, "anon-url")
 
 		w.Write([]byte("I was downloaded"))
@@ -421,7 +528,7 @@ func (t *remoteRepoTestSuite) TestLocalUserDownloadUsesAnonURL(c *C) {
 	snap.DownloadURL = "AUTH-URL"
 
 	path := filepath.Join(c.MkDir(), "downloaded-file")
-	err := t.store.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, t.localUser)
+	err := t.store.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, t.localUser, nil)
 	c.Assert(err, IsNil)
 	defer os.Remove(path)
 
@@ -432,7 +539,7 @@ func (t *remoteRepoTestSuite) TestLocalUserDownloadUsesAnonURL(c *C) {
 
 func (t *remoteRepoTestSuite) TestDownloadFails(c *C) {
 	var tmpfile *os.File
-	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter) error {
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
 		tmpfile = w.(*os.File)
 		return fmt.Errorf("uh, it failed")
 	}
@@ -443,7 +550,7 @@ func (t *remoteRepoTestSuite) TestDownloadFails(c *C) {
 	snap.DownloadURL = "AUTH-URL"
 	// simulate a failed download
 	path := filepath.Join(c.MkDir(), "downloaded-file")
-	err := t.store.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, nil)
+	err := t.store.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, nil, nil)
 	c.Assert(err, ErrorMatches, "uh, it failed")
 	// ... and ensure that the tempfile is removed
 	c.Assert(osutil.FileExists(tmpfile.Name()), Equals, false)
@@ -451,7 +558,7 @@ func (t *remoteRepoTestSuite) TestDownloadFails(c *C) {
 
 func (t *remoteRepoTestSuite) TestDownloadSyncFails(c *C) {
 	var tmpfile *os.File
-	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter) error {
+	download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
 		tmpfile = w.(*os.File)
 		w.Write([]byte("sync will fail"))
 		err := tmpfile.Close()
@@ -466,7 +573,7 @@ func (t *remoteRepoTestSuite) TestDownloadSyncFails(c *C) {
 
 	// simulate a failed sync
 	path := filepath.Join(c.MkDir(), "downloaded-file")
-	err := t.store.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, nil)
+	err := t.store.Download(context.TODO(), "foo", path, &snap.DownloadInfo, nil, nil, nil)
 	c.Assert(err, ErrorMatches, "fsync:.*")
 	// ... and ensure that the tempfile is removed
 	c.Assert(osutil.FileExists(tmpfile.Name()), Equals, false)
@@ -485,12 +592,46 @@ func (t *remoteRepoTestSuite) TestActualDownload(c *C) {
 	var buf SillyBuffer
 	// keep tests happy
 	sha3 := ""
-	err := download(context.TODO(), "foo", sha3, mockServer.URL, nil, theStore, &buf, 0, nil)
+	err := download(context.TODO(), "foo", sha3, mockServer.URL, nil, theStore, &buf, 0, nil, nil)
 	c.Assert(err, IsNil)
 	c.Check(buf.String(), Equals, "response-data")
 	c.Check(n, Equals, 1)
 }
 
+func (t *remoteRepoTestSuite) TestActualDownloadRateLimitLifted(c *C) {
+	data := strings.Repeat("x", 1000)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, data)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	oldSleep := rateLimitSleep
+	rateLimitSleep = func(ctx context.Context, d time.Duration) error {
+		c.Errorf("unexpected wait of %v for the rate limit", d)
+		return nil
+	}
+	defer func() { rateLimitSleep = oldSleep }()
+
+	// the limit is lifted after the first read, which can only get
+	// what is in the full bucket
+	calls := 0
+	rate := func() int64 {
+		calls++
+		if calls == 1 {
+			return 100
+		}
+		return 0
+	}
+
+	theStore := New(&Config{}, nil)
+	var buf SillyBuffer
+	err := download(context.TODO(), "foo", "", mockServer.URL, nil, theStore, &buf, 0, nil, &DownloadOptions{RateLimit: rate})
+	c.Assert(err, IsNil)
+	c.Check(buf.String(), Equals, data)
+	c.Check(calls > 1, Equals, true)
+}
+
 func (t *remoteRepoTestSuite) TestDownloadCancellation(c *C) {
 	// the channel used by mock server to request cancellation from the test
 	syncCh := make(chan struct{})
@@ -514,7 +655,7 @@ func (t *remoteRepoTestSuite) TestDownloadCancellation(c *C) {
 	go func() {
 		sha3 := ""
 		var buf SillyBuffer
-		err := download(ctx, "foo", sha3, mockServer.URL, nil, theStore, &buf, 0, nil)
+		err := download(ctx, "foo", sha3, mockServer.URL, nil, theStore, &buf, 0, nil, nil)
 		result <- err.Error()
 		close(result)
 	}()
@@ -544,7 +685,7 @@ func (t *remoteRepoTestSuite) TestActualDownloadNonPurchased401(c *C) {
 
 	theStore := New(&Config{}, nil)
 	var buf bytes.Buffer
-	err := download(context.TODO(), "foo", "sha3", mockServer.URL, nil, theStore, nopeSeeker{&buf}, -1, nil)
+	err := download(context.TODO(), "foo", "sha3", mockServer.URL, nil, theStore, nopeSeeker{&buf}, -1, nil, nil)
 	c.Assert(err, NotNil)
 	c.Check(err.Error(), Equals, "Please buy foo before installing it.")
 	c.Check(n, Equals, 1)
@@ -561,7 +702,7 @@ func (t *remoteRepoTestSuite) TestActualDownload404(c *C) {
 
 	theStore := New(&Config{}, nil)
 	var buf SillyBuffer
-	err := download(context.TODO(), "foo", "sha3", mockServer.URL, nil, theStore, &buf, 0, nil)
+	err := download(context.TODO(), "foo", "sha3", mockServer.URL, nil, theStore, &buf, 0, nil, nil)
 	c.Assert(err, NotNil)
 	c.Assert(err, FitsTypeOf, &ErrDownload{})
 	c.Check(err.(*ErrDownload).Code, Equals, http.StatusNotFound)
@@ -579,7 +720,7 @@ func (t *remoteRepoTestSuite) TestActualDownload500(c *C) {
 
 	theStore := New(&Config{}, nil)
 	var buf SillyBuffer
-	err := download(context.TODO(), "foo", "sha3", mockServer.URL, nil, theStore, &buf, 0, nil)
+	err := download(context.TODO(), "foo", "sha3", mockServer.URL, nil, theStore, &buf, 0, nil, nil)
 	c.Assert(err, NotNil)
 	c.Assert(err, FitsTypeOf, &ErrDownload{})
 	c.Check(err.(*ErrDownload).Code, Equals, http.StatusInternalServerError)
@@ -603,7 +744,7 @@ func (t *remoteRepoTestSuite) TestActualDownload500Once(c *C) {
 	var buf SillyBuffer
 	// keep tests happy
 	sha3 := ""
-	err := download(context.TODO(), "foo", sha3, mockServer.URL, nil, theStore, &buf, 0, nil)
+	err := download(context.TODO(), "foo", sha3, mockServer.URL, nil, theStore, &buf, 0, nil, nil)
 	c.Assert(err, IsNil)
 	c.Check(buf.String(), Equals, "response-data")
 	c.Check(n, Equals, 2)
@@ -659,6 +800,8 @@ func (t *remoteRepoTestSuite) TestActualDownloadResume(c *C) {
 	n := 0
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		n++
+		c.Check(r.Header.Get("Range"), Equals, "bytes=5-")
+		w.WriteHeader(http.StatusPartialContent)
 		io.WriteString(w, "data")
 	}))
 	c.Assert(mockServer, NotNil)
@@ -670,12 +813,61 @@ func (t *remoteRepoTestSuite) TestActualDownloadResume(c *C) {
 	h := crypto.SHA3_384.New()
 	h.Write([]byte("some data"))
 	sha3 := fmt.Sprintf("%x", h.Sum(nil))
-	err := download(context.TODO(), "foo", sha3, mockServer.URL, nil, theStore, buf, int64(len("some ")), nil)
+	err := download(context.TODO(), "foo", sha3, mockServer.URL, nil, theStore, buf, int64(len("some ")), nil, nil)
 	c.Check(err, IsNil)
 	c.Check(buf.String(), Equals, "some data")
 	c.Check(n, Equals, 1)
 }
 
+func (t *remoteRepoTestSuite) TestActualDownloadResumeRangeIgnored(c *C) {
+	n := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		c.Check(r.Header.Get("Range"), Equals, "bytes=5-")
+		// no support for ranges, the whole file is sent
+		io.WriteString(w, "some data")
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	theStore := New(&Config{}, nil)
+	buf := NewSillyBufferString("some ")
+	h := crypto.SHA3_384.New()
+	h.Write([]byte("some data"))
+	sha3 := fmt.Sprintf("%x", h.Sum(nil))
+	err := download(context.TODO(), "foo", sha3, mockServer.URL, nil, theStore, buf, int64(len("some ")), nil, nil)
+	c.Check(err, IsNil)
+	c.Check(buf.String(), Equals, "some data")
+	c.Check(n, Equals, 1)
+}
+
+func (t *remoteRepoTestSuite) TestActualDownloadResumeAlreadyComplete(c *C) {
+	n := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		c.Check(r.Header.Get("Range"), Equals, "bytes=9-")
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	theStore := New(&Config{}, nil)
+	h := crypto.SHA3_384.New()
+	h.Write([]byte("some data"))
+	sha3 := fmt.Sprintf("%x", h.Sum(nil))
+
+	buf := NewSillyBufferString("some data")
+	err := download(context.TODO(), "foo", sha3, mockServer.URL, nil, theStore, buf, int64(len("some data")), nil, nil)
+	c.Check(err, IsNil)
+	c.Check(buf.String(), Equals, "some data")
+
+	// a complete but broken partial file is caught
+	buf = NewSillyBufferString("some dada")
+	err = download(context.TODO(), "foo", sha3, mockServer.URL, nil, theStore, buf, int64(len("some dada")), nil, nil)
+	c.Check(err, FitsTypeOf, HashError{})
+	c.Check(n, Equals, 2)
+}
+
 func (t *remoteRepoTestSuite) TestUseDeltas(c *C) {
 	origPath := os.Getenv("PATH")
 	defer os.Setenv("PATH", origPath)
@@ -803,7 +995,7 @@ func (t *remoteRepoTestSuite) TestDownloadWithDelta(c *C) {
 
 	for _, testCase := range deltaTests {
 		downloadIndex := 0
-		download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter) error {
+		download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
 			if testCase.downloads[downloadIndex].error {
 				downloadIndex++
 				return errors.New("Bang")
@@ -821,7 +1013,7 @@ func (t *remoteRepoTestSuite) TestDownloadWithDelta(c *C) {
 		}
 
 		path := filepath.Join(c.MkDir(), "subdir", "downloaded-file")
-		err := t.store.Download(context.TODO(), "foo", path, &testCase.info, nil, nil)
+		err := t.store.Download(context.TODO(), "foo", path, &testCase.info, nil, nil, nil)
 
 		c.Assert(err, IsNil)
 		defer os.Remove(path)
@@ -912,7 +1104,7 @@ func (t *remoteRepoTestSuite) TestDownloadDelta(c *C) {
 
 	for _, testCase := range downloadDeltaTests {
 		t.store.deltaFormat = testCase.format
-		download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter) error {
+		download = func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *DownloadOptions) error {
 			expectedUser := t.user
 			if testCase.useLocalUser {
 				expectedUser = t.localUser
@@ -938,7 +1130,7 @@ func (t *remoteRepoTestSuite) TestDownloadDelta(c *C) {
 			authedUser = nil
 		}
 
-		err = t.store.downloadDelta("snapname", &testCase.info, w, nil, authedUser)
+		err = t.store.downloadDelta("snapname", &testCase.info, w, nil, authedUser, nil)
 
 		if testCase.expectError {
 			c.Assert(err, NotNil)
@@ -1676,6 +1868,80 @@ func (t *remoteRepoTestSuite) TestUbuntuStoreRepositoryDetailsAndChannels(c *C)
 	c.Check(snap.Validate(result), IsNil)
 }
 
+func (t *remoteRepoTestSuite) TestUbuntuStoreRepositoryDetailsChannelMap(c *C) {
+	n := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		c.Check(r.URL.Path, Equals, "/details/hello-world")
+		c.Check(r.URL.Query().Get("fields"), Equals, "abc,def,channel_maps_list")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, `{
+  "package_name": "hello-world",
+  "snap_id": "buPKUD3TKqCOgLEjjHx5kSiCpIs5cMuQ",
+  "revision": 27,
+  "version": "6.3",
+  "channel_maps_list": [{
+    "track": "latest",
+    "map": [
+      {"info": "released", "channel": "stable", "confinement": "strict", "revision": 27, "version": "6.3", "epoch": "0", "binary_filesize": 20480},
+      {"info": "tracking", "channel": "candidate"},
+      {"info": "closed", "channel": "beta"},
+      {"info": "released", "channel": "edge", "confinement": "devmode", "revision": 28, "version": "6.4", "epoch": "0", "binary_filesize": 20480}
+    ]
+  }, {
+    "track": "2.x",
+    "map": [
+      {"info": "released", "channel": "2.x/stable", "confinement": "strict", "revision": 20, "version": "2.1", "epoch": "0", "binary_filesize": 10240}
+    ]
+  }]
+}`)
+	}))
+
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	detailsURI, err := url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+	cfg := Config{
+		DetailsURI:   detailsURI,
+		DetailFields: []string{"abc", "def"},
+	}
+	repo := New(&cfg, nil)
+	c.Assert(repo, NotNil)
+
+	// no need to fake the channels when the store sends them
+	result, err := repo.SnapInfo(SnapSpec{Name: "hello-world"}, nil)
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, 1)
+	c.Check(result.Tracks, DeepEquals, []string{"latest", "2.x"})
+	c.Check(result.Channels, DeepEquals, map[string]*snap.ChannelSnapInfo{
+		"stable": {
+			Revision:    snap.R(27),
+			Version:     "6.3",
+			Confinement: snap.StrictConfinement,
+			Channel:     "stable",
+			Epoch:       "0",
+			Size:        20480,
+		},
+		"edge": {
+			Revision:    snap.R(28),
+			Version:     "6.4",
+			Confinement: snap.DevModeConfinement,
+			Channel:     "edge",
+			Epoch:       "0",
+			Size:        20480,
+		},
+		"2.x/stable": {
+			Revision:    snap.R(20),
+			Version:     "2.1",
+			Confinement: snap.StrictConfinement,
+			Channel:     "2.x/stable",
+			Epoch:       "0",
+			Size:        10240,
+		},
+	})
+}
+
 func (t *remoteRepoTestSuite) TestUbuntuStoreRepositoryNonDefaults(c *C) {
 	restore := release.MockOnClassic(true)
 	defer restore()
@@ -2752,7 +3018,7 @@ func (t *remoteRepoTestSuite) TestUbuntuStoreRepositoryListRefreshWithDeltas(c *
 			"epoch":       "0",
 			"confinement": "",
 		})
-		c.Assert(resp.Fields, DeepEquals, getStructFields(snapDetails{}))
+		c.Assert(resp.Fields, DeepEquals, detailFields)
 
 		io.WriteString(w, MockUpdatesWithDeltasJSON)
 	}))
@@ -2968,6 +3234,29 @@ func (t *remoteRepoTestSuite) TestDefaultConfig(c *C) {
 	c.Check(strings.HasPrefix(defaultConfig.SearchURI.String(), "https://search.apps.ubuntu.com/api/v1/snaps/search"), Equals, true)
 	c.Check(strings.HasPrefix(defaultConfig.BulkURI.String(), "https://search.apps.ubuntu.com/api/v1/snaps/metadata"), Equals, true)
 	c.Check(defaultConfig.AssertionsURI.String(), Equals, "https://assertions.ubuntu.com/v1/assertions/")
+	c.Check(defaultConfig.SnapActionURI.String(), Equals, "https://api.snapcraft.io/v2/snaps/refresh")
+}
+
+func (t *remoteRepoTestSuite) TestSetBaseURL(c *C) {
+	u, err := url.Parse("http://mirror.internal:8080/store")
+	c.Assert(err, IsNil)
+
+	cfg := DefaultConfig()
+	c.Assert(cfg.SetBaseURL(u), IsNil)
+	c.Check(cfg.SearchURI.String(), Equals, "http://mirror.internal:8080/store/snaps/search")
+	c.Check(cfg.DetailsURI.String(), Equals, "http://mirror.internal:8080/store/snaps/details/")
+	c.Check(cfg.BulkURI.String(), Equals, "http://mirror.internal:8080/store/snaps/metadata")
+	c.Check(cfg.SectionsURI.String(), Equals, "http://mirror.internal:8080/store/snaps/sections")
+	c.Check(cfg.AssertionsURI.String(), Equals, "http://mirror.internal:8080/store/assertions/")
+	c.Check(cfg.SnapActionURI.String(), Equals, "http://mirror.internal:8080/store/v2/snaps/refresh")
+	// purchases still go to the main store
+	c.Check(cfg.OrdersURI, DeepEquals, defaultConfig.OrdersURI)
+	// the default is untouched
+	c.Check(defaultConfig.AssertionsURI.String(), Equals, "https://assertions.ubuntu.com/v1/assertions/")
+
+	u, err = url.Parse("ftp://mirror.internal/")
+	c.Assert(err, IsNil)
+	c.Check(cfg.SetBaseURL(u), ErrorMatches, `invalid store URL "ftp://mirror.internal/": only http and https are supported`)
 }
 
 func (t *remoteRepoTestSuite) TestNew(c *C) {
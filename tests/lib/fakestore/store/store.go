@@ -21,17 +21,11 @@ package store
 
 import (
 	"encoding/base64"
-	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
-	"net"
 	"net/http"
 	"path/filepath"
 	"strings"
-	"time"
-
-	"gopkg.in/tylerb/graceful.v1"
 
 	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/asserts/sysdb"
@@ -40,6 +34,7 @@ import (
 	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/store"
+	"github.com/snapcore/snapd/store/mirror"
 )
 
 func rootEndpoint(w http.ResponseWriter, req *http.Request) {
@@ -47,6 +42,11 @@ func rootEndpoint(w http.ResponseWriter, req *http.Request) {
 	fmt.Fprintf(w, "I'm a teapot")
 }
 
+func searchEndpoint(w http.ResponseWriter, req *http.Request) {
+	w.WriteHeader(501)
+	fmt.Fprintf(w, "search not implemented")
+}
+
 func hexify(in string) string {
 	bs, err := base64.RawURLEncoding.DecodeString(in)
 	if err != nil {
@@ -55,21 +55,18 @@ func hexify(in string) string {
 	return fmt.Sprintf("%x", bs)
 }
 
-// Store is our snappy software store implementation
+// Store is our snappy software store implementation, a store mirror
+// serving the snaps dropped into a directory, which do not need to be
+// signed, and the assertions in its asserts subdirectory.
 type Store struct {
-	url       string
 	blobDir   string
 	assertDir string
 
-	assertFallback bool
-	fallback       *store.Store
-
-	srv *graceful.Server
+	mirror *mirror.Mirror
 }
 
 // NewStore creates a new store server serving snaps from the given top directory and assertions from topDir/asserts. If assertFallback is true missing assertions are looked up in the main online store.
 func NewStore(topDir, addr string, assertFallback bool) *Store {
-	mux := http.NewServeMux()
 	var sto *store.Store
 	if assertFallback {
 		httputil.SetUserAgentFromVersion("unknown", "fakestore")
@@ -78,34 +75,17 @@ func NewStore(topDir, addr string, assertFallback bool) *Store {
 	store := &Store{
 		blobDir:   topDir,
 		assertDir: filepath.Join(topDir, "asserts"),
-
-		assertFallback: assertFallback,
-		fallback:       sto,
-
-		url: fmt.Sprintf("http://%s", addr),
-		srv: &graceful.Server{
-			Timeout: 2 * time.Second,
-
-			Server: &http.Server{
-				Addr:    addr,
-				Handler: mux,
-			},
-		},
 	}
-
-	mux.HandleFunc("/", rootEndpoint)
-	mux.HandleFunc("/search", store.searchEndpoint)
-	mux.HandleFunc("/snaps/details/", store.detailsEndpoint)
-	mux.HandleFunc("/snaps/metadata", store.bulkEndpoint)
-	mux.Handle("/download/", http.StripPrefix("/download/", http.FileServer(http.Dir(topDir))))
-	mux.HandleFunc("/assertions/", store.assertionsEndpoint)
+	store.mirror = mirror.NewWithCatalog(store, addr, sto)
+	store.mirror.HandleFunc("/", rootEndpoint)
+	store.mirror.HandleFunc("/search", searchEndpoint)
 
 	return store
 }
 
 // URL returns the base-url that the store is listening on
 func (s *Store) URL() string {
-	return s.url
+	return strings.TrimSuffix(s.mirror.URL(), "/")
 }
 
 func (s *Store) SnapsDir() string {
@@ -114,27 +94,12 @@ func (s *Store) SnapsDir() string {
 
 // Start listening
 func (s *Store) Start() error {
-	l, err := net.Listen("tcp", s.srv.Addr)
-	if err != nil {
-		return err
-	}
-
-	go s.srv.Serve(l)
-	return nil
+	return s.mirror.Start()
 }
 
 // Stop stops the server
 func (s *Store) Stop() error {
-	timeoutTime := 2000 * time.Millisecond
-	s.srv.Stop(timeoutTime / 2)
-
-	select {
-	case <-s.srv.StopChan():
-	case <-time.After(timeoutTime):
-		return fmt.Errorf("store failed to stop after %s", timeoutTime)
-	}
-
-	return nil
+	return s.mirror.Stop()
 }
 
 var (
@@ -171,31 +136,25 @@ type essentialInfo struct {
 	Digest      string
 }
 
-var errInfo = errors.New("cannot get info")
-
-func snapEssentialInfo(w http.ResponseWriter, fn, snapID string, bs asserts.Backstore) (*essentialInfo, error) {
+func snapEssentialInfo(fn, snapID string, bs asserts.Backstore) (*essentialInfo, *snap.Info, error) {
 	snapFile, err := snap.Open(fn)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("cannot read: %v: %v", fn, err), http.StatusBadRequest)
-		return nil, errInfo
+		return nil, nil, fmt.Errorf("cannot read: %v: %v", fn, err)
 	}
 
 	info, err := snap.ReadInfoFromSnapFile(snapFile, nil)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("can get info for: %v: %v", fn, err), http.StatusBadRequest)
-		return nil, errInfo
+		return nil, nil, fmt.Errorf("can get info for: %v: %v", fn, err)
 	}
 
 	snapDigest, size, err := asserts.SnapFileSHA3_384(fn)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("can get digest for: %v: %v", fn, err), http.StatusBadRequest)
-		return nil, errInfo
+		return nil, nil, fmt.Errorf("can get digest for: %v: %v", fn, err)
 	}
 
 	snapRev, devAcct, err := findSnapRevision(snapDigest, bs)
 	if err != nil && err != asserts.ErrNotFound {
-		http.Error(w, fmt.Sprintf("can get info for: %v: %v", fn, err), http.StatusBadRequest)
-		return nil, errInfo
+		return nil, nil, fmt.Errorf("can get info for: %v: %v", fn, err)
 	}
 
 	var devel, develID string
@@ -221,87 +180,7 @@ func snapEssentialInfo(w http.ResponseWriter, fn, snapID string, bs asserts.Back
 		Version:     info.Version,
 		Digest:      snapDigest,
 		Size:        size,
-	}, nil
-}
-
-type searchPayloadJSON struct {
-	Packages []detailsReplyJSON `json:"clickindex:package"`
-}
-
-type searchReplyJSON struct {
-	Payload searchPayloadJSON `json:"_embedded"`
-}
-
-type detailsReplyJSON struct {
-	Architectures   []string `json:"architecture"`
-	SnapID          string   `json:"snap_id"`
-	PackageName     string   `json:"package_name"`
-	Developer       string   `json:"origin"`
-	DeveloperID     string   `json:"developer_id"`
-	AnonDownloadURL string   `json:"anon_download_url"`
-	DownloadURL     string   `json:"download_url"`
-	Version         string   `json:"version"`
-	Revision        int      `json:"revision"`
-	DownloadDigest  string   `json:"download_sha3_384"`
-}
-
-func (s *Store) searchEndpoint(w http.ResponseWriter, req *http.Request) {
-	w.WriteHeader(501)
-	fmt.Fprintf(w, "search not implemented")
-}
-
-func (s *Store) detailsEndpoint(w http.ResponseWriter, req *http.Request) {
-	pkg := strings.TrimPrefix(req.URL.Path, "/snaps/details/")
-	if pkg == req.URL.Path {
-		panic("how?")
-	}
-
-	bs, err := s.collectAssertions()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("internal error collecting assertions: %v", err), http.StatusInternalServerError)
-		return
-	}
-	snaps, err := s.collectSnaps()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("internal error collecting snaps: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	fn, ok := snaps[pkg]
-	if !ok {
-		http.NotFound(w, req)
-		return
-	}
-
-	essInfo, err := snapEssentialInfo(w, fn, "", bs)
-	if essInfo == nil {
-		if err != errInfo {
-			panic(err)
-		}
-		return
-	}
-
-	details := detailsReplyJSON{
-		Architectures:   []string{"all"},
-		SnapID:          essInfo.SnapID,
-		PackageName:     essInfo.Name,
-		Developer:       essInfo.DevelName,
-		DeveloperID:     essInfo.DeveloperID,
-		AnonDownloadURL: fmt.Sprintf("%s/download/%s", s.URL(), filepath.Base(fn)),
-		DownloadURL:     fmt.Sprintf("%s/download/%s", s.URL(), filepath.Base(fn)),
-		Version:         essInfo.Version,
-		Revision:        essInfo.Revision,
-		DownloadDigest:  hexify(essInfo.Digest),
-	}
-
-	// use indent because this is a development tool, output
-	// should look nice
-	out, err := json.MarshalIndent(details, "", "    ")
-	if err != nil {
-		http.Error(w, fmt.Sprintf("cannot marshal: %v: %v", details, err), http.StatusBadRequest)
-		return
-	}
-	w.Write(out)
+	}, info, nil
 }
 
 func (s *Store) collectSnaps() (map[string]string, error) {
@@ -327,23 +206,6 @@ func (s *Store) collectSnaps() (map[string]string, error) {
 	return snaps, err
 }
 
-type candidateSnap struct {
-	SnapID string `json:"snap_id"`
-}
-
-type bulkReqJSON struct {
-	CandidateSnaps []candidateSnap `json:"snaps"`
-	Fields         []string        `json:"fields"`
-}
-
-type payload struct {
-	Packages []detailsReplyJSON `json:"clickindex:package"`
-}
-
-type bulkReplyJSON struct {
-	Payload payload `json:"_embedded"`
-}
-
 var someSnapIDtoName = map[string]map[string]string{
 	"production": {
 		"b8X2psL1ryVrPt5WEmpYiqfr5emixTd7": "ubuntu-core",
@@ -362,20 +224,13 @@ var someSnapIDtoName = map[string]map[string]string{
 	},
 }
 
-func (s *Store) bulkEndpoint(w http.ResponseWriter, req *http.Request) {
-	var pkgs bulkReqJSON
-	var replyData bulkReplyJSON
-
-	decoder := json.NewDecoder(req.Body)
-	if err := decoder.Decode(&pkgs); err != nil {
-		http.Error(w, fmt.Sprintf("cannot decode request body: %v", err), http.StatusBadRequest)
-		return
-	}
-
-	bs, err := s.collectAssertions()
+// Index returns the index of the snaps in the top directory, each with
+// its only revision released to the stable channel, which the other
+// risks follow.
+func (s *Store) Index() (*mirror.Index, error) {
+	bs, err := s.Assertions()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("internal error collecting assertions: %v", err), http.StatusInternalServerError)
-		return
+		return nil, fmt.Errorf("internal error collecting assertions: %v", err)
 	}
 
 	var remoteStore string
@@ -386,61 +241,49 @@ func (s *Store) bulkEndpoint(w http.ResponseWriter, req *http.Request) {
 	}
 	snapIDtoName, err := addSnapIDs(bs, someSnapIDtoName[remoteStore])
 	if err != nil {
-		http.Error(w, fmt.Sprintf("internal error collecting snapIDs: %v", err), http.StatusInternalServerError)
-		return
+		return nil, fmt.Errorf("internal error collecting snapIDs: %v", err)
+	}
+	nameToSnapID := make(map[string]string, len(snapIDtoName))
+	for snapID, name := range snapIDtoName {
+		nameToSnapID[name] = snapID
 	}
 
 	snaps, err := s.collectSnaps()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("internal error collecting snaps: %v", err), http.StatusInternalServerError)
-		return
+		return nil, fmt.Errorf("internal error collecting snaps: %v", err)
 	}
 
-	// check if we have downloadable snap of the given SnapID
-	for _, pkg := range pkgs.CandidateSnaps {
-
-		name := snapIDtoName[pkg.SnapID]
-		if name == "" {
-			http.Error(w, fmt.Sprintf("unknown snapid: %q", pkg.SnapID), http.StatusBadRequest)
-			return
+	idx := &mirror.Index{Snaps: make(map[string]*mirror.SnapIndex, len(snaps))}
+	for name, fn := range snaps {
+		essInfo, info, err := snapEssentialInfo(fn, nameToSnapID[name], bs)
+		if err != nil {
+			return nil, err
 		}
-
-		if fn, ok := snaps[name]; ok {
-			essInfo, err := snapEssentialInfo(w, fn, pkg.SnapID, bs)
-			if essInfo == nil {
-				if err != errInfo {
-					panic(err)
-				}
-				return
-			}
-
-			replyData.Payload.Packages = append(replyData.Payload.Packages, detailsReplyJSON{
-				Architectures:   []string{"all"},
-				SnapID:          essInfo.SnapID,
-				PackageName:     essInfo.Name,
-				Developer:       essInfo.DevelName,
-				DeveloperID:     essInfo.DeveloperID,
-				DownloadURL:     fmt.Sprintf("%s/download/%s", s.URL(), filepath.Base(fn)),
-				AnonDownloadURL: fmt.Sprintf("%s/download/%s", s.URL(), filepath.Base(fn)),
-				Version:         essInfo.Version,
-				Revision:        essInfo.Revision,
-				DownloadDigest:  hexify(essInfo.Digest),
-			})
+		idx.Snaps[name] = &mirror.SnapIndex{
+			SnapID: essInfo.SnapID,
+			Revisions: []*mirror.RevisionInfo{{
+				Revision:    essInfo.Revision,
+				File:        filepath.Base(fn),
+				Version:     essInfo.Version,
+				Summary:     info.Summary(),
+				Description: info.Description(),
+				Type:        info.Type,
+				Confinement: string(info.Confinement),
+				Epoch:       info.Epoch,
+				Sha3_384:    hexify(essInfo.Digest),
+				Size:        essInfo.Size,
+				DeveloperID: essInfo.DeveloperID,
+				Developer:   essInfo.DevelName,
+			}},
+			Channels: map[string]int{"stable": essInfo.Revision},
 		}
 	}
-
-	// use indent because this is a development tool, output
-	// should look nice
-	out, err := json.MarshalIndent(replyData, "", "    ")
-	if err != nil {
-		http.Error(w, fmt.Sprintf("can marshal: %v: %v", replyData, err), http.StatusBadRequest)
-		return
-	}
-	w.Write(out)
-
+	return idx, nil
 }
 
-func (s *Store) collectAssertions() (asserts.Backstore, error) {
+// Assertions returns the assertions snapd trusts, the ones of the test
+// keys and the ones in the asserts directory.
+func (s *Store) Assertions() (asserts.Backstore, error) {
 	bs := asserts.NewMemoryBackstore()
 
 	add := func(a asserts.Assertion) {
@@ -476,69 +319,6 @@ func (s *Store) collectAssertions() (asserts.Backstore, error) {
 	return bs, nil
 }
 
-func isAssertNotFound(err error) bool {
-	if err == asserts.ErrNotFound {
-		return true
-	}
-	if _, ok := err.(*store.AssertionNotFoundError); ok {
-		return true
-	}
-	return false
-}
-
-func (s *Store) retrieveAssertion(bs asserts.Backstore, assertType *asserts.AssertionType, primaryKey []string) (asserts.Assertion, error) {
-	a, err := bs.Get(assertType, primaryKey, assertType.MaxSupportedFormat())
-	if err == asserts.ErrNotFound && s.assertFallback {
-		return s.fallback.Assertion(assertType, primaryKey, nil)
-	}
-	return a, err
-}
-
-func (s *Store) assertionsEndpoint(w http.ResponseWriter, req *http.Request) {
-	assertPath := strings.TrimPrefix(req.URL.Path, "/assertions/")
-
-	bs, err := s.collectAssertions()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("internal error collecting assertions: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	comps := strings.Split(assertPath, "/")
-
-	if len(comps) == 0 {
-		http.Error(w, "missing assertion type", http.StatusBadRequest)
-		return
-
-	}
-
-	typ := asserts.Type(comps[0])
-	if typ == nil {
-		http.Error(w, fmt.Sprintf("unknown assertion type: %s", comps[0]), http.StatusBadRequest)
-		return
-	}
-
-	if len(typ.PrimaryKey) != len(comps)-1 {
-		http.Error(w, fmt.Sprintf("wrong primary key length: %v", comps), http.StatusBadRequest)
-		return
-	}
-
-	a, err := s.retrieveAssertion(bs, typ, comps[1:])
-	if isAssertNotFound(err) {
-		w.Header().Set("Content-Type", "application/problem+json")
-		w.WriteHeader(404)
-		w.Write([]byte(`{"status": 404}`))
-		return
-	}
-	if err != nil {
-		http.Error(w, fmt.Sprintf("cannot retrieve assertion %v: %v", comps, err), http.StatusBadRequest)
-		return
-	}
-
-	w.Header().Set("Content-Type", asserts.MediaType)
-	w.WriteHeader(http.StatusOK)
-	w.Write(asserts.Encode(a))
-}
-
 func addSnapIDs(bs asserts.Backstore, initial map[string]string) (map[string]string, error) {
 	m := make(map[string]string)
 	for id, name := range initial {
@@ -57,6 +57,14 @@ func getSha(fn string) string {
 	return hexify(snapDigest)
 }
 
+func getSize(fn string) float64 {
+	_, size, err := asserts.SnapFileSHA3_384(fn)
+	if err != nil {
+		panic(err)
+	}
+	return float64(size)
+}
+
 func (s *storeTestSuite) SetUpTest(c *C) {
 	topdir := c.MkDir()
 	err := os.Mkdir(filepath.Join(topdir, "asserts"), 0755)
@@ -115,6 +123,52 @@ func (s *storeTestSuite) TestSearchEndpoint(c *C) {
 
 }
 
+func (s *storeTestSuite) TestSnapsSearchEndpoint(c *C) {
+	s.makeTestSnap(c, "name: foo\nversion: 1")
+	s.makeTestSnap(c, "name: bar\nversion: 1")
+
+	resp, err := s.StoreGet("/snaps/search?q=foo")
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+
+	c.Assert(resp.StatusCode, Equals, 200)
+	var body struct {
+		Top struct {
+			Cat []map[string]interface{} `json:"clickindex:package"`
+		} `json:"_embedded"`
+	}
+	c.Assert(json.NewDecoder(resp.Body).Decode(&body), IsNil)
+	c.Assert(body.Top.Cat, HasLen, 1)
+	c.Check(body.Top.Cat[0]["package_name"], Equals, "foo")
+}
+
+func (s *storeTestSuite) TestIndex(c *C) {
+	snapFn := s.makeTestSnap(c, "name: foo\nversion: 7")
+	s.makeAssertions(c, snapFn, "foo", "xidididididididididididididididid", "foo-devel", "foo-devel-id", 77)
+	s.makeTestSnap(c, "name: test-snapd-tools\nversion: 1")
+
+	idx, err := s.store.Index()
+	c.Assert(err, IsNil)
+	c.Assert(idx.Snaps, HasLen, 2)
+
+	foo := idx.Snaps["foo"]
+	c.Assert(foo, NotNil)
+	c.Check(foo.SnapID, Equals, "xidididididididididididididididid")
+	c.Check(foo.Channels, DeepEquals, map[string]int{"stable": 77})
+	c.Assert(foo.Revisions, HasLen, 1)
+	c.Check(foo.Revisions[0].Revision, Equals, 77)
+	c.Check(foo.Revisions[0].File, Equals, "foo_7_all.snap")
+	c.Check(foo.Revisions[0].Developer, Equals, "foo-devel")
+	c.Check(foo.Revisions[0].Sha3_384, Equals, getSha(snapFn))
+
+	// snaps without assertions get their snap id from the known ones
+	tools := idx.Snaps["test-snapd-tools"]
+	c.Assert(tools, NotNil)
+	c.Check(tools.SnapID, Equals, "eFe8BTR5L5V9F7yHeMAPxkEr2NdUXMtw")
+	c.Check(tools.Channels, DeepEquals, map[string]int{"stable": 424242})
+	c.Check(tools.Revisions[0].Developer, Equals, "canonical")
+}
+
 func (s *storeTestSuite) TestDetailsEndpointWithAssertions(c *C) {
 	snapFn := s.makeTestSnap(c, "name: foo\nversion: 7")
 	s.makeAssertions(c, snapFn, "foo", "xidididididididididididididididid", "foo-devel", "foo-devel-id", 77)
@@ -138,6 +192,10 @@ func (s *storeTestSuite) TestDetailsEndpointWithAssertions(c *C) {
 		"version":           "7",
 		"revision":          float64(77),
 		"download_sha3_384": getSha(snapFn),
+		"binary_filesize":   getSize(snapFn),
+		"content":           "app",
+		"confinement":       "strict",
+		"epoch":             "0",
 	})
 }
 
@@ -162,6 +220,10 @@ func (s *storeTestSuite) TestDetailsEndpoint(c *C) {
 		"version":           "1",
 		"revision":          float64(424242),
 		"download_sha3_384": getSha(snapFn),
+		"binary_filesize":   getSize(snapFn),
+		"content":           "app",
+		"confinement":       "strict",
+		"epoch":             "0",
 	})
 }
 
@@ -194,6 +256,11 @@ func (s *storeTestSuite) TestBulkEndpoint(c *C) {
 		"version":           "1",
 		"revision":          float64(424242),
 		"download_sha3_384": getSha(snapFn),
+		"binary_filesize":   getSize(snapFn),
+		"content":           "app",
+		"confinement":       "strict",
+		"epoch":             "0",
+		"channel":           "stable",
 	}})
 }
 
@@ -225,9 +292,94 @@ func (s *storeTestSuite) TestBulkEndpointWithAssertions(c *C) {
 		"version":           "10",
 		"revision":          float64(99),
 		"download_sha3_384": getSha(snapFn),
+		"binary_filesize":   getSize(snapFn),
+		"content":           "app",
+		"confinement":       "strict",
+		"epoch":             "0",
+		"channel":           "stable",
+	}})
+}
+
+func (s *storeTestSuite) TestSnapActionEndpoint(c *C) {
+	snapFn := s.makeTestSnap(c, "name: test-snapd-tools\nversion: 1")
+
+	resp, err := s.StorePostJSON("/v2/snaps/refresh", []byte(`{
+"context": [{"instance-key":"eFe8BTR5L5V9F7yHeMAPxkEr2NdUXMtw","snap-id":"eFe8BTR5L5V9F7yHeMAPxkEr2NdUXMtw","tracking-channel":"stable","revision":1}],
+"actions": [{"action":"refresh","instance-key":"eFe8BTR5L5V9F7yHeMAPxkEr2NdUXMtw","snap-id":"eFe8BTR5L5V9F7yHeMAPxkEr2NdUXMtw"},
+            {"action":"install","instance-key":"install-foo","name":"foo"}]
+}`))
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+
+	c.Assert(resp.StatusCode, Equals, 200)
+	var body struct {
+		Results []map[string]interface{} `json:"results"`
+	}
+	c.Assert(json.NewDecoder(resp.Body).Decode(&body), IsNil)
+	c.Check(body.Results, DeepEquals, []map[string]interface{}{{
+		"result":       "refresh",
+		"instance-key": "eFe8BTR5L5V9F7yHeMAPxkEr2NdUXMtw",
+		"snap-id":      "eFe8BTR5L5V9F7yHeMAPxkEr2NdUXMtw",
+		"name":         "test-snapd-tools",
+		"snap": map[string]interface{}{
+			"architectures": []interface{}{"all"},
+			"snap-id":       "eFe8BTR5L5V9F7yHeMAPxkEr2NdUXMtw",
+			"name":          "test-snapd-tools",
+			"publisher": map[string]interface{}{
+				"id":       "canonical",
+				"username": "canonical",
+			},
+			"download": map[string]interface{}{
+				"url":      s.store.URL() + "/download/test-snapd-tools_1_all.snap",
+				"sha3-384": getSha(snapFn),
+				"size":     getSize(snapFn),
+			},
+			"version":     "1",
+			"revision":    float64(424242),
+			"summary":     "",
+			"description": "",
+			"type":        "app",
+			"confinement": "strict",
+		},
+	}, {
+		"result":       "error",
+		"instance-key": "install-foo",
+		"name":         "foo",
+		"error": map[string]interface{}{
+			"code":    "name-not-found",
+			"message": "snap not found",
+		},
 	}})
 }
 
+func (s *storeTestSuite) TestSnapActionEndpointAssertedSnap(c *C) {
+	snapFn := s.makeTestSnap(c, "name: foo\nversion: 10")
+	s.makeAssertions(c, snapFn, "foo", "xidididididididididididididididid", "foo-devel", "foo-devel-id", 99)
+
+	resp, err := s.StorePostJSON("/v2/snaps/refresh", []byte(`{
+"actions": [{"action":"download","instance-key":"download-foo","name":"foo"}]
+}`))
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+
+	c.Assert(resp.StatusCode, Equals, 200)
+	var body struct {
+		Results []struct {
+			Result string                 `json:"result"`
+			Snap   map[string]interface{} `json:"snap"`
+		} `json:"results"`
+	}
+	c.Assert(json.NewDecoder(resp.Body).Decode(&body), IsNil)
+	c.Assert(body.Results, HasLen, 1)
+	c.Check(body.Results[0].Result, Equals, "download")
+	c.Check(body.Results[0].Snap["snap-id"], Equals, "xidididididididididididididididid")
+	c.Check(body.Results[0].Snap["revision"], Equals, float64(99))
+	c.Check(body.Results[0].Snap["publisher"], DeepEquals, map[string]interface{}{
+		"id":       "foo-devel-id",
+		"username": "foo-devel",
+	})
+}
+
 func (s *storeTestSuite) makeTestSnap(c *C, snapYamlContent string) string {
 	fn := snaptest.MakeTestSnapWithFiles(c, snapYamlContent, nil)
 	dst := filepath.Join(s.store.blobDir, filepath.Base(fn))